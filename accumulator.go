@@ -0,0 +1,222 @@
+package flowmeter
+
+import "time"
+
+// flowAccumulator holds the incremental state of every feature module for one flow.
+// Each module updates in O(1) per packet and keeps no per-packet slices, so memory per
+// flow is constant regardless of packet count. Packets must be fed in timestamp order.
+type flowAccumulator struct {
+	basic      basicState
+	counts     countsState
+	packetLen  packetLenState
+	iat        iatState
+	flags      flagsState
+	rates      ratesState
+	ratio      ratioState
+	bulk       bulkState
+	subflow    subflowState
+	activeIdle activeIdleState
+	initWin    initWinState
+	tcpHealth  tcpHealthState
+	rtt        rttState
+	tcpOpts    tcpOptsState
+	osfp       osFingerprintState
+	ipLayer    ipLayerState
+	icmp       icmpState
+	tunnel     tunnelState
+
+	meta flowMeta
+
+	enabled  moduleSet // built-in modules to run
+	features *Registry
+	custom   []FeatureState // one per custom module of features
+}
+
+// init applies the thresholds from cfg; it must be called before the first update.
+func (a *flowAccumulator) init(cfg *Config) {
+	a.bulk.idleThresholdUs = cfg.BulkIdleThreshold.Microseconds()
+	a.bulk.minPackets = cfg.BulkMinPackets
+	a.subflow.thresholdUs = cfg.SubflowIdleThreshold.Microseconds()
+	a.activeIdle.thresholdUs = cfg.ActiveIdleThreshold.Microseconds()
+	a.osfp.db = cfg.OSSignatures
+	a.enabled = allModules
+	if r := cfg.Features; r != nil {
+		a.enabled = r.builtins
+		a.features = r
+		a.custom = r.newStates(cfg)
+	}
+}
+
+// update feeds one packet to every enabled module.
+func (a *flowAccumulator) update(p *PacketInfo) {
+	a.meta.update(p)
+	if a.enabled&modBasic != 0 {
+		a.basic.update(p)
+	}
+	if a.enabled&modCounts != 0 {
+		a.counts.update(p)
+	}
+	if a.enabled&modPacketLen != 0 {
+		a.packetLen.update(p)
+	}
+	if a.enabled&modIAT != 0 {
+		a.iat.update(p)
+	}
+	if a.enabled&modFlags != 0 {
+		a.flags.update(p)
+	}
+	if a.enabled&modRates != 0 {
+		a.rates.update(p)
+	}
+	if a.enabled&modRatio != 0 {
+		a.ratio.update(p)
+	}
+	if a.enabled&modBulk != 0 {
+		a.bulk.update(p)
+	}
+	if a.enabled&modSubflow != 0 {
+		a.subflow.update(p)
+	}
+	if a.enabled&modActiveIdle != 0 {
+		a.activeIdle.update(p)
+	}
+	if a.enabled&modInitWin != 0 {
+		a.initWin.update(p)
+	}
+	if a.enabled&modTCPHealth != 0 {
+		a.tcpHealth.update(p)
+	}
+	if a.enabled&modRTT != 0 {
+		a.rtt.update(p)
+	}
+	if a.enabled&modTCPOpts != 0 {
+		a.tcpOpts.update(p)
+	}
+	if a.enabled&modOSFP != 0 {
+		a.osfp.update(p)
+	}
+	if a.enabled&modIPLayer != 0 {
+		a.ipLayer.update(p)
+	}
+	if a.enabled&modICMP != 0 {
+		a.icmp.update(p)
+	}
+	if a.enabled&modTunnel != 0 {
+		a.tunnel.update(p)
+	}
+	for _, st := range a.custom {
+		st.Update(p)
+	}
+}
+
+// finalize computes the flow features. Order matters: rates, ratio and subflow read
+// duration and packet totals written by basic and counts, and custom modules read the
+// built-in features.
+func (a *flowAccumulator) finalize() FlowFeatures {
+	f := FlowFeatures{}
+	if a.enabled&modBasic != 0 {
+		a.basic.finalize(&f)
+	}
+	if a.enabled&modCounts != 0 {
+		a.counts.finalize(&f)
+	}
+	if a.enabled&modPacketLen != 0 {
+		a.packetLen.finalize(&f)
+	}
+	if a.enabled&modIAT != 0 {
+		a.iat.finalize(&f)
+	}
+	if a.enabled&modFlags != 0 {
+		a.flags.finalize(&f)
+	}
+	if a.enabled&modRates != 0 {
+		a.rates.finalize(&f)
+	}
+	if a.enabled&modRatio != 0 {
+		a.ratio.finalize(&f)
+	}
+	if a.enabled&modBulk != 0 {
+		a.bulk.finalize(&f)
+	}
+	if a.enabled&modSubflow != 0 {
+		a.subflow.finalize(&f)
+	}
+	if a.enabled&modActiveIdle != 0 {
+		a.activeIdle.finalize(&f)
+	}
+	if a.enabled&modInitWin != 0 {
+		a.initWin.finalize(&f)
+	}
+	if a.enabled&modTCPHealth != 0 {
+		a.tcpHealth.finalize(&f)
+	}
+	if a.enabled&modRTT != 0 {
+		a.rtt.finalize(&f)
+	}
+	if a.enabled&modTCPOpts != 0 {
+		a.tcpOpts.finalize(&f)
+	}
+	if a.enabled&modOSFP != 0 {
+		a.osfp.finalize(&f)
+	}
+	if a.enabled&modIPLayer != 0 {
+		a.ipLayer.finalize(&f)
+	}
+	if a.enabled&modICMP != 0 {
+		a.icmp.finalize(&f)
+	}
+	if a.enabled&modTunnel != 0 {
+		a.tunnel.finalize(&f)
+	}
+	if a.custom != nil {
+		a.features.finalizeCustom(a.custom, &f)
+	}
+	return f
+}
+
+// flow returns the finished flow: its key, features and metadata. reason is why the flow
+// ended.
+func (a *flowAccumulator) flow(key FlowKey, reason EndReason) FlowWithKey {
+	return FlowWithKey{
+		Key:       key,
+		Features:  a.finalize(),
+		Start:     a.meta.first,
+		End:       a.meta.last,
+		Initiator: a.meta.initiator,
+		Reason:    reason,
+	}
+}
+
+// flowMeta records the flow metadata of FlowWithKey. Unlike the modules it is always
+// updated, so the metadata does not depend on the selected features.
+type flowMeta struct {
+	n         int
+	first     time.Time
+	last      time.Time
+	initiator Endpoint
+	fin, rst  bool // flags of the last packet
+}
+
+func (m *flowMeta) update(p *PacketInfo) {
+	if m.n == 0 {
+		m.first = p.Timestamp
+		m.initiator = initiator(p)
+	}
+	m.n++
+	m.last = p.Timestamp
+	m.fin, m.rst = p.FIN, p.RST
+}
+
+// initiator returns the forward endpoint of p's flow: the sender of a Forward packet,
+// the receiver of a Backward one.
+func initiator(p *PacketInfo) Endpoint {
+	sender := Endpoint{ParseAddr(p.SrcIP), p.SrcPort}
+	receiver := Endpoint{ParseAddr(p.DstIP), p.DstPort}
+	if p.Swapped {
+		sender, receiver = receiver, sender
+	}
+	if p.Direction == Backward {
+		return receiver
+	}
+	return sender
+}
@@ -1,40 +1,47 @@
 package flowmeter
 
-const activeIdleThresholdUs = 1_000_000 // 1 second in microseconds
+// activeIdleState accumulates ActiveTime and IdleTime (min, mean, max, std).
+// Active = continuous period with no gap > Config.ActiveIdleThreshold (1s) between packets.
+// Idle = a gap above the threshold.
+// Durations are in microseconds, matching CICFlowMeter. Packets must arrive sorted by timestamp.
+type activeIdleState struct {
+	thresholdUs                int64
+	n                          int
+	startActiveUs, endActiveUs int64
+	active, idle               RunningStats
+}
 
-// computeActiveIdle fills ActiveTime and IdleTime (min, mean, max, std).
-// Active = continuous period with no gap > 1s between packets. Idle = gap > 1s.
-// Durations are in microseconds, matching CICFlowMeter. Packets must be sorted by timestamp.
-func computeActiveIdle(packets []PacketInfo, f *FlowFeatures) {
-	if len(packets) < 2 {
-		return
+func (s *activeIdleState) update(p *PacketInfo) {
+	tsUs := p.Timestamp.UnixMicro()
+	if s.n == 0 {
+		s.startActiveUs = tsUs
+		s.endActiveUs = tsUs
+	} else if gapUs := tsUs - s.endActiveUs; gapUs > s.thresholdUs {
+		if s.endActiveUs-s.startActiveUs > 0 {
+			s.active.Add(float64(s.endActiveUs - s.startActiveUs))
+		}
+		s.idle.Add(float64(gapUs))
+		s.startActiveUs = tsUs
+		s.endActiveUs = tsUs
+	} else {
+		s.endActiveUs = tsUs
 	}
-	var activeDurations, idleDurations []float64
-	startActiveUs := packets[0].Timestamp.UnixMicro()
-	endActiveUs := packets[0].Timestamp.UnixMicro()
+	s.n++
+}
 
-	for i := 1; i < len(packets); i++ {
-		tsUs := packets[i].Timestamp.UnixMicro()
-		gapUs := tsUs - endActiveUs
-		if gapUs > activeIdleThresholdUs {
-			if endActiveUs-startActiveUs > 0 {
-				activeDurations = append(activeDurations, float64(endActiveUs-startActiveUs))
-			}
-			idleDurations = append(idleDurations, float64(gapUs))
-			startActiveUs = tsUs
-			endActiveUs = tsUs
-		} else {
-			endActiveUs = tsUs
-		}
+func (s *activeIdleState) finalize(f *FlowFeatures) {
+	if s.n < 2 {
+		return
 	}
-	if endActiveUs-startActiveUs > 0 {
-		activeDurations = append(activeDurations, float64(endActiveUs-startActiveUs))
+	// The last active period is still open; close it on a copy so finalize is repeatable.
+	active := s.active
+	if s.endActiveUs-s.startActiveUs > 0 {
+		active.Add(float64(s.endActiveUs - s.startActiveUs))
 	}
-
-	if len(activeDurations) > 0 {
-		f.ActiveTime = StatsFromValues(activeDurations)
+	if active.Count() > 0 {
+		f.ActiveTime = active.Stats()
 	}
-	if len(idleDurations) > 0 {
-		f.IdleTime = StatsFromValues(idleDurations)
+	if s.idle.Count() > 0 {
+		f.IdleTime = s.idle.Stats()
 	}
 }
@@ -1,25 +1,36 @@
 package flowmeter
 
-// computeBasic fills flow duration, flow bytes/s, and flow packets/s.
-func computeBasic(packets []PacketInfo, f *FlowFeatures) {
-	if len(packets) == 0 {
-		return
-	}
-	first := packets[0].Timestamp
-	last := packets[len(packets)-1].Timestamp
-	dur := last.Sub(first)
-	f.FlowDurationUs = dur.Microseconds()
+import "time"
+
+// basicState accumulates flow duration, flow bytes/s, and flow packets/s.
+type basicState struct {
+	n          int
+	first      time.Time
+	last       time.Time
+	totalBytes int64
+}
 
+func (s *basicState) update(p *PacketInfo) {
+	if s.n == 0 {
+		s.first = p.Timestamp
+	}
+	s.n++
+	s.last = p.Timestamp
 	// Flow bytes = sum of payload (TCP/UDP payload only), matching CICFlowMeter.
-	var totalBytes int64
-	for _, p := range packets {
-		totalBytes += int64(p.PayloadSize)
+	s.totalBytes += int64(p.PayloadSize)
+}
+
+func (s *basicState) finalize(f *FlowFeatures) {
+	if s.n == 0 {
+		return
 	}
+	dur := s.last.Sub(s.first)
+	f.FlowDurationUs = dur.Microseconds()
 
 	durSec := float64(f.FlowDurationUs) / 1e6
 	if durSec > 0 {
-		f.FlowBytesPerSec = float64(totalBytes) / durSec
-		f.FlowPacketsPerSec = float64(len(packets)) / durSec
+		f.FlowBytesPerSec = float64(s.totalBytes) / durSec
+		f.FlowPacketsPerSec = float64(s.n) / durSec
 	}
 	// When duration is 0 (single packet or same timestamp), leave rates at 0 to match CIC getfPktsPerSecond/getbPktsPerSecond.
 }
@@ -1,7 +1,5 @@
 package flowmeter
 
-const bulkIdleThresholdUs = 1_000_000 // 1 second in microseconds
-
 // bulkDirState holds state for computing bulk stats in one direction.
 type bulkDirState struct {
 	start, lastTs                        int64
@@ -12,7 +10,8 @@ type bulkDirState struct {
 // updateBulkDir updates state for one packet in the given direction.
 // otherLastTs is the timestamp of the most recent packet in the opposite direction;
 // if otherLastTs > state.start the current bulk is reset (cross-direction interrupt).
-func updateBulkDir(state *bulkDirState, ts, size int64, otherLastTs int64) {
+// idleThresholdUs is the largest gap inside a bulk; minPackets packets make a bulk.
+func updateBulkDir(state *bulkDirState, ts, size int64, otherLastTs int64, idleThresholdUs int64, minPackets int) {
 	if otherLastTs > state.start {
 		state.start = 0
 	}
@@ -26,7 +25,7 @@ func updateBulkDir(state *bulkDirState, ts, size int64, otherLastTs int64) {
 		state.sizeHelper = size
 		return
 	}
-	if (ts - state.lastTs) > bulkIdleThresholdUs {
+	if (ts - state.lastTs) > idleThresholdUs {
 		state.start = ts
 		state.lastTs = ts
 		state.pktHelper = 1
@@ -35,12 +34,12 @@ func updateBulkDir(state *bulkDirState, ts, size int64, otherLastTs int64) {
 	}
 	state.pktHelper++
 	state.sizeHelper += size
-	if state.pktHelper == 4 {
+	if state.pktHelper == minPackets {
 		state.stateCount++
-		state.pktTotal += 4
+		state.pktTotal += int64(minPackets)
 		state.sizeTotal += state.sizeHelper
 		state.durUs += ts - state.start
-	} else if state.pktHelper > 4 {
+	} else if state.pktHelper > minPackets {
 		state.pktTotal++
 		state.sizeTotal += size
 		state.durUs += ts - state.lastTs
@@ -48,40 +47,41 @@ func updateBulkDir(state *bulkDirState, ts, size int64, otherLastTs int64) {
 	state.lastTs = ts
 }
 
-// computeBulk fills Fwd/Bwd avg bytes per bulk, avg packets per bulk, and avg bulk rate.
-// A bulk is a run of at least 4 same-direction packets with payload > 0 and gap <= 1s.
-// Packets must be sorted by timestamp.
-func computeBulk(packets []PacketInfo, f *FlowFeatures) {
-	if len(packets) == 0 {
-		return
-	}
-	var fState, bState bulkDirState
-	var lastFwdTs, lastBwdTs int64
+// bulkState accumulates Fwd/Bwd avg bytes per bulk, avg packets per bulk, and avg bulk rate.
+// A bulk is a run of at least Config.BulkMinPackets (4) same-direction packets with
+// payload > 0 and gap <= Config.BulkIdleThreshold (1s). Packets must arrive sorted by timestamp.
+type bulkState struct {
+	idleThresholdUs      int64
+	minPackets           int
+	fwd, bwd             bulkDirState
+	lastFwdTs, lastBwdTs int64
+}
 
-	for _, p := range packets {
-		ts := p.Timestamp.UnixMicro()
-		size := int64(p.PayloadSize)
-		if p.Direction == Forward {
-			updateBulkDir(&fState, ts, size, lastBwdTs)
-			lastFwdTs = ts
-		} else {
-			updateBulkDir(&bState, ts, size, lastFwdTs)
-			lastBwdTs = ts
-		}
+func (s *bulkState) update(p *PacketInfo) {
+	ts := p.Timestamp.UnixMicro()
+	size := int64(p.PayloadSize)
+	if p.Direction == Forward {
+		updateBulkDir(&s.fwd, ts, size, s.lastBwdTs, s.idleThresholdUs, s.minPackets)
+		s.lastFwdTs = ts
+	} else {
+		updateBulkDir(&s.bwd, ts, size, s.lastFwdTs, s.idleThresholdUs, s.minPackets)
+		s.lastBwdTs = ts
 	}
+}
 
-	if fState.stateCount > 0 {
-		f.FwdAvgBytesPerBulk = float64(fState.sizeTotal) / float64(fState.stateCount)
-		f.FwdAvgPacketsPerBulk = float64(fState.pktTotal) / float64(fState.stateCount)
-		if fState.durUs > 0 {
-			f.FwdAvgBulkRate = float64(fState.sizeTotal) / (float64(fState.durUs) / 1e6)
+func (s *bulkState) finalize(f *FlowFeatures) {
+	if s.fwd.stateCount > 0 {
+		f.FwdAvgBytesPerBulk = float64(s.fwd.sizeTotal) / float64(s.fwd.stateCount)
+		f.FwdAvgPacketsPerBulk = float64(s.fwd.pktTotal) / float64(s.fwd.stateCount)
+		if s.fwd.durUs > 0 {
+			f.FwdAvgBulkRate = float64(s.fwd.sizeTotal) / (float64(s.fwd.durUs) / 1e6)
 		}
 	}
-	if bState.stateCount > 0 {
-		f.BwdAvgBytesPerBulk = float64(bState.sizeTotal) / float64(bState.stateCount)
-		f.BwdAvgPacketsPerBulk = float64(bState.pktTotal) / float64(bState.stateCount)
-		if bState.durUs > 0 {
-			f.BwdAvgBulkRate = float64(bState.sizeTotal) / (float64(bState.durUs) / 1e6)
+	if s.bwd.stateCount > 0 {
+		f.BwdAvgBytesPerBulk = float64(s.bwd.sizeTotal) / float64(s.bwd.stateCount)
+		f.BwdAvgPacketsPerBulk = float64(s.bwd.pktTotal) / float64(s.bwd.stateCount)
+		if s.bwd.durUs > 0 {
+			f.BwdAvgBulkRate = float64(s.bwd.sizeTotal) / (float64(s.bwd.durUs) / 1e6)
 		}
 	}
 }
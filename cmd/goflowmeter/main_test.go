@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Bi9River/goflowmeter"
+)
+
+// writePcap writes a classic pcap with two Ethernet/IPv4/UDP packets of one connection,
+// from 192.168.0.client:5000 to 192.168.0.server:53 and back.
+func writePcap(t *testing.T, path string, client, server byte) {
+	t.Helper()
+	udp := func(src, dst byte, sport, dport uint16) []byte {
+		b := make([]byte, 14+20+8+10)
+		binary.BigEndian.PutUint16(b[12:14], 0x0800)
+		ip := b[14:]
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:4], 38)
+		ip[9] = 17
+		copy(ip[12:16], []byte{192, 168, 0, src})
+		copy(ip[16:20], []byte{192, 168, 0, dst})
+		binary.BigEndian.PutUint16(ip[20:22], sport)
+		binary.BigEndian.PutUint16(ip[22:24], dport)
+		binary.BigEndian.PutUint16(ip[24:26], 18)
+		return b
+	}
+	frames := [][]byte{udp(client, server, 5000, 53), udp(server, client, 53, 5000)}
+	out := make([]byte, 24)
+	binary.LittleEndian.PutUint32(out[0:4], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint32(out[16:20], 65535)
+	binary.LittleEndian.PutUint32(out[20:24], 1)
+	for i, fr := range frames {
+		rh := make([]byte, 16)
+		binary.LittleEndian.PutUint32(rh[0:4], 1_700_000_000)
+		binary.LittleEndian.PutUint32(rh[4:8], uint32(i*1000))
+		binary.LittleEndian.PutUint32(rh[8:12], uint32(len(fr)))
+		binary.LittleEndian.PutUint32(rh[12:16], uint32(len(fr)))
+		out = append(append(out, rh...), fr...)
+	}
+	if err := os.WriteFile(path, out, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRun_WritesCICCSV(t *testing.T) {
+	dir := t.TempDir()
+	writePcap(t, filepath.Join(dir, "a.pcap"), 1, 2)
+	outPath := filepath.Join(dir, "flows.csv")
+	if err := run([]string{dir}, outPath, options{label: "BENIGN"}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	f, err := os.Open(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("reading CSV: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("expected header + 1 flow, got %d rows", len(rows))
+	}
+	header, row := rows[0], rows[1]
+	if len(header) != 84 || header[0] != "Flow ID" || header[7] != "Flow Duration" || header[83] != "Label" {
+		t.Errorf("unexpected header (len %d): %v", len(header), header)
+	}
+	if row[0] != "192.168.0.1-192.168.0.2-5000-53-17" || row[5] != "17" || row[83] != "BENIGN" {
+		t.Errorf("unexpected row: id=%q proto=%q label=%q", row[0], row[5], row[83])
+	}
+	if row[7] != "1000" || row[8] != "1" || row[9] != "1" {
+		t.Errorf("expected duration 1000us and 1 fwd + 1 bwd packet, got %q %q %q", row[7], row[8], row[9])
+	}
+}
+
+func TestRun_WritesJSONLines(t *testing.T) {
+	dir := t.TempDir()
+	writePcap(t, filepath.Join(dir, "a.pcap"), 1, 2)
+	outPath := filepath.Join(dir, "flows.jsonl")
+	if err := run([]string{dir}, outPath, options{label: "BENIGN", format: "jsonl"}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	b, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]any
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatalf("expected one JSON object, got %q: %v", b, err)
+	}
+	if m["flow_id"] != "192.168.0.1-192.168.0.2-5000-53-17" || m["flow_duration"] != 1000.0 || m["label"] != "BENIGN" {
+		t.Errorf("unexpected flow: %v", m)
+	}
+}
+
+func TestRun_InitiatorOrder(t *testing.T) {
+	dir := t.TempDir()
+	writePcap(t, filepath.Join(dir, "a.pcap"), 2, 1)
+	outPath := filepath.Join(dir, "flows.csv")
+	opts := options{cicFlowID: true, flowInfo: true}
+	opts.cfg.KeyOrder = flowmeter.KeyOrderInitiator
+	if err := run([]string{dir}, outPath, opts); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	f, err := os.Open(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("reading CSV: %v", err)
+	}
+	if len(rows) != 2 || rows[0][7] != "CIC Flow ID" || rows[0][9] != "Initiator IP" {
+		t.Fatalf("expected one flow with the CIC Flow ID and flow information columns, got %v", rows)
+	}
+	row := rows[1]
+	if row[0] != "192.168.0.2-192.168.0.1-5000-53-17" || row[1] != "192.168.0.2" || row[7] != "192.168.0.1-192.168.0.2-53-5000-17" || row[9] != "192.168.0.2" {
+		t.Errorf("expected the client 192.168.0.2 first, got id=%q src=%q cic=%q initiator=%q", row[0], row[1], row[7], row[9])
+	}
+}
+
+func TestRun_UnknownFormat(t *testing.T) {
+	dir := t.TempDir()
+	writePcap(t, filepath.Join(dir, "a.pcap"), 1, 2)
+	if err := run([]string{dir}, filepath.Join(dir, "out"), options{format: "xml"}); err == nil {
+		t.Error("expected an error for format xml")
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/Bi9River/goflowmeter/writer"
+)
+
+// nonFinitePolicies maps the -non-finite values to writer policies.
+var nonFinitePolicies = map[string]writer.NonFinite{
+	"keep":  writer.NonFiniteKeep,
+	"zero":  writer.NonFiniteZero,
+	"error": writer.NonFiniteError,
+}
+
+// compressions maps the -compression values to Parquet codecs.
+var compressions = map[string]writer.Compression{
+	"snappy": writer.CompressionSnappy,
+	"zstd":   writer.CompressionZstd,
+	"gzip":   writer.CompressionGzip,
+	"none":   writer.CompressionNone,
+}
+
+// newWriter returns the flow writer of opts.format ("" is csv).
+func newWriter(w io.Writer, opts options) (writer.FlowWriter, error) {
+	wo := writer.Options{
+		KeyFields: opts.cfg.KeyFields,
+		Label:     opts.label,
+		Precision: opts.precision,
+		NonFinite: opts.nonFinite,
+		FlowInfo:  opts.flowInfo,
+		CICFlowID: opts.cicFlowID,
+	}
+	switch opts.format {
+	case "", "csv":
+		return writer.NewCSV(w, wo), nil
+	case "jsonl":
+		return writer.NewJSONLines(w, wo), nil
+	case "parquet":
+		return writer.NewParquet(w, writer.ParquetOptions{Options: wo, Compression: opts.compression})
+	}
+	return nil, fmt.Errorf("unknown output format %q (want csv, jsonl or parquet)", opts.format)
+}
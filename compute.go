@@ -1,45 +1,165 @@
 package flowmeter
 
-import "sort"
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
-// FlowWithKey pairs a flow key with its computed features (for per-IP aggregation by SrcIP).
+// FlowWithKey pairs a flow key with its computed features (for per-IP aggregation by SrcIP)
+// and the flow's metadata.
 type FlowWithKey struct {
 	Key      FlowKey
 	Features FlowFeatures
+	// Start and End are the timestamps of the flow's first and last packets.
+	Start time.Time
+	End   time.Time
+	// Initiator is the endpoint that sent the flow's Forward packets (the first packet's
+	// sender, or an ICMP flow's requester). Unless Config.KeyOrder is KeyOrderInitiator,
+	// Key may list the initiator second; the responder is Key's other endpoint.
+	Initiator Endpoint
+	// Reason is why the flow ended.
+	Reason EndReason
+}
+
+// InitiatorKey returns Key oriented by the initiator: Src is the endpoint that sent the
+// forward packets, the client of a connection. Grouping or aggregating by its SrcIP
+// groups by client, where Key's canonical order would pick the smaller address. It is
+// Key if the initiator is already first or unknown.
+func (f *FlowWithKey) InitiatorKey() FlowKey {
+	k := f.Key
+	in := f.Initiator
+	if in.Addr == k.DstAddr && in.Port == k.DstPort && (in.Addr != k.SrcAddr || in.Port != k.SrcPort) {
+		return k.reversed()
+	}
+	return k
 }
 
 // ProcessPacketsWithKeys groups packets by flow (5-tuple), then computes flow features
 // for each flow. Call once per time window's packet set. Returns one FlowWithKey per flow
-// so the caller always knows which features belong to which flow; order is undefined.
+// so the caller always knows which features belong to which flow; flows are returned in
+// order of their first packet in the input.
+// It is ProcessPacketsWithConfig with DefaultConfig.
 func ProcessPacketsWithKeys(packets []PacketInfo) []FlowWithKey {
+	return ProcessPacketsWithConfig(packets, DefaultConfig())
+}
+
+// ProcessPacketsWithConfig is ProcessPacketsWithKeys with the thresholds taken from cfg.
+// cfg.KeyFields adds VLAN, tunnel ID, observation domain or MACs to the flow key.
+// The timeouts in cfg only apply to FlowTable; a window is never split here.
+// With cfg.Workers > 1 keys and flows are computed by that many goroutines; the result
+// is the same as with one worker. packets is not modified. With KeyOrderInitiator the
+// output keys list each flow's initiator first.
+func ProcessPacketsWithConfig(packets []PacketInfo, cfg Config) []FlowWithKey {
 	if len(packets) == 0 {
 		return nil
 	}
-	// Group by canonical flow key (CICFlowMeter format: smaller IP first, then smaller port)
-	byFlow := make(map[FlowKey][]PacketInfo)
-	for _, p := range packets {
-		k := CanonicalFlowKey(p.Key())
-		byFlow[k] = append(byFlow[k], p)
-	}
-	out := make([]FlowWithKey, 0, len(byFlow))
-	for key, flowPackets := range byFlow {
-		// Sort by time for duration, IAT, and other time-based features
-		sort.Slice(flowPackets, func(i, j int) bool {
-			return flowPackets[i].Timestamp.Before(flowPackets[j].Timestamp)
-		})
-		f := FlowFeatures{}
-		computeBasic(flowPackets, &f)
-		computeCounts(flowPackets, &f)
-		computePacketLen(flowPackets, &f)
-		computeIAT(flowPackets, &f)
-		computeFlags(flowPackets, &f)
-		computeRates(flowPackets, &f)
-		computeRatio(flowPackets, &f)
-		computeBulk(flowPackets, &f)
-		computeSubflow(flowPackets, &f)
-		computeActiveIdle(flowPackets, &f)
-		computeInitWin(flowPackets, &f)
-		out = append(out, FlowWithKey{Key: key, Features: f})
+	cfg = cfg.withDefaults()
+	// Canonical flow key per packet (cfg.KeyOrder decides which endpoint comes first)
+	keys := make([]FlowKey, len(packets))
+	parallelFor(len(packets), packetChunk, cfg.Workers, func(start, end int) {
+		for i := start; i < end; i++ {
+			keys[i] = cfg.KeyOrder.Canonical(packets[i].KeyWith(cfg.KeyFields))
+		}
+	})
+	// Number flows in order of first appearance
+	index := make(map[FlowKey]int32)
+	flowOf := make([]int32, len(packets))
+	var firstPacket []int32
+	for i := range keys {
+		f, ok := index[keys[i]]
+		if !ok {
+			f = int32(len(firstPacket))
+			index[keys[i]] = f
+			firstPacket = append(firstPacket, int32(i))
+		}
+		flowOf[i] = f
 	}
+	out := make([]FlowWithKey, len(firstPacket))
+	for f, i := range firstPacket {
+		out[f].Key = keys[i]
+	}
+	// Bucket packet indices by flow; the counting sort keeps input order within a flow,
+	// so each flow sees its packets exactly as a stable sort of the input would.
+	offsets := make([]int32, len(out)+1)
+	for _, f := range flowOf {
+		offsets[f+1]++
+	}
+	for f := 1; f < len(offsets); f++ {
+		offsets[f] += offsets[f-1]
+	}
+	order := make([]int32, len(packets))
+	fill := append([]int32(nil), offsets[:len(out)]...)
+	for i, f := range flowOf {
+		order[fill[f]] = int32(i)
+		fill[f]++
+	}
+	parallelFor(len(out), flowChunk, cfg.Workers, func(start, end int) {
+		for f := start; f < end; f++ {
+			out[f] = computeFlow(out[f].Key, packets, order[offsets[f]:offsets[f+1]], &cfg)
+		}
+	})
 	return out
 }
+
+// Work is handed to workers in chunks so the shared counter stays off the hot path while
+// windows with a few very large flows still balance.
+const (
+	packetChunk = 4096
+	flowChunk   = 256
+)
+
+// parallelFor calls fn on consecutive [start, end) ranges covering [0, n). With more than
+// one worker the ranges are claimed from a shared counter by up to workers goroutines;
+// fn must only write state owned by its range.
+func parallelFor(n, chunk, workers int, fn func(start, end int)) {
+	if workers <= 1 || n <= chunk {
+		fn(0, n)
+		return
+	}
+	if c := (n + chunk - 1) / chunk; workers > c {
+		workers = c
+	}
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				start := int(next.Add(int64(chunk))) - chunk
+				if start >= n {
+					return
+				}
+				fn(start, min(start+chunk, n))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// computeFlow sorts one flow's packet indices by time and feeds the packets to a
+// flowAccumulator. The flow ends with the window unless its last packet carries FIN
+// or RST.
+func computeFlow(key FlowKey, packets []PacketInfo, idx []int32, cfg *Config) FlowWithKey {
+	// Sort by time for duration, IAT, and other time-based features
+	sort.SliceStable(idx, func(i, j int) bool {
+		return packets[idx[i]].Timestamp.Before(packets[idx[j]].Timestamp)
+	})
+	var acc flowAccumulator
+	acc.init(cfg)
+	for _, i := range idx {
+		acc.update(&packets[i])
+	}
+	reason := EndWindow
+	switch {
+	case acc.meta.rst:
+		reason = EndRST
+	case acc.meta.fin:
+		reason = EndFIN
+	}
+	fl := acc.flow(key, reason)
+	cfg.KeyOrder.orient(&fl)
+	return fl
+}
@@ -0,0 +1,127 @@
+package flowmeter
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// syntheticWindow returns nFlows TCP flows of pktsPerFlow packets each, interleaved the
+// way a capture window would be (flow i's packet j at j*100ms + i µs).
+func syntheticWindow(nFlows, pktsPerFlow int) []PacketInfo {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	packets := make([]PacketInfo, 0, nFlows*pktsPerFlow)
+	for j := 0; j < pktsPerFlow; j++ {
+		for i := 0; i < nFlows; i++ {
+			dir := Forward
+			if j%3 == 1 {
+				dir = Backward
+			}
+			packets = append(packets, PacketInfo{
+				Timestamp:   base.Add(time.Duration(j)*100*time.Millisecond + time.Duration(i)*time.Microsecond),
+				Direction:   dir,
+				HeaderLen:   20,
+				PayloadSize: 100 + (i+j)%1400,
+				TCPWindow:   8192,
+				SrcIP:       fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff),
+				DstIP:       "192.168.0.1",
+				SrcPort:     uint16(1024 + i%60000),
+				DstPort:     443,
+				Protocol:    6,
+				SYN:         j == 0,
+				ACK:         j > 0,
+				PSH:         j%2 == 0,
+			})
+		}
+	}
+	return packets
+}
+
+func TestProcessPackets_Workers_Deterministic(t *testing.T) {
+	packets := syntheticWindow(3000, 6)
+	want := ProcessPacketsWithConfig(append([]PacketInfo(nil), packets...), Config{Workers: 1})
+	if len(want) != 3000 {
+		t.Fatalf("expected 3000 flows, got %d", len(want))
+	}
+	for _, workers := range []int{2, 7, 64} {
+		got := ProcessPacketsWithConfig(append([]PacketInfo(nil), packets...), Config{Workers: workers})
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("workers=%d: result differs from sequential", workers)
+		}
+	}
+}
+
+func TestProcessPackets_FirstSeenOrder(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	packets := []PacketInfo{
+		{Timestamp: base, Direction: Forward, SrcIP: "3.3.3.3", DstIP: "4.4.4.4", SrcPort: 1, DstPort: 2, Protocol: 17},
+		{Timestamp: base, Direction: Forward, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 1, DstPort: 2, Protocol: 17},
+		{Timestamp: base, Direction: Backward, SrcIP: "3.3.3.3", DstIP: "4.4.4.4", SrcPort: 1, DstPort: 2, Protocol: 17},
+	}
+	pairs := ProcessPacketsWithKeys(packets)
+	if len(pairs) != 2 || pairs[0].Key.SrcIP() != "3.3.3.3" || pairs[1].Key.SrcIP() != "1.1.1.1" {
+		t.Fatalf("expected flows in first-seen order 3.3.3.3, 1.1.1.1, got %+v", pairs)
+	}
+}
+
+func TestProcessPackets_FlowMetadata(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	// 2.2.2.2 opens the connection, but the canonical key lists 1.1.1.1 first.
+	raw := []RawPacket{
+		{Timestamp: base, SrcIP: "2.2.2.2", DstIP: "1.1.1.1", SrcPort: 40000, DstPort: 80, Protocol: 6, SYN: true},
+		{Timestamp: base.Add(time.Millisecond), SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 80, DstPort: 40000, Protocol: 6, SYN: true, ACK: true},
+		{Timestamp: base.Add(5 * time.Millisecond), SrcIP: "2.2.2.2", DstIP: "1.1.1.1", SrcPort: 40000, DstPort: 80, Protocol: 6, FIN: true, ACK: true},
+		// An echo reply seen before its request: the requester 3.3.3.3 initiated.
+		{Timestamp: base.Add(time.Millisecond), SrcIP: "4.4.4.4", DstIP: "3.3.3.3", Protocol: 1, ICMPType: 0, ICMPID: 7},
+		{Timestamp: base.Add(2 * time.Millisecond), SrcIP: "3.3.3.3", DstIP: "4.4.4.4", Protocol: 1, ICMPType: 8, ICMPID: 7},
+	}
+	reg := NewRegistry()
+	if err := reg.Select("Flow IAT Mean"); err != nil {
+		t.Fatal(err)
+	}
+	flows := ProcessPacketsWithConfig(ConvertToPacketInfo(raw), Config{Features: reg})
+	if len(flows) != 2 {
+		t.Fatalf("expected 2 flows, got %d", len(flows))
+	}
+	tcp, icmp := flows[0], flows[1]
+	if tcp.Key.Protocol != 6 {
+		tcp, icmp = icmp, tcp
+	}
+	if tcp.Key.SrcIP() != "1.1.1.1" || tcp.Initiator.IP() != "2.2.2.2" || tcp.Initiator.Port != 40000 {
+		t.Errorf("expected key from 1.1.1.1 and initiator 2.2.2.2:40000, got %s and %s:%d", tcp.Key.SrcIP(), tcp.Initiator.IP(), tcp.Initiator.Port)
+	}
+	// The times do not depend on the selected features.
+	if !tcp.Start.Equal(base) || !tcp.End.Equal(base.Add(5*time.Millisecond)) || tcp.Features.FlowDurationUs != 0 {
+		t.Errorf("expected %v-%v without Flow Duration, got %v-%v and %d", base, base.Add(5*time.Millisecond), tcp.Start, tcp.End, tcp.Features.FlowDurationUs)
+	}
+	if tcp.Reason != EndFIN || icmp.Reason != EndWindow {
+		t.Errorf("expected fin and window, got %v and %v", tcp.Reason, icmp.Reason)
+	}
+	if icmp.Initiator.IP() != "3.3.3.3" || !icmp.Start.Equal(base.Add(time.Millisecond)) {
+		t.Errorf("expected 3.3.3.3 to initiate at %v, got %s at %v", base.Add(time.Millisecond), icmp.Initiator.IP(), icmp.Start)
+	}
+}
+
+func BenchmarkProcessPackets_100kFlows(b *testing.B) {
+	const nFlows, pktsPerFlow = 100_000, 10
+	packets := syntheticWindow(nFlows, pktsPerFlow)
+	work := make([]PacketInfo, len(packets))
+	counts := []int{1, 4}
+	if n := runtime.GOMAXPROCS(0); n != 1 && n != 4 {
+		counts = append(counts, n)
+	}
+	for _, workers := range counts {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			cfg := Config{Workers: workers}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				copy(work, packets)
+				ProcessPacketsWithConfig(work, cfg)
+			}
+			b.ReportMetric(float64(nFlows*b.N)/b.Elapsed().Seconds(), "flows/s")
+			b.ReportMetric(float64(len(packets)*b.N)/b.Elapsed().Seconds(), "packets/s")
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package flowmeter
+
+import (
+	"time"
+
+	"github.com/Bi9River/goflowmeter/p0f"
+)
+
+// Config holds the tunable thresholds and timeouts of the flowmeter. DefaultConfig
+// reproduces the CICFlowMeter-compatible behavior of ProcessPacketsWithKeys; zero fields
+// take their DefaultConfig value (IdleTimeout's default, 0, disables it).
+type Config struct {
+	// FlowTimeout ends a flow this long after its first packet (streaming only).
+	FlowTimeout time.Duration
+	// IdleTimeout ends a flow after this long without packets (streaming only); 0 disables.
+	IdleTimeout time.Duration
+	// ActiveIdleThreshold is the gap that separates active periods (CICFlowMeter's
+	// "activity timeout" used for the Active/Idle features).
+	ActiveIdleThreshold time.Duration
+	// BulkIdleThreshold is the largest same-direction gap inside a bulk.
+	BulkIdleThreshold time.Duration
+	// BulkMinPackets is the number of consecutive packets with payload that form a bulk.
+	BulkMinPackets int
+	// SubflowIdleThreshold is the gap that starts a new subflow.
+	SubflowIdleThreshold time.Duration
+	// KeyOrder decides which endpoint comes first in output flow keys; the zero value is
+	// KeyOrderNumeric. KeyOrderInitiator puts each flow's initiator first.
+	KeyOrder KeyOrder
+	// KeyFields adds VLAN, tunnel ID, observation domain or MAC addresses to flow keys;
+	// the zero value keys flows on the 5-tuple.
+	KeyFields KeyFields
+	// OSSignatures labels SYN fingerprints with the best p0f match (see p0f.LoadFile);
+	// nil computes the fingerprints only.
+	OSSignatures *p0f.DB
+	// Features selects the built-in feature modules to run and adds custom ones; nil runs
+	// every built-in module. The Registry must not change while flows are computed.
+	Features *Registry
+	// Workers is the number of goroutines ProcessPacketsWithConfig computes flows with;
+	// 0 or 1 computes them sequentially. runtime.GOMAXPROCS(0) uses every CPU.
+	Workers int
+}
+
+// DefaultConfig returns the CICFlowMeter-compatible configuration: 120 s flow timeout,
+// no idle timeout, 1 s active/idle, bulk and subflow thresholds, and 4-packet bulks.
+func DefaultConfig() Config {
+	return Config{
+		FlowTimeout:          DefaultFlowTimeout,
+		ActiveIdleThreshold:  time.Second,
+		BulkIdleThreshold:    time.Second,
+		BulkMinPackets:       4,
+		SubflowIdleThreshold: time.Second,
+	}
+}
+
+// withDefaults returns cfg with zero fields replaced by their DefaultConfig values.
+func (cfg Config) withDefaults() Config {
+	def := DefaultConfig()
+	if cfg.FlowTimeout <= 0 {
+		cfg.FlowTimeout = def.FlowTimeout
+	}
+	if cfg.IdleTimeout < 0 {
+		cfg.IdleTimeout = 0
+	}
+	if cfg.ActiveIdleThreshold <= 0 {
+		cfg.ActiveIdleThreshold = def.ActiveIdleThreshold
+	}
+	if cfg.BulkIdleThreshold <= 0 {
+		cfg.BulkIdleThreshold = def.BulkIdleThreshold
+	}
+	if cfg.BulkMinPackets <= 0 {
+		cfg.BulkMinPackets = def.BulkMinPackets
+	}
+	if cfg.SubflowIdleThreshold <= 0 {
+		cfg.SubflowIdleThreshold = def.SubflowIdleThreshold
+	}
+	return cfg
+}
@@ -0,0 +1,67 @@
+package flowmeter
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestProcessPackets_Config_ZeroValueIsDefault(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	packets := []PacketInfo{
+		{Timestamp: base, PayloadSize: 100, Direction: Forward, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 1, DstPort: 2, Protocol: 6},
+		{Timestamp: base.Add(100 * time.Millisecond), PayloadSize: 100, Direction: Forward, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 1, DstPort: 2, Protocol: 6},
+		{Timestamp: base.Add(1500 * time.Millisecond), PayloadSize: 50, Direction: Backward, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 1, DstPort: 2, Protocol: 6},
+	}
+	want := ProcessPacketsWithKeys(append([]PacketInfo(nil), packets...))
+	got := ProcessPacketsWithConfig(append([]PacketInfo(nil), packets...), Config{})
+	if len(got) != 1 || len(want) != 1 {
+		t.Fatalf("expected 1 flow each, got %d and %d", len(got), len(want))
+	}
+	if !reflect.DeepEqual(got[0].Features, want[0].Features) {
+		t.Errorf("zero Config differs from DefaultConfig:\n got %+v\nwant %+v", got[0].Features, want[0].Features)
+	}
+}
+
+func TestProcessPackets_Config_ThresholdsApplied(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Forward packets 300ms apart: no idle period and no subflow gap at the defaults,
+	// two of each with a 200ms threshold. Three payload packets form a bulk only when
+	// BulkMinPackets is lowered to 3.
+	packets := []PacketInfo{
+		{Timestamp: base, PayloadSize: 100, Direction: Forward, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 1, DstPort: 2, Protocol: 6},
+		{Timestamp: base.Add(300 * time.Millisecond), PayloadSize: 100, Direction: Forward, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 1, DstPort: 2, Protocol: 6},
+		{Timestamp: base.Add(600 * time.Millisecond), PayloadSize: 100, Direction: Forward, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 1, DstPort: 2, Protocol: 6},
+	}
+	def := ProcessPacketsWithKeys(append([]PacketInfo(nil), packets...))[0].Features
+	if def.IdleTime.Max != 0 || def.SubflowFwdPackets != 0 || def.FwdAvgPacketsPerBulk != 0 {
+		t.Fatalf("defaults: expected no idle, no subflow gaps, no bulk, got idle=%f subflow=%f bulk=%f", def.IdleTime.Max, def.SubflowFwdPackets, def.FwdAvgPacketsPerBulk)
+	}
+
+	cfg := DefaultConfig()
+	cfg.ActiveIdleThreshold = 200 * time.Millisecond
+	cfg.SubflowIdleThreshold = 200 * time.Millisecond
+	cfg.BulkMinPackets = 3
+	f := ProcessPacketsWithConfig(append([]PacketInfo(nil), packets...), cfg)[0].Features
+	idleUs := float64((300 * time.Millisecond).Microseconds())
+	if f.IdleTime.Mean != idleUs || f.IdleTime.Max != idleUs {
+		t.Errorf("IdleTime: expected %.0f µs, got mean=%f max=%f", idleUs, f.IdleTime.Mean, f.IdleTime.Max)
+	}
+	// 3 packets / 2 gaps (CICFlowMeter divides by the number of gaps)
+	if f.SubflowFwdPackets != 1.5 {
+		t.Errorf("SubflowFwdPackets: expected 1.5, got %f", f.SubflowFwdPackets)
+	}
+	if f.FwdAvgPacketsPerBulk != 3 {
+		t.Errorf("FwdAvgPacketsPerBulk: expected 3, got %f", f.FwdAvgPacketsPerBulk)
+	}
+}
+
+func TestFlowTable_Config_IdleTimeout(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	table := NewFlowTableWithConfig(Config{IdleTimeout: time.Second})
+	table.Add(PacketInfo{Timestamp: base, Direction: Forward, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 1, DstPort: 2, Protocol: 17})
+	out := table.Add(PacketInfo{Timestamp: base.Add(2 * time.Second), Direction: Forward, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 1, DstPort: 2, Protocol: 17})
+	if len(out) != 1 || out[0].Features.TotalFwdPackets != 1 {
+		t.Fatalf("expected the idle flow to be emitted with 1 packet, got %+v", out)
+	}
+}
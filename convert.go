@@ -1,7 +1,6 @@
 package flowmeter
 
 import (
-	"fmt"
 	"sort"
 	"time"
 )
@@ -14,6 +13,26 @@ type RawPacket struct {
 	HeaderLen   int
 	PayloadSize int
 	TCPWindow   uint16   // TCP window size (from TCP header); 0 for non-TCP or if unknown
+	SeqNum      uint32   // TCP sequence number; 0 for non-TCP or if unknown
+	AckNum      uint32   // TCP acknowledgment number; 0 for non-TCP or if unknown
+	TCPOptions  TCPOptions
+	ICMPType    uint8    // ICMP / ICMPv6 type; 0 for other protocols
+	ICMPCode    uint8    // ICMP / ICMPv6 code
+	ICMPID      uint16   // identifier of ICMP queries and replies (echo, ...); 0 otherwise
+	ICMPSeq     uint16   // sequence number of ICMP queries and replies; 0 otherwise
+	TTL         uint8    // IPv4 TTL or IPv6 hop limit; 0 if unknown
+	DSCP        uint8    // differentiated services code point (upper 6 bits of TOS / traffic class)
+	ECN         uint8    // ECN field (lower 2 bits of TOS / traffic class); 3 = CE
+	DontFragment bool    // IPv4 DF flag
+	MoreFragments bool   // IPv4 MF flag or IPv6 fragment header M flag
+	FragmentOffset uint16 // fragment offset in bytes; 0 for the first or an unfragmented datagram
+	Fragments   int      // IP fragments the packet was reassembled from; 0 if it was not reassembled
+	IPID        uint32   // IPv4 identification, or the IPv6 fragment header's; 0 if none
+	Tunnel      TunnelInfo // tunnel the packet was decapsulated from; zero if none
+	VLANID      uint16   // outermost 802.1Q VLAN ID; 0 if untagged
+	ObservationDomain uint32 // capture interface or observation domain ID; 0 if unknown
+	SrcMAC      MAC      // Ethernet source address; zero if unknown
+	DstMAC      MAC      // Ethernet destination address; zero if unknown
 	SrcIP       string
 	DstIP       string
 	SrcPort     uint16
@@ -29,105 +48,152 @@ type RawPacket struct {
 	ECE         bool
 }
 
-// canonicalFlowKey returns a string key that is the same for (A,B,sp,dp) and (B,A,dp,sp).
-func canonicalFlowKey(srcIP, dstIP string, srcPort, dstPort uint16, protocol uint8) string {
-	c := CanonicalFlowKey(FlowKey{SrcIP: srcIP, DstIP: dstIP, SrcPort: srcPort, DstPort: dstPort, Protocol: protocol})
-	return fmt.Sprintf("%s|%s|%d|%d|%d", c.SrcIP, c.DstIP, c.SrcPort, c.DstPort, c.Protocol)
-}
-
-// CanonicalFlowKey returns the flow key in CICFlowMeter format: smaller IP first (lexicographic),
-// then smaller port if IPs equal. Use this so FlowKey matches CIC's Flow ID / Src-Dst column order.
-func CanonicalFlowKey(k FlowKey) FlowKey {
-	ipMin, ipMax := k.SrcIP, k.DstIP
-	pMin, pMax := k.SrcPort, k.DstPort
-	if k.SrcIP > k.DstIP {
-		ipMin, ipMax = k.DstIP, k.SrcIP
-		pMin, pMax = k.DstPort, k.SrcPort
-	} else if k.SrcIP == k.DstIP && k.SrcPort > k.DstPort {
-		pMin, pMax = k.DstPort, k.SrcPort
-	}
-	return FlowKey{SrcIP: ipMin, DstIP: ipMax, SrcPort: pMin, DstPort: pMax, Protocol: k.Protocol}
-}
-
-// flowIdentity is the normalized 5-tuple we use for all PacketInfo in one flow.
-type flowIdentity struct {
-	SrcIP    string
-	DstIP    string
-	SrcPort  uint16
-	DstPort  uint16
-	Protocol uint8
-}
-
-// forwardEndpoint is (SrcIP, SrcPort) of the first packet in the flow.
-type forwardEndpoint struct {
-	IP   string
-	Port uint16
-}
-
 // ConvertToPacketInfo converts raw packets (e.g. from a PCAP reader) into PacketInfo
 // with direction assigned by the first-packet rule and a consistent 5-tuple per flow.
 // Packets from the same connection (A↔B) are normalized to one flow key; Direction
 // is Forward if the packet was sent by the flow's first sender, else Backward.
+// ICMP and ICMPv6 flows are keyed on ICMPPorts; in query/reply flows (echo, ...) the
+// requester is forward even if the first packet seen is a reply.
 func ConvertToPacketInfo(raw []RawPacket) []PacketInfo {
+	return ConvertToPacketInfoWithFields(raw, 0)
+}
+
+// ConvertToPacketInfoWithFields is ConvertToPacketInfo for flows keyed with extra fields
+// (Config.KeyFields): packets that differ in a selected field belong to different flows,
+// so each gets its own forward endpoint. Each PacketInfo's MAC addresses are swapped
+// along with its normalized 5-tuple, and Swapped records that the packet was sent from
+// the normalized DstIP:DstPort.
+func ConvertToPacketInfoWithFields(raw []RawPacket, fields KeyFields) []PacketInfo {
 	if len(raw) == 0 {
 		return nil
 	}
 	// Group by canonical flow key
 	type flowState struct {
-		identity flowIdentity
-		forward  forwardEndpoint
+		identity FlowKey
+		forward  Endpoint
 		packets  []RawPacket
 	}
-	byFlow := make(map[string]*flowState)
+	byFlow := make(map[FlowKey]*flowState)
 	for i := range raw {
 		r := &raw[i]
-		key := canonicalFlowKey(r.SrcIP, r.DstIP, r.SrcPort, r.DstPort, r.Protocol)
+		key := CanonicalFlowKey(rawKeyWith(r, fields))
 		if byFlow[key] == nil {
-			byFlow[key] = &flowState{packets: nil}
+			byFlow[key] = &flowState{identity: key}
 		}
 		byFlow[key].packets = append(byFlow[key].packets, raw[i])
 	}
-	// Sort each flow by time; first packet defines forward endpoint; identity is canonical (CIC format).
+	// Sort each flow by time; first packet defines forward endpoint; identity is canonical.
 	for _, state := range byFlow {
 		sort.Slice(state.packets, func(i, j int) bool {
 			return state.packets[i].Timestamp.Before(state.packets[j].Timestamp)
 		})
-		first := state.packets[0]
-		can := CanonicalFlowKey(FlowKey{SrcIP: first.SrcIP, DstIP: first.DstIP, SrcPort: first.SrcPort, DstPort: first.DstPort, Protocol: first.Protocol})
-		state.identity = flowIdentity{can.SrcIP, can.DstIP, can.SrcPort, can.DstPort, can.Protocol}
-		state.forward = forwardEndpoint{first.SrcIP, first.SrcPort}
+		first := rawKey(&state.packets[0])
+		if _, reply, _ := icmpRequestType(first.Protocol, state.packets[0].ICMPType); isICMP(first.Protocol) && reply {
+			first = first.reversed() // an ICMP flow's forward side is the requester
+		}
+		state.forward = Endpoint{first.SrcAddr, first.SrcPort}
 	}
 	// Build []PacketInfo with normalized 5-tuple and direction
 	out := make([]PacketInfo, 0, len(raw))
 	for _, state := range byFlow {
 		id := state.identity
+		srcIP, dstIP := id.SrcIP(), id.DstIP()
 		fwd := state.forward
 		for _, r := range state.packets {
+			k := rawKey(&r)
 			dir := Backward
-			if r.SrcIP == fwd.IP && r.SrcPort == fwd.Port {
+			if k.SrcPort == fwd.Port && k.SrcAddr == fwd.Addr {
 				dir = Forward
 			}
-			out = append(out, PacketInfo{
-				Timestamp:   r.Timestamp,
-				Direction:   dir,
-				HeaderLen:   r.HeaderLen,
-				PayloadSize: r.PayloadSize,
-				TCPWindow:   r.TCPWindow,
-				SrcIP:       id.SrcIP,
-				DstIP:       id.DstIP,
-				SrcPort:     id.SrcPort,
-				DstPort:     id.DstPort,
-				Protocol:    id.Protocol,
-				FIN:         r.FIN,
-				SYN:         r.SYN,
-				RST:         r.RST,
-				PSH:         r.PSH,
-				ACK:         r.ACK,
-				URG:         r.URG,
-				CWR:         r.CWR,
-				ECE:         r.ECE,
-			})
+			swapped := k.SrcPort != id.SrcPort || k.SrcAddr != id.SrcAddr
+			p := packetInfo(&r, id, dir, swapped)
+			p.SrcIP, p.DstIP = srcIP, dstIP
+			out = append(out, p)
 		}
 	}
 	return out
 }
+
+// ConvertPacket converts one raw packet for a FlowTable. The 5-tuple is normalized as by
+// ConvertToPacketInfoWithFields, and Direction is Forward if the packet was sent from the
+// normalized SrcIP:SrcPort, else Backward; the FlowTable then orients each flow by its
+// first packet. Unlike ConvertToPacketInfo it needs no other packets, so a capture can be
+// streamed packet by packet. Use ConvertToPacketInfo for ProcessPackets*, which keep the
+// directions they are given.
+func ConvertPacket(r RawPacket, fields KeyFields) PacketInfo {
+	k := rawKeyWith(&r, fields)
+	id := CanonicalFlowKey(k)
+	swapped := k.SrcPort != id.SrcPort || k.SrcAddr != id.SrcAddr
+	dir := Forward
+	if swapped {
+		dir = Backward
+	}
+	p := packetInfo(&r, id, dir, swapped)
+	p.SrcIP, p.DstIP = id.SrcIP(), id.DstIP()
+	return p
+}
+
+// packetInfo returns r as a PacketInfo of the flow identified by id, with r's MAC
+// addresses swapped if swapped is set. The caller sets SrcIP and DstIP.
+func packetInfo(r *RawPacket, id FlowKey, dir Direction, swapped bool) PacketInfo {
+	srcMAC, dstMAC := r.SrcMAC, r.DstMAC
+	if swapped {
+		srcMAC, dstMAC = dstMAC, srcMAC
+	}
+	return PacketInfo{
+		Timestamp:   r.Timestamp,
+		Direction:   dir,
+		HeaderLen:   r.HeaderLen,
+		PayloadSize: r.PayloadSize,
+		TCPWindow:   r.TCPWindow,
+		SeqNum:      r.SeqNum,
+		AckNum:      r.AckNum,
+		TCPOptions:  r.TCPOptions,
+		ICMPType:    r.ICMPType,
+		ICMPCode:    r.ICMPCode,
+		ICMPID:      r.ICMPID,
+		ICMPSeq:     r.ICMPSeq,
+		TTL:         r.TTL,
+		DSCP:        r.DSCP,
+		ECN:         r.ECN,
+		DontFragment: r.DontFragment,
+		MoreFragments: r.MoreFragments,
+		FragmentOffset: r.FragmentOffset,
+		Fragments:   r.Fragments,
+		IPID:        r.IPID,
+		Tunnel:      r.Tunnel,
+		VLANID:      r.VLANID,
+		ObservationDomain: r.ObservationDomain,
+		SrcMAC:      srcMAC,
+		DstMAC:      dstMAC,
+		Swapped:     swapped,
+		SrcPort:     id.SrcPort,
+		DstPort:     id.DstPort,
+		Protocol:    id.Protocol,
+		FIN:         r.FIN,
+		SYN:         r.SYN,
+		RST:         r.RST,
+		PSH:         r.PSH,
+		ACK:         r.ACK,
+		URG:         r.URG,
+		CWR:         r.CWR,
+		ECE:         r.ECE,
+	}
+}
+
+// rawKey returns the (uncanonicalized) flow key of r. ICMP messages get the ports of
+// ICMPPorts.
+func rawKey(r *RawPacket) FlowKey {
+	k := FlowKey{SrcAddr: ParseAddr(r.SrcIP), DstAddr: ParseAddr(r.DstIP), SrcPort: r.SrcPort, DstPort: r.DstPort, Protocol: r.Protocol}
+	if isICMP(r.Protocol) {
+		k.SrcPort, k.DstPort = ICMPPorts(r.Protocol, r.ICMPType, r.ICMPCode, r.ICMPID)
+	}
+	return k
+}
+
+// rawKeyWith returns rawKey extended with the fields selected by fields.
+func rawKeyWith(r *RawPacket, fields KeyFields) FlowKey {
+	k := rawKey(r)
+	fields.extend(&k, r.VLANID, r.Tunnel.ID, r.ObservationDomain, r.SrcMAC, r.DstMAC)
+	return k
+}
@@ -1,6 +1,7 @@
 package flowmeter
 
 import (
+	"reflect"
 	"testing"
 	"time"
 )
@@ -26,6 +27,10 @@ func TestConvertToPacketInfo_OneFlow(t *testing.T) {
 	if packets[0].Direction != Forward || packets[1].Direction != Backward || packets[2].Direction != Forward {
 		t.Errorf("directions: expected Fwd,Bwd,Fwd got %v %v %v", packets[0].Direction, packets[1].Direction, packets[2].Direction)
 	}
+	// Only the packet from 2.2.2.2 had its 5-tuple reversed.
+	if packets[0].Swapped || !packets[1].Swapped || packets[2].Swapped {
+		t.Errorf("swapped: expected false,true,false got %v %v %v", packets[0].Swapped, packets[1].Swapped, packets[2].Swapped)
+	}
 }
 
 func TestConvertToPacketInfo_Empty(t *testing.T) {
@@ -38,3 +43,83 @@ func TestConvertToPacketInfo_Empty(t *testing.T) {
 		t.Errorf("expected nil for empty slice, got len %d", len(packets))
 	}
 }
+
+func TestConvertToPacketInfo_IPv4MappedSameFlow(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	raw := []RawPacket{
+		{Timestamp: base, SrcIP: "10.0.0.9", DstIP: "10.0.0.10", SrcPort: 11111, DstPort: 80, Protocol: 6},
+		{Timestamp: base.Add(time.Millisecond), SrcIP: "::ffff:10.0.0.10", DstIP: "::ffff:10.0.0.9", SrcPort: 80, DstPort: 11111, Protocol: 6},
+	}
+	packets := ConvertToPacketInfo(raw)
+	if len(packets) != 2 {
+		t.Fatalf("expected 2 packets, got %d", len(packets))
+	}
+	if packets[0].Key() != packets[1].Key() {
+		t.Errorf("expected one flow, got keys %+v and %+v", packets[0].Key(), packets[1].Key())
+	}
+	if packets[0].SrcIP != "10.0.0.9" || packets[0].DstIP != "10.0.0.10" {
+		t.Errorf("expected 10.0.0.9 -> 10.0.0.10, got %s -> %s", packets[0].SrcIP, packets[0].DstIP)
+	}
+	if packets[0].Direction != Forward || packets[1].Direction != Backward {
+		t.Errorf("directions: expected Fwd,Bwd got %v %v", packets[0].Direction, packets[1].Direction)
+	}
+}
+
+func TestConvertToPacketInfo_KeyFieldsSeparateTenants(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	// The same RFC1918 connection in two VLANs; in VLAN 20 the other side starts it.
+	raw := []RawPacket{
+		{Timestamp: base, SrcIP: "10.0.0.1", DstIP: "10.0.0.2", SrcPort: 40000, DstPort: 80, Protocol: 6, VLANID: 10, SrcMAC: MAC{1}, DstMAC: MAC{2}},
+		{Timestamp: base.Add(time.Millisecond), SrcIP: "10.0.0.2", DstIP: "10.0.0.1", SrcPort: 80, DstPort: 40000, Protocol: 6, VLANID: 20, SrcMAC: MAC{2}, DstMAC: MAC{1}},
+		{Timestamp: base.Add(2 * time.Millisecond), SrcIP: "10.0.0.1", DstIP: "10.0.0.2", SrcPort: 40000, DstPort: 80, Protocol: 6, VLANID: 20, SrcMAC: MAC{1}, DstMAC: MAC{2}},
+	}
+	packets := ConvertToPacketInfoWithFields(raw, KeyVLAN|KeyMAC)
+	cfg := DefaultConfig()
+	cfg.KeyFields = KeyVLAN | KeyMAC
+	flows := ProcessPacketsWithConfig(packets, cfg)
+	if len(flows) != 2 {
+		t.Fatalf("expected one flow per VLAN, got %d", len(flows))
+	}
+	for _, fl := range flows {
+		if fl.Key.SrcMAC != (MAC{1}) || fl.Key.DstMAC != (MAC{2}) {
+			t.Errorf("expected MACs to follow the endpoints, got %v -> %v", fl.Key.SrcMAC, fl.Key.DstMAC)
+		}
+		if fl.Key.VLANID == 20 && (fl.Features.TotalFwdPackets != 1 || fl.Features.TotalBwdPackets != 1) {
+			t.Errorf("VLAN 20: expected 1/1 packets, got %d/%d", fl.Features.TotalFwdPackets, fl.Features.TotalBwdPackets)
+		}
+	}
+	if n := len(ProcessPacketsWithKeys(ConvertToPacketInfo(raw))); n != 1 {
+		t.Errorf("expected the 5-tuple key to merge the VLANs into 1 flow, got %d", n)
+	}
+}
+
+func TestConvertPacket_StreamsLikeConvertToPacketInfo(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	// The client's address sorts after the server's, so its packets are reversed.
+	raw := []RawPacket{
+		{Timestamp: base, SrcIP: "10.0.0.9", DstIP: "10.0.0.1", SrcPort: 5000, DstPort: 80, Protocol: 6, HeaderLen: 40, SrcMAC: MAC{9}, DstMAC: MAC{1}, SYN: true},
+		{Timestamp: base.Add(time.Millisecond), SrcIP: "10.0.0.1", DstIP: "10.0.0.9", SrcPort: 80, DstPort: 5000, Protocol: 6, HeaderLen: 40, SrcMAC: MAC{1}, DstMAC: MAC{9}, SYN: true, ACK: true},
+		{Timestamp: base.Add(2 * time.Millisecond), SrcIP: "10.0.0.9", DstIP: "10.0.0.1", SrcPort: 5000, DstPort: 80, Protocol: 6, HeaderLen: 40, PayloadSize: 100, SrcMAC: MAC{9}, DstMAC: MAC{1}, ACK: true},
+		{Timestamp: base.Add(3 * time.Millisecond), SrcIP: "10.0.0.1", DstIP: "10.0.0.9", SrcPort: 80, DstPort: 5000, Protocol: 6, HeaderLen: 40, PayloadSize: 300, SrcMAC: MAC{1}, DstMAC: MAC{9}, ACK: true},
+	}
+	p := ConvertPacket(raw[0], KeyMAC)
+	if p.SrcIP != "10.0.0.1" || p.SrcPort != 80 || !p.Swapped || p.Direction != Backward || p.SrcMAC != (MAC{1}) {
+		t.Errorf("expected the client's packet reversed to 10.0.0.1:80 and Backward, got %s:%d swapped=%v direction=%v src MAC %v", p.SrcIP, p.SrcPort, p.Swapped, p.Direction, p.SrcMAC)
+	}
+
+	stream := NewFlowTable(0, 0)
+	for _, r := range raw {
+		stream.Add(ConvertPacket(r, 0))
+	}
+	got := stream.Flush()
+	want := ProcessPacketsWithKeys(ConvertToPacketInfo(raw))
+	if len(got) != 1 || len(want) != 1 {
+		t.Fatalf("expected 1 flow each, got %d streamed and %d batch", len(got), len(want))
+	}
+	if !reflect.DeepEqual(got[0].Features, want[0].Features) || got[0].Initiator != want[0].Initiator {
+		t.Errorf("streamed flow differs from batch:\n got %+v from %v\nwant %+v from %v", got[0].Features, got[0].Initiator, want[0].Features, want[0].Initiator)
+	}
+	if got[0].Features.TotalFwdPackets != 2 || got[0].Features.TotalFwdBytes != 100 {
+		t.Errorf("expected the client's 2 packets and 100 bytes forward, got %d and %v", got[0].Features.TotalFwdPackets, got[0].Features.TotalFwdBytes)
+	}
+}
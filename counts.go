@@ -1,15 +1,25 @@
 package flowmeter
 
-// computeCounts fills total forward/backward packet counts and byte totals.
+// countsState accumulates total forward/backward packet counts and byte totals.
 // Byte totals use payload size (TCP/UDP payload only), matching CICFlowMeter.
-func computeCounts(packets []PacketInfo, f *FlowFeatures) {
-	for _, p := range packets {
-		if p.Direction == Forward {
-			f.TotalFwdPackets++
-			f.TotalFwdBytes += int64(p.PayloadSize)
-		} else {
-			f.TotalBwdPackets++
-			f.TotalBwdBytes += int64(p.PayloadSize)
-		}
+type countsState struct {
+	fwdPackets, bwdPackets int
+	fwdBytes, bwdBytes     int64
+}
+
+func (s *countsState) update(p *PacketInfo) {
+	if p.Direction == Forward {
+		s.fwdPackets++
+		s.fwdBytes += int64(p.PayloadSize)
+	} else {
+		s.bwdPackets++
+		s.bwdBytes += int64(p.PayloadSize)
 	}
 }
+
+func (s *countsState) finalize(f *FlowFeatures) {
+	f.TotalFwdPackets = s.fwdPackets
+	f.TotalBwdPackets = s.bwdPackets
+	f.TotalFwdBytes = s.fwdBytes
+	f.TotalBwdBytes = s.bwdBytes
+}
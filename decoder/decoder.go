@@ -0,0 +1,223 @@
+// Package decoder turns captured frames (raw bytes plus a link type) into flowmeter.RawPacket.
+//
+// Decoder.Decode fills a Packet without allocating; Packet.RawPacket converts it to the
+// flowmeter input type. Frames that are not IP or are cut short are reported as *Error
+// values (match them with errors.Is against ErrTruncated, ErrNotIP, ErrUnsupportedLink)
+// and counted in Decoder.Stats.
+package decoder
+
+import (
+	"net/netip"
+	"time"
+
+	"github.com/Bi9River/goflowmeter"
+)
+
+// LinkType is a libpcap LINKTYPE_* value identifying the outermost header of a frame.
+type LinkType uint16
+
+const (
+	LinkTypeNull      LinkType = 0   // BSD loopback: 4-byte host-order address family
+	LinkTypeEthernet  LinkType = 1   // Ethernet II, optionally 802.1Q / QinQ tagged
+	LinkTypeRaw       LinkType = 101 // raw IPv4 or IPv6 (version nibble decides)
+	LinkTypeLoop      LinkType = 108 // OpenBSD loopback: 4-byte network-order address family
+	LinkTypeLinuxSLL  LinkType = 113 // Linux "cooked" capture v1
+	LinkTypeIPv4      LinkType = 228 // raw IPv4
+	LinkTypeIPv6      LinkType = 229 // raw IPv6
+	LinkTypeLinuxSLL2 LinkType = 276 // Linux "cooked" capture v2
+)
+
+// IP protocol numbers used by the decoder.
+const (
+	ProtoICMP   = 1
+	ProtoTCP    = 6
+	ProtoUDP    = 17
+	ProtoICMPv6 = 58
+)
+
+// TCP flag bits as they appear in byte 13 of the TCP header.
+const (
+	FlagFIN = 1 << iota
+	FlagSYN
+	FlagRST
+	FlagPSH
+	FlagACK
+	FlagURG
+	FlagECE
+	FlagCWR
+)
+
+// maxVLANs is the number of 802.1Q tags recorded (outer S-tag and inner C-tag for QinQ).
+const maxVLANs = 2
+
+// Packet is the decoded view of one frame. It holds no pointers into the frame and
+// decoding into it does not allocate, so one Packet can be reused for every frame.
+type Packet struct {
+	SrcAddr  netip.Addr
+	DstAddr  netip.Addr
+	SrcPort  uint16
+	DstPort  uint16
+	Protocol uint8 // transport protocol after any IPv6 extension headers
+
+	VLANs    [maxVLANs]uint16 // VLAN IDs, outermost first
+	NumVLANs int
+
+	SrcMAC flowmeter.MAC // Ethernet addresses; zero for other link types
+	DstMAC flowmeter.MAC
+
+	// Fragment is true for IP fragments without a transport header: non-first fragments
+	// and first fragments too short to hold it. Ports, HeaderLen and TCP fields are zero
+	// and PayloadSize is the fragment's data.
+	Fragment bool
+
+	// For fragments (see IsFragment), IPOffset is where the IP header starts in the frame
+	// and FragDataOffset where the fragment's data starts (past the IPv4 header or the
+	// IPv6 fragment header). FragDataLen is the data length according to the IP header;
+	// it exceeds the captured bytes when the snaplen cut the frame.
+	IPOffset       int
+	FragDataOffset int
+	FragDataLen    int
+
+	HeaderLen   int // TCP header length (data offset * 4), or 8 for UDP and ICMP; 0 otherwise
+	PayloadSize int // transport payload length according to the IP header
+	TCPFlags    uint8
+	TCPWindow   uint16
+	SeqNum      uint32
+	AckNum      uint32
+	TCPOptions  flowmeter.TCPOptions
+
+	ICMPType uint8  // ICMP or ICMPv6 type
+	ICMPCode uint8  // ICMP or ICMPv6 code
+	ICMPID   uint16 // identifier of echo and other query/reply messages; 0 otherwise
+	ICMPSeq  uint16 // sequence number of echo and other query/reply messages; 0 otherwise
+
+	TTL            uint8  // IPv4 TTL or IPv6 hop limit
+	DSCP           uint8  // upper 6 bits of the TOS / traffic class
+	ECN            uint8  // lower 2 bits of the TOS / traffic class
+	DontFragment   bool   // IPv4 DF flag
+	MoreFragments  bool   // IPv4 MF flag or IPv6 fragment header M flag
+	FragmentOffset uint16 // in bytes
+	IPID           uint32 // IPv4 identification or IPv6 fragment identification
+
+	// Tunnel is the tunnel the packet was decapsulated from (TunnelViewInner), or the one
+	// it carries (TunnelViewBoth); zero otherwise.
+	Tunnel flowmeter.TunnelInfo
+
+	// Distances of the IP header and fragment data from the end of the frame, set while
+	// decoding and turned into IPOffset and FragDataOffset by Decoder.Decode.
+	ipTail, fragTail int
+	l4Tail           int // transport header, for tunnel detection
+	innerTail        int // encapsulated packet (TunnelViewBoth)
+	innerLink        LinkType
+}
+
+// IsFragment reports whether p is part of a fragmented datagram (MF set or a non-zero
+// fragment offset). An IPv6 atomic fragment (offset 0, M clear) is not.
+func (p *Packet) IsFragment() bool {
+	return p.MoreFragments || p.FragmentOffset != 0
+}
+
+// RawPacket converts p to a flowmeter.RawPacket with the given capture timestamp. The
+// RawPacket's VLANID is the outermost tag.
+func (p *Packet) RawPacket(ts time.Time) flowmeter.RawPacket {
+	return flowmeter.RawPacket{
+		Timestamp:      ts,
+		HeaderLen:      p.HeaderLen,
+		PayloadSize:    p.PayloadSize,
+		TCPWindow:      p.TCPWindow,
+		SeqNum:         p.SeqNum,
+		AckNum:         p.AckNum,
+		TCPOptions:     p.TCPOptions,
+		TTL:            p.TTL,
+		DSCP:           p.DSCP,
+		ECN:            p.ECN,
+		DontFragment:   p.DontFragment,
+		MoreFragments:  p.MoreFragments,
+		FragmentOffset: p.FragmentOffset,
+		IPID:           p.IPID,
+		Tunnel:         p.Tunnel,
+		VLANID:         p.VLANs[0],
+		SrcMAC:         p.SrcMAC,
+		DstMAC:         p.DstMAC,
+		SrcIP:          p.SrcAddr.String(),
+		DstIP:          p.DstAddr.String(),
+		SrcPort:        p.SrcPort,
+		DstPort:        p.DstPort,
+		Protocol:       p.Protocol,
+		FIN:            p.TCPFlags&FlagFIN != 0,
+		SYN:            p.TCPFlags&FlagSYN != 0,
+		RST:            p.TCPFlags&FlagRST != 0,
+		PSH:            p.TCPFlags&FlagPSH != 0,
+		ACK:            p.TCPFlags&FlagACK != 0,
+		URG:            p.TCPFlags&FlagURG != 0,
+		CWR:            p.TCPFlags&FlagCWR != 0,
+		ECE:            p.TCPFlags&FlagECE != 0,
+		ICMPType:       p.ICMPType,
+		ICMPCode:       p.ICMPCode,
+		ICMPID:         p.ICMPID,
+		ICMPSeq:        p.ICMPSeq,
+	}
+}
+
+// Stats counts decode outcomes by reason.
+type Stats struct {
+	Decoded         uint64
+	Truncated       uint64
+	NotIP           uint64
+	UnsupportedLink uint64
+	BadHeader       uint64
+	Decapsulated    uint64 // frames returned as their inner packet (TunnelViewInner, DecodeInner)
+}
+
+// Decoder decodes frames and keeps per-reason counters. The zero value is ready to use
+// and leaves tunnels encapsulated; a Decoder is not safe for concurrent use.
+type Decoder struct {
+	// Tunnels selects the outer packet, the inner packet or both for GRE (incl. ERSPAN),
+	// VXLAN, GENEVE, IP-in-IP / 6in4 and GTP-U traffic.
+	Tunnels TunnelView
+
+	stats Stats
+}
+
+// Decode parses data captured on link type lt into pkt, overwriting all of its fields.
+// It returns nil or an *Error describing why the frame was rejected.
+func (d *Decoder) Decode(data []byte, lt LinkType, pkt *Packet) error {
+	*pkt = Packet{}
+	err := decodeLink(data, lt, pkt)
+	if err == nil && d.Tunnels != TunnelViewOuter {
+		d.decapsulate(data, pkt)
+	}
+	if pkt.IsFragment() {
+		pkt.IPOffset = len(data) - pkt.ipTail
+		pkt.FragDataOffset = len(data) - pkt.fragTail
+	}
+	if err == nil {
+		d.stats.Decoded++
+		return nil
+	}
+	switch err.Reason {
+	case ReasonTruncated:
+		d.stats.Truncated++
+	case ReasonNotIP:
+		d.stats.NotIP++
+	case ReasonUnsupportedLink:
+		d.stats.UnsupportedLink++
+	case ReasonBadHeader:
+		d.stats.BadHeader++
+	}
+	return err
+}
+
+// Stats returns the counters accumulated so far.
+func (d *Decoder) Stats() Stats {
+	return d.stats
+}
+
+// Decode is a convenience wrapper that decodes one frame into a RawPacket.
+func Decode(data []byte, lt LinkType, ts time.Time) (flowmeter.RawPacket, error) {
+	var pkt Packet
+	if err := decodeLink(data, lt, &pkt); err != nil {
+		return flowmeter.RawPacket{Timestamp: ts}, err
+	}
+	return pkt.RawPacket(ts), nil
+}
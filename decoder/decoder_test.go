@@ -0,0 +1,324 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// ipv4TCP builds a raw IPv4/TCP packet 10.0.0.1:sport -> 10.0.0.2:dport. optLen bytes of
+// TCP options are added to the header.
+func ipv4TCP(sport, dport uint16, flags byte, optLen, payload int) []byte {
+	tcpLen := 20 + optLen
+	b := make([]byte, 20+tcpLen+payload)
+	b[0] = 0x45
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	b[8] = 64
+	b[9] = ProtoTCP
+	copy(b[12:16], []byte{10, 0, 0, 1})
+	copy(b[16:20], []byte{10, 0, 0, 2})
+	tcp := b[20:]
+	binary.BigEndian.PutUint16(tcp[0:2], sport)
+	binary.BigEndian.PutUint16(tcp[2:4], dport)
+	binary.BigEndian.PutUint32(tcp[4:8], 1000)
+	binary.BigEndian.PutUint32(tcp[8:12], 2000)
+	tcp[12] = byte(tcpLen/4) << 4
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:16], 29200)
+	return b
+}
+
+// ipv6UDP builds a raw IPv6/UDP packet 2001:db8::1:sport -> 2001:db8::2:dport.
+func ipv6UDP(sport, dport uint16, payload int) []byte {
+	b := make([]byte, 40+8+payload)
+	b[0] = 0x60
+	binary.BigEndian.PutUint16(b[4:6], uint16(8+payload))
+	b[6] = ProtoUDP
+	b[7] = 64
+	b[8], b[9], b[23] = 0x20, 0x01, 1
+	b[24], b[25], b[39] = 0x20, 0x01, 2
+	b[10], b[11], b[26], b[27] = 0x0d, 0xb8, 0x0d, 0xb8
+	binary.BigEndian.PutUint16(b[40:42], sport)
+	binary.BigEndian.PutUint16(b[42:44], dport)
+	binary.BigEndian.PutUint16(b[44:46], uint16(8+payload))
+	return b
+}
+
+func TestDecode_RawIPv6UDP(t *testing.T) {
+	ts := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	r, err := Decode(ipv6UDP(5353, 53, 30), LinkTypeRaw, ts)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if r.SrcIP != "2001:db8::1" || r.DstIP != "2001:db8::2" || r.SrcPort != 5353 || r.DstPort != 53 || r.Protocol != ProtoUDP {
+		t.Errorf("unexpected 5-tuple %s:%d -> %s:%d/%d", r.SrcIP, r.SrcPort, r.DstIP, r.DstPort, r.Protocol)
+	}
+	if r.HeaderLen != 8 || r.PayloadSize != 30 || !r.Timestamp.Equal(ts) {
+		t.Errorf("expected HeaderLen=8 PayloadSize=30, got %d %d", r.HeaderLen, r.PayloadSize)
+	}
+}
+
+func TestDecode_TCPHeaderFields(t *testing.T) {
+	var d Decoder
+	var pkt Packet
+	if err := d.Decode(ipv4TCP(40000, 443, 0xff, 12, 100), LinkTypeIPv4, &pkt); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if pkt.HeaderLen != 32 || pkt.PayloadSize != 100 || pkt.TCPWindow != 29200 {
+		t.Errorf("expected HeaderLen=32 PayloadSize=100 window=29200, got %d %d %d", pkt.HeaderLen, pkt.PayloadSize, pkt.TCPWindow)
+	}
+	r := pkt.RawPacket(time.Time{})
+	if r.SeqNum != 1000 || r.AckNum != 2000 {
+		t.Errorf("expected seq=1000 ack=2000, got %d %d", r.SeqNum, r.AckNum)
+	}
+	if !(r.FIN && r.SYN && r.RST && r.PSH && r.ACK && r.URG && r.ECE && r.CWR) {
+		t.Errorf("expected all eight TCP flags set, got %+v", r)
+	}
+	if pkt.SrcAddr != netip.MustParseAddr("10.0.0.1") || pkt.DstPort != 443 {
+		t.Errorf("unexpected addresses/ports: %v:%d -> %v:%d", pkt.SrcAddr, pkt.SrcPort, pkt.DstAddr, pkt.DstPort)
+	}
+}
+
+func TestDecode_IPv6ExtensionChain(t *testing.T) {
+	udp := ipv6UDP(1000, 2000, 16)
+	// Insert hop-by-hop (8 bytes) and destination options (16 bytes) before UDP.
+	ext := make([]byte, 24)
+	ext[0], ext[1] = ipv6DestOpts, 0
+	ext[8], ext[9] = ProtoUDP, 1
+	b := append(append(append([]byte{}, udp[:40]...), ext...), udp[40:]...)
+	b[6] = ipv6HopByHop
+	binary.BigEndian.PutUint16(b[4:6], uint16(len(b)-40))
+
+	var d Decoder
+	var pkt Packet
+	if err := d.Decode(b, LinkTypeIPv6, &pkt); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if pkt.Protocol != ProtoUDP || pkt.SrcPort != 1000 || pkt.DstPort != 2000 || pkt.PayloadSize != 16 {
+		t.Errorf("expected UDP 1000->2000 with 16 bytes payload, got proto=%d %d->%d payload=%d", pkt.Protocol, pkt.SrcPort, pkt.DstPort, pkt.PayloadSize)
+	}
+}
+
+func TestDecode_ErrorsAndCounters(t *testing.T) {
+	var d Decoder
+	var pkt Packet
+	if err := d.Decode(make([]byte, 10), LinkTypeEthernet, &pkt); !errors.Is(err, ErrTruncated) {
+		t.Errorf("short Ethernet frame: expected ErrTruncated, got %v", err)
+	}
+	if err := d.Decode(make([]byte, 60), LinkTypeEthernet, &pkt); !errors.Is(err, ErrNotIP) {
+		t.Errorf("EtherType 0: expected ErrNotIP, got %v", err)
+	}
+	if err := d.Decode(make([]byte, 60), LinkType(9999), &pkt); !errors.Is(err, ErrUnsupportedLink) {
+		t.Errorf("unknown link type: expected ErrUnsupportedLink, got %v", err)
+	}
+	tcp := ipv4TCP(1, 2, 0, 0, 0)
+	if err := d.Decode(tcp[:30], LinkTypeIPv4, &pkt); !errors.Is(err, ErrTruncated) {
+		t.Errorf("cut TCP header: expected ErrTruncated, got %v", err)
+	}
+	var de *Error
+	if err := d.Decode(tcp[:30], LinkTypeIPv4, &pkt); !errors.As(err, &de) || de.Layer != "tcp" {
+		t.Errorf("expected *Error at layer tcp, got %v", err)
+	}
+	if err := d.Decode(tcp, LinkTypeIPv4, &pkt); err != nil {
+		t.Errorf("valid packet: %v", err)
+	}
+	want := Stats{Decoded: 1, Truncated: 3, NotIP: 1, UnsupportedLink: 1}
+	if d.Stats() != want {
+		t.Errorf("Stats: expected %+v, got %+v", want, d.Stats())
+	}
+}
+
+func TestDecode_NoAllocations(t *testing.T) {
+	frame := append(make([]byte, 14), ipv4TCP(1, 2, 0x18, 0, 64)...)
+	binary.BigEndian.PutUint16(frame[12:14], etherTypeIPv4)
+	var d Decoder
+	var pkt Packet
+	allocs := testing.AllocsPerRun(100, func() {
+		_ = d.Decode(frame, LinkTypeEthernet, &pkt)
+		_ = d.Decode(frame[:20], LinkTypeEthernet, &pkt)
+	})
+	if allocs != 0 {
+		t.Errorf("expected 0 allocations per decode, got %v", allocs)
+	}
+}
+
+func TestDecode_TCPOptions(t *testing.T) {
+	// Linux SYN layout: MSS, SACK-permitted, timestamps, NOP, window scale.
+	b := ipv4TCP(40000, 443, FlagSYN, 20, 0)
+	copy(b[40:60], []byte{
+		2, 4, 0x05, 0xb4,
+		4, 2,
+		8, 10, 0, 0, 0x10, 0x00, 0, 0, 0, 0,
+		1,
+		3, 3, 7,
+	})
+	var d Decoder
+	var pkt Packet
+	if err := d.Decode(b, LinkTypeIPv4, &pkt); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	o := pkt.RawPacket(time.Time{}).TCPOptions
+	if !o.HasMSS || o.MSS != 1460 || !o.HasWindowScale || o.WindowScale != 7 || !o.SACKPermitted || !o.HasTimestamps || o.TSVal != 4096 {
+		t.Errorf("unexpected options: %+v", o)
+	}
+
+	// Bad length in the middle: options before it are kept.
+	b = ipv4TCP(40000, 443, FlagSYN, 8, 0)
+	copy(b[40:48], []byte{2, 4, 0x05, 0xb4, 3, 9, 7, 0})
+	if err := d.Decode(b, LinkTypeIPv4, &pkt); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !pkt.TCPOptions.HasMSS || pkt.TCPOptions.HasWindowScale {
+		t.Errorf("expected MSS only after malformed option, got %+v", pkt.TCPOptions)
+	}
+
+	// Options cut by the snaplen are parsed as far as captured.
+	b = ipv4TCP(40000, 443, FlagSYN, 8, 0)
+	copy(b[40:48], []byte{2, 4, 0x05, 0xb4, 3, 3, 7, 0})
+	if err := d.Decode(b[:44], LinkTypeIPv4, &pkt); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !pkt.TCPOptions.HasMSS || pkt.TCPOptions.HasWindowScale {
+		t.Errorf("expected MSS only from truncated header, got %+v", pkt.TCPOptions)
+	}
+}
+
+func TestDecode_FingerprintFields(t *testing.T) {
+	b := ipv4TCP(40000, 443, FlagSYN, 8, 0)
+	binary.BigEndian.PutUint16(b[4:6], 4242)
+	binary.BigEndian.PutUint16(b[6:8], 0x4000) // DF
+	copy(b[40:48], []byte{2, 4, 0x05, 0xb4, 1, 0, 0, 9})
+	var d Decoder
+	var pkt Packet
+	if err := d.Decode(b, LinkTypeIPv4, &pkt); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	r := pkt.RawPacket(time.Time{})
+	if r.TTL != 64 || !r.DontFragment || r.IPID != 4242 {
+		t.Errorf("expected TTL 64, DF, IP ID 4242, got %d/%v/%d", r.TTL, r.DontFragment, r.IPID)
+	}
+	o := r.TCPOptions
+	if o.NumKinds != 3 || o.Kinds[0] != 2 || o.Kinds[1] != 1 || o.Kinds[2] != 0 {
+		t.Errorf("expected layout mss,nop,eol, got %v", o.Kinds[:o.NumKinds])
+	}
+	if o.EOLPadding != 2 || !o.DataAfterEOL || o.Malformed {
+		t.Errorf("expected 2 bytes after EOL with data and no malformed flag, got %+v", o)
+	}
+}
+
+func TestDecode_IPHeaderFields(t *testing.T) {
+	b := ipv4TCP(40000, 443, FlagACK, 0, 10)
+	b[1] = 46<<2 | 3                           // DSCP EF, ECN CE
+	binary.BigEndian.PutUint16(b[6:8], 0x2000) // MF, offset 0: first fragment
+	var d Decoder
+	var pkt Packet
+	if err := d.Decode(b, LinkTypeIPv4, &pkt); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	r := pkt.RawPacket(time.Time{})
+	if r.DSCP != 46 || r.ECN != 3 || !r.MoreFragments || r.FragmentOffset != 0 || r.DstPort != 443 {
+		t.Errorf("expected DSCP 46, ECN 3, MF, offset 0 and ports, got %+v", r)
+	}
+
+	// IPv6 traffic class and a first fragment (offset 0, M set)
+	udp := ipv6UDP(1000, 2000, 16)
+	udp[0], udp[1] = 0x62, 0x90 // traffic class 0x29: DSCP AF11, ECT(1)
+	frag := []byte{ProtoUDP, 0, 0, 1, 0xde, 0xad, 0xbe, 0xef}
+	b = append(append(append([]byte{}, udp[:40]...), frag...), udp[40:]...)
+	b[6] = ipv6Fragment
+	binary.BigEndian.PutUint16(b[4:6], uint16(len(b)-40))
+	if err := d.Decode(b, LinkTypeIPv6, &pkt); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if pkt.DSCP != 10 || pkt.ECN != 1 || !pkt.MoreFragments || pkt.FragmentOffset != 0 || pkt.IPID != 0xdeadbeef || pkt.SrcPort != 1000 {
+		t.Errorf("expected DSCP 10, ECN 1, M, offset 0, id 0xdeadbeef and ports, got %+v", pkt)
+	}
+
+	// IPv6 last fragment at offset 1232
+	binary.BigEndian.PutUint16(b[42:44], 1232)
+	if err := d.Decode(b, LinkTypeIPv6, &pkt); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !pkt.Fragment || pkt.MoreFragments || pkt.FragmentOffset != 1232 {
+		t.Errorf("expected non-first last fragment at 1232, got frag=%v mf=%v off=%d", pkt.Fragment, pkt.MoreFragments, pkt.FragmentOffset)
+	}
+}
+
+func TestDecode_FragmentOffsets(t *testing.T) {
+	// First fragment too short for the TCP header: a Fragment, not an error.
+	b := ipv4TCP(40000, 443, FlagACK, 0, 0)[:32]
+	binary.BigEndian.PutUint16(b[2:4], 32)
+	binary.BigEndian.PutUint16(b[6:8], 0x2000)
+	frame := append(make([]byte, 14), b...)
+	binary.BigEndian.PutUint16(frame[12:14], 0x0800)
+	var d Decoder
+	var pkt Packet
+	if err := d.Decode(frame, LinkTypeEthernet, &pkt); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !pkt.Fragment || !pkt.IsFragment() || pkt.SrcPort != 0 || pkt.PayloadSize != 12 {
+		t.Errorf("expected a port-less first fragment with 12 bytes, got %+v", pkt)
+	}
+	if pkt.IPOffset != 14 || pkt.FragDataOffset != 34 || pkt.FragDataLen != 12 {
+		t.Errorf("expected offsets 14/34 and 12 bytes, got %d/%d/%d", pkt.IPOffset, pkt.FragDataOffset, pkt.FragDataLen)
+	}
+
+	// IPv6: data starts past the fragment header.
+	udp := ipv6UDP(1000, 2000, 16)
+	frag := []byte{ProtoUDP, 0, 0, 8, 0, 0, 0, 1}
+	b = append(append(append([]byte{}, udp[:40]...), frag...), udp[40:]...)
+	b[6] = ipv6Fragment
+	binary.BigEndian.PutUint16(b[4:6], uint16(len(b)-40))
+	if err := d.Decode(b, LinkTypeIPv6, &pkt); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if pkt.IPOffset != 0 || pkt.FragDataOffset != 48 || pkt.FragDataLen != 24 || pkt.FragmentOffset != 8 {
+		t.Errorf("expected offsets 0/48, 24 bytes at 8, got %d/%d/%d at %d", pkt.IPOffset, pkt.FragDataOffset, pkt.FragDataLen, pkt.FragmentOffset)
+	}
+}
+
+func TestDecode_ICMP(t *testing.T) {
+	b := ipv4TCP(0, 0, 0, 0, 44)
+	b[9] = ProtoICMP
+	icmp := b[20:]
+	icmp[0], icmp[1] = 8, 0
+	binary.BigEndian.PutUint16(icmp[4:6], 0x1234)
+	binary.BigEndian.PutUint16(icmp[6:8], 7)
+	var d Decoder
+	var pkt Packet
+	if err := d.Decode(b, LinkTypeIPv4, &pkt); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	r := pkt.RawPacket(time.Time{})
+	if r.ICMPType != 8 || r.ICMPID != 0x1234 || r.ICMPSeq != 7 || r.HeaderLen != 8 || r.PayloadSize != 56 || r.SrcPort != 0 {
+		t.Errorf("expected echo request id 0x1234 seq 7 with 56 bytes, got %+v", r)
+	}
+
+	// Destination unreachable carries no identifier.
+	icmp[0], icmp[1] = 3, 3
+	if err := d.Decode(b, LinkTypeIPv4, &pkt); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if pkt.ICMPType != 3 || pkt.ICMPCode != 3 || pkt.ICMPID != 0 || pkt.ICMPSeq != 0 {
+		t.Errorf("expected port unreachable without id/seq, got %+v", pkt)
+	}
+
+	// ICMPv6 echo reply
+	v6 := ipv6UDP(0, 0, 8)
+	v6[6] = ProtoICMPv6
+	v6[40], v6[41] = 129, 0
+	binary.BigEndian.PutUint16(v6[44:46], 0)
+	binary.BigEndian.PutUint16(v6[46:48], 9)
+	if err := d.Decode(v6, LinkTypeIPv6, &pkt); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if pkt.ICMPType != 129 || pkt.ICMPID != 0 || pkt.ICMPSeq != 9 || pkt.PayloadSize != 8 {
+		t.Errorf("expected ICMPv6 echo reply seq 9 with 8 bytes, got %+v", pkt)
+	}
+
+	if err := d.Decode(b[:24], LinkTypeIPv4, &pkt); !errors.Is(err, ErrTruncated) {
+		t.Errorf("expected ErrTruncated for a cut ICMP header, got %v", err)
+	}
+}
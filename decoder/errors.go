@@ -0,0 +1,69 @@
+package decoder
+
+// Reason classifies why a frame could not be decoded.
+type Reason uint8
+
+const (
+	ReasonTruncated       Reason = iota + 1 // frame ends before a header does
+	ReasonNotIP                             // link payload is not IPv4 or IPv6 (ARP, LLC, ...)
+	ReasonUnsupportedLink                   // link type the decoder does not know
+	ReasonBadHeader                         // header field is invalid (e.g. IHL < 5)
+)
+
+func (r Reason) String() string {
+	switch r {
+	case ReasonTruncated:
+		return "truncated"
+	case ReasonNotIP:
+		return "not IP"
+	case ReasonUnsupportedLink:
+		return "unsupported link type"
+	case ReasonBadHeader:
+		return "bad header"
+	}
+	return "unknown"
+}
+
+// Error is the error type returned by the decoder. Layer names the header being parsed
+// ("ethernet", "ipv4", "tcp", ...). errors.Is matches any *Error with the same Reason, so
+// callers can test against ErrTruncated, ErrNotIP, ErrUnsupportedLink and ErrBadHeader.
+type Error struct {
+	Reason Reason
+	Layer  string
+}
+
+func (e *Error) Error() string {
+	if e.Layer == "" {
+		return "decoder: " + e.Reason.String()
+	}
+	return "decoder: " + e.Layer + ": " + e.Reason.String()
+}
+
+// Is reports whether target is an *Error with the same Reason.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Reason == e.Reason
+}
+
+// Sentinel errors for errors.Is.
+var (
+	ErrTruncated       = &Error{Reason: ReasonTruncated}
+	ErrNotIP           = &Error{Reason: ReasonNotIP}
+	ErrUnsupportedLink = &Error{Reason: ReasonUnsupportedLink}
+	ErrBadHeader       = &Error{Reason: ReasonBadHeader}
+)
+
+// Preallocated per-layer errors so the hot path never allocates.
+var (
+	errTruncLink = &Error{Reason: ReasonTruncated, Layer: "link"}
+	errTruncIPv4 = &Error{Reason: ReasonTruncated, Layer: "ipv4"}
+	errTruncIPv6 = &Error{Reason: ReasonTruncated, Layer: "ipv6"}
+	errTruncTCP  = &Error{Reason: ReasonTruncated, Layer: "tcp"}
+	errTruncUDP  = &Error{Reason: ReasonTruncated, Layer: "udp"}
+	errTruncICMP = &Error{Reason: ReasonTruncated, Layer: "icmp"}
+	errNotIPLink = &Error{Reason: ReasonNotIP, Layer: "link"}
+	errBadIPv4   = &Error{Reason: ReasonBadHeader, Layer: "ipv4"}
+	errBadIPv6   = &Error{Reason: ReasonBadHeader, Layer: "ipv6"}
+	errBadTCP    = &Error{Reason: ReasonBadHeader, Layer: "tcp"}
+	errLink      = &Error{Reason: ReasonUnsupportedLink, Layer: "link"}
+)
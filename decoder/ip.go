@@ -0,0 +1,157 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"net/netip"
+)
+
+// IPv6 extension header numbers walked by decodeIPv6.
+const (
+	ipv6HopByHop = 0
+	ipv6Routing  = 43
+	ipv6Fragment = 44
+	ipv6AH       = 51
+	ipv6DestOpts = 60
+	ipv6Mobility = 135
+	ipv6HIP      = 139
+	ipv6Shim6    = 140
+)
+
+// decodeIP dispatches on the IP version nibble.
+func decodeIP(data []byte, pkt *Packet) *Error {
+	if len(data) < 1 {
+		return errTruncLink
+	}
+	switch data[0] >> 4 {
+	case 4:
+		return decodeIPv4(data, pkt)
+	case 6:
+		return decodeIPv6(data, pkt)
+	}
+	return errNotIPLink
+}
+
+func decodeIPv4(data []byte, pkt *Packet) *Error {
+	if len(data) < 20 {
+		return errTruncIPv4
+	}
+	if data[0]>>4 != 4 {
+		return errBadIPv4
+	}
+	ihl := int(data[0]&0x0f) * 4
+	total := int(binary.BigEndian.Uint16(data[2:4]))
+	if ihl < 20 || total < ihl {
+		return errBadIPv4
+	}
+	if len(data) < ihl {
+		return errTruncIPv4
+	}
+	pkt.SrcAddr = netip.AddrFrom4([4]byte(data[12:16]))
+	pkt.DstAddr = netip.AddrFrom4([4]byte(data[16:20]))
+	pkt.Protocol = data[9]
+	pkt.TTL = data[8]
+	pkt.DSCP, pkt.ECN = data[1]>>2, data[1]&0x03
+	pkt.IPID = uint32(binary.BigEndian.Uint16(data[4:6]))
+	flags := binary.BigEndian.Uint16(data[6:8])
+	pkt.DontFragment = flags&0x4000 != 0
+	pkt.MoreFragments = flags&0x2000 != 0
+	fragOffset := (flags & 0x1fff) * 8
+	pkt.FragmentOffset = fragOffset
+	end := total
+	if end > len(data) {
+		end = len(data) // snaplen truncation: sizes still come from the headers
+	}
+	if pkt.IsFragment() {
+		pkt.ipTail, pkt.fragTail, pkt.FragDataLen = len(data), len(data)-ihl, total-ihl
+	}
+	if fragOffset != 0 {
+		pkt.Fragment = true
+		pkt.PayloadSize = total - ihl
+		return nil
+	}
+	pkt.l4Tail = len(data) - ihl
+	return decodeFirstFragment(data[ihl:end], total-ihl, pkt)
+}
+
+// decodeFirstFragment decodes the transport header of an unfragmented datagram or a first
+// fragment. A first fragment that ends before the transport header does (and was not cut
+// by the snaplen) is reported as a Fragment rather than an error, so it can still be
+// reassembled.
+func decodeFirstFragment(data []byte, ipPayloadLen int, pkt *Packet) *Error {
+	err := decodeTransport(data, ipPayloadLen, pkt)
+	if err != nil && err.Reason == ReasonTruncated && pkt.MoreFragments && len(data) == ipPayloadLen {
+		pkt.Fragment = true
+		pkt.PayloadSize = ipPayloadLen
+		return nil
+	}
+	return err
+}
+
+func decodeIPv6(data []byte, pkt *Packet) *Error {
+	if len(data) < 40 {
+		return errTruncIPv6
+	}
+	if data[0]>>4 != 6 {
+		return errBadIPv6
+	}
+	pkt.SrcAddr = netip.AddrFrom16([16]byte(data[8:24]))
+	pkt.DstAddr = netip.AddrFrom16([16]byte(data[24:40]))
+	pkt.TTL = data[7]
+	tc := data[0]<<4 | data[1]>>4
+	pkt.DSCP, pkt.ECN = tc>>2, tc&0x03
+	payloadLen := int(binary.BigEndian.Uint16(data[4:6]))
+	if payloadLen == 0 {
+		payloadLen = len(data) - 40 // jumbogram: length lives in a hop-by-hop option
+	}
+	end := 40 + payloadLen
+	if end > len(data) {
+		end = len(data)
+	}
+	next := data[6]
+	ipTail := len(data)
+	rest := data[40:end]
+	remaining := payloadLen
+	for {
+		var hdrLen int
+		switch next {
+		case ipv6HopByHop, ipv6Routing, ipv6DestOpts, ipv6Mobility, ipv6HIP, ipv6Shim6:
+			if len(rest) < 2 {
+				return errTruncIPv6
+			}
+			hdrLen = (int(rest[1]) + 1) * 8
+		case ipv6AH:
+			if len(rest) < 2 {
+				return errTruncIPv6
+			}
+			hdrLen = (int(rest[1]) + 2) * 4
+		case ipv6Fragment:
+			if len(rest) < 8 {
+				return errTruncIPv6
+			}
+			hdrLen = 8
+			fragField := binary.BigEndian.Uint16(rest[2:4])
+			pkt.FragmentOffset = fragField & 0xfff8
+			pkt.MoreFragments = fragField&0x0001 != 0
+			pkt.IPID = binary.BigEndian.Uint32(rest[4:8])
+			if pkt.IsFragment() {
+				pkt.ipTail, pkt.fragTail, pkt.FragDataLen = ipTail, len(data)-(end-len(rest))-hdrLen, remaining-hdrLen
+			}
+			if pkt.FragmentOffset != 0 {
+				pkt.Protocol = rest[0]
+				pkt.Fragment = true
+				pkt.PayloadSize = remaining - hdrLen
+				return nil
+			}
+		default:
+			pkt.Protocol = next
+			pkt.l4Tail = len(data) - (end - len(rest))
+			return decodeFirstFragment(rest, remaining, pkt)
+		}
+		if len(rest) < hdrLen {
+			return errTruncIPv6
+		}
+		next = rest[0]
+		rest = rest[hdrLen:]
+		remaining -= hdrLen
+	}
+}
@@ -0,0 +1,82 @@
+package decoder
+
+import (
+	"encoding/binary"
+
+	"github.com/Bi9River/goflowmeter"
+)
+
+// EtherType values handled by the link layer.
+const (
+	etherTypeIPv4   = 0x0800
+	etherTypeIPv6   = 0x86DD
+	etherTypeVLAN   = 0x8100 // 802.1Q C-tag
+	etherTypeQinQ   = 0x88A8 // 802.1ad S-tag
+	etherTypeQinQv1 = 0x9100 // legacy QinQ
+)
+
+// decodeLink strips the link-layer header for lt and decodes the network layer.
+func decodeLink(data []byte, lt LinkType, pkt *Packet) *Error {
+	switch lt {
+	case LinkTypeEthernet:
+		return decodeEthernet(data, pkt)
+	case LinkTypeNull, LinkTypeLoop:
+		// Address family is host order (Null) or network order (Loop); the IP version
+		// nibble is unambiguous, so skip it.
+		if len(data) < 4 {
+			return errTruncLink
+		}
+		return decodeIP(data[4:], pkt)
+	case LinkTypeRaw:
+		return decodeIP(data, pkt)
+	case LinkTypeIPv4:
+		return decodeIPv4(data, pkt)
+	case LinkTypeIPv6:
+		return decodeIPv6(data, pkt)
+	case LinkTypeLinuxSLL:
+		// packet type(2) ARPHRD(2) addr len(2) addr(8) protocol(2)
+		if len(data) < 16 {
+			return errTruncLink
+		}
+		return decodeEtherType(binary.BigEndian.Uint16(data[14:16]), data[16:], pkt)
+	case LinkTypeLinuxSLL2:
+		// protocol(2) reserved(2) ifindex(4) ARPHRD(2) packet type(1) addr len(1) addr(8)
+		if len(data) < 20 {
+			return errTruncLink
+		}
+		return decodeEtherType(binary.BigEndian.Uint16(data[0:2]), data[20:], pkt)
+	}
+	return errLink
+}
+
+// decodeEthernet parses an Ethernet II header and any 802.1Q / QinQ tags.
+func decodeEthernet(data []byte, pkt *Packet) *Error {
+	if len(data) < 14 {
+		return errTruncLink
+	}
+	pkt.DstMAC, pkt.SrcMAC = flowmeter.MAC(data[0:6]), flowmeter.MAC(data[6:12])
+	etherType := binary.BigEndian.Uint16(data[12:14])
+	data = data[14:]
+	for etherType == etherTypeVLAN || etherType == etherTypeQinQ || etherType == etherTypeQinQv1 {
+		if len(data) < 4 {
+			return errTruncLink
+		}
+		if pkt.NumVLANs < maxVLANs {
+			pkt.VLANs[pkt.NumVLANs] = binary.BigEndian.Uint16(data[0:2]) & 0x0fff
+			pkt.NumVLANs++
+		}
+		etherType = binary.BigEndian.Uint16(data[2:4])
+		data = data[4:]
+	}
+	return decodeEtherType(etherType, data, pkt)
+}
+
+func decodeEtherType(etherType uint16, data []byte, pkt *Packet) *Error {
+	switch etherType {
+	case etherTypeIPv4:
+		return decodeIPv4(data, pkt)
+	case etherTypeIPv6:
+		return decodeIPv6(data, pkt)
+	}
+	return errNotIPLink
+}
@@ -0,0 +1,73 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/Bi9River/goflowmeter"
+)
+
+func TestDecode_QinQ(t *testing.T) {
+	ip := ipv4TCP(1, 2, 0, 0, 0)
+	frame := make([]byte, 14+8)
+	copy(frame[0:6], []byte{0xaa, 0, 0, 0, 0, 1})
+	copy(frame[6:12], []byte{0xbb, 0, 0, 0, 0, 2})
+	binary.BigEndian.PutUint16(frame[12:14], etherTypeQinQ)
+	binary.BigEndian.PutUint16(frame[14:16], 0x2000|100) // PCP bits + VID 100
+	binary.BigEndian.PutUint16(frame[16:18], etherTypeVLAN)
+	binary.BigEndian.PutUint16(frame[18:20], 200)
+	binary.BigEndian.PutUint16(frame[20:22], etherTypeIPv4)
+	frame = append(frame, ip...)
+
+	var d Decoder
+	var pkt Packet
+	if err := d.Decode(frame, LinkTypeEthernet, &pkt); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if pkt.NumVLANs != 2 || pkt.VLANs[0] != 100 || pkt.VLANs[1] != 200 {
+		t.Errorf("expected VLANs [100 200], got %v (n=%d)", pkt.VLANs, pkt.NumVLANs)
+	}
+	if pkt.Protocol != ProtoTCP || pkt.DstPort != 2 {
+		t.Errorf("expected TCP to port 2, got proto=%d port=%d", pkt.Protocol, pkt.DstPort)
+	}
+	r := pkt.RawPacket(time.Time{})
+	if r.VLANID != 100 || r.DstMAC != (flowmeter.MAC{0xaa, 0, 0, 0, 0, 1}) || r.SrcMAC != (flowmeter.MAC{0xbb, 0, 0, 0, 0, 2}) {
+		t.Errorf("expected outer VLAN 100 and the frame's MACs, got %d %v -> %v", r.VLANID, r.SrcMAC, r.DstMAC)
+	}
+}
+
+func TestDecode_LinuxCooked(t *testing.T) {
+	ip := ipv6UDP(7, 8, 0)
+	sll := make([]byte, 16)
+	binary.BigEndian.PutUint16(sll[14:16], etherTypeIPv6)
+	sll2 := make([]byte, 20)
+	binary.BigEndian.PutUint16(sll2[0:2], etherTypeIPv6)
+
+	var d Decoder
+	var pkt Packet
+	for _, c := range []struct {
+		lt     LinkType
+		header []byte
+	}{{LinkTypeLinuxSLL, sll}, {LinkTypeLinuxSLL2, sll2}} {
+		if err := d.Decode(append(append([]byte{}, c.header...), ip...), c.lt, &pkt); err != nil {
+			t.Fatalf("link type %d: %v", c.lt, err)
+		}
+		if pkt.SrcPort != 7 || pkt.DstPort != 8 || !pkt.SrcAddr.Is6() {
+			t.Errorf("link type %d: expected IPv6 UDP 7->8, got %v:%d -> %d", c.lt, pkt.SrcAddr, pkt.SrcPort, pkt.DstPort)
+		}
+	}
+}
+
+func TestDecode_NonFirstFragment(t *testing.T) {
+	ip := ipv4TCP(1, 2, 0, 0, 40)
+	binary.BigEndian.PutUint16(ip[6:8], 185) // offset 185*8 bytes
+	var d Decoder
+	var pkt Packet
+	if err := d.Decode(ip, LinkTypeRaw, &pkt); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !pkt.Fragment || pkt.SrcPort != 0 || pkt.PayloadSize != 60 {
+		t.Errorf("expected fragment without ports and 60 bytes data, got frag=%v port=%d payload=%d", pkt.Fragment, pkt.SrcPort, pkt.PayloadSize)
+	}
+}
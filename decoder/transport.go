@@ -0,0 +1,110 @@
+package decoder
+
+import (
+	"encoding/binary"
+
+	"github.com/Bi9River/goflowmeter"
+)
+
+// TCP option kinds (RFC 9293, RFC 2018, RFC 7323).
+const (
+	tcpOptEnd       = 0
+	tcpOptNOP       = 1
+	tcpOptMSS       = 2
+	tcpOptWScale    = 3
+	tcpOptSACKOK    = 4
+	tcpOptTimestamp = 8
+)
+
+// decodeTransport fills ports, header length, payload size and TCP or ICMP fields.
+// ipPayloadLen is the transport length according to the IP header (it may exceed
+// len(data) when the snaplen cut the frame). ICMP has no ports; protocols other than TCP,
+// UDP and ICMP keep zero ports and HeaderLen.
+func decodeTransport(data []byte, ipPayloadLen int, pkt *Packet) *Error {
+	switch pkt.Protocol {
+	case ProtoTCP:
+		if len(data) < 20 {
+			return errTruncTCP
+		}
+		hdrLen := int(data[12]>>4) * 4
+		if hdrLen < 20 {
+			return errBadTCP
+		}
+		pkt.SrcPort = binary.BigEndian.Uint16(data[0:2])
+		pkt.DstPort = binary.BigEndian.Uint16(data[2:4])
+		pkt.SeqNum = binary.BigEndian.Uint32(data[4:8])
+		pkt.AckNum = binary.BigEndian.Uint32(data[8:12])
+		pkt.HeaderLen = hdrLen
+		pkt.TCPFlags = data[13]
+		pkt.TCPWindow = binary.BigEndian.Uint16(data[14:16])
+		// Options cut off by the snaplen are parsed as far as they were captured.
+		parseTCPOptions(data[20:min(hdrLen, len(data))], &pkt.TCPOptions)
+	case ProtoUDP:
+		if len(data) < 8 {
+			return errTruncUDP
+		}
+		pkt.SrcPort = binary.BigEndian.Uint16(data[0:2])
+		pkt.DstPort = binary.BigEndian.Uint16(data[2:4])
+		pkt.HeaderLen = 8
+	case ProtoICMP, ProtoICMPv6:
+		if len(data) < 8 {
+			return errTruncICMP
+		}
+		pkt.ICMPType, pkt.ICMPCode = data[0], data[1]
+		if flowmeter.ICMPIsQuery(pkt.Protocol, pkt.ICMPType) {
+			pkt.ICMPID = binary.BigEndian.Uint16(data[4:6])
+			pkt.ICMPSeq = binary.BigEndian.Uint16(data[6:8])
+		}
+		pkt.HeaderLen = 8
+	}
+	if pkt.PayloadSize = ipPayloadLen - pkt.HeaderLen; pkt.PayloadSize < 0 {
+		pkt.PayloadSize = 0
+	}
+	return nil
+}
+
+// parseTCPOptions fills opts from the option bytes of a TCP header. Parsing stops at the
+// end-of-list option or at the first option whose length is invalid or runs past the
+// header (Malformed); options before it are kept. Options with an unexpected length are
+// ignored.
+func parseTCPOptions(b []byte, opts *flowmeter.TCPOptions) {
+	for len(b) > 0 {
+		kind := b[0]
+		if opts.NumKinds < flowmeter.MaxTCPOptionKinds {
+			opts.Kinds[opts.NumKinds] = kind
+			opts.NumKinds++
+		}
+		if kind == tcpOptEnd {
+			opts.EOLPadding = uint8(len(b) - 1)
+			for _, c := range b[1:] {
+				if c != 0 {
+					opts.DataAfterEOL = true
+					break
+				}
+			}
+			return
+		}
+		if kind == tcpOptNOP {
+			b = b[1:]
+			continue
+		}
+		if len(b) < 2 || b[1] < 2 || int(b[1]) > len(b) {
+			opts.Malformed = true
+			return
+		}
+		val := b[2:b[1]]
+		switch {
+		case kind == tcpOptMSS && len(val) == 2:
+			opts.MSS, opts.HasMSS = binary.BigEndian.Uint16(val), true
+		case kind == tcpOptWScale && len(val) == 1:
+			opts.WindowScale, opts.HasWindowScale = val[0], true
+		case kind == tcpOptSACKOK && len(val) == 0:
+			opts.SACKPermitted = true
+		case kind == tcpOptTimestamp && len(val) == 8:
+			opts.TSVal = binary.BigEndian.Uint32(val[0:4])
+			opts.TSEcr = binary.BigEndian.Uint32(val[4:8])
+			opts.HasTimestamps = true
+		}
+		b = b[b[1]:]
+	}
+}
@@ -0,0 +1,259 @@
+package decoder
+
+import (
+	"encoding/binary"
+
+	"github.com/Bi9River/goflowmeter"
+)
+
+// TunnelView selects which packet Decode returns for tunneled traffic.
+type TunnelView uint8
+
+const (
+	// TunnelViewOuter leaves tunnels encapsulated: the outer packet is returned and
+	// Packet.Tunnel stays zero. This is the zero value.
+	TunnelViewOuter TunnelView = iota
+	// TunnelViewInner returns the innermost packet (up to maxTunnelDepth levels) with the
+	// tunnel in Packet.Tunnel. A frame whose inner packet cannot be decoded is returned
+	// as the outer packet, still with Packet.Tunnel set.
+	TunnelViewInner
+	// TunnelViewBoth returns the outer packet with Packet.Tunnel set; DecodeInner then
+	// decodes the packet it carries.
+	TunnelViewBoth
+)
+
+// maxTunnelDepth bounds how many nested tunnels are removed.
+const maxTunnelDepth = 4
+
+// Tunnel protocol numbers, UDP ports and GRE protocol types.
+const (
+	protoIPIP = 4
+	protoIPv6 = 41
+	protoGRE  = 47
+
+	portVXLAN  = 4789
+	portGENEVE = 6081
+	portGTPU   = 2152
+
+	greIPv4     = 0x0800
+	greIPv6     = 0x86DD
+	greEthernet = 0x6558 // transparent Ethernet bridging
+	greERSPAN2  = 0x88BE // ERSPAN type I (no sequence number) or II
+	greERSPAN3  = 0x22EB
+
+	gtpuGPDU = 0xFF
+)
+
+var errNoTunnel = &Error{Reason: ReasonNotIP, Layer: "tunnel"}
+
+// decapsulate applies d.Tunnels to pkt, which was decoded from data.
+func (d *Decoder) decapsulate(data []byte, pkt *Packet) {
+	inner, lt, info, ok := tunnelPayload(data, pkt)
+	if !ok {
+		return
+	}
+	pkt.Tunnel = info
+	if d.Tunnels == TunnelViewBoth {
+		pkt.innerTail, pkt.innerLink = len(inner), lt
+		return
+	}
+	if decodeInner(data, inner, lt, info, pkt) {
+		d.stats.Decapsulated++
+	}
+}
+
+// DecodeInner decodes the packet carried by outer, which Decode returned from data in
+// TunnelViewBoth, into pkt (removing any further tunnels as TunnelViewInner does).
+func (d *Decoder) DecodeInner(data []byte, outer *Packet, pkt *Packet) error {
+	if outer.Tunnel.Type == flowmeter.TunnelNone {
+		return errNoTunnel
+	}
+	inner, lt, info := data[len(data)-outer.innerTail:], outer.innerLink, outer.Tunnel
+	*pkt = *outer
+	if !decodeInner(data, inner, lt, info, pkt) {
+		*pkt = Packet{}
+		return errNoTunnel
+	}
+	d.stats.Decapsulated++
+	if pkt.IsFragment() {
+		pkt.IPOffset = len(data) - pkt.ipTail
+		pkt.FragDataOffset = len(data) - pkt.fragTail
+	}
+	return nil
+}
+
+// decodeInner decodes inner (a suffix of data, of link type lt, carried in tunnel info)
+// and any tunnels nested in it into pkt. It reports false, leaving pkt unchanged, if the
+// first inner packet cannot be decoded.
+func decodeInner(data, inner []byte, lt LinkType, info flowmeter.TunnelInfo, pkt *Packet) bool {
+	for depth := 0; depth < maxTunnelDepth; depth++ {
+		var in Packet
+		if decodeLink(inner, lt, &in) != nil {
+			return depth > 0
+		}
+		in.Tunnel = info
+		if in.NumVLANs == 0 { // the carrier's VLANs still tell tenants apart
+			in.VLANs, in.NumVLANs = pkt.VLANs, pkt.NumVLANs
+		}
+		*pkt = in
+		var ok bool
+		if inner, lt, info, ok = tunnelPayload(data, pkt); !ok {
+			break
+		}
+	}
+	return true
+}
+
+// tunnelPayload recognizes a tunnel in pkt (decoded from data) and returns the
+// encapsulated packet, its link type and the tunnel.
+func tunnelPayload(data []byte, pkt *Packet) ([]byte, LinkType, flowmeter.TunnelInfo, bool) {
+	info := flowmeter.TunnelInfo{SrcAddr: pkt.SrcAddr, DstAddr: pkt.DstAddr}
+	if pkt.IsFragment() || pkt.l4Tail == 0 || pkt.l4Tail > len(data) {
+		return nil, 0, info, false
+	}
+	l4 := data[len(data)-pkt.l4Tail:]
+	switch pkt.Protocol {
+	case protoIPIP, protoIPv6:
+		info.Type = flowmeter.TunnelIPIP
+		return l4, LinkTypeRaw, info, true
+	case protoGRE:
+		return greTunnel(l4, info)
+	case ProtoUDP:
+		if len(l4) < 8 {
+			break
+		}
+		switch pkt.DstPort {
+		case portVXLAN:
+			return vxlanTunnel(l4[8:], info)
+		case portGENEVE:
+			return geneveTunnel(l4[8:], info)
+		case portGTPU:
+			return gtpuTunnel(l4[8:], info)
+		}
+	}
+	return nil, 0, info, false
+}
+
+// greTunnel parses a GRE version 0 header (RFC 2784, RFC 2890) and an ERSPAN header
+// after it.
+func greTunnel(b []byte, info flowmeter.TunnelInfo) ([]byte, LinkType, flowmeter.TunnelInfo, bool) {
+	if len(b) < 4 {
+		return nil, 0, info, false
+	}
+	flags, proto := binary.BigEndian.Uint16(b[0:2]), binary.BigEndian.Uint16(b[2:4])
+	if flags&0x0007 != 0 { // version 1 is PPTP's enhanced GRE
+		return nil, 0, info, false
+	}
+	n := 4
+	if flags&0x8000 != 0 { // checksum present
+		n += 4
+	}
+	if flags&0x2000 != 0 { // key present
+		if len(b) < n+4 {
+			return nil, 0, info, false
+		}
+		info.ID = binary.BigEndian.Uint32(b[n : n+4])
+		n += 4
+	}
+	hasSeq := flags&0x1000 != 0
+	if hasSeq {
+		n += 4
+	}
+	if len(b) < n {
+		return nil, 0, info, false
+	}
+	b = b[n:]
+	info.Type = flowmeter.TunnelGRE
+	switch proto {
+	case greIPv4, greIPv6:
+		return b, LinkTypeRaw, info, true
+	case greEthernet:
+		return b, LinkTypeEthernet, info, true
+	case greERSPAN2:
+		info.Type = flowmeter.TunnelERSPAN
+		if !hasSeq { // type I: no ERSPAN header
+			info.ID = 0
+			return b, LinkTypeEthernet, info, true
+		}
+		if len(b) < 8 {
+			return nil, 0, info, false
+		}
+		info.ID = uint32(binary.BigEndian.Uint16(b[2:4]) & 0x03ff)
+		return b[8:], LinkTypeEthernet, info, true
+	case greERSPAN3:
+		info.Type = flowmeter.TunnelERSPAN
+		if len(b) < 12 {
+			return nil, 0, info, false
+		}
+		info.ID = uint32(binary.BigEndian.Uint16(b[2:4]) & 0x03ff)
+		n := 12
+		if b[11]&0x01 != 0 { // optional platform-specific subheader
+			n += 8
+		}
+		if len(b) < n {
+			return nil, 0, info, false
+		}
+		return b[n:], LinkTypeEthernet, info, true
+	}
+	return nil, 0, info, false
+}
+
+// vxlanTunnel parses a VXLAN header (RFC 7348); the I flag must be set.
+func vxlanTunnel(b []byte, info flowmeter.TunnelInfo) ([]byte, LinkType, flowmeter.TunnelInfo, bool) {
+	if len(b) < 8 || b[0]&0x08 == 0 {
+		return nil, 0, info, false
+	}
+	info.Type = flowmeter.TunnelVXLAN
+	info.ID = binary.BigEndian.Uint32(b[4:8]) >> 8
+	return b[8:], LinkTypeEthernet, info, true
+}
+
+// geneveTunnel parses a GENEVE header and its options (RFC 8926).
+func geneveTunnel(b []byte, info flowmeter.TunnelInfo) ([]byte, LinkType, flowmeter.TunnelInfo, bool) {
+	if len(b) < 8 || b[0]>>6 != 0 {
+		return nil, 0, info, false
+	}
+	n := 8 + int(b[0]&0x3f)*4
+	if len(b) < n {
+		return nil, 0, info, false
+	}
+	info.Type = flowmeter.TunnelGENEVE
+	info.ID = binary.BigEndian.Uint32(b[4:8]) >> 8
+	switch binary.BigEndian.Uint16(b[2:4]) {
+	case greEthernet:
+		return b[n:], LinkTypeEthernet, info, true
+	case greIPv4, greIPv6:
+		return b[n:], LinkTypeRaw, info, true
+	}
+	return nil, 0, info, false
+}
+
+// gtpuTunnel parses a GTPv1-U header with its optional fields and extension headers
+// (3GPP TS 29.281). Only G-PDUs carry user packets.
+func gtpuTunnel(b []byte, info flowmeter.TunnelInfo) ([]byte, LinkType, flowmeter.TunnelInfo, bool) {
+	if len(b) < 8 || b[0]>>5 != 1 || b[0]&0x10 == 0 || b[1] != gtpuGPDU {
+		return nil, 0, info, false
+	}
+	info.Type = flowmeter.TunnelGTPU
+	info.ID = binary.BigEndian.Uint32(b[4:8])
+	n := 8
+	if b[0]&0x07 != 0 { // E, S or PN: sequence number, N-PDU number, next extension type
+		if len(b) < 12 {
+			return nil, 0, info, false
+		}
+		next := b[11]
+		n = 12
+		for next != 0 {
+			if len(b) < n+1 || b[n] == 0 {
+				return nil, 0, info, false
+			}
+			l := int(b[n]) * 4
+			if len(b) < n+l {
+				return nil, 0, info, false
+			}
+			next = b[n+l-1]
+			n += l
+		}
+	}
+	return b[n:], LinkTypeRaw, info, true
+}
@@ -0,0 +1,163 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"testing"
+
+	"github.com/Bi9River/goflowmeter"
+)
+
+// outerIPv4 wraps payload in an IPv4 header 192.0.2.1 -> 192.0.2.2 with protocol proto.
+func outerIPv4(proto byte, payload []byte) []byte {
+	b := make([]byte, 20+len(payload))
+	b[0] = 0x45
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	b[8] = 64
+	b[9] = proto
+	copy(b[12:16], []byte{192, 0, 2, 1})
+	copy(b[16:20], []byte{192, 0, 2, 2})
+	copy(b[20:], payload)
+	return b
+}
+
+// outerUDP wraps payload in IPv4/UDP 192.0.2.1:50000 -> 192.0.2.2:dport.
+func outerUDP(dport uint16, payload []byte) []byte {
+	udp := make([]byte, 8+len(payload))
+	binary.BigEndian.PutUint16(udp[0:2], 50000)
+	binary.BigEndian.PutUint16(udp[2:4], dport)
+	binary.BigEndian.PutUint16(udp[4:6], uint16(len(udp)))
+	copy(udp[8:], payload)
+	return outerIPv4(ProtoUDP, udp)
+}
+
+// innerEthernet puts an Ethernet II header in front of an IPv4 packet.
+func innerEthernet(ip []byte) []byte {
+	b := append(make([]byte, 14), ip...)
+	binary.BigEndian.PutUint16(b[12:14], etherTypeIPv4)
+	return b
+}
+
+func TestDecode_Tunnels(t *testing.T) {
+	inner := ipv4TCP(40000, 443, FlagSYN, 0, 10)
+
+	gre := make([]byte, 8) // K set
+	binary.BigEndian.PutUint16(gre[0:2], 0x2000)
+	binary.BigEndian.PutUint16(gre[2:4], greIPv4)
+	binary.BigEndian.PutUint32(gre[4:8], 77)
+
+	erspan2 := make([]byte, 16) // GRE with S, then the ERSPAN II header
+	binary.BigEndian.PutUint16(erspan2[0:2], 0x1000)
+	binary.BigEndian.PutUint16(erspan2[2:4], greERSPAN2)
+	binary.BigEndian.PutUint16(erspan2[10:12], 0x1000|300)
+
+	erspan3 := make([]byte, 4+12+8) // GRE, ERSPAN III header with the O bit, subheader
+	binary.BigEndian.PutUint16(erspan3[2:4], greERSPAN3)
+	binary.BigEndian.PutUint16(erspan3[6:8], 0x2000|5)
+	erspan3[15] = 0x01
+
+	vxlan := make([]byte, 8)
+	vxlan[0] = 0x08
+	binary.BigEndian.PutUint32(vxlan[4:8], 4242<<8)
+
+	geneve := make([]byte, 8+8) // two words of options
+	geneve[0] = 2
+	binary.BigEndian.PutUint16(geneve[2:4], greIPv4)
+	binary.BigEndian.PutUint32(geneve[4:8], 99<<8)
+
+	gtpu := make([]byte, 12+4) // S set, one 4-byte extension header
+	gtpu[0], gtpu[1] = 0x32, gtpuGPDU
+	binary.BigEndian.PutUint32(gtpu[4:8], 0xdeadbeef)
+	gtpu[11] = 0x85
+	gtpu[12] = 1
+
+	tests := []struct {
+		name  string
+		frame []byte
+		typ   flowmeter.TunnelType
+		id    uint32
+	}{
+		{"ipip", outerIPv4(protoIPIP, inner), flowmeter.TunnelIPIP, 0},
+		{"gre", outerIPv4(protoGRE, append(gre, inner...)), flowmeter.TunnelGRE, 77},
+		{"erspan2", outerIPv4(protoGRE, append(erspan2, innerEthernet(inner)...)), flowmeter.TunnelERSPAN, 300},
+		{"erspan3", outerIPv4(protoGRE, append(erspan3, innerEthernet(inner)...)), flowmeter.TunnelERSPAN, 5},
+		{"vxlan", outerUDP(portVXLAN, append(vxlan, innerEthernet(inner)...)), flowmeter.TunnelVXLAN, 4242},
+		{"geneve", outerUDP(portGENEVE, append(geneve, inner...)), flowmeter.TunnelGENEVE, 99},
+		{"gtpu", outerUDP(portGTPU, append(gtpu, inner...)), flowmeter.TunnelGTPU, 0xdeadbeef},
+	}
+	outerSrc, outerDst := netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2")
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := Decoder{Tunnels: TunnelViewInner}
+			var pkt Packet
+			if err := d.Decode(tc.frame, LinkTypeIPv4, &pkt); err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if pkt.SrcAddr != netip.MustParseAddr("10.0.0.1") || pkt.SrcPort != 40000 || pkt.DstPort != 443 || pkt.Protocol != ProtoTCP || pkt.PayloadSize != 10 {
+				t.Errorf("expected inner TCP 10.0.0.1:40000 -> :443, got %v:%d -> %v:%d/%d", pkt.SrcAddr, pkt.SrcPort, pkt.DstAddr, pkt.DstPort, pkt.Protocol)
+			}
+			want := flowmeter.TunnelInfo{Type: tc.typ, SrcAddr: outerSrc, DstAddr: outerDst, ID: tc.id}
+			if pkt.Tunnel != want {
+				t.Errorf("expected tunnel %+v, got %+v", want, pkt.Tunnel)
+			}
+			if d.Stats().Decapsulated != 1 {
+				t.Errorf("expected Decapsulated=1, got %d", d.Stats().Decapsulated)
+			}
+
+			var outer Packet
+			var plain Decoder
+			if err := plain.Decode(tc.frame, LinkTypeIPv4, &outer); err != nil {
+				t.Fatalf("Decode (outer view): %v", err)
+			}
+			if outer.SrcAddr != outerSrc || outer.Tunnel.Type != flowmeter.TunnelNone {
+				t.Errorf("outer view: expected outer packet without tunnel, got %v %+v", outer.SrcAddr, outer.Tunnel)
+			}
+		})
+	}
+}
+
+func TestDecode_TunnelViewBoth(t *testing.T) {
+	vxlan := make([]byte, 8)
+	vxlan[0] = 0x08
+	binary.BigEndian.PutUint32(vxlan[4:8], 7<<8)
+	frame := outerUDP(portVXLAN, append(vxlan, innerEthernet(ipv4TCP(1234, 80, FlagACK, 0, 5))...))
+
+	d := Decoder{Tunnels: TunnelViewBoth}
+	var outer, inner Packet
+	if err := d.Decode(frame, LinkTypeIPv4, &outer); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if outer.DstPort != portVXLAN || outer.Tunnel.Type != flowmeter.TunnelVXLAN || outer.Tunnel.ID != 7 {
+		t.Errorf("expected outer VXLAN packet with VNI 7, got port %d tunnel %+v", outer.DstPort, outer.Tunnel)
+	}
+	if err := d.DecodeInner(frame, &outer, &inner); err != nil {
+		t.Fatalf("DecodeInner: %v", err)
+	}
+	if inner.SrcPort != 1234 || inner.DstPort != 80 || inner.Tunnel != outer.Tunnel {
+		t.Errorf("expected inner 1234 -> 80 in the outer tunnel, got %d -> %d %+v", inner.SrcPort, inner.DstPort, inner.Tunnel)
+	}
+
+	var plain Packet
+	if err := d.Decode(ipv4TCP(1, 2, 0, 0, 0), LinkTypeIPv4, &plain); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if err := d.DecodeInner(ipv4TCP(1, 2, 0, 0, 0), &plain, &inner); !errors.Is(err, ErrNotIP) {
+		t.Errorf("DecodeInner without tunnel: expected ErrNotIP, got %v", err)
+	}
+}
+
+func TestDecode_TunnelInnerUndecodable(t *testing.T) {
+	vxlan := make([]byte, 8)
+	vxlan[0] = 0x08
+	frame := outerUDP(portVXLAN, append(vxlan, make([]byte, 20)...)) // Ethernet with EtherType 0
+
+	d := Decoder{Tunnels: TunnelViewInner}
+	var pkt Packet
+	if err := d.Decode(frame, LinkTypeIPv4, &pkt); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if pkt.DstPort != portVXLAN || pkt.Tunnel.Type != flowmeter.TunnelVXLAN || d.Stats().Decapsulated != 0 {
+		t.Errorf("expected the outer packet with the VXLAN tunnel, got port %d %+v decapsulated=%d", pkt.DstPort, pkt.Tunnel, d.Stats().Decapsulated)
+	}
+}
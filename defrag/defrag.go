@@ -0,0 +1,434 @@
+// Package defrag reassembles fragmented IPv4 and IPv6 datagrams between decoding and
+// flow assignment, so that every fragment's bytes are counted in the flow of the
+// datagram's 5-tuple instead of a port-less flow of its own.
+//
+// Feed each decoded fragment (decoder.Packet.IsFragment) to Reassembler.Add together
+// with its frame; once a datagram is complete Add returns it as a raw IP packet, which is
+// decoded again with decoder.LinkTypeRaw. Fragments are buffered by (source,
+// destination, identification, protocol). Incomplete datagrams are dropped after
+// Config.Timeout, and the oldest are evicted when the memory or datagram caps are
+// reached. Each failure is counted in Stats.
+package defrag
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"time"
+
+	"github.com/Bi9River/goflowmeter/decoder"
+)
+
+// OverlapPolicy says what to do with a fragment that overlaps data already received.
+type OverlapPolicy uint8
+
+const (
+	// OverlapDrop discards the whole datagram and every later fragment of it, as RFC 5722
+	// requires for IPv6.
+	OverlapDrop OverlapPolicy = iota
+	// OverlapFirst keeps the data received first (BSD, Windows).
+	OverlapFirst
+	// OverlapLast lets the newer fragment overwrite earlier data.
+	OverlapLast
+)
+
+// Config holds the reassembly limits. Zero fields take the defaults of DefaultConfig.
+type Config struct {
+	Timeout      time.Duration // how long an incomplete datagram is kept, from its first fragment
+	MaxDatagrams int           // datagrams being reassembled at once
+	MaxBytes     int           // fragment data buffered across all datagrams
+	MaxFragments int           // fragments per datagram
+	// Overlap applies to IPv4; IPv6 datagrams with overlapping fragments are always
+	// dropped (RFC 5722).
+	Overlap OverlapPolicy
+}
+
+// DefaultConfig returns a 30 s timeout (as Linux), 4096 datagrams, 16 MiB and 256
+// fragments per datagram, dropping overlaps.
+func DefaultConfig() Config {
+	return Config{
+		Timeout:      30 * time.Second,
+		MaxDatagrams: 4096,
+		MaxBytes:     16 << 20,
+		MaxFragments: 256,
+		Overlap:      OverlapDrop,
+	}
+}
+
+func (c Config) withDefaults() Config {
+	d := DefaultConfig()
+	if c.Timeout <= 0 {
+		c.Timeout = d.Timeout
+	}
+	if c.MaxDatagrams <= 0 {
+		c.MaxDatagrams = d.MaxDatagrams
+	}
+	if c.MaxBytes <= 0 {
+		c.MaxBytes = d.MaxBytes
+	}
+	if c.MaxFragments <= 0 {
+		c.MaxFragments = d.MaxFragments
+	}
+	return c
+}
+
+// Stats counts fragments and reassembly outcomes.
+type Stats struct {
+	Fragments   uint64 // fragments passed to Add
+	Reassembled uint64 // datagrams completed
+	Timeouts    uint64 // datagrams dropped incomplete after the timeout (or by Flush)
+	Overlaps    uint64 // datagrams dropped for overlapping fragments (OverlapDrop)
+	Duplicates  uint64 // fragments ignored because their data had already been received
+	Evicted     uint64 // datagrams dropped to stay within MaxDatagrams / MaxBytes
+	Truncated   uint64 // fragments ignored because the snaplen cut their data
+	Invalid     uint64 // fragments rejected: bad length, beyond 64 KiB, conflicting end, too many fragments
+}
+
+// maxDatagram is the largest IPv4 total length and IPv6 payload length.
+const maxDatagram = 65535
+
+type key struct {
+	src, dst netip.Addr
+	id       uint32
+	proto    uint8
+}
+
+// span is a received byte range [start, end) of the datagram's data.
+type span struct{ start, end int }
+
+// datagram is one datagram being reassembled.
+type datagram struct {
+	key    key
+	first  time.Time
+	v6     bool
+	header []byte // IP header of the offset-0 fragment; nil until it arrives
+	next   uint8  // IPv6: next header from the fragment header
+	data   []byte
+	have   []span // sorted, merged
+	total  int    // data length, known once the last fragment arrived; -1 before
+	frags  int
+	failed bool // overlap or invalid: later fragments are discarded until the timeout
+	done   bool // completed or evicted; only left in the queue
+}
+
+// Reassembler buffers fragments until their datagrams are complete. It is not safe for
+// concurrent use.
+type Reassembler struct {
+	cfg     Config
+	pending map[key]*datagram
+	queue   []*datagram // by first fragment time, for timeouts and eviction
+	head    int
+	bytes   int
+	stats   Stats
+	frags   int // fragments of the datagram Add returned last
+}
+
+// New returns a Reassembler with cfg's limits.
+func New(cfg Config) *Reassembler {
+	return &Reassembler{cfg: cfg.withDefaults(), pending: make(map[key]*datagram)}
+}
+
+// Stats returns the counters accumulated so far.
+func (r *Reassembler) Stats() Stats {
+	return r.stats
+}
+
+// Fragments returns the number of fragments the datagram last returned by Add was
+// reassembled from.
+func (r *Reassembler) Fragments() int {
+	return r.frags
+}
+
+// Pending returns the number of datagrams being reassembled (including ones that failed
+// and only wait for their timeout).
+func (r *Reassembler) Pending() int {
+	return len(r.pending)
+}
+
+// Add buffers the fragment pkt, decoded from frame at time ts. When it completes its
+// datagram, Add returns the reassembled IP packet (header of the first fragment, fragment
+// fields cleared, lengths updated) and true; the slice is newly allocated. pkt must be a
+// fragment (pkt.IsFragment); other packets are ignored. Timestamps should not go
+// backwards: datagrams older than ts - Timeout are dropped first.
+//
+// IPv6 extension headers before the fragment header are not kept in the reassembled
+// packet.
+func (r *Reassembler) Add(ts time.Time, frame []byte, pkt *decoder.Packet) ([]byte, bool) {
+	if !pkt.IsFragment() {
+		return nil, false
+	}
+	r.stats.Fragments++
+	r.Expire(ts)
+
+	off, n := int(pkt.FragmentOffset), pkt.FragDataLen
+	v6 := pkt.SrcAddr.Is6()
+	switch {
+	case pkt.FragDataOffset+n > len(frame):
+		r.stats.Truncated++
+		return nil, false
+	case n < 0 || (pkt.MoreFragments && (n == 0 || n%8 != 0)) || off+n+r.headerRoom(pkt, v6) > maxDatagram:
+		r.stats.Invalid++
+		return nil, false
+	}
+
+	k := key{src: pkt.SrcAddr, dst: pkt.DstAddr, id: pkt.IPID, proto: pkt.Protocol}
+	if v6 {
+		k.proto = frame[pkt.FragDataOffset-8]
+	}
+	d := r.pending[k]
+	if d == nil {
+		d = &datagram{key: k, first: ts, v6: v6, total: -1, next: k.proto}
+		r.pending[k] = d
+		r.queue = append(r.queue, d)
+		r.evict()
+	}
+	if d.failed {
+		return nil, false
+	}
+	d.frags++
+	if d.frags > r.cfg.MaxFragments {
+		r.stats.Invalid++
+		r.fail(d)
+		return nil, false
+	}
+
+	end := off + n
+	if !pkt.MoreFragments {
+		if (d.total >= 0 && d.total != end) || (d.total < 0 && d.maxEnd() > end) {
+			r.stats.Invalid++
+			r.fail(d)
+			return nil, false
+		}
+		d.total = end
+	} else if d.total >= 0 && end > d.total {
+		r.stats.Invalid++
+		r.fail(d)
+		return nil, false
+	}
+
+	src := frame[pkt.FragDataOffset : pkt.FragDataOffset+n]
+	if !r.insert(d, off, src) {
+		return nil, false
+	}
+	if off == 0 && d.header == nil {
+		d.header = append([]byte(nil), frame[pkt.IPOffset:r.headerEnd(pkt, v6)]...)
+	}
+	if d.header == nil || d.total < 0 || len(d.have) != 1 || d.have[0] != (span{0, d.total}) {
+		return nil, false
+	}
+	r.stats.Reassembled++
+	r.frags = d.frags
+	out := d.build()
+	r.remove(d)
+	return out, true
+}
+
+// headerRoom is the header length that counts towards the 64 KiB limit: the IPv4 header
+// (total length covers it); the IPv6 payload length does not include the fixed header.
+func (r *Reassembler) headerRoom(pkt *decoder.Packet, v6 bool) int {
+	if v6 {
+		return 0
+	}
+	return pkt.FragDataOffset - pkt.IPOffset
+}
+
+// headerEnd is where the IP header to keep ends: the IPv4 header with its options, or the
+// fixed IPv6 header.
+func (r *Reassembler) headerEnd(pkt *decoder.Packet, v6 bool) int {
+	if v6 {
+		return pkt.IPOffset + 40
+	}
+	return pkt.FragDataOffset
+}
+
+func (d *datagram) maxEnd() int {
+	if len(d.have) == 0 {
+		return 0
+	}
+	return d.have[len(d.have)-1].end
+}
+
+// insert copies src to offset off under the overlap policy and records the span. It
+// returns false if the datagram was dropped.
+func (r *Reassembler) insert(d *datagram, off int, src []byte) bool {
+	end := off + len(src)
+	overlap, covered := false, 0
+	for _, s := range d.have {
+		if s.start < end && off < s.end {
+			overlap = true
+			covered += min(s.end, end) - max(s.start, off)
+		}
+	}
+	if overlap {
+		if covered == len(src) && string(d.data[off:end]) == string(src) {
+			r.stats.Duplicates++
+			return true
+		}
+		policy := r.cfg.Overlap
+		if d.v6 {
+			policy = OverlapDrop
+		}
+		if policy == OverlapDrop {
+			r.stats.Overlaps++
+			r.fail(d)
+			return false
+		}
+	}
+	if end > len(d.data) {
+		grown := len(d.data)
+		if end > cap(d.data) {
+			buf := make([]byte, end, max(end, 2*cap(d.data)))
+			copy(buf, d.data)
+			r.bytes += cap(buf) - cap(d.data)
+			d.data = buf
+		}
+		d.data = d.data[:end]
+		clear(d.data[grown:])
+	}
+	if overlap && r.cfg.Overlap == OverlapFirst {
+		// Fill only the gaps between what is already there.
+		pos := off
+		for _, s := range d.have {
+			if s.end <= pos || s.start >= end {
+				continue
+			}
+			if s.start > pos {
+				copy(d.data[pos:s.start], src[pos-off:s.start-off])
+			}
+			pos = max(pos, s.end)
+		}
+		if pos < end {
+			copy(d.data[pos:end], src[pos-off:])
+		}
+	} else {
+		copy(d.data[off:end], src)
+	}
+	d.addSpan(off, end)
+	r.evict()
+	return !d.done
+}
+
+// addSpan merges [start, end) into d.have.
+func (d *datagram) addSpan(start, end int) {
+	out := d.have[:0:0]
+	placed := false
+	for _, s := range d.have {
+		switch {
+		case s.end < start:
+			out = append(out, s)
+		case end < s.start:
+			if !placed {
+				out = append(out, span{start, end})
+				placed = true
+			}
+			out = append(out, s)
+		default:
+			start, end = min(start, s.start), max(end, s.end)
+		}
+	}
+	if !placed {
+		out = append(out, span{start, end})
+	}
+	d.have = out
+}
+
+// build returns the reassembled IP packet.
+func (d *datagram) build() []byte {
+	hl := len(d.header)
+	out := make([]byte, hl+d.total)
+	copy(out, d.header)
+	copy(out[hl:], d.data[:d.total])
+	if d.v6 {
+		binary.BigEndian.PutUint16(out[4:6], uint16(d.total))
+		out[6] = d.next
+		return out
+	}
+	binary.BigEndian.PutUint16(out[2:4], uint16(hl+d.total))
+	out[6] &= 0x40 // keep DF, clear MF and the offset
+	out[7] = 0
+	out[10], out[11] = 0, 0
+	binary.BigEndian.PutUint16(out[10:12], ipv4Checksum(out[:hl]))
+	return out
+}
+
+func ipv4Checksum(h []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(h); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(h[i:]))
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+// fail releases d's buffer and keeps it as a tombstone, so later fragments of the same
+// datagram are discarded until its timeout.
+func (r *Reassembler) fail(d *datagram) {
+	d.failed = true
+	r.bytes -= cap(d.data)
+	d.data, d.have = nil, nil
+}
+
+// remove forgets d; its queue entry is skipped later.
+func (r *Reassembler) remove(d *datagram) {
+	r.bytes -= cap(d.data)
+	d.data, d.have, d.done = nil, nil, true
+	delete(r.pending, d.key)
+}
+
+// Expire drops the datagrams whose first fragment is older than now - Timeout.
+func (r *Reassembler) Expire(now time.Time) {
+	cutoff := now.Add(-r.cfg.Timeout)
+	for r.head < len(r.queue) {
+		d := r.queue[r.head]
+		if !d.done {
+			if !d.first.Before(cutoff) {
+				break
+			}
+			if !d.failed {
+				r.stats.Timeouts++
+			}
+			r.remove(d)
+		}
+		r.pop()
+	}
+}
+
+// Flush drops every pending datagram, counting the incomplete ones as timed out. Call
+// it at the end of the input.
+func (r *Reassembler) Flush() {
+	for _, d := range r.queue[r.head:] {
+		if !d.done && !d.failed {
+			r.stats.Timeouts++
+		}
+		if !d.done {
+			r.remove(d)
+		}
+	}
+	r.queue, r.head = r.queue[:0], 0
+}
+
+// evict drops the oldest datagrams while more than MaxDatagrams are pending or more than
+// MaxBytes are buffered.
+func (r *Reassembler) evict() {
+	for r.head < len(r.queue) && (len(r.pending) > r.cfg.MaxDatagrams || r.bytes > r.cfg.MaxBytes) {
+		d := r.queue[r.head]
+		if !d.done {
+			if !d.failed {
+				r.stats.Evicted++
+			}
+			r.remove(d)
+		}
+		r.pop()
+	}
+}
+
+func (r *Reassembler) pop() {
+	r.queue[r.head] = nil
+	r.head++
+	if r.head > 64 && r.head*2 >= len(r.queue) {
+		n := copy(r.queue, r.queue[r.head:])
+		clear(r.queue[n:])
+		r.queue, r.head = r.queue[:n], 0
+	}
+}
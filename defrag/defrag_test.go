@@ -0,0 +1,280 @@
+package defrag
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/Bi9River/goflowmeter/decoder"
+)
+
+var base = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// udpDatagram returns a UDP header and payload bytes 0, 1, 2, ... (payload bytes long).
+func udpDatagram(payload int) []byte {
+	b := make([]byte, 8+payload)
+	binary.BigEndian.PutUint16(b[0:2], 1000)
+	binary.BigEndian.PutUint16(b[2:4], 2000)
+	binary.BigEndian.PutUint16(b[4:6], uint16(len(b)))
+	for i := 8; i < len(b); i++ {
+		b[i] = byte(i)
+	}
+	return b
+}
+
+// v4Frag builds a raw IPv4 fragment carrying data at byte offset off.
+func v4Frag(id uint16, off int, more bool, data []byte) []byte {
+	b := make([]byte, 20+len(data))
+	b[0] = 0x45
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	binary.BigEndian.PutUint16(b[4:6], id)
+	flags := uint16(off / 8)
+	if more {
+		flags |= 0x2000
+	}
+	binary.BigEndian.PutUint16(b[6:8], flags)
+	b[8], b[9] = 64, decoder.ProtoUDP
+	copy(b[12:16], []byte{10, 0, 0, 1})
+	copy(b[16:20], []byte{10, 0, 0, 2})
+	copy(b[20:], data)
+	return b
+}
+
+// v6Frag builds a raw IPv6 packet with a fragment header carrying data at offset off.
+func v6Frag(id uint32, off int, more bool, data []byte) []byte {
+	b := make([]byte, 48+len(data))
+	b[0] = 0x60
+	binary.BigEndian.PutUint16(b[4:6], uint16(8+len(data)))
+	b[6], b[7] = 44, 64
+	b[8], b[9], b[23] = 0x20, 0x01, 1
+	b[24], b[25], b[39] = 0x20, 0x01, 2
+	b[40] = decoder.ProtoUDP
+	field := uint16(off)
+	if more {
+		field |= 1
+	}
+	binary.BigEndian.PutUint16(b[42:44], field)
+	binary.BigEndian.PutUint32(b[44:48], id)
+	copy(b[48:], data)
+	return b
+}
+
+// add decodes frame and passes it to r.
+func add(t *testing.T, r *Reassembler, ms int, frame []byte) ([]byte, bool) {
+	t.Helper()
+	var d decoder.Decoder
+	var pkt decoder.Packet
+	if err := d.Decode(frame, decoder.LinkTypeRaw, &pkt); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !pkt.IsFragment() {
+		t.Fatalf("expected a fragment")
+	}
+	return r.Add(base.Add(time.Duration(ms)*time.Millisecond), frame, &pkt)
+}
+
+// decodeUDP decodes a reassembled datagram and checks ports and payload size.
+func decodeUDP(t *testing.T, datagram []byte, payload int) decoder.Packet {
+	t.Helper()
+	var d decoder.Decoder
+	var pkt decoder.Packet
+	if err := d.Decode(datagram, decoder.LinkTypeRaw, &pkt); err != nil {
+		t.Fatalf("Decode reassembled: %v", err)
+	}
+	if pkt.IsFragment() || pkt.SrcPort != 1000 || pkt.DstPort != 2000 || pkt.PayloadSize != payload {
+		t.Errorf("expected UDP 1000->2000 with %d bytes, got frag=%v %d->%d payload=%d", payload, pkt.IsFragment(), pkt.SrcPort, pkt.DstPort, pkt.PayloadSize)
+	}
+	return pkt
+}
+
+func TestReassembler_IPv4OutOfOrder(t *testing.T) {
+	udp := udpDatagram(3000)
+	r := New(Config{})
+	if _, ok := add(t, r, 0, v4Frag(7, 2960, false, udp[2960:])); ok {
+		t.Fatal("expected no datagram after the last fragment")
+	}
+	if _, ok := add(t, r, 1, v4Frag(7, 0, true, udp[:1480])); ok {
+		t.Fatal("expected no datagram after the first fragment")
+	}
+	out, ok := add(t, r, 2, v4Frag(7, 1480, true, udp[1480:2960]))
+	if !ok {
+		t.Fatal("expected the datagram after the middle fragment")
+	}
+	decodeUDP(t, out, 3000)
+	if string(out[20:]) != string(udp) {
+		t.Errorf("reassembled data differs from the original")
+	}
+	if flags := binary.BigEndian.Uint16(out[6:8]); flags != 0 {
+		t.Errorf("expected fragment fields cleared, got %#x", flags)
+	}
+	if ipv4Checksum(out[:20]) != 0 {
+		t.Errorf("expected a valid header checksum")
+	}
+	if s := r.Stats(); s.Fragments != 3 || s.Reassembled != 1 || r.Pending() != 0 {
+		t.Errorf("expected 3 fragments, 1 reassembled, 0 pending, got %+v pending=%d", s, r.Pending())
+	}
+	if n := r.Fragments(); n != 3 {
+		t.Errorf("expected the datagram built from 3 fragments, got %d", n)
+	}
+}
+
+func TestReassembler_IPv6(t *testing.T) {
+	udp := udpDatagram(2000)
+	r := New(Config{})
+	add(t, r, 0, v6Frag(0xdeadbeef, 0, true, udp[:1232]))
+	out, ok := add(t, r, 1, v6Frag(0xdeadbeef, 1232, false, udp[1232:]))
+	if !ok {
+		t.Fatal("expected the datagram")
+	}
+	pkt := decodeUDP(t, out, 2000)
+	if pkt.Protocol != decoder.ProtoUDP || len(out) != 40+len(udp) {
+		t.Errorf("expected UDP in a %d byte packet, got proto=%d len=%d", 40+len(udp), pkt.Protocol, len(out))
+	}
+}
+
+func TestReassembler_TinyFirstFragment(t *testing.T) {
+	// The UDP header is split across the first two fragments.
+	udp := udpDatagram(100)
+	r := New(Config{})
+	add(t, r, 0, v4Frag(1, 0, true, udp[:0]))
+	if s := r.Stats(); s.Invalid != 1 {
+		t.Errorf("expected an empty non-last fragment to be invalid, got %+v", s)
+	}
+	add(t, r, 0, v4Frag(2, 8, false, udp[8:]))
+	out, ok := add(t, r, 1, v4Frag(2, 0, true, udp[:8]))
+	if !ok {
+		t.Fatal("expected the datagram")
+	}
+	decodeUDP(t, out, 100)
+}
+
+func TestReassembler_OverlapDrop(t *testing.T) {
+	udp := udpDatagram(100)
+	r := New(Config{})
+	add(t, r, 0, v4Frag(3, 0, true, udp[:64]))
+	add(t, r, 1, v4Frag(3, 56, false, udp[56:])) // overlaps bytes 56-63
+	if _, ok := add(t, r, 2, v4Frag(3, 64, false, udp[64:])); ok {
+		t.Error("expected the datagram to stay dropped after an overlap")
+	}
+	if s := r.Stats(); s.Overlaps != 1 || s.Reassembled != 0 {
+		t.Errorf("expected 1 overlap and nothing reassembled, got %+v", s)
+	}
+	// The tombstone goes away with the timeout without counting as one.
+	r.Expire(base.Add(time.Minute))
+	if s := r.Stats(); r.Pending() != 0 || s.Timeouts != 0 {
+		t.Errorf("expected the tombstone to expire silently, got pending=%d %+v", r.Pending(), s)
+	}
+}
+
+func TestReassembler_OverlapPolicies(t *testing.T) {
+	udp := udpDatagram(100)
+	forged := append([]byte(nil), udp[56:]...)
+	for i := range forged {
+		forged[i] = 0xff
+	}
+	for _, c := range []struct {
+		policy OverlapPolicy
+		want   byte // byte 60 of the datagram
+	}{{OverlapFirst, udp[60]}, {OverlapLast, 0xff}} {
+		r := New(Config{Overlap: c.policy})
+		add(t, r, 0, v4Frag(4, 0, true, udp[:64]))
+		out, ok := add(t, r, 1, v4Frag(4, 56, false, forged))
+		if !ok {
+			t.Fatalf("policy %d: expected the datagram", c.policy)
+		}
+		if got := out[20+60]; got != c.want {
+			t.Errorf("policy %d: expected byte %#x, got %#x", c.policy, c.want, got)
+		}
+		if out[20+70] != 0xff {
+			t.Errorf("policy %d: expected new data past the overlap", c.policy)
+		}
+	}
+
+	// IPv6 always drops (RFC 5722).
+	r := New(Config{Overlap: OverlapLast})
+	add(t, r, 0, v6Frag(9, 0, true, udp[:64]))
+	if _, ok := add(t, r, 1, v6Frag(9, 56, false, forged)); ok || r.Stats().Overlaps != 1 {
+		t.Errorf("expected IPv6 overlap to drop the datagram, got %+v", r.Stats())
+	}
+}
+
+func TestReassembler_Duplicate(t *testing.T) {
+	udp := udpDatagram(100)
+	r := New(Config{})
+	add(t, r, 0, v4Frag(5, 0, true, udp[:64]))
+	add(t, r, 1, v4Frag(5, 0, true, udp[:64]))
+	if _, ok := add(t, r, 2, v4Frag(5, 64, false, udp[64:])); !ok {
+		t.Fatal("expected the datagram despite the duplicate")
+	}
+	if s := r.Stats(); s.Duplicates != 1 || s.Overlaps != 0 {
+		t.Errorf("expected 1 duplicate and no overlap, got %+v", s)
+	}
+}
+
+func TestReassembler_Timeout(t *testing.T) {
+	udp := udpDatagram(100)
+	r := New(Config{Timeout: time.Second})
+	add(t, r, 0, v4Frag(6, 0, true, udp[:64]))
+	if _, ok := add(t, r, 1500, v4Frag(6, 64, false, udp[64:])); ok {
+		t.Error("expected the late fragment to start a new datagram")
+	}
+	if s := r.Stats(); s.Timeouts != 1 || r.Pending() != 1 {
+		t.Errorf("expected 1 timeout and 1 pending, got %+v pending=%d", s, r.Pending())
+	}
+	r.Flush()
+	if s := r.Stats(); s.Timeouts != 2 || r.Pending() != 0 {
+		t.Errorf("expected Flush to time out the rest, got %+v pending=%d", s, r.Pending())
+	}
+}
+
+func TestReassembler_Caps(t *testing.T) {
+	udp := udpDatagram(2000)
+	r := New(Config{MaxDatagrams: 2})
+	for id := uint16(1); id <= 3; id++ {
+		add(t, r, int(id), v4Frag(id, 0, true, udp[:64]))
+	}
+	if s := r.Stats(); s.Evicted != 1 || r.Pending() != 2 {
+		t.Errorf("expected the oldest datagram evicted, got %+v pending=%d", s, r.Pending())
+	}
+	if _, ok := add(t, r, 4, v4Frag(1, 64, false, udp[64:])); ok {
+		t.Error("expected the evicted datagram not to complete")
+	}
+
+	r = New(Config{MaxBytes: 2000})
+	add(t, r, 0, v4Frag(1, 0, true, udp[:1480]))
+	add(t, r, 1, v4Frag(2, 0, true, udp[:1480]))
+	if s := r.Stats(); s.Evicted != 1 {
+		t.Errorf("expected the byte cap to evict one datagram, got %+v", s)
+	}
+
+	r = New(Config{MaxFragments: 2})
+	add(t, r, 0, v4Frag(1, 0, true, udp[:8]))
+	add(t, r, 1, v4Frag(1, 8, true, udp[8:16]))
+	add(t, r, 2, v4Frag(1, 16, false, udp[16:]))
+	if s := r.Stats(); s.Invalid != 1 || s.Reassembled != 0 {
+		t.Errorf("expected too many fragments to be invalid, got %+v", s)
+	}
+}
+
+func TestReassembler_Invalid(t *testing.T) {
+	udp := udpDatagram(100)
+	r := New(Config{})
+	add(t, r, 0, v4Frag(1, 0, true, udp[:60])) // not a multiple of 8
+	add(t, r, 0, v4Frag(2, 65528, false, udp[:16]))
+	add(t, r, 0, v4Frag(3, 64, false, udp[64:]))
+	add(t, r, 0, v4Frag(3, 64, false, udp[64:80])) // conflicting end
+	if s := r.Stats(); s.Invalid != 3 {
+		t.Errorf("expected 3 invalid fragments, got %+v", s)
+	}
+
+	// Snaplen-cut fragment
+	frame := v4Frag(4, 0, true, udp[:64])
+	var d decoder.Decoder
+	var pkt decoder.Packet
+	if err := d.Decode(frame[:50], decoder.LinkTypeRaw, &pkt); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if _, ok := r.Add(base, frame[:50], &pkt); ok || r.Stats().Truncated != 1 {
+		t.Errorf("expected a truncated fragment, got %+v", r.Stats())
+	}
+}
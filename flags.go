@@ -1,49 +1,71 @@
 package flowmeter
 
-// computeFlags fills TCP flag counts per direction (PSH, URG), header length
+// flagsState accumulates TCP flag counts per direction (PSH, URG), header length
 // per direction, and flow-wide counts for FIN, SYN, RST, PSH, ACK, URG, CWR, ECE.
-func computeFlags(packets []PacketInfo, f *FlowFeatures) {
-	for _, p := range packets {
-		if p.Direction == Forward {
-			if p.PSH {
-				f.FwdPSHFlag++
-			}
-			if p.URG {
-				f.FwdURGFlag++
-			}
-			f.FwdHeaderLen += int64(p.HeaderLen)
-		} else {
-			if p.PSH {
-				f.BwdPSHFlag++
-			}
-			if p.URG {
-				f.BwdURGFlag++
-			}
-			f.BwdHeaderLen += int64(p.HeaderLen)
-		}
-		if p.FIN {
-			f.FIN++
-		}
-		if p.SYN {
-			f.SYN++
-		}
-		if p.RST {
-			f.RST++
-		}
+type flagsState struct {
+	fwdPSH, bwdPSH, fwdURG, bwdURG int
+	fwdHeaderLen, bwdHeaderLen     int64
+	fin, syn, rst, psh             int
+	ack, urg, cwr, ece             int
+}
+
+func (s *flagsState) update(p *PacketInfo) {
+	if p.Direction == Forward {
 		if p.PSH {
-			f.PSH++
-		}
-		if p.ACK {
-			f.ACK++
+			s.fwdPSH++
 		}
 		if p.URG {
-			f.URG++
+			s.fwdURG++
 		}
-		if p.CWR {
-			f.CWR++
+		s.fwdHeaderLen += int64(p.HeaderLen)
+	} else {
+		if p.PSH {
+			s.bwdPSH++
 		}
-		if p.ECE {
-			f.ECE++
+		if p.URG {
+			s.bwdURG++
 		}
+		s.bwdHeaderLen += int64(p.HeaderLen)
+	}
+	if p.FIN {
+		s.fin++
 	}
+	if p.SYN {
+		s.syn++
+	}
+	if p.RST {
+		s.rst++
+	}
+	if p.PSH {
+		s.psh++
+	}
+	if p.ACK {
+		s.ack++
+	}
+	if p.URG {
+		s.urg++
+	}
+	if p.CWR {
+		s.cwr++
+	}
+	if p.ECE {
+		s.ece++
+	}
+}
+
+func (s *flagsState) finalize(f *FlowFeatures) {
+	f.FwdPSHFlag = s.fwdPSH
+	f.BwdPSHFlag = s.bwdPSH
+	f.FwdURGFlag = s.fwdURG
+	f.BwdURGFlag = s.bwdURG
+	f.FwdHeaderLen = s.fwdHeaderLen
+	f.BwdHeaderLen = s.bwdHeaderLen
+	f.FIN = s.fin
+	f.SYN = s.syn
+	f.RST = s.rst
+	f.PSH = s.psh
+	f.ACK = s.ack
+	f.URG = s.urg
+	f.CWR = s.cwr
+	f.ECE = s.ece
 }
@@ -0,0 +1,211 @@
+package flowmeter
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DefaultFlowTimeout is CICFlowMeter's default flow timeout (120 s from the first packet).
+const DefaultFlowTimeout = 120 * time.Second
+
+// FlowTable is a long-lived, stateful flow table for streaming input. Packets are added
+// one at a time; a flow is emitted when it exceeds the flow timeout (measured from its
+// first packet), when it has been inactive longer than the idle timeout, or when a
+// packet carrying TCP FIN or RST terminates it. Apart from the orientation below, emitted
+// features are computed exactly as ProcessPacketsWithKeys would compute them for the same
+// packets.
+//
+// Each flow is oriented by its own first packet, as in CICFlowMeter: if that packet was
+// added as Backward (other than an ICMP reply, whose requester stays forward), the
+// Direction of every packet of the flow is reversed. A flow started after a FIN, RST or
+// timeout split therefore has its first sender as initiator even when the directions
+// were assigned over the whole capture.
+//
+// Flow state is a constant-size accumulator, not a packet buffer, so long flows cost no
+// more memory than short ones. Packets of one flow must therefore be added in timestamp
+// order (capture order). A FlowTable is not safe for concurrent use.
+type FlowTable struct {
+	cfg   Config
+	flows map[FlowKey]*tableFlow
+	seq   uint64 // flows created so far
+}
+
+// tableFlow is the per-flow state kept by FlowTable until the flow is emitted.
+type tableFlow struct {
+	acc  flowAccumulator
+	seq  uint64 // creation order, to break ties between flows that start together
+	flip bool   // the first packet was added as Backward: reverse every packet's Direction
+}
+
+// pendingFlow is a flow Expire or Flush is about to emit.
+type pendingFlow struct {
+	key    FlowKey
+	fl     *tableFlow
+	reason EndReason
+}
+
+// EndReason is why a flow ended (FlowWithKey.Reason).
+type EndReason uint8
+
+const (
+	EndUnknown     EndReason = iota // not recorded, e.g. by a flow exporter
+	EndWindow                       // the window (ProcessPackets*) or the input (FlowTable.Flush) ended
+	EndFlowTimeout                  // the flow timeout, counted from the first packet, expired
+	EndIdleTimeout                  // the flow was inactive longer than the idle timeout
+	EndFIN                          // a packet carried TCP FIN
+	EndRST                          // a packet carried TCP RST
+)
+
+var endReasonNames = [...]string{
+	EndUnknown:     "unknown",
+	EndWindow:      "window",
+	EndFlowTimeout: "flow-timeout",
+	EndIdleTimeout: "idle-timeout",
+	EndFIN:         "fin",
+	EndRST:         "rst",
+}
+
+// String returns the reason's name: unknown, window, flow-timeout, idle-timeout, fin or
+// rst.
+func (r EndReason) String() string {
+	if int(r) < len(endReasonNames) {
+		return endReasonNames[r]
+	}
+	return fmt.Sprintf("EndReason(%d)", r)
+}
+
+// NewFlowTable returns an empty FlowTable using DefaultConfig with the given timeouts.
+// flowTimeout <= 0 uses DefaultFlowTimeout. idleTimeout <= 0 disables the inactivity
+// check (CICFlowMeter itself only splits flows on the flow timeout and on FIN/RST).
+func NewFlowTable(flowTimeout, idleTimeout time.Duration) *FlowTable {
+	cfg := DefaultConfig()
+	cfg.FlowTimeout = flowTimeout
+	cfg.IdleTimeout = idleTimeout
+	return NewFlowTableWithConfig(cfg)
+}
+
+// NewFlowTableWithConfig returns an empty FlowTable whose timeouts and feature thresholds
+// come from cfg.
+func NewFlowTableWithConfig(cfg Config) *FlowTable {
+	return &FlowTable{
+		cfg:   cfg.withDefaults(),
+		flows: make(map[FlowKey]*tableFlow),
+	}
+}
+
+// Add inserts one packet and returns the flows it caused to be emitted: the packet's
+// previous flow if it timed out (the packet then starts a new flow with the same key),
+// and the packet's own flow if the packet carries FIN or RST. Most calls return nil.
+func (t *FlowTable) Add(p PacketInfo) []FlowWithKey {
+	var out []FlowWithKey
+	key := t.cfg.KeyOrder.Canonical(p.KeyWith(t.cfg.KeyFields))
+	fl := t.flows[key]
+	if fl != nil {
+		if reason := t.expired(fl, p.Timestamp); reason != EndUnknown {
+			out = append(out, t.emit(key, fl, reason))
+			fl = nil
+		}
+	}
+	if fl == nil {
+		t.seq++
+		fl = &tableFlow{seq: t.seq, flip: p.Direction != firstDirection(&p)}
+		fl.acc.init(&t.cfg)
+		t.flows[key] = fl
+	}
+	if fl.flip {
+		p.Direction = reverse(p.Direction)
+	}
+	fl.acc.update(&p)
+	switch {
+	case p.RST:
+		out = append(out, t.emit(key, fl, EndRST))
+	case p.FIN:
+		out = append(out, t.emit(key, fl, EndFIN))
+	}
+	return out
+}
+
+// Expire emits every flow that has timed out as of now (typically the timestamp of the
+// most recent packet). Call it periodically so idle flows do not accumulate. Flows are
+// returned in order of their first packet.
+func (t *FlowTable) Expire(now time.Time) []FlowWithKey {
+	var pending []pendingFlow
+	for key, fl := range t.flows {
+		if reason := t.expired(fl, now); reason != EndUnknown {
+			pending = append(pending, pendingFlow{key, fl, reason})
+		}
+	}
+	return t.emitOrdered(pending)
+}
+
+// Flush emits all flows still held by the table (e.g. at end of capture) and empties it.
+// Flows are returned in order of their first packet.
+func (t *FlowTable) Flush() []FlowWithKey {
+	pending := make([]pendingFlow, 0, len(t.flows))
+	for key, fl := range t.flows {
+		pending = append(pending, pendingFlow{key, fl, EndWindow})
+	}
+	return t.emitOrdered(pending)
+}
+
+// emitOrdered emits pending sorted by first packet time, then by creation, so the
+// output does not depend on map iteration order.
+func (t *FlowTable) emitOrdered(pending []pendingFlow) []FlowWithKey {
+	if len(pending) == 0 {
+		return nil
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		a, b := pending[i].fl, pending[j].fl
+		if !a.acc.meta.first.Equal(b.acc.meta.first) {
+			return a.acc.meta.first.Before(b.acc.meta.first)
+		}
+		return a.seq < b.seq
+	})
+	out := make([]FlowWithKey, len(pending))
+	for i, p := range pending {
+		out[i] = t.emit(p.key, p.fl, p.reason)
+	}
+	return out
+}
+
+// Len returns the number of flows currently held by the table.
+func (t *FlowTable) Len() int {
+	return len(t.flows)
+}
+
+// expired returns the timeout fl has hit at time now, or EndUnknown if it has not.
+func (t *FlowTable) expired(fl *tableFlow, now time.Time) EndReason {
+	if now.Sub(fl.acc.meta.first) > t.cfg.FlowTimeout {
+		return EndFlowTimeout
+	}
+	if t.cfg.IdleTimeout > 0 && now.Sub(fl.acc.meta.last) > t.cfg.IdleTimeout {
+		return EndIdleTimeout
+	}
+	return EndUnknown
+}
+
+// firstDirection returns the direction the first packet p of a flow should have:
+// Backward for an ICMP reply, Forward otherwise.
+func firstDirection(p *PacketInfo) Direction {
+	if _, reply, _ := icmpRequestType(p.Protocol, p.ICMPType); isICMP(p.Protocol) && reply {
+		return Backward
+	}
+	return Forward
+}
+
+// reverse returns the opposite direction of d.
+func reverse(d Direction) Direction {
+	if d == Forward {
+		return Backward
+	}
+	return Forward
+}
+
+// emit removes the flow from the table and computes its features.
+func (t *FlowTable) emit(key FlowKey, fl *tableFlow, reason EndReason) FlowWithKey {
+	delete(t.flows, key)
+	out := fl.acc.flow(key, reason)
+	t.cfg.KeyOrder.orient(&out)
+	return out
+}
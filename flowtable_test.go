@@ -0,0 +1,191 @@
+package flowmeter
+
+import (
+	"net/netip"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestFlowTable_MatchesProcessPackets(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	packets := []PacketInfo{
+		{Timestamp: base, HeaderLen: 40, PayloadSize: 60, Direction: Forward, TCPWindow: 1024, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 11111, DstPort: 80, Protocol: 6, SYN: true},
+		{Timestamp: base.Add(10 * time.Millisecond), HeaderLen: 40, PayloadSize: 40, Direction: Backward, TCPWindow: 512, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 11111, DstPort: 80, Protocol: 6, SYN: true, ACK: true},
+		{Timestamp: base.Add(20 * time.Millisecond), HeaderLen: 40, PayloadSize: 160, Direction: Forward, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 11111, DstPort: 80, Protocol: 6, PSH: true, ACK: true},
+		{Timestamp: base.Add(1500 * time.Millisecond), HeaderLen: 40, PayloadSize: 50, Direction: Backward, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 11111, DstPort: 80, Protocol: 6, ACK: true},
+		{Timestamp: base.Add(3 * time.Second), HeaderLen: 8, PayloadSize: 80, Direction: Forward, SrcIP: "3.3.3.3", DstIP: "4.4.4.4", SrcPort: 53, DstPort: 5353, Protocol: 17},
+	}
+	want := make(map[FlowKey]FlowWithKey)
+	for _, p := range ProcessPacketsWithKeys(append([]PacketInfo(nil), packets...)) {
+		want[p.Key] = p
+	}
+
+	table := NewFlowTable(0, 0)
+	var got []FlowWithKey
+	for _, p := range packets {
+		got = append(got, table.Add(p)...)
+	}
+	got = append(got, table.Flush()...)
+	if len(got) != len(want) {
+		t.Fatalf("expected %d flows, got %d", len(want), len(got))
+	}
+	for _, p := range got {
+		w := want[p.Key]
+		if !reflect.DeepEqual(p.Features, w.Features) {
+			t.Errorf("flow %v: streaming features differ from batch:\n got %+v\nwant %+v", p.Key, p.Features, w.Features)
+		}
+		if !p.Start.Equal(w.Start) || !p.End.Equal(w.End) || p.Initiator != w.Initiator || p.Reason != EndWindow {
+			t.Errorf("flow %v: expected %v-%v from %v ending with window, got %v-%v from %v ending with %v", p.Key, w.Start, w.End, w.Initiator, p.Start, p.End, p.Initiator, p.Reason)
+		}
+	}
+	if table.Len() != 0 {
+		t.Errorf("expected empty table after Flush, got %d flows", table.Len())
+	}
+}
+
+func TestFlowTable_FlowTimeoutSplitsFlow(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	table := NewFlowTable(10*time.Second, 0)
+	mk := func(ts time.Time) PacketInfo {
+		return PacketInfo{Timestamp: ts, Direction: Forward, PayloadSize: 10, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 1, DstPort: 2, Protocol: 17}
+	}
+	if out := table.Add(mk(base)); out != nil {
+		t.Fatalf("expected no emission on first packet, got %d", len(out))
+	}
+	table.Add(mk(base.Add(5 * time.Second)))
+	out := table.Add(mk(base.Add(11 * time.Second)))
+	if len(out) != 1 {
+		t.Fatalf("expected 1 emitted flow at flow timeout, got %d", len(out))
+	}
+	if out[0].Features.TotalFwdPackets != 2 || out[0].Features.FlowDurationUs != 5_000_000 {
+		t.Errorf("emitted flow: expected 2 packets over 5s, got %d packets over %dus", out[0].Features.TotalFwdPackets, out[0].Features.FlowDurationUs)
+	}
+	if out[0].Reason != EndFlowTimeout || !out[0].End.Equal(base.Add(5*time.Second)) {
+		t.Errorf("emitted flow: expected a flow timeout at 5s, got %v at %v", out[0].Reason, out[0].End)
+	}
+	rest := table.Flush()
+	if len(rest) != 1 || rest[0].Features.TotalFwdPackets != 1 || rest[0].Reason != EndWindow || !rest[0].Start.Equal(base.Add(11*time.Second)) {
+		t.Errorf("expected remaining flow with 1 packet, got %+v", rest)
+	}
+}
+
+func TestFlowTable_IdleTimeoutAndExpire(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	table := NewFlowTable(0, 5*time.Second)
+	table.Add(PacketInfo{Timestamp: base, Direction: Forward, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 1, DstPort: 2, Protocol: 17})
+	table.Add(PacketInfo{Timestamp: base.Add(time.Second), Direction: Forward, SrcIP: "3.3.3.3", DstIP: "4.4.4.4", SrcPort: 1, DstPort: 2, Protocol: 17})
+	if out := table.Expire(base.Add(4 * time.Second)); len(out) != 0 {
+		t.Fatalf("expected no expiry after 4s, got %d", len(out))
+	}
+	out := table.Expire(base.Add(5500 * time.Millisecond))
+	if len(out) != 1 || out[0].Key.SrcIP() != "1.1.1.1" {
+		t.Fatalf("expected only the 1.1.1.1 flow to expire, got %+v", out)
+	}
+	if out[0].Reason != EndIdleTimeout {
+		t.Errorf("expected an idle timeout, got %v", out[0].Reason)
+	}
+	if table.Len() != 1 {
+		t.Errorf("expected 1 flow left in table, got %d", table.Len())
+	}
+}
+
+func TestFlowTable_FINAndRSTTerminate(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	table := NewFlowTable(0, 0)
+	table.Add(PacketInfo{Timestamp: base, Direction: Forward, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 1, DstPort: 2, Protocol: 6, SYN: true})
+	out := table.Add(PacketInfo{Timestamp: base.Add(time.Millisecond), Direction: Backward, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 1, DstPort: 2, Protocol: 6, RST: true})
+	if len(out) != 1 || out[0].Reason != EndRST {
+		t.Fatalf("expected RST to emit the flow, got %+v", out)
+	}
+	if out[0].Features.TotalFwdPackets != 1 || out[0].Features.TotalBwdPackets != 1 || out[0].Features.RST != 1 {
+		t.Errorf("expected 1 fwd + 1 bwd packet and RST=1, got fwd=%d bwd=%d RST=%d", out[0].Features.TotalFwdPackets, out[0].Features.TotalBwdPackets, out[0].Features.RST)
+	}
+	out = table.Add(PacketInfo{Timestamp: base.Add(2 * time.Millisecond), Direction: Forward, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 1, DstPort: 2, Protocol: 6, FIN: true, ACK: true})
+	if len(out) != 1 || out[0].Features.FIN != 1 || out[0].Reason != EndFIN {
+		t.Fatalf("expected FIN packet to start and terminate a new flow, got %+v", out)
+	}
+	if table.Len() != 0 {
+		t.Errorf("expected empty table, got %d flows", table.Len())
+	}
+}
+
+func TestFlowTable_FlushAndExpireOrder(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for run := 0; run < 5; run++ {
+		table := NewFlowTable(0, 10*time.Second)
+		// Flows 0..19 start every 100 ms; 20..39 start together with 0..19.
+		for i := 0; i < 40; i++ {
+			ts := base.Add(time.Duration(i%20) * 100 * time.Millisecond)
+			table.Add(PacketInfo{Timestamp: ts, Direction: Forward, SrcIP: "10.0.0.1", DstIP: "10.0.1.1", SrcPort: uint16(40000 - i), DstPort: 53, Protocol: 17})
+		}
+		check := func(name string, out []FlowWithKey, n int) {
+			if len(out) != n {
+				t.Fatalf("%s: expected %d flows, got %d", name, n, len(out))
+			}
+			for i := 1; i < len(out); i++ {
+				prev, cur := out[i-1], out[i]
+				if cur.Start.Before(prev.Start) || cur.Start.Equal(prev.Start) && cur.Key.SrcPort > prev.Key.SrcPort {
+					t.Fatalf("%s: flow %d (%v, port %d) out of order after (%v, port %d)", name, i, cur.Start, cur.Key.SrcPort, prev.Start, prev.Key.SrcPort)
+				}
+			}
+		}
+		// The first 10 start times (20 flows) are idle for more than 10 s at 10.95 s.
+		check("Expire", table.Expire(base.Add(10950*time.Millisecond)), 20)
+		check("Flush", table.Flush(), 20)
+	}
+}
+
+func TestFlowTable_SplitFlowOrientedByFirstPacket(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	fromClient := RawPacket{SrcIP: "10.0.0.9", DstIP: "10.0.0.1", SrcPort: 5000, DstPort: 80, Protocol: 6, HeaderLen: 40}
+	fromServer := RawPacket{SrcIP: "10.0.0.1", DstIP: "10.0.0.9", SrcPort: 80, DstPort: 5000, Protocol: 6, HeaderLen: 40}
+	var raw []RawPacket
+	add := func(r RawPacket, ms int, set func(*RawPacket)) {
+		r.Timestamp = base.Add(time.Duration(ms) * time.Millisecond)
+		set(&r)
+		raw = append(raw, r)
+	}
+	add(fromClient, 0, func(r *RawPacket) { r.SYN = true })
+	add(fromServer, 1, func(r *RawPacket) { r.SYN, r.ACK = true, true })
+	add(fromClient, 2, func(r *RawPacket) { r.FIN, r.ACK = true, true })
+	add(fromServer, 3, func(r *RawPacket) { r.ACK = true })
+	add(fromServer, 4, func(r *RawPacket) { r.ACK = true })
+	// Directions are assigned over the whole capture: the server's ACKs are Backward.
+	packets := ConvertToPacketInfo(raw)
+	sort.SliceStable(packets, func(i, j int) bool { return packets[i].Timestamp.Before(packets[j].Timestamp) })
+
+	table := NewFlowTable(0, 0)
+	var got []FlowWithKey
+	for _, p := range packets {
+		got = append(got, table.Add(p)...)
+	}
+	got = append(got, table.Flush()...)
+	if len(got) != 2 {
+		t.Fatalf("expected 2 flows split on FIN, got %d", len(got))
+	}
+	client := Endpoint{netip.MustParseAddr("10.0.0.9"), 5000}
+	server := Endpoint{netip.MustParseAddr("10.0.0.1"), 80}
+	if f := got[0].Features; f.TotalFwdPackets != 2 || f.TotalBwdPackets != 1 || got[0].Initiator != client {
+		t.Errorf("first flow: expected 2 fwd, 1 bwd from %v, got fwd=%d bwd=%d from %v", client, f.TotalFwdPackets, f.TotalBwdPackets, got[0].Initiator)
+	}
+	if f := got[1].Features; f.TotalFwdPackets != 2 || f.TotalBwdPackets != 0 || got[1].Initiator != server {
+		t.Errorf("second flow: expected 2 fwd, 0 bwd from %v, got fwd=%d bwd=%d from %v", server, f.TotalFwdPackets, f.TotalBwdPackets, got[1].Initiator)
+	}
+}
+
+func TestFlowTable_ICMPReplyFirstKeepsRequesterForward(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	table := NewFlowTable(0, 0)
+	// An echo reply seen first is Backward; the requester 1.1.1.1 stays forward.
+	table.Add(PacketInfo{Timestamp: base, Direction: Backward, Swapped: true, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 7, DstPort: 8, Protocol: 1, ICMPType: 0, ICMPID: 7})
+	table.Add(PacketInfo{Timestamp: base.Add(time.Second), Direction: Forward, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 7, DstPort: 8, Protocol: 1, ICMPType: 8, ICMPID: 7})
+	out := table.Flush()
+	if len(out) != 1 {
+		t.Fatalf("expected 1 flow, got %d", len(out))
+	}
+	if f := out[0].Features; f.TotalFwdPackets != 1 || f.TotalBwdPackets != 1 || out[0].Initiator.Addr != netip.MustParseAddr("1.1.1.1") {
+		t.Errorf("expected 1 fwd, 1 bwd from 1.1.1.1, got fwd=%d bwd=%d from %v", f.TotalFwdPackets, f.TotalBwdPackets, out[0].Initiator)
+	}
+}
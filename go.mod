@@ -1,3 +1,5 @@
 module github.com/Bi9River/goflowmeter
 
 go 1.21
+
+require github.com/klauspost/compress v1.17.11
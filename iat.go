@@ -2,51 +2,52 @@ package flowmeter
 
 import "time"
 
-// iatForDirection filters packets by direction and returns IAT deltas in microseconds
-// and total duration between consecutive same-direction packets. Caller must pass
-// packets sorted by timestamp.
-func iatForDirection(packets []PacketInfo, dir Direction) (deltasUs []float64, total time.Duration) {
-	var dirPackets []PacketInfo
-	for _, p := range packets {
-		if p.Direction == dir {
-			dirPackets = append(dirPackets, p)
-		}
-	}
-	if len(dirPackets) < 2 {
-		return nil, 0
-	}
-	for i := 1; i < len(dirPackets); i++ {
-		d := dirPackets[i].Timestamp.Sub(dirPackets[i-1].Timestamp)
-		total += d
-		deltasUs = append(deltasUs, float64(d.Microseconds()))
+// iatDirState accumulates IAT deltas between consecutive same-direction packets.
+type iatDirState struct {
+	seen  bool
+	last  time.Time
+	stats RunningStats // deltas in microseconds
+	total time.Duration
+}
+
+func (s *iatDirState) update(ts time.Time) {
+	if s.seen {
+		d := ts.Sub(s.last)
+		s.total += d
+		s.stats.Add(float64(d.Microseconds()))
 	}
-	return deltasUs, total
+	s.seen = true
+	s.last = ts
 }
 
-// computeIAT fills inter-arrival time statistics: flow-wide, forward, and backward.
-// Packets must be sorted by timestamp. IAT = time between consecutive packets
+// iatState accumulates inter-arrival time statistics: flow-wide, forward, and backward.
+// Packets must arrive sorted by timestamp. IAT = time between consecutive packets
 // (per direction for Fwd/Bwd). Single-packet flows get zero IAT stats.
 // All IAT values (Mean, Std, Min, Max) are in microseconds, matching CICFlowMeter.
-func computeIAT(packets []PacketInfo, f *FlowFeatures) {
-	if len(packets) < 2 {
-		return
-	}
-	// Flow IAT: consecutive packets (already time-ordered), in microseconds
-	var flowDeltas []float64
-	for i := 1; i < len(packets); i++ {
-		d := packets[i].Timestamp.Sub(packets[i-1].Timestamp)
-		flowDeltas = append(flowDeltas, float64(d.Microseconds()))
+type iatState struct {
+	flow, fwd, bwd iatDirState
+}
+
+func (s *iatState) update(p *PacketInfo) {
+	s.flow.update(p.Timestamp)
+	if p.Direction == Forward {
+		s.fwd.update(p.Timestamp)
+	} else {
+		s.bwd.update(p.Timestamp)
 	}
-	f.FlowIAT = StatsFromValues(flowDeltas)
+}
 
-	fwdDeltas, fwdTotal := iatForDirection(packets, Forward)
-	if len(fwdDeltas) > 0 {
-		f.FwdIATTotal = fwdTotal
-		f.FwdIAT = StatsFromValues(fwdDeltas)
+func (s *iatState) finalize(f *FlowFeatures) {
+	if s.flow.stats.Count() == 0 {
+		return
+	}
+	f.FlowIAT = s.flow.stats.Stats()
+	if s.fwd.stats.Count() > 0 {
+		f.FwdIATTotal = s.fwd.total
+		f.FwdIAT = s.fwd.stats.Stats()
 	}
-	bwdDeltas, bwdTotal := iatForDirection(packets, Backward)
-	if len(bwdDeltas) > 0 {
-		f.BwdIATTotal = bwdTotal
-		f.BwdIAT = StatsFromValues(bwdDeltas)
+	if s.bwd.stats.Count() > 0 {
+		f.BwdIATTotal = s.bwd.total
+		f.BwdIAT = s.bwd.stats.Stats()
 	}
 }
@@ -0,0 +1,158 @@
+package flowmeter
+
+import "math/bits"
+
+// IP protocol numbers of ICMP and ICMPv6.
+const (
+	protoICMP   = 1
+	protoICMPv6 = 58
+)
+
+// ICMP types the ICMP features look at.
+const (
+	icmpEchoReply      = 0
+	icmpUnreachable    = 3
+	icmpEchoRequest    = 8
+	icmpTimeExceeded   = 11
+	icmpv6Unreachable  = 1
+	icmpv6TimeExceeded = 3
+	icmpv6EchoRequest  = 128
+	icmpv6EchoReply    = 129
+	maxPendingEchoes   = 16
+)
+
+// icmpRequestType returns the request type of the query/reply pair t belongs to (echo,
+// timestamp, information and address mask for ICMP; echo for ICMPv6) and whether t is
+// the reply.
+func icmpRequestType(proto, t uint8) (req uint8, reply, ok bool) {
+	if proto == protoICMPv6 {
+		switch t {
+		case icmpv6EchoRequest:
+			return t, false, true
+		case icmpv6EchoReply:
+			return icmpv6EchoRequest, true, true
+		}
+		return 0, false, false
+	}
+	switch t {
+	case icmpEchoRequest, 13, 15, 17:
+		return t, false, true
+	case icmpEchoReply:
+		return icmpEchoRequest, true, true
+	case 14, 16, 18:
+		return t - 1, true, true
+	}
+	return 0, false, false
+}
+
+// ICMPIsQuery reports whether an ICMP (proto 1) or ICMPv6 (proto 58) message of type t
+// is a query or reply that carries an identifier and sequence number.
+func ICMPIsQuery(proto, t uint8) bool {
+	_, _, ok := icmpRequestType(proto, t)
+	return ok
+}
+
+// ICMPPorts returns the ports an ICMP or ICMPv6 message has in its flow key. Queries
+// and replies are keyed on the request type and identifier: a request gets
+// (identifier, request type) and its reply (request type, identifier), so both end up in
+// one flow with the requester on the forward side and sessions with other identifiers in
+// other flows. Other messages get (0, type<<8 | code), as in nfdump.
+// ConvertToPacketInfo applies this; callers building PacketInfo for ICMP themselves can
+// use it for SrcPort and DstPort.
+func ICMPPorts(proto, typ, code uint8, id uint16) (src, dst uint16) {
+	req, reply, ok := icmpRequestType(proto, typ)
+	switch {
+	case !ok:
+		return 0, uint16(typ)<<8 | uint16(code)
+	case reply:
+		return uint16(req), id
+	}
+	return id, uint16(req)
+}
+
+func isICMP(proto uint8) bool {
+	return proto == protoICMP || proto == protoICMPv6
+}
+
+// pendingEcho is an echo request waiting for its reply.
+type pendingEcho struct {
+	seq uint16
+	ts  int64 // microseconds
+}
+
+// icmpState counts ICMP messages by kind and pairs echo replies with their requests by
+// sequence number for the echo RTT. Up to maxPendingEchoes requests wait for a reply;
+// beyond that the oldest is given up. Flows of other protocols report ICMPType and
+// ICMPCode as -1. Packets must arrive sorted by timestamp.
+type icmpState struct {
+	seen                 bool
+	firstType, firstCode uint8
+	types                [4]uint64 // bitset of the types seen
+	echoRequests         int
+	echoReplies          int
+	matched              int
+	unreachable          int
+	timeExceeded         int
+	other                int
+	pending              [maxPendingEchoes]pendingEcho
+	nPending             int
+	rtt                  RunningStats
+}
+
+func (s *icmpState) update(p *PacketInfo) {
+	if !isICMP(p.Protocol) {
+		return
+	}
+	t := p.ICMPType
+	if !s.seen {
+		s.seen, s.firstType, s.firstCode = true, t, p.ICMPCode
+	}
+	s.types[t>>6] |= 1 << (t & 63)
+	v6 := p.Protocol == protoICMPv6
+	ts := p.Timestamp.UnixMicro()
+	switch {
+	case (!v6 && t == icmpEchoRequest) || (v6 && t == icmpv6EchoRequest):
+		s.echoRequests++
+		if s.nPending == maxPendingEchoes {
+			copy(s.pending[:], s.pending[1:])
+			s.nPending--
+		}
+		s.pending[s.nPending] = pendingEcho{p.ICMPSeq, ts}
+		s.nPending++
+	case (!v6 && t == icmpEchoReply) || (v6 && t == icmpv6EchoReply):
+		s.echoReplies++
+		for i := 0; i < s.nPending; i++ {
+			if s.pending[i].seq == p.ICMPSeq {
+				s.rtt.Add(float64(ts - s.pending[i].ts))
+				s.matched++
+				copy(s.pending[i:], s.pending[i+1:s.nPending])
+				s.nPending--
+				break
+			}
+		}
+	case (!v6 && t == icmpUnreachable) || (v6 && t == icmpv6Unreachable):
+		s.unreachable++
+	case (!v6 && t == icmpTimeExceeded) || (v6 && t == icmpv6TimeExceeded):
+		s.timeExceeded++
+	default:
+		s.other++
+	}
+}
+
+func (s *icmpState) finalize(f *FlowFeatures) {
+	f.ICMPType, f.ICMPCode = -1, -1
+	if !s.seen {
+		return
+	}
+	f.ICMPType, f.ICMPCode = int(s.firstType), int(s.firstCode)
+	for _, w := range s.types {
+		f.ICMPDistinctTypes += bits.OnesCount64(w)
+	}
+	f.ICMPEchoRequests = s.echoRequests
+	f.ICMPEchoReplies = s.echoReplies
+	f.ICMPEchoUnanswered = s.echoRequests - s.matched
+	f.ICMPUnreachable = s.unreachable
+	f.ICMPTimeExceeded = s.timeExceeded
+	f.ICMPOther = s.other
+	f.ICMPEchoRTT = s.rtt.Stats()
+}
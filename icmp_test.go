@@ -0,0 +1,134 @@
+package flowmeter
+
+import (
+	"testing"
+	"time"
+)
+
+func icmpRaw(base time.Time, ms int, src, dst string, typ, code uint8, id, seq uint16) RawPacket {
+	return RawPacket{
+		Timestamp: base.Add(time.Duration(ms) * time.Millisecond), HeaderLen: 8, PayloadSize: 56,
+		SrcIP: src, DstIP: dst, Protocol: 1, ICMPType: typ, ICMPCode: code, ICMPID: id, ICMPSeq: seq,
+	}
+}
+
+func TestICMPPorts(t *testing.T) {
+	cases := []struct {
+		proto, typ, code uint8
+		id               uint16
+		src, dst         uint16
+	}{
+		{1, 8, 0, 0x1234, 0x1234, 8}, // echo request
+		{1, 0, 0, 0x1234, 8, 0x1234}, // echo reply
+		{1, 14, 0, 7, 13, 7},         // timestamp reply
+		{1, 3, 3, 0, 0, 3<<8 | 3},    // port unreachable
+		{58, 128, 0, 9, 9, 128},      // ICMPv6 echo request
+		{58, 129, 0, 9, 128, 9},      // ICMPv6 echo reply
+		{58, 135, 0, 0, 0, 135 << 8}, // neighbor solicitation
+	}
+	for _, c := range cases {
+		src, dst := ICMPPorts(c.proto, c.typ, c.code, c.id)
+		if src != c.src || dst != c.dst {
+			t.Errorf("ICMPPorts(%d, %d, %d, %d): expected %d/%d, got %d/%d", c.proto, c.typ, c.code, c.id, c.src, c.dst, src, dst)
+		}
+	}
+}
+
+func TestProcessPackets_ICMP_EchoSessions(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	raw := []RawPacket{
+		icmpRaw(base, 0, "10.0.0.1", "10.0.0.2", 8, 0, 100, 1),
+		icmpRaw(base, 3, "10.0.0.2", "10.0.0.1", 0, 0, 100, 1),
+		icmpRaw(base, 1000, "10.0.0.1", "10.0.0.2", 8, 0, 100, 2),
+		icmpRaw(base, 1005, "10.0.0.2", "10.0.0.1", 0, 0, 100, 2),
+		icmpRaw(base, 2000, "10.0.0.1", "10.0.0.2", 8, 0, 100, 3), // lost
+		icmpRaw(base, 10, "10.0.0.1", "10.0.0.2", 8, 0, 200, 1),   // another ping session
+	}
+	flows := ProcessPacketsWithKeys(ConvertToPacketInfo(raw))
+	if len(flows) != 2 {
+		t.Fatalf("expected one flow per echo identifier, got %d", len(flows))
+	}
+	var f FlowFeatures
+	for _, fl := range flows {
+		if fl.Key.SrcPort == 100 || fl.Key.DstPort == 100 {
+			f = fl.Features
+		}
+	}
+	if f.TotalFwdPackets != 3 || f.TotalBwdPackets != 2 {
+		t.Errorf("expected requests forward and replies backward (3/2), got %d/%d", f.TotalFwdPackets, f.TotalBwdPackets)
+	}
+	if f.ICMPEchoRequests != 3 || f.ICMPEchoReplies != 2 || f.ICMPEchoUnanswered != 1 {
+		t.Errorf("expected 3 requests, 2 replies, 1 unanswered, got %d/%d/%d", f.ICMPEchoRequests, f.ICMPEchoReplies, f.ICMPEchoUnanswered)
+	}
+	if f.ICMPEchoRTT.Min != 3000 || f.ICMPEchoRTT.Max != 5000 || f.ICMPEchoRTT.Mean != 4000 {
+		t.Errorf("ICMPEchoRTT: expected min 3000 max 5000 mean 4000, got %+v", f.ICMPEchoRTT)
+	}
+	if f.ICMPType != 8 || f.ICMPCode != 0 || f.ICMPDistinctTypes != 2 {
+		t.Errorf("expected first type 8/0 and 2 distinct types, got %d/%d/%d", f.ICMPType, f.ICMPCode, f.ICMPDistinctTypes)
+	}
+}
+
+func TestProcessPackets_ICMP_ReplyFirst(t *testing.T) {
+	// Capture started between request and reply: the requester is still forward.
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	raw := []RawPacket{
+		icmpRaw(base, 0, "10.0.0.2", "10.0.0.1", 0, 0, 5, 1),
+		icmpRaw(base, 1000, "10.0.0.1", "10.0.0.2", 8, 0, 5, 2),
+	}
+	packets := ConvertToPacketInfo(raw)
+	for _, p := range packets {
+		if want := p.ICMPType == 8; (p.Direction == Forward) != want {
+			t.Errorf("type %d: expected forward=%v, got %v", p.ICMPType, want, p.Direction == Forward)
+		}
+	}
+	f := ProcessPacketsWithKeys(packets)[0].Features
+	if f.ICMPEchoUnanswered != 1 || f.ICMPEchoRTT.Mean != 0 {
+		t.Errorf("expected the unmatched reply to give no RTT sample, got unanswered=%d rtt=%+v", f.ICMPEchoUnanswered, f.ICMPEchoRTT)
+	}
+}
+
+func TestProcessPackets_ICMP_ErrorsAndV6(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	raw := []RawPacket{
+		icmpRaw(base, 0, "10.0.0.254", "10.0.0.1", 3, 1, 0, 0),
+		icmpRaw(base, 1, "10.0.0.254", "10.0.0.1", 3, 1, 0, 0),
+		icmpRaw(base, 2, "10.0.0.254", "10.0.0.1", 11, 0, 0, 0),
+		{Timestamp: base, SrcIP: "2001:db8::1", DstIP: "2001:db8::2", Protocol: 58, ICMPType: 128, ICMPID: 1, ICMPSeq: 1},
+		{Timestamp: base.Add(time.Millisecond), SrcIP: "2001:db8::2", DstIP: "2001:db8::1", Protocol: 58, ICMPType: 129, ICMPID: 1, ICMPSeq: 1},
+		{Timestamp: base, SrcIP: "2001:db8::2", DstIP: "2001:db8::1", Protocol: 58, ICMPType: 1, ICMPCode: 4},
+	}
+	flows := ProcessPacketsWithKeys(ConvertToPacketInfo(raw))
+	if len(flows) != 4 {
+		t.Fatalf("expected 4 flows (one per type/code and the echo session), got %d", len(flows))
+	}
+	for _, fl := range flows {
+		f := fl.Features
+		switch {
+		case fl.Key.Protocol == 1 && f.ICMPType == 3:
+			// Canonical ordering puts 10.0.0.1 first, so the type/code lands in SrcPort.
+			if f.ICMPUnreachable != 2 || fl.Key.SrcPort != 3<<8|1 || fl.Key.DstPort != 0 {
+				t.Errorf("expected 2 host unreachables keyed %d/0, got %d keyed %d/%d", 3<<8|1, f.ICMPUnreachable, fl.Key.SrcPort, fl.Key.DstPort)
+			}
+		case fl.Key.Protocol == 1:
+			if f.ICMPTimeExceeded != 1 {
+				t.Errorf("expected 1 time exceeded, got %d", f.ICMPTimeExceeded)
+			}
+		case f.ICMPType == 1:
+			if f.ICMPUnreachable != 1 || f.ICMPCode != 4 {
+				t.Errorf("expected 1 ICMPv6 port unreachable, got %d code %d", f.ICMPUnreachable, f.ICMPCode)
+			}
+		default:
+			if f.ICMPEchoRequests != 1 || f.ICMPEchoReplies != 1 || f.ICMPEchoRTT.Mean != 1000 {
+				t.Errorf("expected an answered ICMPv6 echo with 1 ms RTT, got %d/%d %+v", f.ICMPEchoRequests, f.ICMPEchoReplies, f.ICMPEchoRTT)
+			}
+		}
+	}
+}
+
+func TestProcessPackets_ICMP_NonICMPFlow(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := ProcessPacketsWithKeys([]PacketInfo{tcpSeg(base, 0, Forward, 1, 1, 10, 100)})[0].Features
+	if f.ICMPType != -1 || f.ICMPCode != -1 || f.ICMPEchoRequests != 0 {
+		t.Errorf("expected ICMP type/code -1 for a TCP flow, got %d/%d", f.ICMPType, f.ICMPCode)
+	}
+}
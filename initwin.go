@@ -1,20 +1,27 @@
 package flowmeter
 
-// computeInitWin fills initial window bytes (InitWinBytesFwd, InitWinBytesBwd) to match
+// initWinState accumulates initial window bytes (InitWinBytesFwd, InitWinBytesBwd) to match
 // CICFlowMeter: InitWinBytesFwd = TCP window of the first forward packet;
 // InitWinBytesBwd = TCP window of the last backward packet. For non-TCP, TCPWindow is 0.
-// Packets must be sorted by timestamp.
-func computeInitWin(packets []PacketInfo, f *FlowFeatures) {
-	for _, p := range packets {
-		if p.Direction == Forward {
-			f.InitWinBytesFwd = int64(p.TCPWindow)
-			break
-		}
-	}
-	for i := len(packets) - 1; i >= 0; i-- {
-		if packets[i].Direction == Backward {
-			f.InitWinBytesBwd = int64(packets[i].TCPWindow)
-			break
+// Packets must arrive sorted by timestamp.
+type initWinState struct {
+	seenFwd bool
+	fwd     int64
+	bwd     int64
+}
+
+func (s *initWinState) update(p *PacketInfo) {
+	if p.Direction == Forward {
+		if !s.seenFwd {
+			s.fwd = int64(p.TCPWindow)
+			s.seenFwd = true
 		}
+	} else {
+		s.bwd = int64(p.TCPWindow)
 	}
 }
+
+func (s *initWinState) finalize(f *FlowFeatures) {
+	f.InitWinBytesFwd = s.fwd
+	f.InitWinBytesBwd = s.bwd
+}
@@ -0,0 +1,215 @@
+// Package ipfix exports computed flows to IPFIX (RFC 7011) collectors over UDP or TCP.
+//
+// The flow key, times, core counters and end reason are sent as IANA Information
+// Elements, with the backward direction in the RFC 5103 reverse elements; the remaining
+// features are enterprise-specific elements numbered by their CICFlowMeter column. Elements lists
+// the template of a flow.
+package ipfix
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/Bi9River/goflowmeter"
+)
+
+// Element is an IPFIX Information Element, one field of a template.
+type Element struct {
+	Name       string
+	ID         uint16
+	Enterprise uint32 // Private Enterprise Number; 0 for IANA elements
+	Length     uint16 // encoded length in bytes
+}
+
+// ReverseEnterprise is the Private Enterprise Number of the RFC 5103 reverse elements,
+// which carry the backward direction of a biflow.
+const ReverseEnterprise = 29305
+
+// DefaultEnterprise is the Private Enterprise Number of the feature elements when
+// Options.Enterprise is 0: 32473, reserved for documentation (RFC 5612). Collectors
+// that store the features should be configured with the number actually used.
+const DefaultEnterprise = 32473
+
+// IANA Information Element IDs.
+const (
+	iePacketDeltaCount         = 2
+	ieProtocolIdentifier       = 4
+	ieTCPControlBits           = 6
+	ieFlowEndReason            = 136
+	ieSourceTransportPort      = 7
+	ieSourceIPv4Address        = 8
+	ieDestinationTransportPort = 11
+	ieDestinationIPv4Address   = 12
+	ieSourceIPv6Address        = 27
+	ieDestinationIPv6Address   = 28
+	ieSourceMacAddress         = 56
+	ieVlanID                   = 58
+	ieDestinationMacAddress    = 80
+	ieObservationDomainID      = 149
+	ieFlowStartMicroseconds    = 154
+	ieFlowEndMicroseconds      = 155
+	ieFlowDurationMicroseconds = 162
+	ieLayer2SegmentID          = 351
+	ieTransportOctetDeltaCount = 401
+)
+
+// extendedElementBase numbers the enterprise elements of features without a CIC column:
+// the i-th feature of flowmeter.ExtendedFeatureSchema is element extendedElementBase+i.
+const extendedElementBase = 1000
+
+// field is an element of a template with the function that encodes its value into
+// b[:Length].
+type field struct {
+	Element
+	put func(b []byte, fl *flowmeter.FlowWithKey)
+}
+
+func put8(b []byte, v uint8)   { b[0] = v }
+func put16(b []byte, v uint16) { binary.BigEndian.PutUint16(b, v) }
+func put32(b []byte, v uint32) { binary.BigEndian.PutUint32(b, v) }
+func put64(b []byte, v uint64) { binary.BigEndian.PutUint64(b, v) }
+
+// keyFields returns the flow key elements of one address family.
+func keyFields(v6 bool, fields flowmeter.KeyFields) []field {
+	var out []field
+	if v6 {
+		out = append(out,
+			field{Element{"sourceIPv6Address", ieSourceIPv6Address, 0, 16}, func(b []byte, fl *flowmeter.FlowWithKey) {
+				copy(b[:16], fl.Key.SrcAddr.AsSlice())
+			}},
+			field{Element{"destinationIPv6Address", ieDestinationIPv6Address, 0, 16}, func(b []byte, fl *flowmeter.FlowWithKey) {
+				copy(b[:16], fl.Key.DstAddr.AsSlice())
+			}},
+		)
+	} else {
+		out = append(out,
+			field{Element{"sourceIPv4Address", ieSourceIPv4Address, 0, 4}, func(b []byte, fl *flowmeter.FlowWithKey) {
+				copy(b[:4], fl.Key.SrcAddr.AsSlice())
+			}},
+			field{Element{"destinationIPv4Address", ieDestinationIPv4Address, 0, 4}, func(b []byte, fl *flowmeter.FlowWithKey) {
+				copy(b[:4], fl.Key.DstAddr.AsSlice())
+			}},
+		)
+	}
+	out = append(out,
+		field{Element{"sourceTransportPort", ieSourceTransportPort, 0, 2}, func(b []byte, fl *flowmeter.FlowWithKey) { put16(b, fl.Key.SrcPort) }},
+		field{Element{"destinationTransportPort", ieDestinationTransportPort, 0, 2}, func(b []byte, fl *flowmeter.FlowWithKey) { put16(b, fl.Key.DstPort) }},
+		field{Element{"protocolIdentifier", ieProtocolIdentifier, 0, 1}, func(b []byte, fl *flowmeter.FlowWithKey) { put8(b, fl.Key.Protocol) }},
+	)
+	if fields&flowmeter.KeyVLAN != 0 {
+		out = append(out, field{Element{"vlanId", ieVlanID, 0, 2}, func(b []byte, fl *flowmeter.FlowWithKey) { put16(b, fl.Key.VLANID) }})
+	}
+	if fields&flowmeter.KeyVNI != 0 {
+		out = append(out, field{Element{"layer2SegmentId", ieLayer2SegmentID, 0, 8}, func(b []byte, fl *flowmeter.FlowWithKey) { put64(b, uint64(fl.Key.VNI)) }})
+	}
+	if fields&flowmeter.KeyObservationDomain != 0 {
+		out = append(out, field{Element{"observationDomainId", ieObservationDomainID, 0, 4}, func(b []byte, fl *flowmeter.FlowWithKey) { put32(b, fl.Key.ObservationDomain) }})
+	}
+	if fields&flowmeter.KeyMAC != 0 {
+		out = append(out,
+			field{Element{"sourceMacAddress", ieSourceMacAddress, 0, 6}, func(b []byte, fl *flowmeter.FlowWithKey) { copy(b, fl.Key.SrcMAC[:]) }},
+			field{Element{"destinationMacAddress", ieDestinationMacAddress, 0, 6}, func(b []byte, fl *flowmeter.FlowWithKey) { copy(b, fl.Key.DstMAC[:]) }},
+		)
+	}
+	return out
+}
+
+// ianaFeatures are the CIC features sent as IANA elements instead of enterprise ones.
+var ianaFeatures = map[int]bool{8: true, 9: true, 10: true, 11: true, 12: true}
+
+// coreFields are the flow times and counters sent as IANA elements: the first and last
+// packet times, forward packets and payload bytes, their RFC 5103 reverses, the duration,
+// the union of the TCP flags of both directions and the end reason.
+var coreFields = []field{
+	{Element{"flowStartMicroseconds", ieFlowStartMicroseconds, 0, 8}, func(b []byte, fl *flowmeter.FlowWithKey) { putMicros(b, fl.Start) }},
+	{Element{"flowEndMicroseconds", ieFlowEndMicroseconds, 0, 8}, func(b []byte, fl *flowmeter.FlowWithKey) { putMicros(b, fl.End) }},
+	{Element{"flowDurationMicroseconds", ieFlowDurationMicroseconds, 0, 4}, func(b []byte, fl *flowmeter.FlowWithKey) {
+		put32(b, uint32(min(fl.Features.FlowDurationUs, math.MaxUint32)))
+	}},
+	{Element{"packetDeltaCount", iePacketDeltaCount, 0, 8}, func(b []byte, fl *flowmeter.FlowWithKey) { put64(b, uint64(fl.Features.TotalFwdPackets)) }},
+	{Element{"transportOctetDeltaCount", ieTransportOctetDeltaCount, 0, 8}, func(b []byte, fl *flowmeter.FlowWithKey) { put64(b, uint64(fl.Features.TotalFwdBytes)) }},
+	{Element{"reversePacketDeltaCount", iePacketDeltaCount, ReverseEnterprise, 8}, func(b []byte, fl *flowmeter.FlowWithKey) { put64(b, uint64(fl.Features.TotalBwdPackets)) }},
+	{Element{"reverseTransportOctetDeltaCount", ieTransportOctetDeltaCount, ReverseEnterprise, 8}, func(b []byte, fl *flowmeter.FlowWithKey) { put64(b, uint64(fl.Features.TotalBwdBytes)) }},
+	{Element{"tcpControlBits", ieTCPControlBits, 0, 2}, func(b []byte, fl *flowmeter.FlowWithKey) { put16(b, tcpControlBits(&fl.Features)) }},
+	{Element{"flowEndReason", ieFlowEndReason, 0, 1}, func(b []byte, fl *flowmeter.FlowWithKey) { put8(b, endReasons[fl.Reason]) }},
+}
+
+// endReasons maps end reasons to flowEndReason values: idle timeout (1), active timeout
+// (2), end of flow detected (3) and forced end (4). EndUnknown is sent as 0.
+var endReasons = map[flowmeter.EndReason]uint8{
+	flowmeter.EndIdleTimeout: 1,
+	flowmeter.EndFlowTimeout: 2,
+	flowmeter.EndFIN:         3,
+	flowmeter.EndRST:         3,
+	flowmeter.EndWindow:      4,
+}
+
+// ntpEpochOffset is the number of seconds from 1900, the NTP epoch, to 1970.
+const ntpEpochOffset = 2208988800
+
+// putMicros encodes t as dateTimeMicroseconds: an NTP timestamp whose 11 least
+// significant fraction bits are zero (RFC 7011, section 6.1.9). The zero time is sent
+// as 0.
+func putMicros(b []byte, t time.Time) {
+	if t.IsZero() {
+		put64(b, 0)
+		return
+	}
+	sec := uint64(t.Unix() + ntpEpochOffset)
+	us := uint64(t.Nanosecond() / 1000)
+	frac := (us<<32 + 500_000) / 1_000_000 &^ 0x7ff
+	put64(b, sec<<32|frac)
+}
+
+// tcpControlBits returns the TCP flags seen in the flow in tcpControlBits layout.
+func tcpControlBits(f *flowmeter.FlowFeatures) uint16 {
+	var bits uint16
+	for i, n := range [...]int{f.FIN, f.SYN, f.RST, f.PSH, f.ACK, f.URG, f.ECE, f.CWR} {
+		if n > 0 {
+			bits |= 1 << i
+		}
+	}
+	return bits
+}
+
+// featureFields returns the enterprise elements of schema. Features of custom modules
+// have no element number and are rejected.
+func featureFields(schema []flowmeter.FeatureDescriptor, enterprise uint32) ([]field, error) {
+	extended := map[string]int{}
+	for i, d := range flowmeter.ExtendedFeatureSchema() {
+		extended[d.Name] = i
+	}
+	out := make([]field, 0, len(schema))
+	for _, d := range schema {
+		id := d.CICColumn
+		if id == 0 {
+			i, ok := extended[d.Name]
+			if !ok {
+				return nil, fmt.Errorf("ipfix: no element for feature %q", d.Name)
+			}
+			id = extendedElementBase + i
+		}
+		d := d
+		f := field{Element: Element{Name: d.Name, ID: uint16(id), Enterprise: enterprise, Length: 8}}
+		if d.Type == flowmeter.DataTypeInt {
+			f.put = func(b []byte, fl *flowmeter.FlowWithKey) { put64(b, uint64(int64(d.Value(&fl.Features)))) }
+		} else {
+			f.put = func(b []byte, fl *flowmeter.FlowWithKey) { put64(b, math.Float64bits(d.Value(&fl.Features))) }
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+// defaultSchema returns the CIC features not sent as IANA elements.
+func defaultSchema() []flowmeter.FeatureDescriptor {
+	var out []flowmeter.FeatureDescriptor
+	for _, d := range flowmeter.FeatureSchema() {
+		if !ianaFeatures[d.CICColumn] {
+			out = append(out, d)
+		}
+	}
+	return out
+}
@@ -0,0 +1,299 @@
+package ipfix
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/Bi9River/goflowmeter"
+)
+
+// Template IDs of the two address families.
+const (
+	templateIPv4 = 256
+	templateIPv6 = 257
+)
+
+const (
+	version           = 10
+	messageHeaderLen  = 16
+	setHeaderLen      = 4
+	templateSetID     = 2
+	enterpriseBit     = 0x8000
+	maxMessageSizeTCP = 65535
+)
+
+// DefaultTemplateRefresh is how often templates are resent over UDP when
+// Options.TemplateRefresh is 0.
+const DefaultTemplateRefresh = 10 * time.Minute
+
+// DefaultMessageSize is the largest UDP message when Options.MaxMessageSize is 0; it
+// fits an Ethernet MTU.
+const DefaultMessageSize = 1400
+
+// Options configures an Exporter. The zero value exports the CIC features with the
+// 5-tuple key in observation domain 0.
+type Options struct {
+	// ObservationDomain is the Observation Domain ID of the message headers.
+	ObservationDomain uint32
+	// Enterprise is the Private Enterprise Number of the feature elements; 0 uses
+	// DefaultEnterprise.
+	Enterprise uint32
+	// Schema lists the features sent as enterprise elements; nil sends the CIC features
+	// other than duration, packet and byte totals (which go in IANA elements). Features
+	// of custom modules cannot be exported.
+	Schema []flowmeter.FeatureDescriptor
+	// KeyFields adds the extended flow key fields as IANA elements.
+	KeyFields flowmeter.KeyFields
+	// TemplateRefresh is how often templates are resent over UDP; 0 uses
+	// DefaultTemplateRefresh. Over TCP they are sent once per connection.
+	TemplateRefresh time.Duration
+	// MaxMessageSize bounds the size of a message; 0 uses DefaultMessageSize over UDP
+	// and 65535 over TCP.
+	MaxMessageSize int
+}
+
+// Exporter sends flows to an IPFIX collector. Records are buffered until a message is
+// full or Flush is called; Write, Flush and Close are not safe for concurrent use.
+type Exporter struct {
+	conn      net.Conn
+	datagram  bool
+	opts      Options
+	templates [2][]field // IPv4, IPv6
+	sizes     [2]int     // record lengths
+	tmplSets  [][]byte   // encoded template sets, one per message
+	domain    uint32
+	maxSize   int
+
+	sets     [2][]byte // pending data records, without set header
+	records  uint32    // data records in sets
+	sequence uint32    // data records sent before the next message
+	sentAt   time.Time // last time templates were sent; zero if never
+	now      func() time.Time
+	err      error
+}
+
+// ErrTooLarge is returned by Write when a single record does not fit in
+// Options.MaxMessageSize.
+var ErrTooLarge = errors.New("ipfix: record larger than the message size")
+
+var errClosed = errors.New("ipfix: Exporter is closed")
+
+// Dial connects to the collector at address over network ("udp" or "tcp", or their 4
+// and 6 variants) and returns an Exporter writing to it.
+func Dial(network, address string, opts Options) (*Exporter, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	e, err := NewExporter(conn, opts)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+// NewExporter returns an Exporter writing to conn. Connections that implement
+// net.PacketConn (UDP) get one message per datagram and periodic template refreshes;
+// others (TCP) are treated as streams. Close closes conn.
+func NewExporter(conn net.Conn, opts Options) (*Exporter, error) {
+	_, datagram := conn.(net.PacketConn)
+	if opts.Enterprise == 0 {
+		opts.Enterprise = DefaultEnterprise
+	}
+	if opts.Schema == nil {
+		opts.Schema = defaultSchema()
+	}
+	if opts.TemplateRefresh <= 0 {
+		opts.TemplateRefresh = DefaultTemplateRefresh
+	}
+	e := &Exporter{conn: conn, datagram: datagram, opts: opts, domain: opts.ObservationDomain, maxSize: opts.MaxMessageSize, now: time.Now}
+	if e.maxSize <= 0 {
+		e.maxSize = DefaultMessageSize
+		if !datagram {
+			e.maxSize = maxMessageSizeTCP
+		}
+	}
+	e.maxSize = min(e.maxSize, maxMessageSizeTCP)
+	features, err := featureFields(opts.Schema, opts.Enterprise)
+	if err != nil {
+		return nil, err
+	}
+	for i, v6 := range [...]bool{false, true} {
+		t := keyFields(v6, opts.KeyFields)
+		t = append(t, coreFields...)
+		e.templates[i] = append(t, features...)
+		for _, f := range e.templates[i] {
+			e.sizes[i] += int(f.Length)
+		}
+	}
+	if messageHeaderLen+setHeaderLen+max(e.sizes[0], e.sizes[1]) > e.maxSize {
+		return nil, ErrTooLarge
+	}
+	if both := e.templateSet(0, 1); messageHeaderLen+len(both) <= e.maxSize {
+		e.tmplSets = [][]byte{both}
+	} else {
+		e.tmplSets = [][]byte{e.templateSet(0), e.templateSet(1)}
+		if messageHeaderLen+max(len(e.tmplSets[0]), len(e.tmplSets[1])) > e.maxSize {
+			return nil, ErrTooLarge
+		}
+	}
+	return e, nil
+}
+
+// Elements returns the elements of the template of IPv4 (or, if v6, IPv6) flows, in
+// record order.
+func (e *Exporter) Elements(v6 bool) []Element {
+	t := e.templates[0]
+	if v6 {
+		t = e.templates[1]
+	}
+	out := make([]Element, len(t))
+	for i, f := range t {
+		out[i] = f.Element
+	}
+	return out
+}
+
+// Write adds one flow to the current message, sending the message first if the flow
+// does not fit. The key is sent from the initiator's side (fl.InitiatorKey), as RFC 5103
+// biflows require, whatever Config.KeyOrder gave the flow.
+func (e *Exporter) Write(fl flowmeter.FlowWithKey) error {
+	if e.err != nil {
+		return e.err
+	}
+	fl.Key = fl.InitiatorKey()
+	i := 0
+	if fl.Key.SrcAddr.Is6() {
+		i = 1
+	}
+	if e.size()+e.sizes[i]+e.setOverhead(i) > e.maxSize {
+		if err := e.Flush(); err != nil {
+			return err
+		}
+	}
+	set := e.sets[i]
+	n := len(set)
+	set = append(set, make([]byte, e.sizes[i])...)
+	b := set[n:]
+	for _, f := range e.templates[i] {
+		f.put(b, &fl)
+		b = b[f.Length:]
+	}
+	e.sets[i] = set
+	e.records++
+	return nil
+}
+
+// Flush sends the buffered records, preceded by messages carrying the templates when
+// they are due.
+func (e *Exporter) Flush() error {
+	if e.err != nil {
+		return e.err
+	}
+	if e.records == 0 {
+		return nil
+	}
+	now := e.now()
+	if e.templatesDue(now) {
+		for _, set := range e.tmplSets {
+			if err := e.send(append(make([]byte, messageHeaderLen, messageHeaderLen+len(set)), set...), now); err != nil {
+				return err
+			}
+		}
+		e.sentAt = now
+	}
+	msg := make([]byte, messageHeaderLen, e.maxSize)
+	for i, set := range e.sets {
+		if len(set) == 0 {
+			continue
+		}
+		msg = binary.BigEndian.AppendUint16(msg, uint16(templateIPv4+i))
+		msg = binary.BigEndian.AppendUint16(msg, uint16(setHeaderLen+len(set)))
+		msg = append(msg, set...)
+		e.sets[i] = set[:0]
+	}
+	err := e.send(msg, now)
+	e.sequence += e.records
+	e.records = 0
+	return err
+}
+
+// send fills in the message header of msg and writes it.
+func (e *Exporter) send(msg []byte, now time.Time) error {
+	binary.BigEndian.PutUint16(msg[0:2], version)
+	binary.BigEndian.PutUint16(msg[2:4], uint16(len(msg)))
+	binary.BigEndian.PutUint32(msg[4:8], uint32(now.Unix()))
+	binary.BigEndian.PutUint32(msg[8:12], e.sequence)
+	binary.BigEndian.PutUint32(msg[12:16], e.domain)
+	if _, err := e.conn.Write(msg); err != nil {
+		e.err = err
+		return err
+	}
+	return nil
+}
+
+// Close sends the buffered records and closes the connection.
+func (e *Exporter) Close() error {
+	err := e.Flush()
+	if cerr := e.conn.Close(); err == nil && cerr != nil {
+		err = cerr
+	}
+	if e.err == nil {
+		e.err = errClosed
+	}
+	return err
+}
+
+// templatesDue reports whether the next message must carry the templates: the first
+// message, and over UDP every TemplateRefresh.
+func (e *Exporter) templatesDue(now time.Time) bool {
+	if e.sentAt.IsZero() {
+		return true
+	}
+	return e.datagram && now.Sub(e.sentAt) >= e.opts.TemplateRefresh
+}
+
+// size returns the length of the message holding the buffered records.
+func (e *Exporter) size() int {
+	n := messageHeaderLen
+	for _, set := range e.sets {
+		if len(set) > 0 {
+			n += setHeaderLen + len(set)
+		}
+	}
+	return n
+}
+
+// setOverhead returns the header length a record of family i adds to the message.
+func (e *Exporter) setOverhead(i int) int {
+	if len(e.sets[i]) == 0 {
+		return setHeaderLen
+	}
+	return 0
+}
+
+// templateSet encodes a template set with the templates of the given families.
+func (e *Exporter) templateSet(families ...int) []byte {
+	b := make([]byte, setHeaderLen)
+	for _, i := range families {
+		t := e.templates[i]
+		b = binary.BigEndian.AppendUint16(b, uint16(templateIPv4+i))
+		b = binary.BigEndian.AppendUint16(b, uint16(len(t)))
+		for _, f := range t {
+			if f.Enterprise != 0 {
+				b = binary.BigEndian.AppendUint16(b, f.ID|enterpriseBit)
+				b = binary.BigEndian.AppendUint16(b, f.Length)
+				b = binary.BigEndian.AppendUint32(b, f.Enterprise)
+				continue
+			}
+			b = binary.BigEndian.AppendUint16(b, f.ID)
+			b = binary.BigEndian.AppendUint16(b, f.Length)
+		}
+	}
+	binary.BigEndian.PutUint16(b[0:2], templateSetID)
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	return b
+}
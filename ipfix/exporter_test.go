@@ -0,0 +1,406 @@
+package ipfix
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Bi9River/goflowmeter"
+)
+
+// testFlows returns a TCP flow 1.1.1.1:12345 -> 2.2.2.2:80 (2 packets forward with 220
+// payload bytes, 1 backward with 110) and a UDP flow between two IPv6 addresses.
+func testFlows(t *testing.T) []flowmeter.FlowWithKey {
+	t.Helper()
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	packets := []flowmeter.PacketInfo{
+		{Timestamp: base, HeaderLen: 40, PayloadSize: 60, Direction: flowmeter.Forward, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 12345, DstPort: 80, Protocol: 6, SYN: true},
+		{Timestamp: base.Add(time.Second), HeaderLen: 40, PayloadSize: 160, Direction: flowmeter.Forward, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 12345, DstPort: 80, Protocol: 6, PSH: true, ACK: true},
+		{Timestamp: base.Add(2 * time.Second), HeaderLen: 40, PayloadSize: 110, Direction: flowmeter.Backward, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 12345, DstPort: 80, Protocol: 6, FIN: true, ACK: true},
+		{Timestamp: base, HeaderLen: 8, PayloadSize: 30, Direction: flowmeter.Forward, SrcIP: "2001:db8::1", DstIP: "2001:db8::2", SrcPort: 5353, DstPort: 53, Protocol: 17},
+	}
+	flows := flowmeter.ProcessPacketsWithKeys(packets)
+	if len(flows) != 2 || !flows[0].Key.SrcAddr.Is4() {
+		t.Fatalf("expected the IPv4 flow first of 2, got %v", flows)
+	}
+	return flows
+}
+
+// message is a decoded IPFIX message.
+type message struct {
+	length, sequence, domain uint32
+	templates                map[uint16][]Element
+	records                  map[uint16][][]byte
+}
+
+// parseMessage decodes msg, splitting data sets into records with the templates of
+// tmpl (those of msg are added to it).
+func parseMessage(t *testing.T, msg []byte, tmpl map[uint16][]Element) message {
+	t.Helper()
+	if len(msg) < 16 || binary.BigEndian.Uint16(msg) != 10 {
+		t.Fatalf("expected an IPFIX header, got %x", msg)
+	}
+	m := message{
+		length:    uint32(binary.BigEndian.Uint16(msg[2:])),
+		sequence:  binary.BigEndian.Uint32(msg[8:]),
+		domain:    binary.BigEndian.Uint32(msg[12:]),
+		templates: map[uint16][]Element{},
+		records:   map[uint16][][]byte{},
+	}
+	if int(m.length) != len(msg) {
+		t.Fatalf("expected length %d, got %d", len(msg), m.length)
+	}
+	for b := msg[16:]; len(b) > 0; {
+		id, n := binary.BigEndian.Uint16(b), int(binary.BigEndian.Uint16(b[2:]))
+		body := b[4:n]
+		b = b[n:]
+		if id == 2 {
+			for len(body) > 0 {
+				tid, count := binary.BigEndian.Uint16(body), int(binary.BigEndian.Uint16(body[2:]))
+				body = body[4:]
+				var els []Element
+				for i := 0; i < count; i++ {
+					el := Element{ID: binary.BigEndian.Uint16(body), Length: binary.BigEndian.Uint16(body[2:])}
+					body = body[4:]
+					if el.ID&0x8000 != 0 {
+						el.ID &^= 0x8000
+						el.Enterprise = binary.BigEndian.Uint32(body)
+						body = body[4:]
+					}
+					els = append(els, el)
+				}
+				m.templates[tid] = els
+				tmpl[tid] = els
+			}
+			continue
+		}
+		size := 0
+		for _, el := range tmpl[id] {
+			size += int(el.Length)
+		}
+		if size == 0 {
+			t.Fatalf("data set %d without template", id)
+		}
+		for len(body) >= size {
+			m.records[id] = append(m.records[id], body[:size])
+			body = body[size:]
+		}
+	}
+	return m
+}
+
+// values splits a record into the values of its template elements, by name.
+func values(els []Element, rec []byte) map[string][]byte {
+	out := map[string][]byte{}
+	for _, el := range els {
+		out[el.Name] = rec[:el.Length]
+		rec = rec[el.Length:]
+	}
+	return out
+}
+
+func listenUDP(t *testing.T) net.PacketConn {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	return pc
+}
+
+func receive(t *testing.T, pc net.PacketConn) []byte {
+	t.Helper()
+	buf := make([]byte, 65536)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("receiving: %v", err)
+	}
+	return buf[:n]
+}
+
+func TestExporter_UDP(t *testing.T) {
+	pc := listenUDP(t)
+	e, err := Dial("udp", pc.LocalAddr().String(), Options{ObservationDomain: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	flows := testFlows(t)
+	for _, fl := range flows {
+		if err := e.Write(fl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	v4, v6 := e.Elements(false), e.Elements(true)
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tmpl := map[uint16][]Element{}
+	m := parseMessage(t, receive(t, pc), tmpl)
+	if m.domain != 7 || m.sequence != 0 || len(m.templates) != 2 {
+		t.Fatalf("expected domain 7, sequence 0 and 2 templates, got %d %d %d", m.domain, m.sequence, len(m.templates))
+	}
+	for i, el := range m.templates[256] {
+		if el.ID != v4[i].ID || el.Enterprise != v4[i].Enterprise || el.Length != v4[i].Length {
+			t.Fatalf("template element %d: expected %+v, got %+v", i, v4[i], el)
+		}
+	}
+	m = parseMessage(t, receive(t, pc), tmpl)
+	if m.domain != 7 || m.sequence != 0 || len(m.records[256]) != 1 || len(m.records[257]) != 1 {
+		t.Fatalf("expected one IPv4 and one IPv6 record, got %d and %d", len(m.records[256]), len(m.records[257]))
+	}
+	got := values(v4, m.records[256][0])
+	if netip.AddrFrom4([4]byte(got["sourceIPv4Address"])).String() != "1.1.1.1" || binary.BigEndian.Uint16(got["destinationTransportPort"]) != 80 || got["protocolIdentifier"][0] != 6 {
+		t.Errorf("unexpected key %x %x %x", got["sourceIPv4Address"], got["destinationTransportPort"], got["protocolIdentifier"])
+	}
+	for name, want := range map[string]uint64{
+		"packetDeltaCount":                2,
+		"transportOctetDeltaCount":        220,
+		"reversePacketDeltaCount":         1,
+		"reverseTransportOctetDeltaCount": 110,
+	} {
+		if v := binary.BigEndian.Uint64(got[name]); v != want {
+			t.Errorf("%s: expected %d, got %d", name, want, v)
+		}
+	}
+	if d := binary.BigEndian.Uint32(got["flowDurationMicroseconds"]); d != 2_000_000 {
+		t.Errorf("expected duration 2000000us, got %d", d)
+	}
+	// NTP seconds of 2025-01-01 00:00:00 UTC in the upper half.
+	start := uint64(1735689600+ntpEpochOffset) << 32
+	if s, e := binary.BigEndian.Uint64(got["flowStartMicroseconds"]), binary.BigEndian.Uint64(got["flowEndMicroseconds"]); s != start || e != start+2<<32 {
+		t.Errorf("expected start %#x and end %#x, got %#x and %#x", start, start+2<<32, s, e)
+	}
+	// The last packet carried FIN: end of flow detected.
+	if r := got["flowEndReason"][0]; r != 3 {
+		t.Errorf("expected flowEndReason 3, got %d", r)
+	}
+	// FIN, SYN, PSH and ACK.
+	if bits := binary.BigEndian.Uint16(got["tcpControlBits"]); bits != 0x1b {
+		t.Errorf("expected tcpControlBits 0x1b, got %#x", bits)
+	}
+	if el := v4[len(v4)-1]; el.Enterprise != DefaultEnterprise || el.ID != 84 {
+		t.Errorf("expected the last CIC feature as enterprise element 84, got %+v", el)
+	}
+	mean := math.Float64frombits(binary.BigEndian.Uint64(got["Fwd Packet Length Mean"]))
+	if mean != 110 {
+		t.Errorf("expected Fwd Packet Length Mean 110, got %v", mean)
+	}
+	got6 := values(v6, m.records[257][0])
+	if a := netip.AddrFrom16([16]byte(got6["destinationIPv6Address"])); a.String() != "2001:db8::2" {
+		t.Errorf("expected destination 2001:db8::2, got %v", a)
+	}
+	if err := e.Write(flows[0]); err == nil {
+		t.Error("expected an error writing after Close")
+	}
+}
+
+func TestExporter_InitiatorKey(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	// The server's address sorts first, so the canonical key lists it as the source.
+	raw := []flowmeter.RawPacket{
+		{Timestamp: base, HeaderLen: 40, PayloadSize: 100, SrcIP: "10.0.0.9", DstIP: "10.0.0.1", SrcPort: 5000, DstPort: 80, Protocol: 6, SYN: true},
+		{Timestamp: base.Add(time.Millisecond), HeaderLen: 40, PayloadSize: 300, SrcIP: "10.0.0.1", DstIP: "10.0.0.9", SrcPort: 80, DstPort: 5000, Protocol: 6, SYN: true, ACK: true},
+	}
+	flows := flowmeter.ProcessPacketsWithKeys(flowmeter.ConvertToPacketInfo(raw))
+	if len(flows) != 1 || flows[0].Key.SrcIP() != "10.0.0.1" {
+		t.Fatalf("expected 1 flow keyed from 10.0.0.1, got %v", flows)
+	}
+	pc := listenUDP(t)
+	e, err := Dial("udp", pc.LocalAddr().String(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Write(flows[0]); err != nil {
+		t.Fatal(err)
+	}
+	v4 := e.Elements(false)
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tmpl := map[uint16][]Element{}
+	parseMessage(t, receive(t, pc), tmpl)
+	m := parseMessage(t, receive(t, pc), tmpl)
+	if len(m.records[256]) != 1 {
+		t.Fatalf("expected 1 IPv4 record, got %d", len(m.records[256]))
+	}
+	got := values(v4, m.records[256][0])
+	src := netip.AddrFrom4([4]byte(got["sourceIPv4Address"]))
+	dst := netip.AddrFrom4([4]byte(got["destinationIPv4Address"]))
+	sport, dport := binary.BigEndian.Uint16(got["sourceTransportPort"]), binary.BigEndian.Uint16(got["destinationTransportPort"])
+	if src.String() != "10.0.0.9" || sport != 5000 || dst.String() != "10.0.0.1" || dport != 80 {
+		t.Errorf("expected 10.0.0.9:5000 -> 10.0.0.1:80, got %v:%d -> %v:%d", src, sport, dst, dport)
+	}
+	if fwd, rev := binary.BigEndian.Uint64(got["transportOctetDeltaCount"]), binary.BigEndian.Uint64(got["reverseTransportOctetDeltaCount"]); fwd != 100 || rev != 300 {
+		t.Errorf("expected 100 bytes from the initiator and 300 reverse, got %d and %d", fwd, rev)
+	}
+}
+
+func TestExporter_TemplateRefresh(t *testing.T) {
+	pc := listenUDP(t)
+	e, err := Dial("udp", pc.LocalAddr().String(), Options{TemplateRefresh: 5 * time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	e.now = func() time.Time { return now }
+	flows := testFlows(t)
+	tmpl := map[uint16][]Element{}
+	for i, tc := range []struct {
+		after     time.Duration
+		templates bool
+		sequence  uint32
+	}{
+		{0, true, 0},
+		{time.Minute, false, 2},
+		{5 * time.Minute, true, 4},
+		{time.Minute, false, 6},
+	} {
+		now = now.Add(tc.after)
+		for _, fl := range flows {
+			e.Write(fl)
+		}
+		if err := e.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		m := parseMessage(t, receive(t, pc), tmpl)
+		if got := len(m.templates) > 0; got != tc.templates {
+			t.Errorf("message %d: expected templates %v, got %v", i, tc.templates, got)
+		}
+		if len(m.templates) > 0 {
+			m = parseMessage(t, receive(t, pc), tmpl)
+		}
+		if m.sequence != tc.sequence {
+			t.Errorf("message %d: expected sequence %d, got %d", i, tc.sequence, m.sequence)
+		}
+		if len(m.records[256])+len(m.records[257]) != 2 {
+			t.Errorf("message %d: expected 2 records, got %v", i, m.records)
+		}
+	}
+}
+
+func TestExporter_MessageSize(t *testing.T) {
+	pc := listenUDP(t)
+	probe, err := Dial("udp", pc.LocalAddr().String(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Room for two IPv4 records; the templates go in a message of their own.
+	size := 16 + 4 + 2*probe.sizes[0]
+	probe.Close()
+	e, err := Dial("udp", pc.LocalAddr().String(), Options{MaxMessageSize: size})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fl := testFlows(t)[0]
+	for i := 0; i < 5; i++ {
+		if err := e.Write(fl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e.Close()
+	tmpl := map[uint16][]Element{}
+	var counts []int
+	for i := 0; len(counts) < 3; i++ {
+		msg := receive(t, pc)
+		if len(msg) > size {
+			t.Errorf("message %d: expected at most %d bytes, got %d", i, size, len(msg))
+		}
+		m := parseMessage(t, msg, tmpl)
+		if len(m.templates) > 0 {
+			if len(m.records) > 0 {
+				t.Errorf("message %d: expected templates alone, got records too", i)
+			}
+			continue
+		}
+		counts = append(counts, len(m.records[256]))
+	}
+	if len(tmpl) != 2 || !reflect.DeepEqual(counts, []int{2, 2, 1}) {
+		t.Errorf("expected both templates, then 2, 2 and 1 records, got %d templates and %v", len(tmpl), counts)
+	}
+	if _, err := Dial("udp", pc.LocalAddr().String(), Options{MaxMessageSize: 500}); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestExporter_TCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	stream := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			stream <- nil
+			return
+		}
+		b, _ := io.ReadAll(conn)
+		stream <- b
+	}()
+	e, err := Dial("tcp", ln.Addr().String(), Options{TemplateRefresh: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	e.now = func() time.Time { return now }
+	fl := testFlows(t)[0]
+	for i := 0; i < 3; i++ {
+		e.Write(fl)
+		if err := e.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(time.Hour)
+	}
+	e.Close()
+	b := <-stream
+	tmpl := map[uint16][]Element{}
+	// Over TCP the templates are sent once, before the first data records.
+	for i := 0; i < 4; i++ {
+		if len(b) < 4 {
+			t.Fatalf("expected 4 messages, got %d", i)
+		}
+		n := int(binary.BigEndian.Uint16(b[2:]))
+		m := parseMessage(t, b[:n], tmpl)
+		b = b[n:]
+		if i == 0 {
+			if len(m.templates) != 2 || len(m.records) != 0 {
+				t.Errorf("expected a message with the 2 templates, got %d templates, %d data sets", len(m.templates), len(m.records))
+			}
+			continue
+		}
+		if len(m.templates) != 0 || m.sequence != uint32(i-1) || len(m.records[256]) != 1 {
+			t.Errorf("message %d: unexpected %d templates, sequence %d, %d records", i, len(m.templates), m.sequence, len(m.records[256]))
+		}
+	}
+	if len(b) != 0 {
+		t.Errorf("expected 4 messages, got %d more bytes", len(b))
+	}
+}
+
+func TestExporter_CustomFeatureRejected(t *testing.T) {
+	pc := listenUDP(t)
+	schema := []flowmeter.FeatureDescriptor{{Name: "Max Payload", Module: "maxpayload", Type: flowmeter.DataTypeInt}}
+	if _, err := Dial("udp", pc.LocalAddr().String(), Options{Schema: schema}); err == nil {
+		t.Error("expected an error for a custom feature")
+	}
+	schema = flowmeter.ExtendedFeatureSchema()[:1]
+	e, err := Dial("udp", pc.LocalAddr().String(), Options{Schema: schema})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	els := e.Elements(false)
+	if el := els[len(els)-1]; el.ID != 1000 || el.Name != schema[0].Name {
+		t.Errorf("expected extended feature element 1000, got %+v", el)
+	}
+}
@@ -0,0 +1,70 @@
+package flowmeter
+
+import "math/bits"
+
+// ecnCE is the ECN codepoint for Congestion Experienced (RFC 3168).
+const ecnCE = 3
+
+// ipDirState accumulates IP header features for one direction.
+type ipDirState struct {
+	ttl       RunningStats
+	ttlSeen   [4]uint64 // bitset of TTL values
+	ecnCE     int
+	dscpSeen  uint64 // bit i set if DSCP i was seen
+	fragments int
+}
+
+// ipLayerState derives per-direction IP header features: TTL / hop limit (min, max,
+// mean, std and the number of distinct values), ECN-CE marks, the DSCP values seen and
+// the number of fragments (packets with MF set or a non-zero fragment offset, and the
+// fragments a reassembled packet was built from). A TTL of 0 means the IP header is
+// unknown: the packet is left out of the TTL and DSCP features, so packets without
+// header fields do not count as DSCP 0. Within one flow the TTL normally stays constant
+// per direction, so a spread hints at spoofing or a route change.
+type ipLayerState struct {
+	fwd, bwd ipDirState
+}
+
+func (s *ipLayerState) update(p *PacketInfo) {
+	d := &s.fwd
+	if p.Direction == Backward {
+		d = &s.bwd
+	}
+	if p.TTL != 0 {
+		d.ttl.Add(float64(p.TTL))
+		d.ttlSeen[p.TTL>>6] |= 1 << (p.TTL & 63)
+		d.dscpSeen |= 1 << (p.DSCP & 63)
+	}
+	if p.ECN == ecnCE {
+		d.ecnCE++
+	}
+	switch {
+	case p.Fragments > 0:
+		d.fragments += p.Fragments
+	case p.MoreFragments || p.FragmentOffset != 0:
+		d.fragments++
+	}
+}
+
+func (d *ipDirState) distinctTTLs() int {
+	n := 0
+	for _, w := range d.ttlSeen {
+		n += bits.OnesCount64(w)
+	}
+	return n
+}
+
+func (s *ipLayerState) finalize(f *FlowFeatures) {
+	f.FwdTTL = s.fwd.ttl.Stats()
+	f.BwdTTL = s.bwd.ttl.Stats()
+	f.FwdTTLDistinct = s.fwd.distinctTTLs()
+	f.BwdTTLDistinct = s.bwd.distinctTTLs()
+	f.FwdECNCE = s.fwd.ecnCE
+	f.BwdECNCE = s.bwd.ecnCE
+	f.FwdDSCPSeen = s.fwd.dscpSeen
+	f.BwdDSCPSeen = s.bwd.dscpSeen
+	f.FwdDSCPDistinct = bits.OnesCount64(s.fwd.dscpSeen)
+	f.BwdDSCPDistinct = bits.OnesCount64(s.bwd.dscpSeen)
+	f.FwdFragments = s.fwd.fragments
+	f.BwdFragments = s.bwd.fragments
+}
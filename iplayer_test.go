@@ -0,0 +1,86 @@
+package flowmeter
+
+import (
+	"testing"
+	"time"
+)
+
+func ipPkt(base time.Time, ms int, dir Direction, ttl uint8) PacketInfo {
+	return PacketInfo{
+		Timestamp: base.Add(time.Duration(ms) * time.Millisecond), Direction: dir, HeaderLen: 8, PayloadSize: 100,
+		TTL: ttl, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 1, DstPort: 2, Protocol: 17,
+	}
+}
+
+func TestProcessPackets_IPLayer_TTL(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	packets := []PacketInfo{
+		ipPkt(base, 0, Forward, 60),
+		ipPkt(base, 1, Forward, 60),
+		ipPkt(base, 2, Forward, 120), // spoofed
+		ipPkt(base, 3, Backward, 250),
+		ipPkt(base, 4, Backward, 0), // unknown TTL: ignored
+	}
+	f := ProcessPacketsWithKeys(packets)[0].Features
+	if f.FwdTTL.Min != 60 || f.FwdTTL.Max != 120 || f.FwdTTL.Mean != 80 {
+		t.Errorf("FwdTTL: expected min 60 max 120 mean 80, got %+v", f.FwdTTL)
+	}
+	if f.FwdTTL.Std == 0 {
+		t.Errorf("FwdTTL: expected non-zero std")
+	}
+	if f.FwdTTLDistinct != 2 || f.BwdTTLDistinct != 1 {
+		t.Errorf("TTLDistinct: expected 2/1, got %d/%d", f.FwdTTLDistinct, f.BwdTTLDistinct)
+	}
+	if f.BwdTTL.Min != 250 || f.BwdTTL.Max != 250 || f.BwdTTL.Mean != 250 {
+		t.Errorf("BwdTTL: expected 250, got %+v", f.BwdTTL)
+	}
+}
+
+func TestProcessPackets_IPLayer_DSCPAndECN(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	p0 := ipPkt(base, 0, Forward, 64)
+	p0.DSCP, p0.ECN = 46, 1 // EF, ECT(1)
+	p1 := ipPkt(base, 1, Forward, 64)
+	p1.DSCP, p1.ECN = 46, 3 // CE
+	p2 := ipPkt(base, 2, Forward, 64)
+	p2.DSCP, p2.ECN = 63, 3
+	p3 := ipPkt(base, 3, Backward, 64)
+	f := ProcessPacketsWithKeys([]PacketInfo{p0, p1, p2, p3})[0].Features
+	if f.FwdECNCE != 2 || f.BwdECNCE != 0 {
+		t.Errorf("ECNCE: expected 2/0, got %d/%d", f.FwdECNCE, f.BwdECNCE)
+	}
+	if f.FwdDSCPSeen != 1<<46|1<<63 || f.FwdDSCPDistinct != 2 {
+		t.Errorf("Fwd DSCP: expected EF and 63, got mask %#x distinct %d", f.FwdDSCPSeen, f.FwdDSCPDistinct)
+	}
+	if f.BwdDSCPSeen != 1 || f.BwdDSCPDistinct != 1 {
+		t.Errorf("Bwd DSCP: expected default (0) only, got mask %#x distinct %d", f.BwdDSCPSeen, f.BwdDSCPDistinct)
+	}
+	// Without IP header fields (TTL 0) no DSCP value is recorded.
+	f = ProcessPacketsWithKeys([]PacketInfo{ipPkt(base, 0, Forward, 0)})[0].Features
+	if f.FwdDSCPSeen != 0 || f.FwdDSCPDistinct != 0 {
+		t.Errorf("Fwd DSCP: expected none for an unknown header, got mask %#x distinct %d", f.FwdDSCPSeen, f.FwdDSCPDistinct)
+	}
+}
+
+func TestProcessPackets_IPLayer_Fragments(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	first := ipPkt(base, 0, Forward, 64)
+	first.MoreFragments = true
+	middle := ipPkt(base, 1, Forward, 64)
+	middle.MoreFragments, middle.FragmentOffset = true, 1480
+	last := ipPkt(base, 2, Forward, 64)
+	last.FragmentOffset = 2960
+	whole := ipPkt(base, 3, Backward, 64)
+	whole.DontFragment = true
+	f := ProcessPacketsWithKeys([]PacketInfo{first, middle, last, whole})[0].Features
+	if f.FwdFragments != 3 || f.BwdFragments != 0 {
+		t.Errorf("Fragments: expected 3/0, got %d/%d", f.FwdFragments, f.BwdFragments)
+	}
+	// A reassembled datagram counts the fragments it was built from.
+	reassembled := ipPkt(base, 4, Backward, 64)
+	reassembled.Fragments = 2
+	f = ProcessPacketsWithKeys([]PacketInfo{first, middle, last, whole, reassembled})[0].Features
+	if f.FwdFragments != 3 || f.BwdFragments != 2 {
+		t.Errorf("Fragments: expected 3/2, got %d/%d", f.FwdFragments, f.BwdFragments)
+	}
+}
@@ -0,0 +1,193 @@
+package flowmeter
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// KeyOrder selects how a flow key's two endpoints are ordered to make it direction-free.
+type KeyOrder int
+
+const (
+	// KeyOrderNumeric puts the numerically smaller address first (IPv4 before IPv6), then
+	// the smaller port when the addresses are equal.
+	KeyOrderNumeric KeyOrder = iota
+	// KeyOrderCIC reproduces CICFlowMeter's Flow ID ordering for dataset compatibility:
+	// addresses are compared byte by byte as signed Java bytes (so 200.x sorts before 10.x)
+	// and ports never decide the order.
+	KeyOrderCIC
+	// KeyOrderInitiator groups flows like KeyOrderNumeric but outputs each flow's key with
+	// the initiator first (FlowWithKey.InitiatorKey), so Src is the client.
+	KeyOrderInitiator
+)
+
+// Canonical returns k with its endpoints ordered according to o. KeyOrderInitiator
+// orders keys numerically: the initiator of a flow is only known once it has packets.
+func (o KeyOrder) Canonical(k FlowKey) FlowKey {
+	if o == KeyOrderCIC {
+		return CICFlowKey(k)
+	}
+	return CanonicalFlowKey(k)
+}
+
+// orient applies o to an output flow: with KeyOrderInitiator its key is turned so the
+// initiator comes first.
+func (o KeyOrder) orient(fl *FlowWithKey) {
+	if o == KeyOrderInitiator {
+		fl.Key = fl.InitiatorKey()
+	}
+}
+
+// KeyFields selects the optional FlowKey fields that take part in flow keys, in addition
+// to the 5-tuple. The zero value keys flows on the 5-tuple alone.
+type KeyFields uint8
+
+const (
+	KeyVLAN              KeyFields = 1 << iota // outermost VLAN ID
+	KeyVNI                                     // tunnel ID of decapsulated packets (TunnelInfo.ID)
+	KeyObservationDomain                       // capture interface or observation domain ID
+	KeyMAC                                     // Ethernet source and destination addresses
+)
+
+var keyFieldNames = [...]string{"vlan", "vni", "domain", "mac"}
+
+// ParseKeyFields parses a comma-separated list of vlan, vni, domain and mac ("" selects
+// none).
+func ParseKeyFields(s string) (KeyFields, error) {
+	var f KeyFields
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		i := 0
+		for i < len(keyFieldNames) && keyFieldNames[i] != name {
+			i++
+		}
+		if i == len(keyFieldNames) {
+			return 0, fmt.Errorf("unknown key field %q (want vlan, vni, domain or mac)", name)
+		}
+		f |= 1 << i
+	}
+	return f, nil
+}
+
+// String returns the selected fields in ParseKeyFields form.
+func (f KeyFields) String() string {
+	var names []string
+	for i, name := range keyFieldNames {
+		if f&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// Select returns k with the extended fields that f does not select cleared, for keys
+// that come with every field filled in, such as flow records from a router.
+func (f KeyFields) Select(k FlowKey) FlowKey {
+	out := FlowKey{SrcAddr: k.SrcAddr, DstAddr: k.DstAddr, SrcPort: k.SrcPort, DstPort: k.DstPort, Protocol: k.Protocol}
+	f.extend(&out, k.VLANID, k.VNI, k.ObservationDomain, k.SrcMAC, k.DstMAC)
+	return out
+}
+
+// extend sets the fields of k selected by f.
+func (f KeyFields) extend(k *FlowKey, vlan uint16, vni, domain uint32, src, dst MAC) {
+	if f&KeyVLAN != 0 {
+		k.VLANID = vlan
+	}
+	if f&KeyVNI != 0 {
+		k.VNI = vni
+	}
+	if f&KeyObservationDomain != 0 {
+		k.ObservationDomain = domain
+	}
+	if f&KeyMAC != 0 {
+		k.SrcMAC, k.DstMAC = src, dst
+	}
+}
+
+// MAC is an Ethernet address. Unlike net.HardwareAddr it is comparable, so it can be part
+// of a FlowKey.
+type MAC [6]byte
+
+// String returns m in the usual colon-separated hex form.
+func (m MAC) String() string {
+	return fmt.Sprintf("%02x:%02x:%02x:%02x:%02x:%02x", m[0], m[1], m[2], m[3], m[4], m[5])
+}
+
+// ParseAddr parses an IP address for use in a FlowKey. IPv4-mapped IPv6 addresses
+// (::ffff:a.b.c.d) are unmapped so they key the same as a.b.c.d; invalid input gives
+// the zero netip.Addr.
+func ParseAddr(s string) netip.Addr {
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return a.Unmap()
+}
+
+// Endpoint is one side of a flow: an address and a port (ICMPPorts for ICMP).
+type Endpoint struct {
+	Addr netip.Addr
+	Port uint16
+}
+
+// IP returns the address as text, or "" if it is unset.
+func (e Endpoint) IP() string {
+	return addrString(e.Addr)
+}
+
+// addrString formats a as text, or "" for the zero Addr.
+func addrString(a netip.Addr) string {
+	if !a.IsValid() {
+		return ""
+	}
+	return a.String()
+}
+
+// CanonicalFlowKey returns k with the numerically smaller address first, then the smaller
+// port if the addresses are equal, so (A,B,sp,dp) and (B,A,dp,sp) give the same key.
+// Extended key fields are kept; the MAC addresses move with their endpoints.
+// Use KeyOrderCIC (CICFlowKey) to match CIC's own Flow ID ordering instead.
+func CanonicalFlowKey(k FlowKey) FlowKey {
+	k.SrcAddr, k.DstAddr = k.SrcAddr.Unmap(), k.DstAddr.Unmap()
+	c := k.SrcAddr.Compare(k.DstAddr)
+	if c > 0 || (c == 0 && k.SrcPort > k.DstPort) {
+		return k.reversed()
+	}
+	return k
+}
+
+// CICFlowKey returns k ordered the way CICFlowMeter builds its Flow ID: the first byte
+// that differs between the two addresses decides, compared as a signed byte; equal
+// addresses keep their order.
+func CICFlowKey(k FlowKey) FlowKey {
+	k.SrcAddr, k.DstAddr = k.SrcAddr.Unmap(), k.DstAddr.Unmap()
+	if k.SrcAddr.BitLen() != k.DstAddr.BitLen() {
+		// CIC never sees mixed families in one flow; fall back to numeric order.
+		if k.SrcAddr.Compare(k.DstAddr) > 0 {
+			return k.reversed()
+		}
+		return k
+	}
+	src, dst := k.SrcAddr.As16(), k.DstAddr.As16()
+	for i := range src {
+		if src[i] != dst[i] {
+			if int8(src[i]) > int8(dst[i]) {
+				return k.reversed()
+			}
+			break
+		}
+	}
+	return k
+}
+
+// reversed swaps the endpoints of k.
+func (k FlowKey) reversed() FlowKey {
+	k.SrcAddr, k.DstAddr = k.DstAddr, k.SrcAddr
+	k.SrcPort, k.DstPort = k.DstPort, k.SrcPort
+	k.SrcMAC, k.DstMAC = k.DstMAC, k.SrcMAC
+	return k
+}
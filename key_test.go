@@ -0,0 +1,177 @@
+package flowmeter
+
+import (
+	"testing"
+	"time"
+)
+
+func mustKey(srcIP, dstIP string, srcPort, dstPort uint16) FlowKey {
+	return FlowKey{SrcAddr: ParseAddr(srcIP), DstAddr: ParseAddr(dstIP), SrcPort: srcPort, DstPort: dstPort, Protocol: 6}
+}
+
+func TestCanonicalFlowKey_NumericOrder(t *testing.T) {
+	k := CanonicalFlowKey(mustKey("10.0.0.10", "10.0.0.9", 80, 12345))
+	if k.SrcIP() != "10.0.0.9" || k.SrcPort != 12345 || k.DstIP() != "10.0.0.10" || k.DstPort != 80 {
+		t.Errorf("expected 10.0.0.9:12345 first, got %s:%d -> %s:%d", k.SrcIP(), k.SrcPort, k.DstIP(), k.DstPort)
+	}
+	if CanonicalFlowKey(k.reversed()) != k {
+		t.Errorf("expected both directions to give the same key")
+	}
+	// Equal addresses: smaller port first
+	k = CanonicalFlowKey(mustKey("::1", "::1", 443, 5000))
+	if k.SrcPort != 443 {
+		t.Errorf("expected smaller port first for equal addresses, got %d", k.SrcPort)
+	}
+}
+
+func TestCanonicalFlowKey_AddressForms(t *testing.T) {
+	if CanonicalFlowKey(mustKey("::1", "2001:db8::1", 1, 2)) != CanonicalFlowKey(mustKey("0:0::1", "2001:0db8:0:0::1", 1, 2)) {
+		t.Errorf("expected equal keys for different IPv6 spellings")
+	}
+	k := CanonicalFlowKey(mustKey("::ffff:192.168.1.1", "10.0.0.1", 1, 2))
+	if k != CanonicalFlowKey(mustKey("192.168.1.1", "10.0.0.1", 1, 2)) {
+		t.Errorf("expected IPv4-mapped address to key like IPv4, got %s", k.DstIP())
+	}
+	if k.DstIP() != "192.168.1.1" {
+		t.Errorf("expected unmapped text form 192.168.1.1, got %s", k.DstIP())
+	}
+	if got := (FlowKey{}).SrcIP(); got != "" {
+		t.Errorf("expected empty string for zero address, got %q", got)
+	}
+}
+
+func TestCICFlowKey_SignedByteOrder(t *testing.T) {
+	// Java compares bytes as signed: 200 (-56) < 10, so 200.0.0.1 comes first.
+	k := CICFlowKey(mustKey("10.0.0.1", "200.0.0.1", 80, 1234))
+	if k.SrcIP() != "200.0.0.1" || k.SrcPort != 1234 {
+		t.Errorf("expected 200.0.0.1:1234 first, got %s:%d", k.SrcIP(), k.SrcPort)
+	}
+	if KeyOrderNumeric.Canonical(k).SrcIP() != "10.0.0.1" {
+		t.Errorf("expected numeric order to put 10.0.0.1 first")
+	}
+	// Equal addresses keep their order; ports are not compared.
+	k = CICFlowKey(mustKey("1.1.1.1", "1.1.1.1", 5000, 80))
+	if k.SrcPort != 5000 {
+		t.Errorf("expected port order kept for equal addresses, got %d", k.SrcPort)
+	}
+}
+
+func TestProcessPackets_KeyOrder_CIC(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	packets := []PacketInfo{
+		{Timestamp: base, Direction: Forward, SrcIP: "10.0.0.1", DstIP: "200.0.0.1", SrcPort: 1234, DstPort: 80, Protocol: 6},
+	}
+	pairs := ProcessPacketsWithConfig(append([]PacketInfo(nil), packets...), Config{KeyOrder: KeyOrderCIC})
+	if len(pairs) != 1 || pairs[0].Key.SrcIP() != "200.0.0.1" {
+		t.Fatalf("expected CIC key with 200.0.0.1 first, got %+v", pairs)
+	}
+	pairs = ProcessPacketsWithKeys(packets)
+	if len(pairs) != 1 || pairs[0].Key.SrcIP() != "10.0.0.1" {
+		t.Fatalf("expected numeric key with 10.0.0.1 first, got %+v", pairs)
+	}
+}
+
+func TestProcessPackets_KeyOrder_Initiator(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Two clients of 10.0.0.1:80; the numeric key lists the server first for both.
+	raw := []RawPacket{
+		{Timestamp: base, SrcIP: "10.0.0.7", DstIP: "10.0.0.1", SrcPort: 40000, DstPort: 80, Protocol: 6, SrcMAC: MAC{7}, DstMAC: MAC{1}},
+		{Timestamp: base.Add(time.Millisecond), SrcIP: "10.0.0.1", DstIP: "10.0.0.7", SrcPort: 80, DstPort: 40000, Protocol: 6, SrcMAC: MAC{1}, DstMAC: MAC{7}},
+		{Timestamp: base.Add(2 * time.Millisecond), SrcIP: "10.0.0.7", DstIP: "10.0.0.1", SrcPort: 40001, DstPort: 80, Protocol: 6, SrcMAC: MAC{7}, DstMAC: MAC{1}},
+	}
+	cfg := Config{KeyOrder: KeyOrderInitiator, KeyFields: KeyMAC}
+	packets := ConvertToPacketInfoWithFields(raw, KeyMAC)
+	batch := ProcessPacketsWithConfig(packets, cfg)
+	table := NewFlowTableWithConfig(cfg)
+	for _, p := range packets {
+		table.Add(p)
+	}
+	streamed := table.Flush()
+	for name, flows := range map[string][]FlowWithKey{"batch": batch, "FlowTable": streamed} {
+		if len(flows) != 2 {
+			t.Fatalf("%s: expected 2 flows, got %d", name, len(flows))
+		}
+		bySrc := map[string]int{}
+		for _, fl := range flows {
+			k := fl.Key
+			bySrc[k.SrcIP()]++
+			if k.DstIP() != "10.0.0.1" || k.DstPort != 80 || k.SrcMAC != (MAC{7}) || k.DstMAC != (MAC{1}) {
+				t.Errorf("%s: expected a key from the client to 10.0.0.1:80, got %+v", name, k)
+			}
+			if CanonicalFlowKey(k).SrcIP() != "10.0.0.1" {
+				t.Errorf("%s: expected the canonical key to list 10.0.0.1 first, got %+v", name, CanonicalFlowKey(k))
+			}
+		}
+		// Aggregating by SrcIP groups by client.
+		if bySrc["10.0.0.7"] != 2 {
+			t.Errorf("%s: expected both flows under 10.0.0.7, got %v", name, bySrc)
+		}
+	}
+	// Without KeyOrderInitiator, InitiatorKey gives the same orientation.
+	fl := ProcessPacketsWithConfig(packets, Config{KeyFields: KeyMAC})[0]
+	if fl.Key.SrcIP() != "10.0.0.1" || fl.InitiatorKey() != batch[0].Key {
+		t.Errorf("expected InitiatorKey %+v of canonical key %+v, got %+v", batch[0].Key, fl.Key, fl.InitiatorKey())
+	}
+}
+
+func TestCanonicalFlowKey_ExtendedFields(t *testing.T) {
+	a, b := MAC{0, 0, 0, 0, 0, 0xa}, MAC{0, 0, 0, 0, 0, 0xb}
+	k := mustKey("10.0.0.2", "10.0.0.1", 80, 12345)
+	k.VLANID, k.VNI, k.ObservationDomain, k.SrcMAC, k.DstMAC = 100, 5000, 3, b, a
+	c := CanonicalFlowKey(k)
+	if c.SrcIP() != "10.0.0.1" || c.SrcMAC != a || c.DstMAC != b {
+		t.Errorf("expected 10.0.0.1 first with its MAC, got %s %v -> %v", c.SrcIP(), c.SrcMAC, c.DstMAC)
+	}
+	if c.VLANID != 100 || c.VNI != 5000 || c.ObservationDomain != 3 {
+		t.Errorf("expected extended fields kept, got %+v", c)
+	}
+	if CICFlowKey(k.reversed()) != CICFlowKey(k) {
+		t.Errorf("expected both directions to give the same CIC key")
+	}
+	if a.String() != "00:00:00:00:00:0a" {
+		t.Errorf("expected 00:00:00:00:00:0a, got %s", a)
+	}
+}
+
+func TestKeyWith_SelectsFields(t *testing.T) {
+	p := PacketInfo{SrcIP: "10.0.0.1", DstIP: "10.0.0.2", SrcPort: 1, DstPort: 2, Protocol: 6,
+		VLANID: 10, ObservationDomain: 2, Tunnel: TunnelInfo{Type: TunnelVXLAN, ID: 77}, SrcMAC: MAC{1}, DstMAC: MAC{2}}
+	if p.KeyWith(0) != p.Key() {
+		t.Errorf("expected the 5-tuple key without key fields, got %+v", p.KeyWith(0))
+	}
+	k := p.KeyWith(KeyVLAN | KeyVNI)
+	if k.VLANID != 10 || k.VNI != 77 || k.ObservationDomain != 0 || k.SrcMAC != (MAC{}) {
+		t.Errorf("expected VLAN 10 and VNI 77 only, got %+v", k)
+	}
+	k = p.KeyWith(KeyObservationDomain | KeyMAC)
+	if k.VLANID != 0 || k.ObservationDomain != 2 || k.SrcMAC != (MAC{1}) || k.DstMAC != (MAC{2}) {
+		t.Errorf("expected domain 2 and MACs only, got %+v", k)
+	}
+}
+
+func TestKeyFields_Select(t *testing.T) {
+	full := FlowKey{SrcAddr: ParseAddr("10.0.0.1"), DstAddr: ParseAddr("10.0.0.2"), SrcPort: 1, DstPort: 2, Protocol: 17,
+		VLANID: 10, VNI: 77, ObservationDomain: 2, SrcMAC: MAC{1}, DstMAC: MAC{2}}
+	if k := KeyFields(0).Select(full); k != (FlowKey{SrcAddr: full.SrcAddr, DstAddr: full.DstAddr, SrcPort: 1, DstPort: 2, Protocol: 17}) {
+		t.Errorf("expected the 5-tuple only, got %+v", k)
+	}
+	if k := (KeyVNI | KeyMAC).Select(full); k.VNI != 77 || k.SrcMAC != (MAC{1}) || k.VLANID != 0 || k.ObservationDomain != 0 {
+		t.Errorf("expected VNI 77 and MACs only, got %+v", k)
+	}
+}
+
+func TestParseKeyFields(t *testing.T) {
+	f, err := ParseKeyFields("vlan, mac")
+	if err != nil || f != KeyVLAN|KeyMAC {
+		t.Errorf("expected vlan|mac, got %v %v", f, err)
+	}
+	if f.String() != "vlan,mac" {
+		t.Errorf("expected vlan,mac, got %s", f)
+	}
+	if f, err := ParseKeyFields(""); err != nil || f != 0 {
+		t.Errorf("expected no fields, got %v %v", f, err)
+	}
+	if _, err := ParseKeyFields("vlan,tenant"); err == nil {
+		t.Errorf("expected an error for an unknown field")
+	}
+}
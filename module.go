@@ -0,0 +1,420 @@
+package flowmeter
+
+import "fmt"
+
+// FeatureModule is a group of flow features computed together, such as an in-house
+// feature added next to the built-in CICFlowMeter modules. Register it in a Registry and
+// set Config.Features; its values then appear in FlowFeatures.Custom, FlowFeatures.Map
+// and Registry.Vector.
+type FeatureModule interface {
+	// Name identifies the module in its Registry.
+	Name() string
+	// Features describes the values the module produces, in the order Finalize writes
+	// them. Names must be unique across the Registry; Field may be left empty.
+	Features() []FeatureDescriptor
+	// NewState returns the module's state for one new flow.
+	NewState(cfg *Config) FeatureState
+}
+
+// FeatureState is a FeatureModule's incremental state for one flow.
+type FeatureState interface {
+	// Update is called with every packet of the flow, in timestamp order.
+	Update(p *PacketInfo)
+	// Finalize writes one value per descriptor of Features into values. f holds the
+	// features of the built-in modules, already computed.
+	Finalize(f *FlowFeatures, values []float64)
+}
+
+// FeatureRequirer is implemented by FeatureModules whose Finalize reads built-in
+// features. Requires names the built-in modules (e.g. "counts") that must run with it.
+type FeatureRequirer interface {
+	Requires() []string
+}
+
+// NewBatchModule returns a FeatureModule for features that are easier to compute over
+// the flow's whole packet list than incrementally. compute gets the packets in timestamp
+// order and writes one value per descriptor into values. The packets are buffered, so
+// memory grows with flow length unlike the built-in modules.
+func NewBatchModule(name string, features []FeatureDescriptor, compute func(packets []PacketInfo, f *FlowFeatures, values []float64)) FeatureModule {
+	return &batchModule{name: name, features: features, compute: compute}
+}
+
+type batchModule struct {
+	name     string
+	features []FeatureDescriptor
+	compute  func(packets []PacketInfo, f *FlowFeatures, values []float64)
+}
+
+func (m *batchModule) Name() string                  { return m.name }
+func (m *batchModule) Features() []FeatureDescriptor { return m.features }
+func (m *batchModule) NewState(*Config) FeatureState { return &batchState{compute: m.compute} }
+
+type batchState struct {
+	packets []PacketInfo
+	compute func(packets []PacketInfo, f *FlowFeatures, values []float64)
+}
+
+func (s *batchState) Update(p *PacketInfo) { s.packets = append(s.packets, *p) }
+
+func (s *batchState) Finalize(f *FlowFeatures, values []float64) {
+	s.compute(s.packets, f, values)
+}
+
+// moduleSet is a bit set of built-in modules, in flowAccumulator order.
+type moduleSet uint32
+
+const (
+	modBasic moduleSet = 1 << iota
+	modCounts
+	modPacketLen
+	modIAT
+	modFlags
+	modRates
+	modRatio
+	modBulk
+	modSubflow
+	modActiveIdle
+	modInitWin
+	modTCPHealth
+	modRTT
+	modTCPOpts
+	modOSFP
+	modIPLayer
+	modICMP
+	modTunnel
+
+	allModules = modTunnel<<1 - 1
+)
+
+// builtinModules names the built-in modules (after their source files) and the modules
+// whose results their finalize reads.
+var builtinModules = []struct {
+	name     string
+	set      moduleSet
+	requires moduleSet
+}{
+	{"basic", modBasic, 0},
+	{"counts", modCounts, 0},
+	{"packetlen", modPacketLen, 0},
+	{"iat", modIAT, 0},
+	{"flags", modFlags, 0},
+	{"rates", modRates, modBasic | modCounts},
+	{"ratio", modRatio, modCounts},
+	{"bulk", modBulk, 0},
+	{"subflow", modSubflow, modCounts},
+	{"activeidle", modActiveIdle, 0},
+	{"initwin", modInitWin, 0},
+	{"tcphealth", modTCPHealth, 0},
+	{"rtt", modRTT, 0},
+	{"tcpopts", modTCPOpts, 0},
+	{"osfp", modOSFP, 0},
+	{"iplayer", modIPLayer, 0},
+	{"icmp", modICMP, 0},
+	{"tunnel", modTunnel, 0},
+}
+
+// Registry lists the feature modules a flowmeter runs: the built-in ones, which can be
+// disabled, and custom FeatureModules. NewRegistry enables every built-in module.
+type Registry struct {
+	builtins moduleSet
+	custom   []registeredModule
+	selected map[string]bool     // features listed by Schema after Select; nil lists all
+	schema   []FeatureDescriptor // Schema, rebuilt whenever the modules or the selection change
+}
+
+type registeredModule struct {
+	module   FeatureModule
+	features []FeatureDescriptor
+	requires moduleSet
+}
+
+// NewRegistry returns a Registry with every built-in module enabled and no custom ones.
+func NewRegistry() *Registry {
+	r := &Registry{builtins: allModules}
+	r.update()
+	return r
+}
+
+// Register adds a custom module. Its name must not be taken by another module, and its
+// feature names not by any other feature.
+func (r *Registry) Register(m FeatureModule) error {
+	name := m.Name()
+	if name == "" {
+		return fmt.Errorf("flowmeter: feature module without a name")
+	}
+	if _, ok := builtinSet(name); ok || r.customIndex(name) >= 0 {
+		return fmt.Errorf("flowmeter: feature module %q already registered", name)
+	}
+	var requires moduleSet
+	if req, ok := m.(FeatureRequirer); ok {
+		for _, dep := range req.Requires() {
+			set, ok := builtinSet(dep)
+			if !ok {
+				return fmt.Errorf("flowmeter: feature module %q requires unknown module %q", name, dep)
+			}
+			requires |= set
+		}
+	}
+	taken := make(map[string]bool)
+	for _, d := range featureSchema {
+		taken[d.Name] = true
+	}
+	for _, d := range extendedSchema {
+		taken[d.Name] = true
+	}
+	for _, c := range r.custom {
+		for _, d := range c.features {
+			taken[d.Name] = true
+		}
+	}
+	features := append([]FeatureDescriptor(nil), m.Features()...)
+	for i := range features {
+		if taken[features[i].Name] {
+			return fmt.Errorf("flowmeter: feature %q of module %q already exists", features[i].Name, name)
+		}
+		taken[features[i].Name] = true
+		features[i].Module, features[i].CICColumn, features[i].value = name, 0, nil
+		if r.selected != nil {
+			r.selected[features[i].Name] = true
+		}
+	}
+	r.builtins |= requiresClosure(requires)
+	r.custom = append(r.custom, registeredModule{module: m, features: features, requires: requires})
+	r.update()
+	return nil
+}
+
+// Disable stops a built-in module from running (its FlowFeatures fields stay zero) or
+// removes a custom one. A built-in module that an enabled one reads from (counts for
+// rates, ratio and subflow; basic for rates; see also FeatureRequirer) cannot be
+// disabled.
+func (r *Registry) Disable(name string) error {
+	if set, ok := builtinSet(name); ok {
+		for _, b := range builtinModules {
+			if r.builtins&b.set != 0 && b.requires&set != 0 {
+				return fmt.Errorf("flowmeter: feature module %q is required by %q", name, b.name)
+			}
+		}
+		for _, c := range r.custom {
+			if c.requires&set != 0 {
+				return fmt.Errorf("flowmeter: feature module %q is required by %q", name, c.module.Name())
+			}
+		}
+		r.builtins &^= set
+		r.update()
+		return nil
+	}
+	if i := r.customIndex(name); i >= 0 {
+		r.custom = append(r.custom[:i], r.custom[i+1:]...)
+		r.update()
+		return nil
+	}
+	return fmt.Errorf("flowmeter: unknown feature module %q", name)
+}
+
+// Enable turns a built-in module back on, together with the modules it reads from. After
+// Select, its features are added to the selection.
+func (r *Registry) Enable(name string) error {
+	set, ok := builtinSet(name)
+	if !ok {
+		return fmt.Errorf("flowmeter: unknown built-in feature module %q", name)
+	}
+	r.builtins |= requiresClosure(set)
+	if r.selected != nil {
+		for _, d := range builtinFeatures() {
+			if d.Module == name {
+				r.selected[d.Name] = true
+			}
+		}
+	}
+	r.update()
+	return nil
+}
+
+// Select restricts r to the named features and modules, e.g. Select("Flow Duration",
+// "Fwd IAT Mean", "bulk"). Names are feature names (CIC, extended or custom) or module
+// names. Only the modules that produce them run, together with the modules those read
+// from; every other module is disabled and custom modules not named are removed.
+// Schema and Vector then list just the named features, or all features of a named
+// module.
+func (r *Registry) Select(names ...string) error {
+	var builtins moduleSet
+	keep := make([]bool, len(r.custom))
+	selected := make(map[string]bool)
+	for _, name := range names {
+		if set, ok := builtinSet(name); ok {
+			builtins |= set
+			for _, d := range builtinFeatures() {
+				if d.Module == name {
+					selected[d.Name] = true
+				}
+			}
+			continue
+		}
+		if i := r.customIndex(name); i >= 0 {
+			keep[i] = true
+			for _, d := range r.custom[i].features {
+				selected[d.Name] = true
+			}
+			continue
+		}
+		if d, ok := r.feature(name); ok {
+			if set, ok := builtinSet(d.Module); ok {
+				builtins |= set
+			} else {
+				keep[r.customIndex(d.Module)] = true
+			}
+			selected[name] = true
+			continue
+		}
+		return fmt.Errorf("flowmeter: unknown feature or module %q", name)
+	}
+	var custom []registeredModule
+	for i, c := range r.custom {
+		if keep[i] {
+			custom = append(custom, c)
+			builtins |= c.requires
+		}
+	}
+	r.builtins, r.custom, r.selected = requiresClosure(builtins), custom, selected
+	r.update()
+	return nil
+}
+
+// Modules returns the names of the enabled modules: built-ins first, then custom ones in
+// registration order.
+func (r *Registry) Modules() []string {
+	var names []string
+	for _, b := range builtinModules {
+		if r.builtins&b.set != 0 {
+			names = append(names, b.name)
+		}
+	}
+	for _, c := range r.custom {
+		names = append(names, c.module.Name())
+	}
+	return names
+}
+
+// Schema returns the descriptors of the features the enabled modules produce: CIC
+// features in CIC order, then the extended features, then custom ones. It is the order
+// of Vector.
+func (r *Registry) Schema() []FeatureDescriptor {
+	return append([]FeatureDescriptor(nil), r.schema...)
+}
+
+// update rebuilds the cached Schema after the modules or the selection changed.
+func (r *Registry) update() {
+	var out []FeatureDescriptor
+	for _, schema := range [][]FeatureDescriptor{featureSchema, extendedSchema} {
+		for _, d := range schema {
+			if set, _ := builtinSet(d.Module); r.builtins&set != 0 && (r.selected == nil || r.selected[d.Name]) {
+				out = append(out, d)
+			}
+		}
+	}
+	for _, c := range r.custom {
+		for _, d := range c.features {
+			if r.selected == nil || r.selected[d.Name] {
+				out = append(out, d)
+			}
+		}
+	}
+	r.schema = out
+}
+
+// Vector returns the values of the features in Schema, in that order.
+func (r *Registry) Vector(f *FlowFeatures) []float64 {
+	v := make([]float64, len(r.schema))
+	for i, d := range r.schema {
+		v[i] = d.Value(f)
+	}
+	return v
+}
+
+// newStates returns a fresh state per custom module.
+func (r *Registry) newStates(cfg *Config) []FeatureState {
+	if len(r.custom) == 0 {
+		return nil
+	}
+	states := make([]FeatureState, len(r.custom))
+	for i, c := range r.custom {
+		states[i] = c.module.NewState(cfg)
+	}
+	return states
+}
+
+// finalizeCustom stores the values of the custom modules' states in f.Custom.
+func (r *Registry) finalizeCustom(states []FeatureState, f *FlowFeatures) {
+	n := 0
+	for _, c := range r.custom {
+		n += len(c.features)
+	}
+	values := make([]float64, n)
+	f.Custom = make(map[string]float64, n)
+	for i, c := range r.custom {
+		v := values[:len(c.features)]
+		values = values[len(c.features):]
+		states[i].Finalize(f, v)
+		for j, d := range c.features {
+			f.Custom[d.Name] = v[j]
+		}
+	}
+}
+
+// feature returns the descriptor of the built-in or custom feature called name.
+func (r *Registry) feature(name string) (FeatureDescriptor, bool) {
+	for _, d := range builtinFeatures() {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	for _, c := range r.custom {
+		for _, d := range c.features {
+			if d.Name == name {
+				return d, true
+			}
+		}
+	}
+	return FeatureDescriptor{}, false
+}
+
+func (r *Registry) customIndex(name string) int {
+	for i, c := range r.custom {
+		if c.module.Name() == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// builtinFeatures returns the CIC and extended feature descriptors.
+func builtinFeatures() []FeatureDescriptor {
+	return append(append([]FeatureDescriptor(nil), featureSchema...), extendedSchema...)
+}
+
+// requiresClosure adds to set the built-in modules its modules read from, transitively.
+func requiresClosure(set moduleSet) moduleSet {
+	for {
+		next := set
+		for _, b := range builtinModules {
+			if set&b.set != 0 {
+				next |= b.requires
+			}
+		}
+		if next == set {
+			return set
+		}
+		set = next
+	}
+}
+
+// builtinSet returns the bit of the built-in module called name.
+func builtinSet(name string) (moduleSet, bool) {
+	for _, b := range builtinModules {
+		if b.name == name {
+			return b.set, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,288 @@
+package flowmeter
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// maxPayloadModule is an incremental custom module: the largest payload per direction.
+type maxPayloadModule struct{}
+
+func (maxPayloadModule) Name() string { return "maxpayload" }
+
+func (maxPayloadModule) Features() []FeatureDescriptor {
+	return []FeatureDescriptor{
+		{Name: "Fwd Max Payload", Unit: UnitBytes, Type: DataTypeInt},
+		{Name: "Bwd Max Payload Share", Unit: UnitRatio, Type: DataTypeFloat},
+	}
+}
+
+func (maxPayloadModule) NewState(*Config) FeatureState { return &maxPayloadState{} }
+
+type maxPayloadState struct{ fwd, bwd int }
+
+func (s *maxPayloadState) Update(p *PacketInfo) {
+	if p.Direction == Forward {
+		s.fwd = max(s.fwd, p.PayloadSize)
+	} else {
+		s.bwd = max(s.bwd, p.PayloadSize)
+	}
+}
+
+func (s *maxPayloadState) Finalize(f *FlowFeatures, values []float64) {
+	values[0] = float64(s.fwd)
+	if f.TotalBwdBytes > 0 {
+		values[1] = float64(s.bwd) / float64(f.TotalBwdBytes)
+	}
+}
+
+func moduleTestPackets() []PacketInfo {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	pkt := func(ms int, dir Direction, payload int) PacketInfo {
+		return PacketInfo{Timestamp: base.Add(time.Duration(ms) * time.Millisecond), Direction: dir, HeaderLen: 20, PayloadSize: payload,
+			SrcIP: "10.0.0.1", DstIP: "10.0.0.2", SrcPort: 1234, DstPort: 80, Protocol: 6}
+	}
+	return []PacketInfo{pkt(0, Forward, 100), pkt(1, Backward, 300), pkt(2, Forward, 500), pkt(3, Backward, 100), pkt(4, Forward, 200)}
+}
+
+func TestProcessPackets_Module_Custom(t *testing.T) {
+	reg := NewRegistry()
+	if err := reg.Register(maxPayloadModule{}); err != nil {
+		t.Fatal(err)
+	}
+	median := NewBatchModule("median", []FeatureDescriptor{{Name: "Median Payload", Unit: UnitBytes}},
+		func(packets []PacketInfo, _ *FlowFeatures, values []float64) {
+			sizes := make([]int, len(packets))
+			for i, p := range packets {
+				sizes[i] = p.PayloadSize
+			}
+			sort.Ints(sizes)
+			values[0] = float64(sizes[len(sizes)/2])
+		})
+	if err := reg.Register(median); err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig()
+	cfg.Features = reg
+	flows := ProcessPacketsWithConfig(moduleTestPackets(), cfg)
+	if len(flows) != 1 {
+		t.Fatalf("expected 1 flow, got %d", len(flows))
+	}
+	f := flows[0].Features
+	want := map[string]float64{"Fwd Max Payload": 500, "Bwd Max Payload Share": 0.75, "Median Payload": 200}
+	if !reflect.DeepEqual(f.Custom, want) {
+		t.Errorf("expected Custom %v, got %v", want, f.Custom)
+	}
+	if m := f.Map(); m["Median Payload"] != 200 || m["Total Fwd Packet"] != 3 {
+		t.Errorf("expected custom and CIC values in Map, got %v and %v", m["Median Payload"], m["Total Fwd Packet"])
+	}
+	schema, vec := reg.Schema(), reg.Vector(&f)
+	if n := len(featureSchema) + len(extendedSchema) + 3; len(schema) != n || len(vec) != n {
+		t.Fatalf("expected %d features, got %d descriptors and %d values", n, len(schema), len(vec))
+	}
+	if schema[len(schema)-1].Name != "Median Payload" || schema[len(schema)-1].Module != "median" || vec[len(vec)-3] != 500 {
+		t.Errorf("expected custom features last, got %+v / %v", schema[len(schema)-1], vec[len(vec)-3:])
+	}
+
+	// A FlowTable runs the same modules.
+	table := NewFlowTableWithConfig(cfg)
+	for _, p := range moduleTestPackets() {
+		table.Add(p)
+	}
+	if got := table.Flush()[0].Features.Custom; !reflect.DeepEqual(got, want) {
+		t.Errorf("FlowTable: expected Custom %v, got %v", want, got)
+	}
+}
+
+func TestProcessPackets_Module_DisableBuiltin(t *testing.T) {
+	reg := NewRegistry()
+	if err := reg.Disable("iat"); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.Disable("counts"); err == nil {
+		t.Errorf("expected counts to be required by rates")
+	}
+	cfg := DefaultConfig()
+	cfg.Features = reg
+	f := ProcessPacketsWithConfig(moduleTestPackets(), cfg)[0].Features
+	all := ProcessPacketsWithKeys(moduleTestPackets())[0].Features
+	if f.FlowIAT != (Stats{}) || f.FwdIATTotal != 0 {
+		t.Errorf("expected no IAT features, got %+v %v", f.FlowIAT, f.FwdIATTotal)
+	}
+	if f.TotalFwdBytes != all.TotalFwdBytes || f.FwdPacketsPerSec != all.FwdPacketsPerSec || f.Custom != nil {
+		t.Errorf("expected the other modules unchanged, got %d %v", f.TotalFwdBytes, f.FwdPacketsPerSec)
+	}
+	for _, d := range reg.Schema() {
+		if d.Module == "iat" {
+			t.Errorf("expected %q to be left out of the schema", d.Name)
+		}
+	}
+	for _, name := range reg.Modules() {
+		if name == "iat" {
+			t.Errorf("expected iat to be disabled")
+		}
+	}
+	if err := reg.Enable("iat"); err != nil || len(reg.Schema()) != len(featureSchema)+len(extendedSchema) {
+		t.Errorf("expected every built-in feature after Enable, got %d (%v)", len(reg.Schema()), err)
+	}
+}
+
+func TestRegistry_RegisterErrors(t *testing.T) {
+	reg := NewRegistry()
+	if err := reg.Register(NewBatchModule("bulk", nil, nil)); err == nil {
+		t.Errorf("expected an error for a built-in module name")
+	}
+	if err := reg.Register(NewBatchModule("dup", []FeatureDescriptor{{Name: "Flow Duration"}}, nil)); err == nil {
+		t.Errorf("expected an error for a CIC feature name")
+	}
+	if err := reg.Register(maxPayloadModule{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.Register(maxPayloadModule{}); err == nil {
+		t.Errorf("expected an error for a second module of the same name")
+	}
+	if err := reg.Disable("maxpayload"); err != nil || len(reg.Modules()) != len(builtinModules) {
+		t.Errorf("expected the custom module removed, got %v (%v)", reg.Modules(), err)
+	}
+	if err := reg.Disable("nope"); err == nil {
+		t.Errorf("expected an error for an unknown module")
+	}
+}
+
+func TestRegistry_VectorFollowsSchema(t *testing.T) {
+	reg := NewRegistry()
+	f := ProcessPacketsWithKeys(moduleTestPackets())[0].Features
+	check := func(step string) {
+		t.Helper()
+		schema, v := reg.Schema(), reg.Vector(&f)
+		if len(v) != len(schema) {
+			t.Fatalf("%s: expected %d values, got %d", step, len(schema), len(v))
+		}
+		for i, d := range schema {
+			if d.Module != "maxpayload" && v[i] != d.Value(&f) {
+				t.Errorf("%s: %s: expected %v, got %v", step, d.Name, d.Value(&f), v[i])
+			}
+		}
+	}
+	check("new")
+	if err := reg.Disable("bulk"); err != nil {
+		t.Fatal(err)
+	}
+	check("disable")
+	if err := reg.Enable("bulk"); err != nil {
+		t.Fatal(err)
+	}
+	check("enable")
+	if err := reg.Register(maxPayloadModule{}); err != nil {
+		t.Fatal(err)
+	}
+	check("register")
+	if err := reg.Select("Flow Duration", "iat"); err != nil {
+		t.Fatal(err)
+	}
+	check("select")
+	// Changing the returned schema does not change the registry's.
+	schema := reg.Schema()
+	schema[0] = FeatureDescriptor{Name: "changed"}
+	if d := reg.Schema()[0]; d.Name != "Flow Duration" {
+		t.Errorf("expected Flow Duration first, got %s", d.Name)
+	}
+}
+
+func TestProcessPackets_Module_Select(t *testing.T) {
+	reg := NewRegistry()
+	if err := reg.Register(maxPayloadModule{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.Select("Fwd Packets/s", "Down/Up Ratio", "Fwd Max Payload", "activeidle"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"basic", "counts", "rates", "ratio", "activeidle", "maxpayload"}
+	if got := reg.Modules(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected modules %v, got %v", want, got)
+	}
+	var names []string
+	for _, d := range reg.Schema() {
+		names = append(names, d.Name)
+	}
+	wantNames := []string{"Fwd Packets/s", "Down/Up Ratio", "Active Mean", "Active Std", "Active Max", "Active Min",
+		"Idle Mean", "Idle Std", "Idle Max", "Idle Min", "Fwd Max Payload"}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("expected schema %v, got %v", wantNames, names)
+	}
+
+	cfg := DefaultConfig()
+	cfg.Features = reg
+	f := ProcessPacketsWithConfig(moduleTestPackets(), cfg)[0].Features
+	all := ProcessPacketsWithKeys(moduleTestPackets())[0].Features
+	if f.FwdPacketsPerSec != all.FwdPacketsPerSec || f.DownUpRatio != all.DownUpRatio || f.Custom["Fwd Max Payload"] != 500 {
+		t.Errorf("expected selected features as without selection, got %v %v %v", f.FwdPacketsPerSec, f.DownUpRatio, f.Custom)
+	}
+	if f.FwdPacketLen != (Stats{}) || f.FlowIAT != (Stats{}) || f.FwdPSHFlag != 0 {
+		t.Errorf("expected unselected modules not to run")
+	}
+	if v := reg.Vector(&f); len(v) != len(wantNames) || v[0] != all.FwdPacketsPerSec {
+		t.Errorf("expected the selected vector, got %v", v)
+	}
+
+	if err := reg.Select("No Such Feature"); err == nil {
+		t.Errorf("expected an error for an unknown feature")
+	}
+}
+
+// cicSubset is a typical model input: 20 of the CIC features.
+var cicSubset = []string{
+	"Flow Duration", "Total Fwd Packet", "Total Bwd packets", "Total Length of Fwd Packet", "Total Length of Bwd Packet",
+	"Fwd Packet Length Max", "Fwd Packet Length Mean", "Bwd Packet Length Max", "Bwd Packet Length Mean", "Flow Bytes/s",
+	"Flow Packets/s", "Fwd PSH Flags", "Fwd Header Length", "Bwd Header Length", "Fwd Packets/s",
+	"Bwd Packets/s", "SYN Flag Count", "ACK Flag Count", "Down/Up Ratio", "Average Packet Size",
+}
+
+func BenchmarkProcessPackets_FeatureSubset(b *testing.B) {
+	packets := syntheticWindow(20_000, 10)
+	work := make([]PacketInfo, len(packets))
+	subset := NewRegistry()
+	if err := subset.Select(cicSubset...); err != nil {
+		b.Fatal(err)
+	}
+	for _, bc := range []struct {
+		name string
+		reg  *Registry
+	}{{"all", nil}, {"cic20", subset}} {
+		b.Run(bc.name, func(b *testing.B) {
+			cfg := Config{Features: bc.reg}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				copy(work, packets)
+				ProcessPacketsWithConfig(work, cfg)
+			}
+		})
+	}
+}
+
+func BenchmarkFlowTable_FeatureSubset(b *testing.B) {
+	packets := syntheticWindow(2_000, 10)
+	subset := NewRegistry()
+	if err := subset.Select(cicSubset...); err != nil {
+		b.Fatal(err)
+	}
+	for _, bc := range []struct {
+		name string
+		reg  *Registry
+	}{{"all", nil}, {"cic20", subset}} {
+		b.Run(bc.name, func(b *testing.B) {
+			cfg := DefaultConfig()
+			cfg.Features = bc.reg
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				table := NewFlowTableWithConfig(cfg)
+				for j := range packets {
+					table.Add(packets[j])
+				}
+				table.Flush()
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package netflow
+
+import (
+	"net"
+	"net/netip"
+)
+
+// maxDatagram is the largest UDP payload.
+const maxDatagram = 65535
+
+// Collector reads export messages from a UDP socket and decodes them.
+type Collector struct {
+	conn    net.PacketConn
+	decoder *Decoder
+	buf     []byte
+}
+
+// Listen listens for export messages on the UDP address (e.g. ":2055" for NetFlow,
+// ":4739" for IPFIX).
+func Listen(network, address string) (*Collector, error) {
+	conn, err := net.ListenPacket(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewCollector(conn), nil
+}
+
+// NewCollector returns a Collector reading from conn. Close closes conn.
+func NewCollector(conn net.PacketConn) *Collector {
+	return &Collector{conn: conn, decoder: NewDecoder(), buf: make([]byte, maxDatagram)}
+}
+
+// Addr returns the local address the Collector listens on.
+func (c *Collector) Addr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// Next waits for the next message and returns its flow records, which may be none (a
+// message with templates only). A malformed message gives a decoding error and no
+// records, since its records may be corrupt; the Collector can keep reading after it.
+// Errors of the socket, including deadlines set with SetReadDeadline on the conn, end
+// collection.
+func (c *Collector) Next() ([]Record, error) {
+	n, from, err := c.conn.ReadFrom(c.buf)
+	if err != nil {
+		return nil, err
+	}
+	var exporter netip.Addr
+	if a, ok := from.(*net.UDPAddr); ok {
+		exporter = a.AddrPort().Addr().Unmap()
+	}
+	return c.decoder.Decode(nil, c.buf[:n], exporter)
+}
+
+// Stats returns the decoder's counters.
+func (c *Collector) Stats() Stats {
+	return c.decoder.Stats
+}
+
+// Close closes the socket.
+func (c *Collector) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,69 @@
+package netflow
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Bi9River/goflowmeter"
+	"github.com/Bi9River/goflowmeter/ipfix"
+)
+
+func TestCollector_IPFIXRoundTrip(t *testing.T) {
+	c, err := Listen("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	packets := []flowmeter.PacketInfo{
+		{Timestamp: base, HeaderLen: 40, PayloadSize: 60, Direction: flowmeter.Forward, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 12345, DstPort: 80, Protocol: 6, SYN: true},
+		{Timestamp: base.Add(time.Second), HeaderLen: 40, PayloadSize: 160, Direction: flowmeter.Forward, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 12345, DstPort: 80, Protocol: 6, PSH: true, ACK: true},
+		{Timestamp: base.Add(2 * time.Second), HeaderLen: 40, PayloadSize: 110, Direction: flowmeter.Backward, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 12345, DstPort: 80, Protocol: 6, FIN: true, ACK: true},
+	}
+	want := flowmeter.ProcessPacketsWithKeys(packets)
+	e, err := ipfix.Dial("udp", c.Addr().String(), ipfix.Options{ObservationDomain: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fl := range want {
+		e.Write(fl)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var records []Record
+	for i := 0; i < 2; i++ { // templates, then data
+		recs, err := c.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, recs...)
+	}
+	if len(records) != 1 || records[0].Domain != 3 || records[0].Fields&FieldPayloadBytes == 0 || records[0].Fields&FieldReverse == 0 {
+		t.Fatalf("expected one biflow record with payload bytes, got %+v", records)
+	}
+	if s := c.Stats(); s.Messages != 2 || s.Records != 1 || s.MissingTemplate != 0 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+	got := ProcessRecords(records, flowmeter.Config{})[0]
+	if got.Key != want[0].Key {
+		t.Errorf("expected key %v, got %v", want[0].Key, got.Key)
+	}
+	if !got.Start.Equal(want[0].Start) || !got.End.Equal(want[0].End) || got.Initiator != want[0].Initiator || got.Reason != flowmeter.EndFIN {
+		t.Errorf("expected %v-%v from %v ending with fin, got %v-%v from %v ending with %v", want[0].Start, want[0].End, want[0].Initiator, got.Start, got.End, got.Initiator, got.Reason)
+	}
+	// Every feature of the schema is available and matches the packet path.
+	for _, d := range Schema() {
+		if !got.Available(d.Name) {
+			t.Errorf("%s: expected it to be available", d.Name)
+			continue
+		}
+		if g, w := d.Value(&got.Features), d.Value(&want[0].Features); g != w {
+			t.Errorf("%s: expected %v, got %v", d.Name, w, g)
+		}
+	}
+	// Records only carry the union of the flags, not per-packet counts.
+	if got.Available("SYN Flag Count") || got.Features.SYN != 0 {
+		t.Errorf("expected SYN Flag Count unavailable, got %d", got.Features.SYN)
+	}
+}
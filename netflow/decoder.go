@@ -0,0 +1,182 @@
+// Package netflow collects NetFlow v5, NetFlow v9 and IPFIX records from routers and
+// turns them into flows with the subset of the flowmeter features that can be derived
+// without per-packet data.
+//
+// A Decoder (or a Collector, which reads datagrams from a UDP socket) decodes export
+// messages into Records, caching the v9 and IPFIX templates of each exporter.
+// ProcessRecords then joins the records of each connection, in both directions, into one
+// flow per canonical flow key, as flowmeter.ProcessPacketsWithKeys does for packets, and
+// computes duration, packet and byte totals, rates, mean packet lengths and the down/up
+// ratio. Flow.Available tells which features a flow has; Schema lists the features
+// records can provide.
+//
+// The derived features differ from packet-derived ones. Byte features need payload byte
+// counts (FieldPayloadBytes, from transportOctetDeltaCount as the ipfix package sends
+// it): NetFlow dOctets and IPFIX octetDeltaCount include the headers CICFlowMeter leaves
+// out, so flows with such records have no byte features. TCP flag features are never
+// derived, as records only carry the union of the flags of their packets. ICMP records
+// have no identifier, so all echo sessions between two hosts form one flow. Sampled
+// exports are not scaled up.
+package netflow
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/Bi9River/goflowmeter"
+)
+
+// Fields tells which optional values a Record carries.
+type Fields uint8
+
+const (
+	FieldTimes        Fields = 1 << iota // Start and End
+	FieldDuration                        // Duration (also set with FieldTimes)
+	FieldBytes                           // Bytes (and ReverseBytes with FieldReverse)
+	FieldTCPFlags                        // TCPFlags (and ReverseTCPFlags with FieldReverse)
+	FieldReverse                         // RFC 5103 reverse counters of an IPFIX biflow
+	FieldPayloadBytes                    // Bytes (and ReverseBytes) count transport payload, not IP packets
+)
+
+// Record is one flow record as exported: the packets of one direction of a connection,
+// or both for an IPFIX biflow. Key is oriented as the exporter sent it, Src being the
+// sender of the counted packets (the forward side of a biflow).
+type Record struct {
+	Key        flowmeter.FlowKey
+	Start, End time.Time
+	Duration   time.Duration
+	// Packets and Bytes count the packets from Key's source. Bytes are IP bytes
+	// (NetFlow dOctets, IPFIX octetDeltaCount) unless FieldPayloadBytes is set.
+	Packets, Bytes               uint64
+	ReversePackets, ReverseBytes uint64
+	TCPFlags, ReverseTCPFlags    uint8               // union of the flags of the counted packets
+	EndReason                    flowmeter.EndReason // from flowEndReason; EndUnknown if absent
+	Fields                       Fields
+	Version                      uint16     // 5, 9 or 10 (IPFIX)
+	Exporter                     netip.Addr // sender of the message, as passed to Decode
+	Domain                       uint32     // v9 source ID, IPFIX observation domain, v5 engine type<<8 | id
+}
+
+// ErrTruncated is returned for messages shorter than their headers say.
+var ErrTruncated = errors.New("netflow: truncated message")
+
+// ErrVersion is returned for messages that are not NetFlow v5, v9 or IPFIX.
+var ErrVersion = errors.New("netflow: unsupported version")
+
+// ErrTemplate is returned for templates whose records would be empty: every field is
+// fixed-length with length 0.
+var ErrTemplate = errors.New("netflow: template with empty records")
+
+// Stats counts what a Decoder dropped.
+type Stats struct {
+	Messages        uint64 // messages decoded
+	Records         uint64 // flow records returned
+	MissingTemplate uint64 // data sets skipped because their template was not seen yet
+	Malformed       uint64 // messages rejected with an error
+}
+
+// templateKey identifies a template: templates are scoped to an exporter and its
+// observation domain (v9 source ID).
+type templateKey struct {
+	exporter netip.Addr
+	version  uint16
+	domain   uint32
+	id       uint16
+}
+
+// Decoder decodes export messages. It keeps the templates of every exporter, so one
+// Decoder must see all the messages of an exporter; it is not safe for concurrent use.
+type Decoder struct {
+	templates map[templateKey][]templateField
+	Stats     Stats
+}
+
+// NewDecoder returns a Decoder with an empty template cache.
+func NewDecoder() *Decoder {
+	return &Decoder{templates: map[templateKey][]templateField{}}
+}
+
+// Decode decodes one message from exporter, appending its flow records to dst. Template
+// records update the cache; data sets whose template is unknown are skipped and counted
+// in Stats.MissingTemplate. If the message is malformed, none of its records are appended
+// and the error is returned with dst unchanged.
+func (d *Decoder) Decode(dst []Record, msg []byte, exporter netip.Addr) ([]Record, error) {
+	if len(msg) < 2 {
+		d.Stats.Malformed++
+		return dst, ErrTruncated
+	}
+	n := len(dst)
+	var err error
+	switch v := binary.BigEndian.Uint16(msg); v {
+	case 5:
+		dst, err = decodeV5(dst, msg, exporter)
+	case 9:
+		dst, err = d.decodeV9(dst, msg, exporter)
+	case 10:
+		dst, err = d.decodeIPFIX(dst, msg, exporter)
+	default:
+		err = fmt.Errorf("%w %d", ErrVersion, v)
+	}
+	if err != nil {
+		d.Stats.Malformed++
+		return dst[:n], err
+	}
+	d.Stats.Messages++
+	d.Stats.Records += uint64(len(dst) - n)
+	return dst, nil
+}
+
+// NetFlow v5 layout.
+const (
+	v5HeaderLen = 24
+	v5RecordLen = 48
+)
+
+func decodeV5(dst []Record, msg []byte, exporter netip.Addr) ([]Record, error) {
+	if len(msg) < v5HeaderLen {
+		return dst, ErrTruncated
+	}
+	count := int(binary.BigEndian.Uint16(msg[2:]))
+	if len(msg) < v5HeaderLen+count*v5RecordLen {
+		return dst, ErrTruncated
+	}
+	uptime := binary.BigEndian.Uint32(msg[4:])
+	now := time.Unix(int64(binary.BigEndian.Uint32(msg[8:])), int64(binary.BigEndian.Uint32(msg[12:])))
+	domain := uint32(msg[20])<<8 | uint32(msg[21])
+	for i := 0; i < count; i++ {
+		b := msg[v5HeaderLen+i*v5RecordLen:]
+		r := Record{
+			Key: flowmeter.FlowKey{
+				SrcAddr:  netip.AddrFrom4([4]byte(b[0:4])),
+				DstAddr:  netip.AddrFrom4([4]byte(b[4:8])),
+				SrcPort:  binary.BigEndian.Uint16(b[32:]),
+				DstPort:  binary.BigEndian.Uint16(b[34:]),
+				Protocol: b[38],
+			},
+			Packets:  uint64(binary.BigEndian.Uint32(b[16:])),
+			Bytes:    uint64(binary.BigEndian.Uint32(b[20:])),
+			Start:    uptimeTime(now, uptime, binary.BigEndian.Uint32(b[24:])),
+			End:      uptimeTime(now, uptime, binary.BigEndian.Uint32(b[28:])),
+			TCPFlags: b[37],
+			Fields:   FieldTimes | FieldDuration | FieldBytes | FieldTCPFlags,
+			Version:  5,
+			Exporter: exporter,
+			Domain:   domain,
+		}
+		r.Duration = r.End.Sub(r.Start)
+		if r.Key.Protocol == 1 {
+			icmpKey(&r.Key, r.Key.DstPort) // v5 carries the type and code in the destination port
+		}
+		dst = append(dst, r)
+	}
+	return dst, nil
+}
+
+// uptimeTime converts a router uptime in milliseconds to wall-clock time, given the
+// uptime at export time now. The subtraction wraps like the 32-bit uptime counter.
+func uptimeTime(now time.Time, uptime, t uint32) time.Time {
+	return now.Add(-time.Duration(int32(uptime-t)) * time.Millisecond)
+}
@@ -0,0 +1,286 @@
+package netflow
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/Bi9River/goflowmeter"
+)
+
+var exporter = netip.MustParseAddr("192.0.2.10")
+
+// v5Message builds a NetFlow v5 message exported at unix second 1_735_689_600 with
+// uptime 100s and one record 10.0.0.1:1234 -> 10.0.0.2:80 (TCP, SYN|ACK) of 5 packets
+// and 500 bytes, seen from uptime 90s to 95.5s.
+func v5Message() []byte {
+	b := make([]byte, v5HeaderLen+v5RecordLen)
+	binary.BigEndian.PutUint16(b[0:], 5)
+	binary.BigEndian.PutUint16(b[2:], 1)
+	binary.BigEndian.PutUint32(b[4:], 100_000)
+	binary.BigEndian.PutUint32(b[8:], 1_735_689_600)
+	b[20], b[21] = 1, 2 // engine type, id
+	r := b[v5HeaderLen:]
+	copy(r[0:4], []byte{10, 0, 0, 1})
+	copy(r[4:8], []byte{10, 0, 0, 2})
+	binary.BigEndian.PutUint32(r[16:], 5)
+	binary.BigEndian.PutUint32(r[20:], 500)
+	binary.BigEndian.PutUint32(r[24:], 90_000)
+	binary.BigEndian.PutUint32(r[28:], 95_500)
+	binary.BigEndian.PutUint16(r[32:], 1234)
+	binary.BigEndian.PutUint16(r[34:], 80)
+	r[37] = 0x12
+	r[38] = 6
+	return b
+}
+
+func TestDecode_V5(t *testing.T) {
+	d := NewDecoder()
+	recs, err := d.Decode(nil, v5Message(), exporter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(recs))
+	}
+	r := recs[0]
+	if r.Key.SrcIP() != "10.0.0.1" || r.Key.DstPort != 80 || r.Key.Protocol != 6 || r.Packets != 5 || r.Bytes != 500 || r.TCPFlags != 0x12 {
+		t.Errorf("unexpected record %+v", r)
+	}
+	export := time.Unix(1_735_689_600, 0)
+	if !r.Start.Equal(export.Add(-10*time.Second)) || r.Duration != 5500*time.Millisecond {
+		t.Errorf("expected start 10s before export and 5.5s duration, got %v %v", r.Start, r.Duration)
+	}
+	if r.Version != 5 || r.Exporter != exporter || r.Domain != 0x0102 || r.Fields&FieldPayloadBytes != 0 {
+		t.Errorf("unexpected version %d, exporter %v, domain %#x, fields %b", r.Version, r.Exporter, r.Domain, r.Fields)
+	}
+	// v5 carries the ICMP type and code in the destination port: an echo reply (0, 0)
+	// is keyed on the request type like its request.
+	icmp := v5Message()
+	icmp[v5HeaderLen+38] = 1
+	binary.BigEndian.PutUint16(icmp[v5HeaderLen+32:], 0)
+	binary.BigEndian.PutUint16(icmp[v5HeaderLen+34:], 0)
+	if recs, err := d.Decode(nil, icmp, exporter); err != nil || recs[0].Key.SrcPort != 8 || recs[0].Key.DstPort != 0 {
+		t.Errorf("expected the echo reply keyed (8, 0), got %v %v", recs, err)
+	}
+	if _, err := d.Decode(nil, v5Message()[:60], exporter); !errors.Is(err, ErrTruncated) {
+		t.Errorf("expected ErrTruncated, got %v", err)
+	}
+	if _, err := d.Decode(nil, []byte{0, 7, 0, 0}, exporter); !errors.Is(err, ErrVersion) {
+		t.Errorf("expected ErrVersion, got %v", err)
+	}
+	if d.Stats.Messages != 2 || d.Stats.Records != 2 || d.Stats.Malformed != 2 {
+		t.Errorf("unexpected stats %+v", d.Stats)
+	}
+}
+
+// set appends a set (v9 flowset) with the given ID and body to b.
+func set(b []byte, id uint16, body []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, id)
+	b = binary.BigEndian.AppendUint16(b, uint16(4+len(body)))
+	return append(b, body...)
+}
+
+// spec appends field specifiers (id, length pairs) to a template record body.
+func spec(b []byte, tid uint16, fields ...uint16) []byte {
+	b = binary.BigEndian.AppendUint16(b, tid)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(fields)/2))
+	for _, f := range fields {
+		b = binary.BigEndian.AppendUint16(b, f)
+	}
+	return b
+}
+
+func v9Header(sourceID uint32) []byte {
+	b := make([]byte, v9HeaderLen)
+	binary.BigEndian.PutUint16(b[0:], 9)
+	binary.BigEndian.PutUint32(b[4:], 100_000)
+	binary.BigEndian.PutUint32(b[8:], 1_735_689_600)
+	binary.BigEndian.PutUint32(b[16:], sourceID)
+	return b
+}
+
+func TestDecode_V9(t *testing.T) {
+	d := NewDecoder()
+	tmpl := spec(nil, 300,
+		ieSourceIPv4Address, 4, ieDestinationIPv4Address, 4, ieProtocolIdentifier, 1,
+		ieICMPTypeCodeIPv4, 2, iePacketDeltaCount, 4, ieOctetDeltaCount, 8,
+		ieFlowStartSysUpTime, 4, ieFlowEndSysUpTime, 4)
+	data := []byte{10, 0, 0, 1, 10, 0, 0, 2, 1, 3, 1}
+	data = binary.BigEndian.AppendUint32(data, 2)
+	data = binary.BigEndian.AppendUint64(data, 168)
+	data = binary.BigEndian.AppendUint32(data, 99_000)
+	data = binary.BigEndian.AppendUint32(data, 99_250)
+	data = append(data, 0, 0) // padding
+
+	// Data before its template is skipped.
+	if recs, err := d.Decode(nil, set(v9Header(7), 300, data), exporter); err != nil || len(recs) != 0 {
+		t.Fatalf("expected no records, got %d %v", len(recs), err)
+	}
+	if d.Stats.MissingTemplate != 1 {
+		t.Errorf("expected 1 missing template, got %d", d.Stats.MissingTemplate)
+	}
+	msg := set(set(v9Header(7), v9TemplateSet, tmpl), 300, data)
+	recs, err := d.Decode(nil, msg, exporter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(recs))
+	}
+	r := recs[0]
+	// ICMP port unreachable (3, 1) keys like the converter: (0, type<<8 | code).
+	if r.Key.Protocol != 1 || r.Key.SrcPort != 0 || r.Key.DstPort != 0x0301 || r.Packets != 2 || r.Bytes != 168 {
+		t.Errorf("unexpected record %+v", r)
+	}
+	if !r.Start.Equal(time.Unix(1_735_689_599, 0)) || r.Duration != 250*time.Millisecond || r.Fields&FieldTCPFlags != 0 {
+		t.Errorf("expected start 1s before export, 250ms and no flags, got %v %v %b", r.Start, r.Duration, r.Fields)
+	}
+	if r.Domain != 7 || r.Key.ObservationDomain != 7 {
+		t.Errorf("expected source ID 7, got %d %d", r.Domain, r.Key.ObservationDomain)
+	}
+	// A malformed message appends none of its records, even those before the error.
+	bad := append(set(v9Header(7), 300, data), 0x01, 0x2c, 0x00, 0xff)
+	binary.BigEndian.PutUint16(bad[2:], 2)
+	prev := []Record{{Version: 5}}
+	if recs, err := d.Decode(prev, bad, exporter); !errors.Is(err, ErrTruncated) || len(recs) != 1 {
+		t.Errorf("expected ErrTruncated and dst unchanged, got %d records, %v", len(recs), err)
+	}
+	// Templates are scoped to the source ID.
+	if recs, _ := d.Decode(nil, set(v9Header(8), 300, data), exporter); len(recs) != 0 {
+		t.Errorf("expected the template of source 7 not to apply to source 8")
+	}
+}
+
+func ipfixMessage(domain uint32, sets ...[]byte) []byte {
+	b := make([]byte, ipfixHeaderLen)
+	binary.BigEndian.PutUint16(b[0:], 10)
+	binary.BigEndian.PutUint32(b[4:], 1_735_689_600)
+	binary.BigEndian.PutUint32(b[12:], domain)
+	for _, s := range sets {
+		b = append(b, s...)
+	}
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)))
+	return b
+}
+
+func TestDecode_IPFIXBiflow(t *testing.T) {
+	d := NewDecoder()
+	var tmpl []byte
+	tmpl = binary.BigEndian.AppendUint16(tmpl, 400)
+	tmpl = binary.BigEndian.AppendUint16(tmpl, 9)
+	for _, f := range [][3]uint32{
+		{ieSourceIPv6Address, 16, 0},
+		{ieDestinationIPv6Address, 16, 0},
+		{ieProtocolIdentifier, 1, 0},
+		{ieFlowStartMilliseconds, 8, 0},
+		{ieFlowEndMilliseconds, 8, 0},
+		{iePacketDeltaCount, 8, 0},
+		{iePacketDeltaCount, 8, reverseEnterprise},
+		{42, varLength, 9999}, // an unknown variable-length enterprise element
+		{ieTCPControlBits, 2, reverseEnterprise},
+	} {
+		id := uint16(f[0])
+		if f[2] != 0 {
+			id |= enterpriseBit
+		}
+		tmpl = binary.BigEndian.AppendUint16(tmpl, id)
+		tmpl = binary.BigEndian.AppendUint16(tmpl, uint16(f[1]))
+		if f[2] != 0 {
+			tmpl = binary.BigEndian.AppendUint32(tmpl, f[2])
+		}
+	}
+	src, dst := netip.MustParseAddr("2001:db8::1"), netip.MustParseAddr("2001:db8::2")
+	data := append(src.AsSlice(), dst.AsSlice()...)
+	data = append(data, 17)
+	data = binary.BigEndian.AppendUint64(data, 1_735_689_600_000)
+	data = binary.BigEndian.AppendUint64(data, 1_735_689_600_040)
+	data = binary.BigEndian.AppendUint64(data, 3)
+	data = binary.BigEndian.AppendUint64(data, 4)
+	data = append(data, 3, 'a', 'b', 'c')
+	data = binary.BigEndian.AppendUint16(data, 0x11)
+	recs, err := d.Decode(nil, ipfixMessage(1, set(nil, ipfixTemplateSet, tmpl), set(nil, 400, data)), exporter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(recs))
+	}
+	r := recs[0]
+	if r.Key.SrcAddr != src || r.Key.DstAddr != dst || r.Packets != 3 || r.ReversePackets != 4 || r.ReverseTCPFlags != 0x11 {
+		t.Errorf("unexpected record %+v", r)
+	}
+	if r.Fields&FieldReverse == 0 || r.Fields&FieldBytes != 0 || r.Duration != 40*time.Millisecond {
+		t.Errorf("expected reverse counters, no bytes and 40ms, got %b %v", r.Fields, r.Duration)
+	}
+	// A template with no fields withdraws it.
+	withdraw := binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(nil, 400), 0)
+	if recs, _ := d.Decode(nil, ipfixMessage(1, set(nil, ipfixTemplateSet, withdraw), set(nil, 400, data)), exporter); len(recs) != 0 {
+		t.Errorf("expected no records after the template was withdrawn, got %d", len(recs))
+	}
+}
+
+func TestDecode_EmptyRecordTemplate(t *testing.T) {
+	d := NewDecoder()
+	// Template 256 has one field (sourceIPv4Address) of length 0.
+	msg := ipfixMessage(1, set(nil, ipfixTemplateSet, spec(nil, 256, ieSourceIPv4Address, 0)), set(nil, 256, make([]byte, 4)))
+	done := make(chan error, 1)
+	go func() {
+		_, err := d.Decode(nil, msg, exporter)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrTemplate) {
+			t.Errorf("expected ErrTemplate, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Decode did not return")
+	}
+	if d.Stats.Malformed != 1 || d.Stats.Records != 0 {
+		t.Errorf("expected 1 malformed message and no records, got %+v", d.Stats)
+	}
+}
+
+func TestDecode_IPFIXBiflowPayloadBytes(t *testing.T) {
+	base := []uint16{ieSourceIPv4Address, 4, ieDestinationIPv4Address, 4, ieProtocolIdentifier, 1, iePacketDeltaCount, 4, ieTransportOctetDeltaCount, 4}
+	for _, tc := range []struct {
+		name    string
+		reverse []uint16 // reverse byte elements, 4 bytes each
+		payload bool
+		bwd     uint64
+	}{
+		{"reverse IP octets", []uint16{ieOctetDeltaCount}, false, 700},
+		{"reverse transport octets", []uint16{ieTransportOctetDeltaCount}, true, 700},
+		{"both, transport first", []uint16{ieTransportOctetDeltaCount, ieOctetDeltaCount}, true, 700},
+	} {
+		d := NewDecoder()
+		tmpl := binary.BigEndian.AppendUint16(nil, 400)
+		tmpl = binary.BigEndian.AppendUint16(tmpl, uint16(len(base)/2+len(tc.reverse)))
+		for _, f := range base {
+			tmpl = binary.BigEndian.AppendUint16(tmpl, f)
+		}
+		data := []byte{10, 0, 0, 1, 10, 0, 0, 2, 6}
+		data = binary.BigEndian.AppendUint32(data, 3)
+		data = binary.BigEndian.AppendUint32(data, 300)
+		for i, id := range tc.reverse {
+			tmpl = binary.BigEndian.AppendUint16(tmpl, id|enterpriseBit)
+			tmpl = binary.BigEndian.AppendUint16(tmpl, 4)
+			tmpl = binary.BigEndian.AppendUint32(tmpl, reverseEnterprise)
+			data = binary.BigEndian.AppendUint32(data, 700+uint32(i)*100)
+		}
+		recs, err := d.Decode(nil, ipfixMessage(1, set(nil, ipfixTemplateSet, tmpl), set(nil, 400, data)), exporter)
+		if err != nil || len(recs) != 1 {
+			t.Fatalf("%s: expected 1 record, got %d %v", tc.name, len(recs), err)
+		}
+		r := recs[0]
+		if got := r.Fields&FieldPayloadBytes != 0; got != tc.payload || r.ReverseBytes != tc.bwd {
+			t.Errorf("%s: expected payload bytes %v and %d reverse bytes, got %v and %d", tc.name, tc.payload, tc.bwd, got, r.ReverseBytes)
+		}
+		if got := ProcessRecords(recs, flowmeter.Config{})[0].Available("Total Length of Bwd Packet"); got != tc.payload {
+			t.Errorf("%s: expected Total Length of Bwd Packet available %v, got %v", tc.name, tc.payload, got)
+		}
+	}
+}
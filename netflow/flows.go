@@ -0,0 +1,195 @@
+package netflow
+
+import (
+	"time"
+
+	"github.com/Bi9River/goflowmeter"
+)
+
+// availability is a set of record values a flow was built with.
+type availability uint8
+
+const (
+	availPackets availability = 1 << iota
+	availBytes                // payload bytes (FieldPayloadBytes)
+	availDuration
+)
+
+// derived maps the features ProcessRecords computes to the record values they need.
+// Packet Length Mean and Average Packet Size are missing: CICFlowMeter counts the first
+// packet's payload twice in them, which records cannot reproduce. So are the TCP flag
+// features: CICFlowMeter counts the packets carrying each flag, while a record only has
+// the union of the flags of its packets.
+var derived = map[string]availability{
+	"Flow Duration":              availDuration,
+	"Total Fwd Packet":           availPackets,
+	"Total Bwd packets":          availPackets,
+	"Total Length of Fwd Packet": availBytes,
+	"Total Length of Bwd Packet": availBytes,
+	"Fwd Packet Length Mean":     availBytes,
+	"Bwd Packet Length Mean":     availBytes,
+	"Flow Bytes/s":               availBytes | availDuration,
+	"Flow Packets/s":             availDuration,
+	"Fwd Packets/s":              availDuration,
+	"Bwd Packets/s":              availDuration,
+	"Down/Up Ratio":              availPackets,
+	"Fwd Segment Size Avg":       availBytes,
+	"Bwd Segment Size Avg":       availBytes,
+}
+
+// Schema returns the features that flows built from records can have, in CICFlowMeter
+// column order. Pass it as writer.Options.Schema to write only those columns.
+func Schema() []flowmeter.FeatureDescriptor {
+	var out []flowmeter.FeatureDescriptor
+	for _, d := range flowmeter.FeatureSchema() {
+		if derived[d.Name] != 0 {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// Flow is a flow built from records. Features not in Schema, and those whose record
+// values were missing (Available reports false), are zero.
+type Flow struct {
+	flowmeter.FlowWithKey
+	Records   int // records joined into the flow
+	available availability
+}
+
+// Available reports whether the feature called name was derived for this flow. Packet
+// counts and the down/up ratio always are; duration only if every record of the flow
+// carried it, and byte features only if every record counted payload bytes
+// (FieldPayloadBytes), since CICFlowMeter's exclude the IP and transport headers.
+func (f *Flow) Available(name string) bool {
+	need := derived[name]
+	return need != 0 && f.available&need == need
+}
+
+// ProcessRecords joins records into flows, one per flow key as cfg.KeyOrder and
+// cfg.KeyFields make it, and computes the derivable features. The record with the
+// earliest start (or, without times, the first one) gives the forward direction; the
+// records of the other direction count as backward, and biflow records contribute to
+// both. Flows are returned in the order of their first record, with canonical keys like
+// the ones of flowmeter.ProcessPacketsWithConfig. Start and End span the records'
+// times (zero without them), Initiator is the source of the forward record and Reason
+// is the end reason of the record that ended last. With flowmeter.KeyOrderInitiator the
+// keys list the initiator first.
+func ProcessRecords(records []Record, cfg flowmeter.Config) []Flow {
+	index := map[flowmeter.FlowKey]int{}
+	var groups [][]int
+	for i := range records {
+		k := cfg.KeyOrder.Canonical(cfg.KeyFields.Select(records[i].Key))
+		g, ok := index[k]
+		if !ok {
+			g = len(groups)
+			index[k] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+	}
+	out := make([]Flow, len(groups))
+	for k, g := range index {
+		out[g] = buildFlow(k, records, groups[g])
+		if cfg.KeyOrder == flowmeter.KeyOrderInitiator {
+			out[g].Key = out[g].InitiatorKey()
+		}
+	}
+	return out
+}
+
+// direction holds the totals of one direction of a flow.
+type direction struct {
+	packets, bytes uint64
+}
+
+func buildFlow(key flowmeter.FlowKey, records []Record, idx []int) Flow {
+	first := idx[0]
+	for _, i := range idx[1:] {
+		r := &records[i]
+		if r.Fields&FieldTimes != 0 && (records[first].Fields&FieldTimes == 0 || r.Start.Before(records[first].Start)) {
+			first = i
+		}
+	}
+	init := records[first].Key
+	fl := Flow{
+		FlowWithKey: flowmeter.FlowWithKey{Key: key, Initiator: flowmeter.Endpoint{Addr: init.SrcAddr, Port: init.SrcPort}},
+		Records:     len(idx),
+		available:   availPackets | availBytes | availDuration,
+	}
+	var fwd, bwd direction
+	var start, end time.Time
+	var longest time.Duration
+	for _, i := range idx {
+		r := &records[i]
+		if r.Fields&(FieldBytes|FieldPayloadBytes) != FieldBytes|FieldPayloadBytes {
+			fl.available &^= availBytes
+		}
+		if r.Fields&FieldDuration == 0 {
+			fl.available &^= availDuration
+		}
+		a, b := &fwd, &bwd
+		if r.Key.SrcAddr != init.SrcAddr || r.Key.SrcPort != init.SrcPort {
+			a, b = b, a
+		}
+		a.packets += r.Packets
+		a.bytes += r.Bytes
+		b.packets += r.ReversePackets
+		b.bytes += r.ReverseBytes
+		if r.Fields&FieldTimes != 0 {
+			if start.IsZero() || r.Start.Before(start) {
+				start = r.Start
+			}
+			if !r.End.Before(end) {
+				end = r.End
+				if r.EndReason != flowmeter.EndUnknown {
+					fl.Reason = r.EndReason
+				}
+			}
+		} else if fl.Reason == flowmeter.EndUnknown {
+			fl.Reason = r.EndReason
+		}
+		longest = max(longest, r.Duration)
+	}
+	dur := longest
+	if !start.IsZero() {
+		dur = max(dur, end.Sub(start))
+		fl.Start, fl.End = start, end
+	}
+	computeFeatures(&fl.Features, fwd, bwd, dur, fl.available)
+	return fl
+}
+
+func computeFeatures(f *flowmeter.FlowFeatures, fwd, bwd direction, dur time.Duration, avail availability) {
+	f.TotalFwdPackets = int(fwd.packets)
+	f.TotalBwdPackets = int(bwd.packets)
+	if f.TotalFwdPackets > 0 {
+		// Integer division, as in the ratio module.
+		f.DownUpRatio = float64(f.TotalBwdPackets / f.TotalFwdPackets)
+	}
+	n := float64(fwd.packets + bwd.packets)
+	if avail&availBytes != 0 {
+		f.TotalFwdBytes = int64(fwd.bytes)
+		f.TotalBwdBytes = int64(bwd.bytes)
+		if fwd.packets > 0 {
+			f.FwdPacketLen.Mean = float64(fwd.bytes) / float64(fwd.packets)
+		}
+		if bwd.packets > 0 {
+			f.BwdPacketLen.Mean = float64(bwd.bytes) / float64(bwd.packets)
+		}
+		f.AvgFwdSegmentSize = f.FwdPacketLen.Mean
+		f.AvgBwdSegmentSize = f.BwdPacketLen.Mean
+	}
+	if avail&availDuration != 0 {
+		f.FlowDurationUs = dur.Microseconds()
+		// Rates stay 0 for zero-length flows, as in the basic and rates modules.
+		if durSec := float64(f.FlowDurationUs) / 1e6; durSec > 0 {
+			if avail&availBytes != 0 {
+				f.FlowBytesPerSec = float64(fwd.bytes+bwd.bytes) / durSec
+			}
+			f.FlowPacketsPerSec = n / durSec
+			f.FwdPacketsPerSec = float64(fwd.packets) / durSec
+			f.BwdPacketsPerSec = float64(bwd.packets) / durSec
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package netflow
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/Bi9River/goflowmeter"
+)
+
+// record returns a unidirectional record src:sport -> dst:dport with all fields, counting
+// payload bytes.
+func record(src, dst string, sport, dport uint16, start time.Time, dur time.Duration, packets, bytes uint64, flags uint8) Record {
+	return Record{
+		Key:      flowmeter.FlowKey{SrcAddr: flowmeter.ParseAddr(src), DstAddr: flowmeter.ParseAddr(dst), SrcPort: sport, DstPort: dport, Protocol: 6, VLANID: 12},
+		Start:    start,
+		End:      start.Add(dur),
+		Duration: dur,
+		Packets:  packets,
+		Bytes:    bytes,
+		TCPFlags: flags,
+		Fields:   FieldTimes | FieldDuration | FieldBytes | FieldPayloadBytes | FieldTCPFlags,
+		Version:  5,
+	}
+}
+
+func TestProcessRecords_JoinsDirections(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	records := []Record{
+		// The server's record arrives first but starts later: the client is forward.
+		record("10.0.0.2", "10.0.0.1", 80, 40000, base.Add(100*time.Millisecond), 1900*time.Millisecond, 6, 6000, flagSYN|flagACK|flagPSH|flagFIN),
+		record("10.0.0.1", "10.0.0.2", 40000, 80, base, 2*time.Second, 2, 120, flagSYN|flagACK|flagFIN),
+		record("10.0.0.1", "10.0.0.3", 40001, 53, base, 0, 1, 60, 0),
+	}
+	flows := ProcessRecords(records, flowmeter.Config{})
+	if len(flows) != 2 {
+		t.Fatalf("expected 2 flows, got %d", len(flows))
+	}
+	fl := flows[0]
+	f := fl.Features
+	if fl.Key.SrcIP() != "10.0.0.1" || fl.Key.SrcPort != 40000 || fl.Records != 2 || fl.Key.VLANID != 0 {
+		t.Errorf("unexpected key %+v (records %d)", fl.Key, fl.Records)
+	}
+	if fl.Initiator.IP() != "10.0.0.1" || fl.Initiator.Port != 40000 || !fl.Start.Equal(base) || !fl.End.Equal(base.Add(2*time.Second)) {
+		t.Errorf("expected 10.0.0.1:40000 from %v to %v, got %v:%d from %v to %v", base, base.Add(2*time.Second), fl.Initiator.IP(), fl.Initiator.Port, fl.Start, fl.End)
+	}
+	if f.FlowDurationUs != 2_000_000 || f.TotalFwdPackets != 2 || f.TotalBwdPackets != 6 || f.TotalFwdBytes != 120 || f.TotalBwdBytes != 6000 {
+		t.Errorf("expected 2s, 2/6 packets and 120/6000 bytes, got %d %d %d %d %d", f.FlowDurationUs, f.TotalFwdPackets, f.TotalBwdPackets, f.TotalFwdBytes, f.TotalBwdBytes)
+	}
+	if f.FwdPacketLen.Mean != 60 || f.BwdPacketLen.Mean != 1000 || f.AvgFwdSegmentSize != 60 || f.AvgBwdSegmentSize != 1000 {
+		t.Errorf("expected means 60 and 1000, got %v %v %v %v", f.FwdPacketLen.Mean, f.BwdPacketLen.Mean, f.AvgFwdSegmentSize, f.AvgBwdSegmentSize)
+	}
+	if f.FlowBytesPerSec != 3060 || f.FlowPacketsPerSec != 4 || f.FwdPacketsPerSec != 1 || f.BwdPacketsPerSec != 3 || f.DownUpRatio != 3 {
+		t.Errorf("expected rates 3060, 4, 1, 3 and ratio 3, got %v %v %v %v %v", f.FlowBytesPerSec, f.FlowPacketsPerSec, f.FwdPacketsPerSec, f.BwdPacketsPerSec, f.DownUpRatio)
+	}
+	// Records carry the union of the flags, not the per-packet counts of CICFlowMeter.
+	if fl.Available("SYN Flag Count") || fl.Available("Bwd PSH Flags") || f.SYN != 0 || f.BwdPSHFlag != 0 {
+		t.Errorf("expected no flag features, got SYN %d and Bwd PSH %d", f.SYN, f.BwdPSHFlag)
+	}
+	// IP bytes include the headers CICFlowMeter leaves out.
+	ip := append([]Record(nil), records...)
+	ip[0].Fields &^= FieldPayloadBytes
+	g := ProcessRecords(ip, flowmeter.Config{})[0]
+	if g.Available("Total Length of Fwd Packet") || g.Available("Flow Bytes/s") || g.Features.TotalFwdBytes != 0 || !g.Available("Flow Packets/s") {
+		t.Errorf("expected byte features unavailable with IP bytes, got %d bytes", g.Features.TotalFwdBytes)
+	}
+	// A zero-length flow has no rates.
+	if g := flows[1].Features; g.FlowDurationUs != 0 || g.FlowBytesPerSec != 0 || g.TotalFwdPackets != 1 {
+		t.Errorf("expected a 1-packet flow without rates, got %+v", g)
+	}
+	// With KeyVLAN the VLAN stays in the key.
+	if k := ProcessRecords(records, flowmeter.Config{KeyFields: flowmeter.KeyVLAN})[0].Key; k.VLANID != 12 {
+		t.Errorf("expected VLAN 12 in the key, got %d", k.VLANID)
+	}
+}
+
+func TestProcessRecords_Biflow(t *testing.T) {
+	r := Record{
+		Key:            flowmeter.FlowKey{SrcAddr: flowmeter.ParseAddr("10.0.0.9"), DstAddr: flowmeter.ParseAddr("10.0.0.1"), SrcPort: 5000, DstPort: 443, Protocol: 6},
+		Duration:       500 * time.Millisecond,
+		Packets:        4,
+		ReversePackets: 9,
+		Fields:         FieldDuration | FieldReverse,
+	}
+	fl := ProcessRecords([]Record{r}, flowmeter.Config{})[0]
+	f := fl.Features
+	// The canonical key puts 10.0.0.1 first, but the record's source stays forward.
+	if fl.Key.SrcIP() != "10.0.0.1" || f.TotalFwdPackets != 4 || f.TotalBwdPackets != 9 || f.FlowDurationUs != 500_000 || f.FlowPacketsPerSec != 26 {
+		t.Errorf("unexpected flow %v: %d %d %d %v", fl.Key, f.TotalFwdPackets, f.TotalBwdPackets, f.FlowDurationUs, f.FlowPacketsPerSec)
+	}
+	if k := ProcessRecords([]Record{r}, flowmeter.Config{KeyOrder: flowmeter.KeyOrderInitiator})[0].Key; k.SrcIP() != "10.0.0.9" || k.SrcPort != 5000 {
+		t.Errorf("expected the initiator 10.0.0.9:5000 first, got %v", k)
+	}
+	for name, want := range map[string]bool{
+		"Total Fwd Packet":           true,
+		"Down/Up Ratio":              true,
+		"Flow Duration":              true,
+		"Flow Packets/s":             true,
+		"Total Length of Fwd Packet": false, // no bytes
+		"Flow Bytes/s":               false,
+		"SYN Flag Count":             false, // records have no per-packet flag counts
+		"Flow IAT Mean":              false, // needs packets
+		"Average Packet Size":        false, // CIC counts the first packet twice
+	} {
+		if got := fl.Available(name); got != want {
+			t.Errorf("Available(%q): expected %v, got %v", name, want, got)
+		}
+	}
+}
+
+func TestProcessRecords_ICMPJoinsDirections(t *testing.T) {
+	d := NewDecoder()
+	tmpl := spec(nil, 300,
+		ieSourceIPv4Address, 4, ieDestinationIPv4Address, 4, ieProtocolIdentifier, 1,
+		ieICMPTypeCodeIPv4, 2, iePacketDeltaCount, 4, ieFlowStartSysUpTime, 4, ieFlowEndSysUpTime, 4)
+	var data []byte
+	for _, r := range []struct {
+		src, dst byte
+		typ      byte
+		first    uint32
+	}{
+		{1, 2, 8, 99_000}, // echo request 10.0.0.1 -> 10.0.0.2
+		{2, 1, 0, 99_001}, // echo reply
+		{1, 2, 3, 99_002}, // port unreachable, keyed apart
+	} {
+		data = append(data, 10, 0, 0, r.src, 10, 0, 0, r.dst, 1, r.typ, 0)
+		data = binary.BigEndian.AppendUint32(data, 3)
+		data = binary.BigEndian.AppendUint32(data, r.first)
+		data = binary.BigEndian.AppendUint32(data, r.first+2000)
+	}
+	recs, err := d.Decode(nil, set(set(v9Header(7), v9TemplateSet, tmpl), 300, data), exporter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 3 || recs[0].Key.SrcPort != 0 || recs[0].Key.DstPort != 8 || recs[1].Key.SrcPort != 8 || recs[1].Key.DstPort != 0 {
+		t.Fatalf("expected echo ports (0, 8) and (8, 0), got %v", recs)
+	}
+	flows := ProcessRecords(recs, flowmeter.Config{})
+	if len(flows) != 2 {
+		t.Fatalf("expected the echo records joined and the unreachable apart, got %d flows", len(flows))
+	}
+	fl := flows[0]
+	if fl.Records != 2 || fl.Initiator.IP() != "10.0.0.1" || fl.Features.TotalFwdPackets != 3 || fl.Features.TotalBwdPackets != 3 {
+		t.Errorf("expected 3 request packets from 10.0.0.1 and 3 replies, got %d records from %v: %d/%d", fl.Records, fl.Initiator.IP(), fl.Features.TotalFwdPackets, fl.Features.TotalBwdPackets)
+	}
+	if k := flows[1].Key; k.SrcPort != 0 || k.DstPort != 0x0300 {
+		t.Errorf("expected the unreachable keyed (0, 0x0300), got (%d, %#x)", k.SrcPort, k.DstPort)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	s := Schema()
+	if len(s) != len(derived) {
+		t.Fatalf("expected %d features, got %d", len(derived), len(s))
+	}
+	last := 0
+	for _, d := range s {
+		if d.CICColumn <= last {
+			t.Errorf("expected CIC order, got %s (%d) after column %d", d.Name, d.CICColumn, last)
+		}
+		last = d.CICColumn
+	}
+	// Every derived value is read back through the schema.
+	fl := ProcessRecords([]Record{record("10.0.0.1", "10.0.0.2", 1, 2, time.Unix(0, 0), time.Second, 3, 300, 0xff)}, flowmeter.Config{})[0]
+	for _, d := range s {
+		if !fl.Available(d.Name) {
+			t.Errorf("expected %s to be available", d.Name)
+		}
+		if v := d.Value(&fl.Features); math.IsNaN(v) {
+			t.Errorf("%s: expected a value, got NaN", d.Name)
+		}
+	}
+}
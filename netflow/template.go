@@ -0,0 +1,424 @@
+package netflow
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"time"
+
+	"github.com/Bi9River/goflowmeter"
+)
+
+// templateField is one field specifier of a v9 or IPFIX template.
+type templateField struct {
+	id         uint16
+	enterprise uint32
+	length     uint16 // varLength for IPFIX variable-length fields
+}
+
+const varLength = 65535
+
+// reverseEnterprise is the Private Enterprise Number of the RFC 5103 reverse elements.
+const reverseEnterprise = 29305
+
+// Information Element IDs read from records (NetFlow v9 field types share the numbers).
+const (
+	ieOctetDeltaCount          = 1
+	iePacketDeltaCount         = 2
+	ieProtocolIdentifier       = 4
+	ieTCPControlBits           = 6
+	ieSourceTransportPort      = 7
+	ieSourceIPv4Address        = 8
+	ieDestinationTransportPort = 11
+	ieDestinationIPv4Address   = 12
+	ieFlowEndSysUpTime         = 21
+	ieFlowStartSysUpTime       = 22
+	ieSourceIPv6Address        = 27
+	ieDestinationIPv6Address   = 28
+	ieICMPTypeCodeIPv4         = 32
+	ieSourceMacAddress         = 56
+	ieVlanID                   = 58
+	ieDestinationMacAddress    = 80
+	ieOctetTotalCount          = 85
+	iePacketTotalCount         = 86
+	ieFlowEndReason            = 136
+	ieICMPTypeCodeIPv6         = 139
+	ieObservationDomainID      = 149
+	ieFlowStartSeconds         = 150
+	ieFlowEndSeconds           = 151
+	ieFlowStartMilliseconds    = 152
+	ieFlowEndMilliseconds      = 153
+	ieFlowStartMicroseconds    = 154
+	ieFlowEndMicroseconds      = 155
+	ieFlowStartNanoseconds     = 156
+	ieFlowEndNanoseconds       = 157
+	ieSystemInitTimeMillis     = 160
+	ieFlowDurationMilliseconds = 161
+	ieFlowDurationMicroseconds = 162
+	ieLayer2SegmentID          = 351
+	ieTransportOctetDeltaCount = 401
+)
+
+// Set IDs of template sets; data sets use template IDs from 256.
+const (
+	v9TemplateSet          = 0
+	ipfixTemplateSet       = 2
+	firstDataSet           = 256
+	v9HeaderLen            = 20
+	ipfixHeaderLen         = 16
+	setHeaderLen           = 4
+	enterpriseBit          = 0x8000
+	ntpEpochOffset   int64 = 2208988800 // seconds from 1900 to 1970
+)
+
+// messageContext holds the header values records are decoded against.
+type messageContext struct {
+	version  uint16
+	exporter netip.Addr
+	domain   uint32
+	now      time.Time // export time
+	uptime   uint32    // v9 sysUptime at export, milliseconds
+}
+
+func (d *Decoder) decodeV9(dst []Record, msg []byte, exporter netip.Addr) ([]Record, error) {
+	if len(msg) < v9HeaderLen {
+		return dst, ErrTruncated
+	}
+	ctx := messageContext{
+		version:  9,
+		exporter: exporter,
+		uptime:   binary.BigEndian.Uint32(msg[4:]),
+		now:      time.Unix(int64(binary.BigEndian.Uint32(msg[8:])), 0),
+		domain:   binary.BigEndian.Uint32(msg[16:]),
+	}
+	return d.decodeSets(dst, msg[v9HeaderLen:], &ctx)
+}
+
+func (d *Decoder) decodeIPFIX(dst []Record, msg []byte, exporter netip.Addr) ([]Record, error) {
+	if len(msg) < ipfixHeaderLen {
+		return dst, ErrTruncated
+	}
+	n := int(binary.BigEndian.Uint16(msg[2:]))
+	if n < ipfixHeaderLen || n > len(msg) {
+		return dst, ErrTruncated
+	}
+	ctx := messageContext{
+		version:  10,
+		exporter: exporter,
+		now:      time.Unix(int64(binary.BigEndian.Uint32(msg[4:])), 0),
+		domain:   binary.BigEndian.Uint32(msg[12:]),
+	}
+	return d.decodeSets(dst, msg[ipfixHeaderLen:n], &ctx)
+}
+
+// decodeSets decodes the (flow)sets of a v9 or IPFIX message. Options templates and
+// their data are skipped.
+func (d *Decoder) decodeSets(dst []Record, b []byte, ctx *messageContext) ([]Record, error) {
+	for len(b) >= setHeaderLen {
+		id, n := binary.BigEndian.Uint16(b), int(binary.BigEndian.Uint16(b[2:]))
+		if n < setHeaderLen || n > len(b) {
+			return dst, ErrTruncated
+		}
+		body := b[setHeaderLen:n]
+		b = b[n:]
+		switch {
+		case (ctx.version == 9 && id == v9TemplateSet) || (ctx.version == 10 && id == ipfixTemplateSet):
+			if err := d.readTemplates(body, ctx); err != nil {
+				return dst, err
+			}
+		case id >= firstDataSet:
+			tmpl, ok := d.templates[templateKey{ctx.exporter, ctx.version, ctx.domain, id}]
+			if !ok {
+				d.Stats.MissingTemplate++
+				continue
+			}
+			var err error
+			if dst, err = decodeData(dst, body, tmpl, ctx); err != nil {
+				return dst, err
+			}
+		}
+	}
+	return dst, nil
+}
+
+// readTemplates caches the templates of a template set. An IPFIX template with no fields
+// withdraws the template; one whose records would be empty is rejected.
+func (d *Decoder) readTemplates(b []byte, ctx *messageContext) error {
+	for len(b) >= 4 {
+		id, count := binary.BigEndian.Uint16(b), int(binary.BigEndian.Uint16(b[2:]))
+		b = b[4:]
+		key := templateKey{ctx.exporter, ctx.version, ctx.domain, id}
+		if count == 0 {
+			delete(d.templates, key)
+			continue
+		}
+		fields := make([]templateField, count)
+		for i := range fields {
+			if len(b) < 4 {
+				return ErrTruncated
+			}
+			f := templateField{id: binary.BigEndian.Uint16(b), length: binary.BigEndian.Uint16(b[2:])}
+			b = b[4:]
+			if ctx.version == 10 && f.id&enterpriseBit != 0 {
+				if len(b) < 4 {
+					return ErrTruncated
+				}
+				f.id &^= enterpriseBit
+				f.enterprise = binary.BigEndian.Uint32(b)
+				b = b[4:]
+			}
+			fields[i] = f
+		}
+		if minRecordLen(fields) == 0 {
+			return ErrTemplate // its data sets would hold endless empty records
+		}
+		d.templates[key] = fields
+	}
+	return nil
+}
+
+// minRecordLen returns the smallest encoded length of a record of tmpl.
+func minRecordLen(tmpl []templateField) int {
+	n := 0
+	for _, f := range tmpl {
+		if f.length == varLength {
+			n++
+		} else {
+			n += int(f.length)
+		}
+	}
+	return n
+}
+
+// decodeData decodes the records of a data set; trailing padding shorter than a record
+// is ignored.
+func decodeData(dst []Record, b []byte, tmpl []templateField, ctx *messageContext) ([]Record, error) {
+	size := minRecordLen(tmpl)
+	for len(b) >= size {
+		var rd recordDecoder
+		rd.r = Record{Version: ctx.version, Exporter: ctx.exporter, Domain: ctx.domain}
+		rd.r.Key.ObservationDomain = ctx.domain
+		for _, f := range tmpl {
+			n := int(f.length)
+			if f.length == varLength {
+				if len(b) < 1 {
+					return dst, ErrTruncated
+				}
+				n, b = int(b[0]), b[1:]
+				if n == 255 {
+					if len(b) < 2 {
+						return dst, ErrTruncated
+					}
+					n, b = int(binary.BigEndian.Uint16(b)), b[2:]
+				}
+			}
+			if len(b) < n {
+				return dst, ErrTruncated
+			}
+			rd.field(f, b[:n])
+			b = b[n:]
+		}
+		dst = append(dst, rd.record(ctx))
+	}
+	return dst, nil
+}
+
+// recordDecoder collects the fields of one data record.
+type recordDecoder struct {
+	r             Record
+	first, last   uint32 // sysUpTime of the first and last packet, milliseconds
+	haveFirst     bool
+	haveLast      bool
+	initMillis    uint64 // systemInitTimeMilliseconds; 0 if absent
+	icmpTypeCode  uint16
+	haveICMP      bool
+	transportSeen bool  // Bytes came from transportOctetDeltaCount
+	reverseBytes  bool  // ReverseBytes was set
+	reverseTransp bool  // ReverseBytes came from the reverse transportOctetDeltaCount
+	endReason     uint8 // flowEndReason; 0 if absent
+}
+
+// field applies one field value.
+func (rd *recordDecoder) field(f templateField, b []byte) {
+	r := &rd.r
+	if f.enterprise == reverseEnterprise {
+		switch f.id {
+		case ieOctetDeltaCount, ieOctetTotalCount:
+			if !rd.reverseTransp {
+				r.ReverseBytes = uintBE(b)
+				rd.reverseBytes = true
+			}
+		case ieTransportOctetDeltaCount:
+			r.ReverseBytes = uintBE(b)
+			rd.reverseBytes, rd.reverseTransp = true, true
+		case iePacketDeltaCount, iePacketTotalCount:
+			r.ReversePackets = uintBE(b)
+		case ieTCPControlBits:
+			r.ReverseTCPFlags = uint8(uintBE(b))
+		default:
+			return
+		}
+		r.Fields |= FieldReverse
+		return
+	}
+	if f.enterprise != 0 {
+		return
+	}
+	switch f.id {
+	case ieOctetDeltaCount, ieOctetTotalCount:
+		if !rd.transportSeen {
+			r.Bytes = uintBE(b)
+			r.Fields |= FieldBytes
+		}
+	case ieTransportOctetDeltaCount:
+		r.Bytes = uintBE(b)
+		r.Fields |= FieldBytes | FieldPayloadBytes
+		rd.transportSeen = true
+	case iePacketDeltaCount, iePacketTotalCount:
+		r.Packets = uintBE(b)
+	case ieProtocolIdentifier:
+		r.Key.Protocol = uint8(uintBE(b))
+	case ieTCPControlBits:
+		r.TCPFlags = uint8(uintBE(b))
+		r.Fields |= FieldTCPFlags
+	case ieSourceTransportPort:
+		r.Key.SrcPort = uint16(uintBE(b))
+	case ieDestinationTransportPort:
+		r.Key.DstPort = uint16(uintBE(b))
+	case ieSourceIPv4Address, ieSourceIPv6Address:
+		r.Key.SrcAddr = addr(b)
+	case ieDestinationIPv4Address, ieDestinationIPv6Address:
+		r.Key.DstAddr = addr(b)
+	case ieICMPTypeCodeIPv4, ieICMPTypeCodeIPv6:
+		rd.icmpTypeCode, rd.haveICMP = uint16(uintBE(b)), true
+	case ieSourceMacAddress:
+		copy(r.Key.SrcMAC[:], b)
+	case ieDestinationMacAddress:
+		copy(r.Key.DstMAC[:], b)
+	case ieVlanID:
+		r.Key.VLANID = uint16(uintBE(b))
+	case ieLayer2SegmentID:
+		r.Key.VNI = uint32(uintBE(b))
+	case ieObservationDomainID:
+		r.Key.ObservationDomain = uint32(uintBE(b))
+	case ieFlowStartSysUpTime:
+		rd.first, rd.haveFirst = uint32(uintBE(b)), true
+	case ieFlowEndSysUpTime:
+		rd.last, rd.haveLast = uint32(uintBE(b)), true
+	case ieSystemInitTimeMillis:
+		rd.initMillis = uintBE(b)
+	case ieFlowEndReason:
+		rd.endReason = uint8(uintBE(b))
+	case ieFlowStartSeconds, ieFlowStartMilliseconds, ieFlowStartMicroseconds, ieFlowStartNanoseconds:
+		r.Start = absTime((f.id-ieFlowStartSeconds)/2, b)
+	case ieFlowEndSeconds, ieFlowEndMilliseconds, ieFlowEndMicroseconds, ieFlowEndNanoseconds:
+		r.End = absTime((f.id-ieFlowEndSeconds)/2, b)
+	case ieFlowDurationMilliseconds:
+		r.Duration = time.Duration(uintBE(b)) * time.Millisecond
+		r.Fields |= FieldDuration
+	case ieFlowDurationMicroseconds:
+		r.Duration = time.Duration(uintBE(b)) * time.Microsecond
+		r.Fields |= FieldDuration
+	}
+}
+
+// record completes the record: times relative to the exporter's uptime and ICMP type
+// and code, which key ICMP flows in place of the ports (see icmpKey).
+func (rd *recordDecoder) record(ctx *messageContext) Record {
+	r := rd.r
+	if r.Start.IsZero() && rd.haveFirst && rd.haveLast {
+		switch {
+		case ctx.version == 9:
+			r.Start = uptimeTime(ctx.now, ctx.uptime, rd.first)
+			r.End = uptimeTime(ctx.now, ctx.uptime, rd.last)
+		case rd.initMillis != 0:
+			r.Start = time.UnixMilli(int64(rd.initMillis) + int64(rd.first))
+			r.End = time.UnixMilli(int64(rd.initMillis) + int64(rd.last))
+		}
+	}
+	if !r.Start.IsZero() && !r.End.IsZero() {
+		r.Fields |= FieldTimes | FieldDuration
+		r.Duration = r.End.Sub(r.Start)
+	}
+	if rd.reverseBytes && !rd.reverseTransp {
+		r.Fields &^= FieldPayloadBytes // reverse IP bytes: the counts are not all payload
+	}
+	r.EndReason = endReason(rd.endReason, r.TCPFlags|r.ReverseTCPFlags)
+	if rd.haveICMP && (r.Key.Protocol == 1 || r.Key.Protocol == 58) {
+		icmpKey(&r.Key, rd.icmpTypeCode)
+	}
+	return r
+}
+
+// icmpKey sets the ports of an ICMP or ICMPv6 key from the message type and code as
+// flowmeter.ICMPPorts does, but without the identifier, which records do not carry:
+// queries and replies are keyed on the request type in both directions, so the records
+// of a request and its reply join, and other messages get (0, type<<8 | code).
+func icmpKey(k *flowmeter.FlowKey, typeCode uint16) {
+	k.SrcPort, k.DstPort = flowmeter.ICMPPorts(k.Protocol, uint8(typeCode>>8), uint8(typeCode), 0)
+}
+
+// TCP flag bits of tcpControlBits.
+const (
+	flagFIN = 1 << iota
+	flagSYN
+	flagRST
+	flagPSH
+	flagACK
+	flagURG
+	flagECE
+	flagCWR
+)
+
+// endReason maps a flowEndReason value to an end reason; "end of flow detected" is
+// EndRST if the flow carried RST and EndFIN otherwise.
+func endReason(v uint8, flags uint8) flowmeter.EndReason {
+	switch v {
+	case 1:
+		return flowmeter.EndIdleTimeout
+	case 2:
+		return flowmeter.EndFlowTimeout
+	case 3:
+		if flags&flagRST != 0 {
+			return flowmeter.EndRST
+		}
+		return flowmeter.EndFIN
+	case 4:
+		return flowmeter.EndWindow
+	}
+	return flowmeter.EndUnknown
+}
+
+// absTime decodes dateTimeSeconds (unit 0), dateTimeMilliseconds (1),
+// dateTimeMicroseconds (2) or dateTimeNanoseconds (3); the last two are NTP timestamps.
+func absTime(unit uint16, b []byte) time.Time {
+	v := uintBE(b)
+	switch unit {
+	case 0:
+		return time.Unix(int64(v), 0)
+	case 1:
+		return time.UnixMilli(int64(v))
+	}
+	sec := int64(v>>32) - ntpEpochOffset
+	frac := v & 0xffffffff
+	if unit == 2 {
+		frac &^= 0x7ff // the low 11 bits are not significant for microseconds
+		return time.Unix(sec, int64(frac*1e9>>32)).Round(time.Microsecond)
+	}
+	return time.Unix(sec, int64(frac*1e9>>32))
+}
+
+// uintBE decodes an unsigned integer of up to 8 bytes, as sent with reduced-size
+// encoding.
+func uintBE(b []byte) uint64 {
+	var v uint64
+	for _, c := range b[:min(len(b), 8)] {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// addr decodes an IPv4 or IPv6 address; other lengths give the zero Addr.
+func addr(b []byte) netip.Addr {
+	a, _ := netip.AddrFromSlice(b)
+	return a.Unmap()
+}
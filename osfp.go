@@ -0,0 +1,119 @@
+package flowmeter
+
+import "github.com/Bi9River/goflowmeter/p0f"
+
+// osSide is the fingerprint of one side's first SYN (forward) or SYN-ACK (backward).
+type osSide struct {
+	seen        bool
+	fingerprint string
+	label       string
+}
+
+// osFingerprintState computes a p0f-style fingerprint for the first SYN and SYN-ACK of a
+// TCP flow from the TTL, window, MSS, window scale, option layout, DF bit, IP ID and
+// flags, and labels it with the best match from Config.OSSignatures ([tcp:request]
+// signatures for a SYN, [tcp:response] for a SYN-ACK). IP options, the reserved bit, the
+// urgent pointer and the IPv6 flow label are not carried in PacketInfo, so olen is always
+// 0 and the 0+, uptr+ and flow quirks never appear.
+type osFingerprintState struct {
+	db       *p0f.DB
+	fwd, bwd osSide
+}
+
+func (s *osFingerprintState) update(p *PacketInfo) {
+	if p.Protocol != 6 || !p.SYN {
+		return
+	}
+	side := &s.fwd
+	if p.Direction == Backward {
+		side = &s.bwd
+	}
+	if side.seen {
+		return
+	}
+	side.seen = true
+	obs := synObservation(p)
+	side.fingerprint = obs.String()
+	if s.db == nil {
+		return
+	}
+	if sig, _, ok := s.db.Match(&obs, p.ACK); ok {
+		side.label = sig.Label.String()
+	}
+}
+
+// synObservation describes a SYN or SYN-ACK for p0f matching.
+func synObservation(p *PacketInfo) p0f.Observation {
+	o := &p.TCPOptions
+	obs := p0f.Observation{
+		Version: 4,
+		TTL:     int(p.TTL),
+		MSS:     -1,
+		Window:  int(p.TCPWindow),
+		Scale:   -1,
+		Layout:  p0f.Layout(o.Kinds[:o.NumKinds], int(o.EOLPadding)),
+		Payload: p.PayloadSize > 0,
+	}
+	if ParseAddr(p.SrcIP).Is6() {
+		obs.Version = 6
+	}
+	if o.HasMSS {
+		obs.MSS = int(o.MSS)
+	}
+	if o.HasWindowScale {
+		obs.Scale = int(o.WindowScale)
+	}
+	q := &obs.Quirks
+	if obs.Version == 4 {
+		if p.DontFragment {
+			*q |= p0f.QuirkDF
+			if p.IPID != 0 {
+				*q |= p0f.QuirkNonZeroID
+			}
+		} else if p.IPID == 0 {
+			*q |= p0f.QuirkZeroID
+		}
+	}
+	if p.ECE || p.CWR {
+		*q |= p0f.QuirkECN
+	}
+	if p.SeqNum == 0 {
+		*q |= p0f.QuirkZeroSeq
+	}
+	if p.ACK && p.AckNum == 0 {
+		*q |= p0f.QuirkZeroAck
+	} else if !p.ACK && p.AckNum != 0 {
+		*q |= p0f.QuirkNonZeroAck
+	}
+	if p.URG {
+		*q |= p0f.QuirkURG
+	}
+	if p.PSH {
+		*q |= p0f.QuirkPUSH
+	}
+	if o.HasTimestamps {
+		if o.TSVal == 0 {
+			*q |= p0f.QuirkTS1Zero
+		}
+		if !p.ACK && o.TSEcr != 0 {
+			*q |= p0f.QuirkTS2NonZero
+		}
+	}
+	if o.DataAfterEOL {
+		*q |= p0f.QuirkOptAfterEOL
+	}
+	if o.HasWindowScale && o.WindowScale > maxWindowScale {
+		*q |= p0f.QuirkExcessWS
+	}
+	if o.Malformed {
+		*q |= p0f.QuirkBadOpt
+	}
+	return obs
+}
+
+func (s *osFingerprintState) finalize(f *FlowFeatures) {
+	f.FwdOSFingerprint = s.fwd.fingerprint
+	f.BwdOSFingerprint = s.bwd.fingerprint
+	f.FwdOSLabel = s.fwd.label
+	f.BwdOSLabel = s.bwd.label
+}
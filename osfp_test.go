@@ -0,0 +1,99 @@
+package flowmeter
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Bi9River/goflowmeter/p0f"
+)
+
+const testSignatures = `
+[tcp:request]
+label = s:unix:Linux:3.11 and newer
+sig   = *:64:0:*:mss*20,7:mss,sok,ts,nop,ws:df,id+:0
+
+label = s:win:Windows:7 or 8
+sig   = *:128:0:*:8192,8:mss,nop,ws,nop,nop,sok:df,id+:0
+
+[tcp:response]
+label = s:unix:Linux:3.x
+sig   = *:64:0:*:mss*10,7:mss,sok,ts,nop,ws:df:0
+`
+
+// osHandshake returns a Linux-like SYN and SYN-ACK.
+func osHandshake(base time.Time) []PacketInfo {
+	syn := tcpSeg(base, 0, Forward, 100, 0, 0, 29200)
+	syn.SYN, syn.ACK = true, false
+	syn.TTL, syn.DontFragment, syn.IPID = 61, true, 4242
+	syn.TCPOptions = TCPOptions{MSS: 1460, HasMSS: true, WindowScale: 7, HasWindowScale: true,
+		SACKPermitted: true, HasTimestamps: true, TSVal: 1000,
+		Kinds: [MaxTCPOptionKinds]uint8{2, 4, 8, 1, 3}, NumKinds: 5}
+	synAck := tcpSeg(base, 1, Backward, 500, 101, 0, 14600)
+	synAck.SYN = true
+	synAck.TTL, synAck.DontFragment = 64, true
+	synAck.TCPOptions = TCPOptions{MSS: 1460, HasMSS: true, WindowScale: 7, HasWindowScale: true,
+		SACKPermitted: true, HasTimestamps: true, TSVal: 2000, TSEcr: 1000,
+		Kinds: [MaxTCPOptionKinds]uint8{2, 4, 8, 1, 3}, NumKinds: 5}
+	ack := tcpSeg(base, 2, Forward, 101, 501, 0, 229)
+	return []PacketInfo{syn, synAck, ack}
+}
+
+func TestProcessPackets_OSFingerprint_NoSignatures(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := ProcessPacketsWithKeys(osHandshake(base))[0].Features
+	if want := "4:61+3:0:1460:mss*20,7:mss,sok,ts,nop,ws:df,id+:0"; f.FwdOSFingerprint != want {
+		t.Errorf("FwdOSFingerprint: expected %s, got %s", want, f.FwdOSFingerprint)
+	}
+	if want := "4:64+0:0:1460:mss*10,7:mss,sok,ts,nop,ws:df:0"; f.BwdOSFingerprint != want {
+		t.Errorf("BwdOSFingerprint: expected %s, got %s", want, f.BwdOSFingerprint)
+	}
+	if f.FwdOSLabel != "" || f.BwdOSLabel != "" {
+		t.Errorf("expected no labels without signatures, got %q/%q", f.FwdOSLabel, f.BwdOSLabel)
+	}
+}
+
+func TestProcessPackets_OSFingerprint_Labels(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	db, err := p0f.Parse(strings.NewReader(testSignatures))
+	if err != nil {
+		t.Fatalf("p0f.Parse: %v", err)
+	}
+	cfg := DefaultConfig()
+	cfg.OSSignatures = db
+	f := ProcessPacketsWithConfig(osHandshake(base), cfg)[0].Features
+	if f.FwdOSLabel != "unix:Linux:3.11 and newer" {
+		t.Errorf("FwdOSLabel: expected unix:Linux:3.11 and newer, got %q", f.FwdOSLabel)
+	}
+	if f.BwdOSLabel != "unix:Linux:3.x" {
+		t.Errorf("BwdOSLabel: expected unix:Linux:3.x, got %q", f.BwdOSLabel)
+	}
+
+	// A Windows SYN with no matching response signature
+	pkts := osHandshake(base)
+	pkts[0].TTL, pkts[0].TCPWindow = 127, 8192
+	pkts[0].TCPOptions = TCPOptions{MSS: 1460, HasMSS: true, WindowScale: 8, HasWindowScale: true, SACKPermitted: true,
+		Kinds: [MaxTCPOptionKinds]uint8{2, 1, 3, 1, 1, 4}, NumKinds: 6}
+	pkts[1].TTL = 128
+	f = ProcessPacketsWithConfig(pkts, cfg)[0].Features
+	if f.FwdOSLabel != "win:Windows:7 or 8" || f.BwdOSLabel != "" {
+		t.Errorf("expected win:Windows:7 or 8 and no response label, got %q/%q", f.FwdOSLabel, f.BwdOSLabel)
+	}
+}
+
+func TestProcessPackets_OSFingerprint_Quirks(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	syn := tcpSeg(base, 0, Forward, 0, 77, 0, 1024)
+	syn.SYN, syn.ACK, syn.ECE, syn.CWR, syn.PSH = true, false, true, true, true
+	syn.TTL = 250
+	syn.TCPOptions = TCPOptions{WindowScale: 15, HasWindowScale: true, HasTimestamps: true, TSEcr: 5,
+		Kinds: [MaxTCPOptionKinds]uint8{3, 8, 0}, NumKinds: 3, EOLPadding: 2, DataAfterEOL: true}
+	f := ProcessPacketsWithKeys([]PacketInfo{syn})[0].Features
+	want := "4:250+5:0:*:1024,15:ws,ts,eol+2:id-,ecn,seq-,ack+,pushf+,ts1-,ts2+,opt+,exws:0"
+	if f.FwdOSFingerprint != want {
+		t.Errorf("expected %s, got %s", want, f.FwdOSFingerprint)
+	}
+	if f.BwdOSFingerprint != "" {
+		t.Errorf("expected no backward fingerprint, got %s", f.BwdOSFingerprint)
+	}
+}
@@ -0,0 +1,314 @@
+package p0f
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// maxDistance is the largest TTL distance (initial minus observed) for an exact match;
+// farther hosts only match fuzzily, as in p0f.
+const maxDistance = 35
+
+// fuzzyQuirks are the quirks that may differ in a fuzzy match; they depend on the path
+// (ECN, DF/ID rewriting by middleboxes) more than on the stack.
+const fuzzyQuirks = QuirkECN | QuirkDF | QuirkNonZeroID | QuirkZeroID
+
+// Label identifies the system a signature belongs to.
+type Label struct {
+	Generic bool   // "g" (generic) rather than "s" (specific) label
+	Class   string // e.g. "unix", "win"; "!" for applications
+	Name    string // e.g. "Linux"
+	Flavor  string // e.g. "3.11 and newer"
+}
+
+// String returns the label as class:name:flavor.
+func (l Label) String() string {
+	return l.Class + ":" + l.Name + ":" + l.Flavor
+}
+
+// wsizeKind says how a signature's window size field is compared.
+type wsizeKind uint8
+
+const (
+	wsizeAny wsizeKind = iota
+	wsizeValue
+	wsizeMSS
+	wsizeMTU
+	wsizeMod
+)
+
+// Signature is one parsed sig line.
+type Signature struct {
+	Label Label
+	Raw   string // the sig value as written in the file
+
+	version    int // 0 = any
+	ittl       int
+	badTTL     bool // "64-": TTL not checked
+	optionsLen int
+	mss        int // -1 = any
+	wsize      wsizeKind
+	wsizeVal   int
+	scale      int // -1 = any
+	layout     string
+	quirks     Quirks
+	pclass     int // -1 = any, 0 = no payload, 1 = payload
+}
+
+// DB holds the TCP signatures of a p0f file: requests (SYN) and responses (SYN-ACK).
+type DB struct {
+	Request  []Signature
+	Response []Signature
+}
+
+// LoadFile parses the p0f signature file at path.
+func LoadFile(path string) (*DB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Parse reads a p0f.fp-format signature file. Sections other than [tcp:request] and
+// [tcp:response] (mtu, http) and keys other than label and sig are skipped.
+func Parse(r io.Reader) (*DB, error) {
+	db := &DB{}
+	var section *[]Signature
+	var label Label
+	haveLabel := false
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || text[0] == ';' {
+			continue
+		}
+		if text[0] == '[' {
+			haveLabel = false
+			switch text {
+			case "[tcp:request]":
+				section = &db.Request
+			case "[tcp:response]":
+				section = &db.Response
+			default:
+				section = nil
+			}
+			continue
+		}
+		if section == nil {
+			continue
+		}
+		key, val, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("p0f: line %d: expected key = value", line)
+		}
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+		switch key {
+		case "label":
+			l, err := parseLabel(val)
+			if err != nil {
+				return nil, fmt.Errorf("p0f: line %d: %v", line, err)
+			}
+			label, haveLabel = l, true
+		case "sig":
+			if !haveLabel {
+				return nil, fmt.Errorf("p0f: line %d: sig without label", line)
+			}
+			sig, err := parseSignature(val)
+			if err != nil {
+				return nil, fmt.Errorf("p0f: line %d: %v", line, err)
+			}
+			sig.Label = label
+			*section = append(*section, sig)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func parseLabel(s string) (Label, error) {
+	parts := strings.SplitN(s, ":", 4)
+	if len(parts) != 4 || (parts[0] != "s" && parts[0] != "g") {
+		return Label{}, fmt.Errorf("bad label %q", s)
+	}
+	return Label{Generic: parts[0] == "g", Class: parts[1], Name: parts[2], Flavor: parts[3]}, nil
+}
+
+func parseSignature(s string) (Signature, error) {
+	f := strings.Split(s, ":")
+	if len(f) != 8 {
+		return Signature{}, fmt.Errorf("bad sig %q: expected 8 fields", s)
+	}
+	sig := Signature{Raw: s, mss: -1, scale: -1, pclass: -1}
+	var err error
+	bad := func(field string) error { return fmt.Errorf("bad sig %q: %s", s, field) }
+
+	switch f[0] {
+	case "*":
+	case "4", "6":
+		sig.version = int(f[0][0] - '0')
+	default:
+		return Signature{}, bad("version")
+	}
+
+	ttl, dist, hasDist := strings.Cut(f[1], "+")
+	if strings.HasSuffix(ttl, "-") {
+		sig.badTTL = true
+		ttl = ttl[:len(ttl)-1]
+	}
+	if sig.ittl, err = strconv.Atoi(ttl); err != nil {
+		return Signature{}, bad("ittl")
+	}
+	if hasDist {
+		// Raw observed+distance form, as printed by Observation.String.
+		d, err := strconv.Atoi(dist)
+		if err != nil {
+			d = InitialTTL(sig.ittl) - sig.ittl
+		}
+		sig.ittl += d
+	}
+	if sig.optionsLen, err = strconv.Atoi(f[2]); err != nil {
+		return Signature{}, bad("olen")
+	}
+	if f[3] != "*" {
+		if sig.mss, err = strconv.Atoi(f[3]); err != nil {
+			return Signature{}, bad("mss")
+		}
+	}
+
+	wsize, scale, ok := strings.Cut(f[4], ",")
+	if !ok {
+		return Signature{}, bad("wsize,scale")
+	}
+	switch {
+	case wsize == "*":
+		sig.wsize = wsizeAny
+	case strings.HasPrefix(wsize, "mss*"):
+		sig.wsize = wsizeMSS
+		sig.wsizeVal, err = strconv.Atoi(wsize[4:])
+	case strings.HasPrefix(wsize, "mtu*"):
+		sig.wsize = wsizeMTU
+		sig.wsizeVal, err = strconv.Atoi(wsize[4:])
+	case strings.HasPrefix(wsize, "%"):
+		sig.wsize = wsizeMod
+		sig.wsizeVal, err = strconv.Atoi(wsize[1:])
+		if err == nil && sig.wsizeVal <= 0 {
+			err = strconv.ErrRange
+		}
+	default:
+		sig.wsize = wsizeValue
+		sig.wsizeVal, err = strconv.Atoi(wsize)
+	}
+	if err != nil {
+		return Signature{}, bad("wsize")
+	}
+	if scale != "*" {
+		if sig.scale, err = strconv.Atoi(scale); err != nil {
+			return Signature{}, bad("scale")
+		}
+	}
+
+	sig.layout = f[5]
+	if sig.quirks, ok = parseQuirks(f[6]); !ok {
+		return Signature{}, bad("quirks")
+	}
+	switch f[7] {
+	case "*":
+	case "0":
+		sig.pclass = 0
+	case "+":
+		sig.pclass = 1
+	default:
+		return Signature{}, bad("pclass")
+	}
+	return sig, nil
+}
+
+// Match finds the best signature for o among the request (SYN) or response (SYN-ACK)
+// signatures. An exact match is preferred; otherwise a fuzzy one, which allows the ECN,
+// DF and IP ID quirks to differ and the TTL distance to exceed 35 hops. Among equals the
+// first signature in file order wins, as in p0f. ok is false if nothing matched.
+func (db *DB) Match(o *Observation, response bool) (sig *Signature, fuzzy, ok bool) {
+	sigs := db.Request
+	if response {
+		sigs = db.Response
+	}
+	var fuzzyMatch *Signature
+	for i := range sigs {
+		s := &sigs[i]
+		exact, matched := s.match(o)
+		if !matched {
+			continue
+		}
+		if exact {
+			return s, false, true
+		}
+		if fuzzyMatch == nil {
+			fuzzyMatch = s
+		}
+	}
+	if fuzzyMatch != nil {
+		return fuzzyMatch, true, true
+	}
+	return nil, false, false
+}
+
+// match reports whether o matches s at all and, if so, whether exactly.
+func (s *Signature) match(o *Observation) (exact, matched bool) {
+	exact = true
+	if s.version != 0 && s.version != o.Version {
+		return false, false
+	}
+	if s.optionsLen != o.OptionsLen || s.layout != o.Layout {
+		return false, false
+	}
+	if s.mss >= 0 && s.mss != o.MSS {
+		return false, false
+	}
+	if s.scale >= 0 && s.scale != o.Scale {
+		return false, false
+	}
+	if s.pclass >= 0 && (s.pclass == 1) != o.Payload {
+		return false, false
+	}
+	switch s.wsize {
+	case wsizeValue:
+		if o.Window != s.wsizeVal {
+			return false, false
+		}
+	case wsizeMSS:
+		if o.MSS <= 0 || o.Window != o.MSS*s.wsizeVal {
+			return false, false
+		}
+	case wsizeMTU:
+		if o.MSS <= 0 || o.Window != o.mtu()*s.wsizeVal {
+			return false, false
+		}
+	case wsizeMod:
+		if o.Window%s.wsizeVal != 0 {
+			return false, false
+		}
+	}
+	if diff := s.quirks ^ o.Quirks; diff != 0 {
+		if diff&^fuzzyQuirks != 0 {
+			return false, false
+		}
+		exact = false
+	}
+	if !s.badTTL {
+		if s.ittl < o.TTL {
+			return false, false
+		}
+		if s.ittl-o.TTL > maxDistance {
+			exact = false
+		}
+	}
+	return exact, true
+}
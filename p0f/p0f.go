@@ -0,0 +1,205 @@
+// Package p0f reads p0f v3 TCP signatures (the [tcp:request] and [tcp:response] sections
+// of p0f.fp) and matches SYN / SYN-ACK observations against them for passive OS
+// fingerprinting.
+//
+// A signature line has the form
+//
+//	sig = ver:ittl:olen:mss:wsize,scale:olayout:quirks:pclass
+//
+// and belongs to the label line above it (label = type:class:name:flavor). Observation
+// renders the same format for a packet, so the fingerprint of an unknown stack can be
+// added to a signature file as is.
+package p0f
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Quirks is a set of p0f quirk flags.
+type Quirks uint32
+
+const (
+	QuirkDF          Quirks = 1 << iota // df: IPv4 don't-fragment set
+	QuirkNonZeroID                      // id+: DF set but IP ID non-zero
+	QuirkZeroID                         // id-: DF not set but IP ID zero
+	QuirkECN                            // ecn: ECN support (ECE/CWR or IP ECN bits)
+	QuirkReserved                       // 0+: reserved IP "must be zero" bit set
+	QuirkFlow                           // flow: non-zero IPv6 flow label
+	QuirkZeroSeq                        // seq-: sequence number zero
+	QuirkNonZeroAck                     // ack+: ACK number non-zero without ACK flag
+	QuirkZeroAck                        // ack-: ACK flag with ACK number zero
+	QuirkNonZeroURG                     // uptr+: urgent pointer non-zero without URG flag
+	QuirkURG                            // urgf+: URG flag set
+	QuirkPUSH                           // pushf+: PUSH flag set
+	QuirkTS1Zero                        // ts1-: own timestamp zero
+	QuirkTS2NonZero                     // ts2+: peer timestamp non-zero on initial SYN
+	QuirkOptAfterEOL                    // opt+: non-zero data after end of options
+	QuirkExcessWS                       // exws: window scale above 14
+	QuirkBadOpt                         // bad: malformed TCP options
+)
+
+// quirkNames maps each quirk bit (by position) to its p0f token.
+var quirkNames = [...]string{"df", "id+", "id-", "ecn", "0+", "flow", "seq-", "ack+", "ack-", "uptr+", "urgf+", "pushf+", "ts1-", "ts2+", "opt+", "exws", "bad"}
+
+// String returns the quirks as a comma-separated p0f list in canonical order.
+func (q Quirks) String() string {
+	var b strings.Builder
+	for i, name := range quirkNames {
+		if q&(1<<i) == 0 {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+	}
+	return b.String()
+}
+
+// parseQuirks parses a comma-separated p0f quirk list.
+func parseQuirks(s string) (Quirks, bool) {
+	var q Quirks
+	if s == "" {
+		return 0, true
+	}
+	for _, tok := range strings.Split(s, ",") {
+		found := false
+		for i, name := range quirkNames {
+			if tok == name {
+				q |= 1 << i
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, false
+		}
+	}
+	return q, true
+}
+
+// TCP option kinds as used in option layouts.
+const (
+	OptEOL       = 0
+	OptNOP       = 1
+	OptMSS       = 2
+	OptWS        = 3
+	OptSACKOK    = 4
+	OptSACK      = 5
+	OptTimestamp = 8
+)
+
+// Layout renders TCP option kinds as a p0f option layout ("mss,sok,ts,nop,ws"). eolPad is
+// the number of bytes after an EOL option, written as "eol+N".
+func Layout(kinds []uint8, eolPad int) string {
+	var b strings.Builder
+	for i, k := range kinds {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		switch k {
+		case OptEOL:
+			b.WriteString("eol+")
+			b.WriteString(strconv.Itoa(eolPad))
+		case OptNOP:
+			b.WriteString("nop")
+		case OptMSS:
+			b.WriteString("mss")
+		case OptWS:
+			b.WriteString("ws")
+		case OptSACKOK:
+			b.WriteString("sok")
+		case OptSACK:
+			b.WriteString("sack")
+		case OptTimestamp:
+			b.WriteString("ts")
+		default:
+			b.WriteByte('?')
+			b.WriteString(strconv.Itoa(int(k)))
+		}
+	}
+	return b.String()
+}
+
+// Observation is what a SYN or SYN-ACK shows of its TCP/IP stack.
+type Observation struct {
+	Version    int    // IP version, 4 or 6
+	TTL        int    // observed TTL / hop limit
+	OptionsLen int    // IP options length in bytes
+	MSS        int    // MSS option; -1 if absent
+	Window     int    // TCP window
+	Scale      int    // window scale option; -1 if absent
+	Layout     string // option layout, see Layout
+	Quirks     Quirks
+	Payload    bool // the segment carries data
+}
+
+// InitialTTL returns the likely initial TTL for an observed one: the next of 32, 64, 128
+// and 255 at or above it.
+func InitialTTL(ttl int) int {
+	switch {
+	case ttl <= 32:
+		return 32
+	case ttl <= 64:
+		return 64
+	case ttl <= 128:
+		return 128
+	}
+	return 255
+}
+
+// String renders o in p0f's raw signature format, with the TTL written as
+// observed+distance and the window as a multiple of the MSS or MTU when it is one.
+func (o *Observation) String() string {
+	var b strings.Builder
+	b.WriteString(strconv.Itoa(o.Version))
+	b.WriteByte(':')
+	b.WriteString(strconv.Itoa(o.TTL))
+	b.WriteByte('+')
+	b.WriteString(strconv.Itoa(InitialTTL(o.TTL) - o.TTL))
+	b.WriteByte(':')
+	b.WriteString(strconv.Itoa(o.OptionsLen))
+	b.WriteByte(':')
+	if o.MSS < 0 {
+		b.WriteByte('*')
+	} else {
+		b.WriteString(strconv.Itoa(o.MSS))
+	}
+	b.WriteByte(':')
+	switch {
+	case o.MSS > 0 && o.Window > 0 && o.Window%o.MSS == 0:
+		b.WriteString("mss*")
+		b.WriteString(strconv.Itoa(o.Window / o.MSS))
+	case o.MSS > 0 && o.Window > 0 && o.Window%o.mtu() == 0:
+		b.WriteString("mtu*")
+		b.WriteString(strconv.Itoa(o.Window / o.mtu()))
+	default:
+		b.WriteString(strconv.Itoa(o.Window))
+	}
+	b.WriteByte(',')
+	if o.Scale < 0 {
+		b.WriteByte('*')
+	} else {
+		b.WriteString(strconv.Itoa(o.Scale))
+	}
+	b.WriteByte(':')
+	b.WriteString(o.Layout)
+	b.WriteByte(':')
+	b.WriteString(o.Quirks.String())
+	b.WriteByte(':')
+	if o.Payload {
+		b.WriteByte('+')
+	} else {
+		b.WriteByte('0')
+	}
+	return b.String()
+}
+
+// mtu derives the link MTU from the MSS: MSS plus the IP and TCP headers.
+func (o *Observation) mtu() int {
+	if o.Version == 6 {
+		return o.MSS + 60
+	}
+	return o.MSS + 40
+}
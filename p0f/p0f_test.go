@@ -0,0 +1,181 @@
+package p0f
+
+import (
+	"strings"
+	"testing"
+)
+
+const testDB = `
+; comment
+[mtu]
+label = Ethernet or modem
+sig   = 1500
+
+[tcp:request]
+label = s:unix:Linux:3.11 and newer
+sig   = *:64:0:*:mss*20,10:mss,sok,ts,nop,ws:df,id+:0
+sig   = *:64:0:*:mss*20,7:mss,sok,ts,nop,ws:df,id+:0
+
+label = s:win:Windows:7 or 8
+sig   = *:128:0:*:8192,8:mss,nop,ws,nop,nop,sok:df,id+:0
+
+label = g:unix:Linux:generic
+sig   = *:64-:0:*:%8192,*:mss,nop,nop,sok,nop,ws:df,id+:0
+
+[tcp:response]
+label = s:unix:Linux:3.x
+sig   = *:64:0:*:mss*10,0:mss:df:0
+`
+
+func loadTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := Parse(strings.NewReader(testDB))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	return db
+}
+
+func linuxSYN() Observation {
+	return Observation{Version: 4, TTL: 57, MSS: 1460, Window: 29200, Scale: 7,
+		Layout: "mss,sok,ts,nop,ws", Quirks: QuirkDF | QuirkNonZeroID}
+}
+
+func TestParse_Sections(t *testing.T) {
+	db := loadTestDB(t)
+	if len(db.Request) != 4 || len(db.Response) != 1 {
+		t.Fatalf("expected 4 request and 1 response signatures, got %d and %d", len(db.Request), len(db.Response))
+	}
+	if l := db.Request[2].Label; l.Generic || l.String() != "win:Windows:7 or 8" {
+		t.Errorf("expected specific label win:Windows:7 or 8, got %+v", l)
+	}
+	if !db.Request[3].Label.Generic {
+		t.Errorf("expected generic label for the last request signature")
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	cases := []struct{ in, want string }{
+		{"[tcp:request]\nsig = *:64:0:*:*,*:mss:df:0", "line 2: sig without label"},
+		{"[tcp:request]\nlabel = x:unix:Linux:3", "line 2: bad label"},
+		{"[tcp:request]\nlabel = s:unix:Linux:3\n\nsig = *:64:0:*:*,*:mss:df", "line 4: bad sig"},
+		{"[tcp:request]\nlabel = s:unix:Linux:3\nsig = *:64:0:*:*,*:mss:df,bogus:0", "line 3: bad sig"},
+		{"[tcp:response]\nnonsense", "line 2: expected key = value"},
+	}
+	for _, c := range cases {
+		_, err := Parse(strings.NewReader(c.in))
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%q: expected error containing %q, got %v", c.in, c.want, err)
+		}
+	}
+}
+
+func TestLayout(t *testing.T) {
+	got := Layout([]uint8{OptMSS, OptNOP, OptWS, OptSACKOK, OptTimestamp, 30, OptEOL}, 3)
+	if got != "mss,nop,ws,sok,ts,?30,eol+3" {
+		t.Errorf("expected mss,nop,ws,sok,ts,?30,eol+3, got %s", got)
+	}
+}
+
+func TestObservation_String(t *testing.T) {
+	o := linuxSYN()
+	if got := o.String(); got != "4:57+7:0:1460:mss*20,7:mss,sok,ts,nop,ws:df,id+:0" {
+		t.Errorf("unexpected fingerprint %s", got)
+	}
+	o = Observation{Version: 6, TTL: 128, MSS: 1440, Window: 3000, Scale: -1, Layout: "mss", Payload: true}
+	if got := o.String(); got != "6:128+0:0:1440:mtu*2,*:mss::+" {
+		t.Errorf("unexpected fingerprint %s", got)
+	}
+	o = Observation{Version: 4, TTL: 64, MSS: -1, Window: 512, Scale: -1}
+	if got := o.String(); got != "4:64+0:0:*:512,*:::0" {
+		t.Errorf("unexpected fingerprint %s", got)
+	}
+}
+
+func TestObservation_StringRoundTrip(t *testing.T) {
+	o := linuxSYN()
+	sig, err := parseSignature(o.String())
+	if err != nil {
+		t.Fatalf("parseSignature: %v", err)
+	}
+	if exact, ok := sig.match(&o); !ok || !exact {
+		t.Errorf("expected own fingerprint to match exactly, got exact=%v ok=%v", exact, ok)
+	}
+	if sig.ittl != 64 {
+		t.Errorf("expected ittl 64, got %d", sig.ittl)
+	}
+}
+
+func TestInitialTTL(t *testing.T) {
+	for ttl, want := range map[int]int{1: 32, 32: 32, 33: 64, 64: 64, 100: 128, 128: 128, 200: 255, 255: 255} {
+		if got := InitialTTL(ttl); got != want {
+			t.Errorf("InitialTTL(%d): expected %d, got %d", ttl, want, got)
+		}
+	}
+}
+
+func TestMatch_Exact(t *testing.T) {
+	db := loadTestDB(t)
+	o := linuxSYN()
+	sig, fuzzy, ok := db.Match(&o, false)
+	if !ok || fuzzy || sig.Label.String() != "unix:Linux:3.11 and newer" || sig != &db.Request[1] {
+		t.Fatalf("expected exact match on the second Linux sig, got %v fuzzy=%v ok=%v", sig, fuzzy, ok)
+	}
+
+	win := Observation{Version: 4, TTL: 120, MSS: 1460, Window: 8192, Scale: 8,
+		Layout: "mss,nop,ws,nop,nop,sok", Quirks: QuirkDF | QuirkNonZeroID}
+	if sig, _, ok := db.Match(&win, false); !ok || sig.Label.Name != "Windows" {
+		t.Errorf("expected Windows, got %v ok=%v", sig, ok)
+	}
+
+	resp := Observation{Version: 4, TTL: 60, MSS: 1460, Window: 14600, Scale: 0, Layout: "mss", Quirks: QuirkDF}
+	if sig, _, ok := db.Match(&resp, true); !ok || sig.Label.String() != "unix:Linux:3.x" {
+		t.Errorf("expected response match unix:Linux:3.x, got %v ok=%v", sig, ok)
+	}
+	if _, _, ok := db.Match(&resp, false); ok {
+		t.Errorf("expected no request match for a response fingerprint")
+	}
+}
+
+func TestMatch_Fuzzy(t *testing.T) {
+	db := loadTestDB(t)
+	// ECN and a missing DF bit differ only in fuzzy quirks
+	o := linuxSYN()
+	o.Quirks = QuirkZeroID | QuirkECN
+	if sig, fuzzy, ok := db.Match(&o, false); !ok || !fuzzy || sig.Label.Name != "Linux" {
+		t.Errorf("expected fuzzy Linux match, got %v fuzzy=%v ok=%v", sig, fuzzy, ok)
+	}
+	// More than 35 hops away: fuzzy
+	o = linuxSYN()
+	o.TTL = 20
+	if _, fuzzy, ok := db.Match(&o, false); !ok || !fuzzy {
+		t.Errorf("expected fuzzy match for distant host, got fuzzy=%v ok=%v", fuzzy, ok)
+	}
+	// TTL above the initial TTL never matches
+	o = linuxSYN()
+	o.TTL = 100
+	if _, _, ok := db.Match(&o, false); ok {
+		t.Errorf("expected no match for TTL above ittl")
+	}
+	// A non-fuzzy quirk (ts1-) rules the signature out
+	o = linuxSYN()
+	o.Quirks |= QuirkTS1Zero
+	if _, _, ok := db.Match(&o, false); ok {
+		t.Errorf("expected no match with extra ts1- quirk")
+	}
+}
+
+func TestMatch_WildcardsAndBadTTL(t *testing.T) {
+	db := loadTestDB(t)
+	// Generic sig: any scale, window a multiple of 8192, TTL not checked
+	o := Observation{Version: 4, TTL: 200, MSS: 1400, Window: 16384, Scale: 4,
+		Layout: "mss,nop,nop,sok,nop,ws", Quirks: QuirkDF | QuirkNonZeroID}
+	sig, fuzzy, ok := db.Match(&o, false)
+	if !ok || fuzzy || !sig.Label.Generic {
+		t.Errorf("expected exact generic match, got %v fuzzy=%v ok=%v", sig, fuzzy, ok)
+	}
+	o.Window = 16000
+	if _, _, ok := db.Match(&o, false); ok {
+		t.Errorf("expected no match for window not a multiple of 8192")
+	}
+}
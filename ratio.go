@@ -1,7 +1,12 @@
 package flowmeter
 
-// computeRatio sets DownUpRatio per CIC: integer division then double.
-func computeRatio(packets []PacketInfo, f *FlowFeatures) {
+// ratioState sets DownUpRatio per CIC: integer division then double.
+// It needs no per-packet state; finalize reads the packet totals, so it must run after counts.
+type ratioState struct{}
+
+func (ratioState) update(*PacketInfo) {}
+
+func (ratioState) finalize(f *FlowFeatures) {
 	if f.TotalFwdPackets > 0 {
 		f.DownUpRatio = float64(f.TotalBwdPackets / f.TotalFwdPackets)
 	}
@@ -0,0 +1,79 @@
+package reader
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Bi9River/goflowmeter/decoder"
+)
+
+// Classic libpcap magic numbers as read in file byte order.
+const (
+	magicMicros = 0xa1b2c3d4
+	magicNanos  = 0xa1b23c4d
+)
+
+// pcapReader parses classic libpcap files (microsecond or nanosecond timestamps, either
+// byte order).
+type pcapReader struct {
+	r        io.Reader
+	order    binary.ByteOrder
+	nanos    bool
+	linkType decoder.LinkType
+	hdr      [16]byte
+	buf      []byte
+}
+
+func newPcapReader(r io.Reader) (*pcapReader, error) {
+	var gh [24]byte
+	if _, err := io.ReadFull(r, gh[:]); err != nil {
+		return nil, fmt.Errorf("%w: pcap global header: %v", ErrFormat, err)
+	}
+	p := &pcapReader{r: r}
+	switch {
+	case binary.LittleEndian.Uint32(gh[0:4]) == magicMicros:
+		p.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(gh[0:4]) == magicMicros:
+		p.order = binary.BigEndian
+	case binary.LittleEndian.Uint32(gh[0:4]) == magicNanos:
+		p.order, p.nanos = binary.LittleEndian, true
+	case binary.BigEndian.Uint32(gh[0:4]) == magicNanos:
+		p.order, p.nanos = binary.BigEndian, true
+	default:
+		return nil, fmt.Errorf("%w: unknown magic %x", ErrFormat, gh[0:4])
+	}
+	// The upper bits of the link-type field carry FCS information; the type is the low 16 bits.
+	p.linkType = decoder.LinkType(p.order.Uint32(gh[20:24]) & 0xffff)
+	return p, nil
+}
+
+func (p *pcapReader) nextFrame() (Frame, error) {
+	if _, err := io.ReadFull(p.r, p.hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return Frame{}, fmt.Errorf("%w: truncated record header", ErrFormat)
+		}
+		return Frame{}, err
+	}
+	sec := int64(p.order.Uint32(p.hdr[0:4]))
+	frac := int64(p.order.Uint32(p.hdr[4:8]))
+	inclLen := p.order.Uint32(p.hdr[8:12])
+	origLen := p.order.Uint32(p.hdr[12:16])
+	if inclLen > maxBlockLen {
+		return Frame{}, fmt.Errorf("%w: record length %d", ErrFormat, inclLen)
+	}
+	p.buf = grow(p.buf, int(inclLen))
+	if _, err := io.ReadFull(p.r, p.buf); err != nil {
+		return Frame{}, fmt.Errorf("%w: truncated record: %v", ErrFormat, err)
+	}
+	if !p.nanos {
+		frac *= 1000
+	}
+	return Frame{
+		Timestamp: time.Unix(sec, frac).UTC(),
+		LinkType:  p.linkType,
+		Data:      p.buf,
+		OrigLen:   int(origLen),
+	}, nil
+}
@@ -0,0 +1,58 @@
+package reader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Bi9River/goflowmeter/decoder"
+)
+
+func TestPcap_ByteOrderAndResolution(t *testing.T) {
+	ts := time.Date(2025, 1, 1, 0, 0, 1, 123456789, time.UTC)
+	cases := []struct {
+		name  string
+		order binary.ByteOrder
+		nanos bool
+		want  time.Time
+	}{
+		{"little-endian micros", binary.LittleEndian, false, ts.Truncate(time.Microsecond)},
+		{"big-endian micros", binary.BigEndian, false, ts.Truncate(time.Microsecond)},
+		{"little-endian nanos", binary.LittleEndian, true, ts},
+		{"big-endian nanos", binary.BigEndian, true, ts},
+	}
+	for _, c := range cases {
+		frame := ethIPv4TCP(1, 2, 0, 0)
+		r, err := NewReader(bytes.NewReader(pcapFile(c.order, c.nanos, []time.Time{ts}, [][]byte{frame})))
+		if err != nil {
+			t.Fatalf("%s: NewReader: %v", c.name, err)
+		}
+		fr, err := r.NextFrame()
+		if err != nil {
+			t.Fatalf("%s: NextFrame: %v", c.name, err)
+		}
+		if !fr.Timestamp.Equal(c.want) {
+			t.Errorf("%s: timestamp: expected %v, got %v", c.name, c.want, fr.Timestamp)
+		}
+		if fr.LinkType != decoder.LinkTypeEthernet || !bytes.Equal(fr.Data, frame) || fr.OrigLen != len(frame) {
+			t.Errorf("%s: unexpected frame link=%d len=%d orig=%d", c.name, fr.LinkType, len(fr.Data), fr.OrigLen)
+		}
+		if _, err := r.NextFrame(); err != io.EOF {
+			t.Errorf("%s: expected io.EOF after last frame, got %v", c.name, err)
+		}
+	}
+}
+
+func TestPcap_TruncatedRecord(t *testing.T) {
+	data := pcapFile(binary.LittleEndian, false, []time.Time{time.Unix(0, 0)}, [][]byte{ethIPv4TCP(1, 2, 0, 0)})
+	r, err := NewReader(bytes.NewReader(data[:len(data)-10]))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	if _, err := r.NextFrame(); !errors.Is(err, ErrFormat) {
+		t.Errorf("expected ErrFormat for truncated record, got %v", err)
+	}
+}
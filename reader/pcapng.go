@@ -0,0 +1,266 @@
+package reader
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"time"
+
+	"github.com/Bi9River/goflowmeter/decoder"
+)
+
+// PCAPNG block types and option codes (draft-ietf-opsawg-pcapng).
+const (
+	blockTypeSHB = 0x0A0D0D0A // Section Header Block
+	blockTypeIDB = 0x00000001 // Interface Description Block
+	blockTypePB  = 0x00000002 // Packet Block (obsolete)
+	blockTypeSPB = 0x00000003 // Simple Packet Block
+	blockTypeEPB = 0x00000006 // Enhanced Packet Block
+
+	byteOrderMagic = 0x1A2B3C4D
+
+	optEndOfOpt  = 0
+	optTSResol   = 9
+	optTSOffset  = 14
+	defaultUnits = 1_000_000 // if_tsresol default: microseconds
+)
+
+// ngInterface is the per-interface state from an Interface Description Block.
+type ngInterface struct {
+	linkType    decoder.LinkType
+	snapLen     uint32
+	unitsPerSec uint64
+	offsetSec   int64
+}
+
+// pcapngReader parses PCAPNG streams: multiple sections (each with its own byte order),
+// multiple interfaces, and per-interface timestamp resolution.
+type pcapngReader struct {
+	r      io.Reader
+	order  binary.ByteOrder
+	ifaces []ngInterface
+	hdr    [12]byte
+	buf    []byte
+}
+
+func newPcapNGReader(r io.Reader) (*pcapngReader, error) {
+	p := &pcapngReader{r: r}
+	if _, err := io.ReadFull(r, p.hdr[:8]); err != nil {
+		return nil, fmt.Errorf("%w: pcapng section header: %v", ErrFormat, err)
+	}
+	if err := p.readSectionHeader(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// readSectionHeader parses the rest of an SHB whose type and length words are in hdr[:8].
+// The byte-order magic decides how the length (and everything in the section) is read.
+func (p *pcapngReader) readSectionHeader() error {
+	if _, err := io.ReadFull(p.r, p.hdr[8:12]); err != nil {
+		return fmt.Errorf("%w: pcapng section header: %v", ErrFormat, err)
+	}
+	switch {
+	case binary.LittleEndian.Uint32(p.hdr[8:12]) == byteOrderMagic:
+		p.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(p.hdr[8:12]) == byteOrderMagic:
+		p.order = binary.BigEndian
+	default:
+		return fmt.Errorf("%w: bad pcapng byte-order magic %x", ErrFormat, p.hdr[8:12])
+	}
+	length := p.order.Uint32(p.hdr[4:8])
+	if length < 28 || length%4 != 0 || length > maxBlockLen {
+		return fmt.Errorf("%w: section header length %d", ErrFormat, length)
+	}
+	// Version, section length and options are not needed.
+	if _, err := io.CopyN(io.Discard, p.r, int64(length-12)); err != nil {
+		return fmt.Errorf("%w: truncated section header: %v", ErrFormat, err)
+	}
+	p.ifaces = p.ifaces[:0]
+	return nil
+}
+
+func (p *pcapngReader) nextFrame() (Frame, error) {
+	for {
+		if _, err := io.ReadFull(p.r, p.hdr[:8]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return Frame{}, fmt.Errorf("%w: truncated block header", ErrFormat)
+			}
+			return Frame{}, err
+		}
+		blockType := p.order.Uint32(p.hdr[0:4])
+		if blockType == blockTypeSHB {
+			if err := p.readSectionHeader(); err != nil {
+				return Frame{}, err
+			}
+			continue
+		}
+		length := p.order.Uint32(p.hdr[4:8])
+		if length < 12 || length%4 != 0 || length > maxBlockLen {
+			return Frame{}, fmt.Errorf("%w: block length %d", ErrFormat, length)
+		}
+		p.buf = grow(p.buf, int(length-8))
+		if _, err := io.ReadFull(p.r, p.buf); err != nil {
+			return Frame{}, fmt.Errorf("%w: truncated block: %v", ErrFormat, err)
+		}
+		body := p.buf[:len(p.buf)-4] // drop trailing block length
+		switch blockType {
+		case blockTypeIDB:
+			if err := p.readInterface(body); err != nil {
+				return Frame{}, err
+			}
+		case blockTypeEPB:
+			return p.enhancedPacket(body)
+		case blockTypeSPB:
+			return p.simplePacket(body)
+		case blockTypePB:
+			return p.obsoletePacket(body)
+		}
+		// Other blocks (name resolution, statistics, custom) are skipped.
+	}
+}
+
+func (p *pcapngReader) readInterface(body []byte) error {
+	if len(body) < 8 {
+		return fmt.Errorf("%w: short interface description block", ErrFormat)
+	}
+	iface := ngInterface{
+		linkType:    decoder.LinkType(p.order.Uint16(body[0:2])),
+		snapLen:     p.order.Uint32(body[4:8]),
+		unitsPerSec: defaultUnits,
+	}
+	opts := body[8:]
+	for len(opts) >= 4 {
+		code := p.order.Uint16(opts[0:2])
+		olen := int(p.order.Uint16(opts[2:4]))
+		if code == optEndOfOpt || 4+olen > len(opts) {
+			break
+		}
+		val := opts[4 : 4+olen]
+		switch {
+		case code == optTSResol && olen >= 1:
+			units, ok := tsUnits(val[0])
+			if !ok {
+				return fmt.Errorf("%w: unsupported if_tsresol %#x", ErrFormat, val[0])
+			}
+			iface.unitsPerSec = units
+		case code == optTSOffset && olen >= 8:
+			iface.offsetSec = int64(p.order.Uint64(val))
+		}
+		next := 4 + (olen+3)&^3
+		if next > len(opts) {
+			break
+		}
+		opts = opts[next:]
+	}
+	p.ifaces = append(p.ifaces, iface)
+	return nil
+}
+
+// tsUnits converts an if_tsresol byte to timestamp units per second: the MSB selects a
+// power of 2, otherwise a power of 10.
+func tsUnits(res byte) (uint64, bool) {
+	exp := uint(res & 0x7f)
+	if res&0x80 != 0 {
+		if exp > 63 {
+			return 0, false
+		}
+		return 1 << exp, true
+	}
+	if exp > 19 {
+		return 0, false
+	}
+	units := uint64(1)
+	for i := uint(0); i < exp; i++ {
+		units *= 10
+	}
+	return units, true
+}
+
+// timestamp converts a raw 64-bit pcapng timestamp on the given interface to time.Time.
+func (iface *ngInterface) timestamp(high, low uint32) time.Time {
+	ts := uint64(high)<<32 | uint64(low)
+	sec := ts / iface.unitsPerSec
+	frac := ts % iface.unitsPerSec
+	// frac < units, so frac*1e9/units < 1e9 and the 128-bit division cannot overflow.
+	hi, lo := bits.Mul64(frac, 1e9)
+	nanos, _ := bits.Div64(hi, lo, iface.unitsPerSec)
+	return time.Unix(int64(sec)+iface.offsetSec, int64(nanos)).UTC()
+}
+
+func (p *pcapngReader) iface(id uint32) (*ngInterface, error) {
+	if int(id) >= len(p.ifaces) {
+		return nil, fmt.Errorf("%w: packet references undefined interface %d", ErrFormat, id)
+	}
+	return &p.ifaces[id], nil
+}
+
+func (p *pcapngReader) enhancedPacket(body []byte) (Frame, error) {
+	if len(body) < 20 {
+		return Frame{}, fmt.Errorf("%w: short enhanced packet block", ErrFormat)
+	}
+	id := p.order.Uint32(body[0:4])
+	iface, err := p.iface(id)
+	if err != nil {
+		return Frame{}, err
+	}
+	capLen := p.order.Uint32(body[12:16])
+	if int(capLen) > len(body)-20 {
+		return Frame{}, fmt.Errorf("%w: enhanced packet captured length %d", ErrFormat, capLen)
+	}
+	return Frame{
+		Timestamp:   iface.timestamp(p.order.Uint32(body[4:8]), p.order.Uint32(body[8:12])),
+		LinkType:    iface.linkType,
+		InterfaceID: int(id),
+		Data:        body[20 : 20+capLen],
+		OrigLen:     int(p.order.Uint32(body[16:20])),
+	}, nil
+}
+
+// simplePacket parses an SPB. It always belongs to interface 0 and has no timestamp, so
+// the frame's Timestamp is the zero time.
+func (p *pcapngReader) simplePacket(body []byte) (Frame, error) {
+	if len(body) < 4 {
+		return Frame{}, fmt.Errorf("%w: short simple packet block", ErrFormat)
+	}
+	iface, err := p.iface(0)
+	if err != nil {
+		return Frame{}, err
+	}
+	origLen := p.order.Uint32(body[0:4])
+	capLen := origLen
+	if iface.snapLen > 0 && capLen > iface.snapLen {
+		capLen = iface.snapLen
+	}
+	if int(capLen) > len(body)-4 {
+		capLen = uint32(len(body) - 4)
+	}
+	return Frame{
+		LinkType: iface.linkType,
+		Data:     body[4 : 4+capLen],
+		OrigLen:  int(origLen),
+	}, nil
+}
+
+func (p *pcapngReader) obsoletePacket(body []byte) (Frame, error) {
+	if len(body) < 20 {
+		return Frame{}, fmt.Errorf("%w: short packet block", ErrFormat)
+	}
+	id := uint32(p.order.Uint16(body[0:2]))
+	iface, err := p.iface(id)
+	if err != nil {
+		return Frame{}, err
+	}
+	capLen := p.order.Uint32(body[12:16])
+	if int(capLen) > len(body)-20 {
+		return Frame{}, fmt.Errorf("%w: packet block captured length %d", ErrFormat, capLen)
+	}
+	return Frame{
+		Timestamp:   iface.timestamp(p.order.Uint32(body[4:8]), p.order.Uint32(body[8:12])),
+		LinkType:    iface.linkType,
+		InterfaceID: int(id),
+		Data:        body[20 : 20+capLen],
+		OrigLen:     int(p.order.Uint32(body[16:20])),
+	}, nil
+}
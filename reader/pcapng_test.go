@@ -0,0 +1,141 @@
+package reader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Bi9River/goflowmeter/decoder"
+)
+
+// ngBuilder writes PCAPNG blocks in a chosen byte order.
+type ngBuilder struct {
+	buf   bytes.Buffer
+	order binary.ByteOrder
+}
+
+func (b *ngBuilder) block(typ uint32, body []byte) {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	n := uint32(12 + len(body))
+	var w [4]byte
+	b.order.PutUint32(w[:], typ)
+	b.buf.Write(w[:])
+	b.order.PutUint32(w[:], n)
+	b.buf.Write(w[:])
+	b.buf.Write(body)
+	b.buf.Write(w[:])
+}
+
+func (b *ngBuilder) section() {
+	body := make([]byte, 16)
+	b.order.PutUint32(body[0:4], byteOrderMagic)
+	b.order.PutUint16(body[4:6], 1)
+	b.order.PutUint64(body[8:16], ^uint64(0))
+	b.block(blockTypeSHB, body)
+}
+
+// iface adds an IDB; tsresol < 0 omits the if_tsresol option.
+func (b *ngBuilder) iface(link decoder.LinkType, tsresol int) {
+	body := make([]byte, 8)
+	b.order.PutUint16(body[0:2], uint16(link))
+	b.order.PutUint32(body[4:8], 65535)
+	if tsresol >= 0 {
+		opt := make([]byte, 8)
+		b.order.PutUint16(opt[0:2], optTSResol)
+		b.order.PutUint16(opt[2:4], 1)
+		opt[4] = byte(tsresol)
+		body = append(body, opt...)
+		body = append(body, 0, 0, 0, 0) // opt_endofopt
+	}
+	b.block(blockTypeIDB, body)
+}
+
+func (b *ngBuilder) epb(ifID uint32, ts uint64, data []byte) {
+	body := make([]byte, 20)
+	b.order.PutUint32(body[0:4], ifID)
+	b.order.PutUint32(body[4:8], uint32(ts>>32))
+	b.order.PutUint32(body[8:12], uint32(ts))
+	b.order.PutUint32(body[12:16], uint32(len(data)))
+	b.order.PutUint32(body[16:20], uint32(len(data)))
+	b.block(blockTypeEPB, append(body, data...))
+}
+
+func (b *ngBuilder) spb(data []byte) {
+	body := make([]byte, 4)
+	b.order.PutUint32(body[0:4], uint32(len(data)))
+	b.block(blockTypeSPB, append(body, data...))
+}
+
+func TestPcapNG_InterfacesAndResolution(t *testing.T) {
+	b := &ngBuilder{order: binary.LittleEndian}
+	b.section()
+	b.iface(decoder.LinkTypeEthernet, -1) // default microseconds
+	b.iface(decoder.LinkTypeRaw, 9)       // nanoseconds
+	frame := ethIPv4TCP(1, 2, 0x02, 0)
+	b.epb(0, 1_700_000_000_123_456, frame)
+	b.epb(1, 1_700_000_000_123_456_789, frame[14:])
+	b.spb(frame)
+	b.block(5, make([]byte, 8)) // interface statistics block, skipped
+
+	r, err := NewReader(bytes.NewReader(b.buf.Bytes()))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	fr, err := r.NextFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Unix(1_700_000_000, 123_456_000).UTC(); !fr.Timestamp.Equal(want) || fr.LinkType != decoder.LinkTypeEthernet {
+		t.Errorf("frame 0: expected %v on Ethernet, got %v on %d", want, fr.Timestamp, fr.LinkType)
+	}
+	fr, err = r.NextFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Unix(1_700_000_000, 123_456_789).UTC(); !fr.Timestamp.Equal(want) || fr.InterfaceID != 1 || fr.LinkType != decoder.LinkTypeRaw {
+		t.Errorf("frame 1: expected %v on interface 1 (raw), got %v on %d (%d)", want, fr.Timestamp, fr.InterfaceID, fr.LinkType)
+	}
+	fr, err = r.NextFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fr.Data, frame) || !fr.Timestamp.IsZero() {
+		t.Errorf("simple packet: expected full frame and zero timestamp, got len=%d ts=%v", len(fr.Data), fr.Timestamp)
+	}
+	if _, err := r.NextFrame(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestPcapNG_MultipleSectionsMixedEndian(t *testing.T) {
+	le := &ngBuilder{order: binary.LittleEndian}
+	le.section()
+	le.iface(decoder.LinkTypeEthernet, 3) // milliseconds
+	le.epb(0, 5_000, ethIPv4TCP(1, 2, 0x02, 0))
+	be := &ngBuilder{order: binary.BigEndian}
+	be.section()
+	be.iface(decoder.LinkTypeEthernet, 0x80|20) // 2^-20 s
+	be.epb(0, 3<<20|1<<19, ethIPv4TCP(2, 1, 0x12, 0))
+
+	r, err := NewReader(io.MultiReader(bytes.NewReader(le.buf.Bytes()), bytes.NewReader(be.buf.Bytes())))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	want := []time.Time{time.Unix(5, 0).UTC(), time.Unix(3, 500_000_000).UTC()}
+	for i, w := range want {
+		p, err := r.Next()
+		if err != nil {
+			t.Fatalf("packet %d: %v", i, err)
+		}
+		if !p.Timestamp.Equal(w) {
+			t.Errorf("packet %d: expected %v, got %v", i, w, p.Timestamp)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
@@ -0,0 +1,235 @@
+// Package reader parses libpcap (.pcap) and PCAPNG (.pcapng) capture files into
+// flowmeter.RawPacket without cgo or libpcap.
+//
+// Use ReadFile for small captures, or Open / NewReader and call Next in a loop to stream
+// packets from captures that do not fit in memory.
+package reader
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/Bi9River/goflowmeter"
+	"github.com/Bi9River/goflowmeter/decoder"
+	"github.com/Bi9River/goflowmeter/defrag"
+)
+
+// maxBlockLen bounds a single record or block so a corrupt length cannot make the reader
+// allocate gigabytes.
+const maxBlockLen = 64 << 20
+
+// ErrFormat is returned (wrapped) when the input is not a valid pcap or pcapng stream.
+var ErrFormat = errors.New("reader: invalid capture format")
+
+// Frame is one captured frame as stored in the file. Data is only valid until the next
+// call to NextFrame or Next.
+type Frame struct {
+	Timestamp   time.Time
+	LinkType    decoder.LinkType
+	InterfaceID int // pcapng interface index; always 0 for classic pcap
+	Data        []byte
+	OrigLen     int // length on the wire; may exceed len(Data) if the snaplen cut it
+}
+
+// frameSource is implemented by the classic pcap and pcapng parsers.
+type frameSource interface {
+	nextFrame() (Frame, error)
+}
+
+// Reader streams frames or decoded packets from a pcap or pcapng stream.
+type Reader struct {
+	src     frameSource
+	closer  io.Closer
+	dec     decoder.Decoder
+	pkt     decoder.Packet
+	skipped int
+
+	reasm    *defrag.Reassembler
+	reasmDec decoder.Decoder // decodes reassembled datagrams, kept out of DecodeStats
+
+	// In TunnelViewBoth, the frame (or datagram) of the outer packet last returned, whose
+	// inner packet the next call to Next returns.
+	innerPending bool
+	innerData    []byte
+	innerTS      time.Time
+	innerDomain  uint32
+	outer        decoder.Packet
+}
+
+// NewReader detects the capture format from the first four bytes of r and returns a Reader.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReaderSize(r, 1<<16)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	var src frameSource
+	if binary.LittleEndian.Uint32(magic) == blockTypeSHB {
+		src, err = newPcapNGReader(br)
+	} else {
+		src, err = newPcapReader(br)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{src: src, reasm: defrag.New(defrag.DefaultConfig())}, nil
+}
+
+// Open opens a capture file for streaming. The caller must Close it.
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	r.closer = f
+	return r, nil
+}
+
+// ReadFile reads a whole capture file and returns its IP packets. Frames that cannot be
+// decoded as IP are skipped.
+func ReadFile(path string) ([]flowmeter.RawPacket, error) {
+	r, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var out []flowmeter.RawPacket
+	for {
+		p, err := r.Next()
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return out, fmt.Errorf("%s: %w", path, err)
+		}
+		out = append(out, p)
+	}
+}
+
+// NextFrame returns the next frame in the file, or io.EOF at the end.
+func (r *Reader) NextFrame() (Frame, error) {
+	return r.src.nextFrame()
+}
+
+// Next returns the next frame decoded as a RawPacket, or io.EOF at the end. Frames the
+// decoder rejects (non-IP, truncated, unsupported link type) are skipped and counted in
+// Skipped.
+//
+// IP fragments are reassembled (see SetReassembler): they are held back and the datagram
+// is returned once complete, with the timestamp, MAC addresses and VLANs of its last
+// fragment. Fragments that never complete are counted in ReassemblyStats.
+//
+// ObservationDomain is set to the frame's pcapng interface index, and Fragments of a
+// reassembled datagram to the number of fragments it was built from.
+//
+// Tunneled packets are returned as selected by SetTunnelView; with TunnelViewBoth the
+// outer packet comes first and its inner packet on the following call.
+func (r *Reader) Next() (flowmeter.RawPacket, error) {
+	if r.innerPending {
+		r.innerPending = false
+		if err := r.dec.DecodeInner(r.innerData, &r.outer, &r.pkt); err == nil {
+			p := r.pkt.RawPacket(r.innerTS)
+			p.ObservationDomain = r.innerDomain
+			return p, nil
+		}
+	}
+	for {
+		fr, err := r.src.nextFrame()
+		if err != nil {
+			if err == io.EOF && r.reasm != nil {
+				r.reasm.Flush()
+			}
+			return flowmeter.RawPacket{}, err
+		}
+		if err := r.dec.Decode(fr.Data, fr.LinkType, &r.pkt); err != nil {
+			r.skipped++
+			continue
+		}
+		frags := 0
+		if r.reasm != nil && r.pkt.IsFragment() {
+			datagram, ok := r.reasm.Add(fr.Timestamp, fr.Data, &r.pkt)
+			if !ok {
+				continue
+			}
+			vlans, numVLANs, tunnel := r.pkt.VLANs, r.pkt.NumVLANs, r.pkt.Tunnel
+			srcMAC, dstMAC := r.pkt.SrcMAC, r.pkt.DstMAC
+			if err := r.reasmDec.Decode(datagram, decoder.LinkTypeRaw, &r.pkt); err != nil {
+				r.skipped++
+				continue
+			}
+			r.pkt.VLANs, r.pkt.NumVLANs = vlans, numVLANs
+			r.pkt.SrcMAC, r.pkt.DstMAC = srcMAC, dstMAC
+			if r.pkt.Tunnel.Type == flowmeter.TunnelNone {
+				r.pkt.Tunnel = tunnel // a reassembled inner datagram keeps its tunnel
+			}
+			fr.Data = datagram
+			frags = r.reasm.Fragments()
+		}
+		if r.dec.Tunnels == decoder.TunnelViewBoth && r.pkt.Tunnel.Type != flowmeter.TunnelNone {
+			r.innerPending, r.innerData, r.innerTS, r.outer = true, fr.Data, fr.Timestamp, r.pkt
+			r.innerDomain = uint32(fr.InterfaceID)
+		}
+		p := r.pkt.RawPacket(fr.Timestamp)
+		p.ObservationDomain = uint32(fr.InterfaceID)
+		p.Fragments = frags
+		return p, nil
+	}
+}
+
+// SetReassembler replaces the Reassembler used for IP fragments, e.g. one with other
+// limits. nil turns reassembly off: fragments are then returned as decoded, non-first
+// ones without ports.
+func (r *Reader) SetReassembler(ra *defrag.Reassembler) {
+	r.reasm = ra
+}
+
+// SetTunnelView selects whether Next returns the outer packet of GRE, ERSPAN, VXLAN,
+// GENEVE, IP-in-IP and GTP-U traffic (the default), the inner one, or both.
+func (r *Reader) SetTunnelView(v decoder.TunnelView) {
+	r.dec.Tunnels = v
+	r.reasmDec.Tunnels = v
+}
+
+// ReassemblyStats returns the fragment reassembly counters (zero if reassembly is off).
+func (r *Reader) ReassemblyStats() defrag.Stats {
+	if r.reasm == nil {
+		return defrag.Stats{}
+	}
+	return r.reasm.Stats()
+}
+
+// Skipped returns the number of frames Next has skipped because they could not be decoded.
+func (r *Reader) Skipped() int {
+	return r.skipped
+}
+
+// DecodeStats returns the decoder's per-reason counters for the frames Next has read.
+func (r *Reader) DecodeStats() decoder.Stats {
+	return r.dec.Stats()
+}
+
+// Close closes the underlying file when the Reader was created by Open.
+func (r *Reader) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+// grow returns buf resized to n bytes, reusing its backing array when large enough.
+func grow(buf []byte, n int) []byte {
+	if cap(buf) < n {
+		return make([]byte, n)
+	}
+	return buf[:n]
+}
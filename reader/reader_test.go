@@ -0,0 +1,331 @@
+package reader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Bi9River/goflowmeter"
+	"github.com/Bi9River/goflowmeter/decoder"
+)
+
+// ethIPv4TCP builds an Ethernet/IPv4/TCP frame 10.0.0.1:sport -> 10.0.0.2:dport with the
+// given TCP flags and payload length.
+func ethIPv4TCP(sport, dport uint16, flags byte, payload int) []byte {
+	b := make([]byte, 14+20+20+payload)
+	binary.BigEndian.PutUint16(b[12:14], 0x0800)
+	ip := b[14:]
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:4], uint16(20+20+payload))
+	ip[8] = 64
+	ip[9] = 6
+	copy(ip[12:16], []byte{10, 0, 0, 1})
+	copy(ip[16:20], []byte{10, 0, 0, 2})
+	tcp := ip[20:]
+	binary.BigEndian.PutUint16(tcp[0:2], sport)
+	binary.BigEndian.PutUint16(tcp[2:4], dport)
+	tcp[12] = 5 << 4
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:16], 65535)
+	return b
+}
+
+// arpFrame builds a minimal non-IP Ethernet frame.
+func arpFrame() []byte {
+	b := make([]byte, 42)
+	binary.BigEndian.PutUint16(b[12:14], 0x0806)
+	return b
+}
+
+// pcapFile builds a classic pcap stream with Ethernet link type.
+func pcapFile(order binary.ByteOrder, nanos bool, ts []time.Time, frames [][]byte) []byte {
+	var buf bytes.Buffer
+	magic := uint32(magicMicros)
+	if nanos {
+		magic = magicNanos
+	}
+	gh := make([]byte, 24)
+	order.PutUint32(gh[0:4], magic)
+	order.PutUint16(gh[4:6], 2)
+	order.PutUint16(gh[6:8], 4)
+	order.PutUint32(gh[16:20], 65535)
+	order.PutUint32(gh[20:24], 1)
+	buf.Write(gh)
+	for i, fr := range frames {
+		rh := make([]byte, 16)
+		order.PutUint32(rh[0:4], uint32(ts[i].Unix()))
+		frac := ts[i].Nanosecond()
+		if !nanos {
+			frac /= 1000
+		}
+		order.PutUint32(rh[4:8], uint32(frac))
+		order.PutUint32(rh[8:12], uint32(len(fr)))
+		order.PutUint32(rh[12:16], uint32(len(fr)))
+		buf.Write(rh)
+		buf.Write(fr)
+	}
+	return buf.Bytes()
+}
+
+func TestReader_ReadFileAndConvert(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	ts := []time.Time{base, base.Add(time.Millisecond), base.Add(2 * time.Millisecond)}
+	frames := [][]byte{ethIPv4TCP(11111, 80, 0x02, 0), arpFrame(), ethIPv4TCP(11111, 80, 0x18, 100)}
+	path := filepath.Join(t.TempDir(), "cap.pcap")
+	if err := os.WriteFile(path, pcapFile(binary.LittleEndian, false, ts, frames), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if len(raw) != 2 {
+		t.Fatalf("expected 2 IP packets (ARP skipped), got %d", len(raw))
+	}
+	if raw[1].PayloadSize != 100 || !raw[1].PSH || !raw[1].ACK || raw[1].SrcIP != "10.0.0.1" {
+		t.Errorf("second packet: unexpected %+v", raw[1])
+	}
+	pairs := flowmeter.ProcessPacketsWithKeys(flowmeter.ConvertToPacketInfo(raw))
+	if len(pairs) != 1 || pairs[0].Features.TotalFwdPackets != 2 {
+		t.Errorf("expected one flow with 2 fwd packets, got %+v", pairs)
+	}
+}
+
+func TestReader_StreamingSkipsNonIP(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	ts := []time.Time{base, base, base}
+	frames := [][]byte{arpFrame(), ethIPv4TCP(1, 2, 0x10, 5), arpFrame()}
+	r, err := NewReader(bytes.NewReader(pcapFile(binary.BigEndian, true, ts, frames)))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	var n int
+	for {
+		_, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		n++
+	}
+	if n != 1 || r.Skipped() != 2 {
+		t.Errorf("expected 1 packet and 2 skipped, got %d and %d", n, r.Skipped())
+	}
+}
+
+func TestReader_UnknownFormat(t *testing.T) {
+	if _, err := NewReader(bytes.NewReader([]byte("not a capture file at all"))); err == nil {
+		t.Error("expected error for unknown magic")
+	}
+}
+
+// ethFragments splits an Ethernet/IPv4/TCP frame's IP payload into fragments of at most
+// size bytes (a multiple of 8), each in its own Ethernet frame.
+func ethFragments(frame []byte, size int) [][]byte {
+	ip := frame[14:]
+	payload := ip[20:]
+	var out [][]byte
+	for off := 0; off < len(payload); off += size {
+		end := min(off+size, len(payload))
+		b := make([]byte, 14+20+end-off)
+		copy(b, frame[:34])
+		binary.BigEndian.PutUint16(b[16:18], uint16(20+end-off))
+		binary.BigEndian.PutUint16(b[18:20], 77)
+		flags := uint16(off / 8)
+		if end < len(payload) {
+			flags |= 0x2000
+		}
+		binary.BigEndian.PutUint16(b[20:22], flags)
+		copy(b[34:], payload[off:end])
+		out = append(out, b)
+	}
+	return out
+}
+
+func TestReader_ReassemblesFragments(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	frags := ethFragments(ethIPv4TCP(11111, 80, 0x18, 3000), 1480)
+	frames := [][]byte{frags[2], frags[0], frags[1], ethIPv4TCP(11111, 80, 0x10, 0)}
+	ts := []time.Time{base, base.Add(time.Millisecond), base.Add(2 * time.Millisecond), base.Add(3 * time.Millisecond)}
+	data := pcapFile(binary.LittleEndian, false, ts, frames)
+
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.SrcPort != 11111 || p.DstPort != 80 || p.PayloadSize != 3000 || !p.PSH || !p.Timestamp.Equal(ts[2]) {
+		t.Errorf("expected reassembled 11111->80 PSH with 3000 bytes at the last fragment's time, got %+v", p)
+	}
+	if _, err := r.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+	if s := r.ReassemblyStats(); s.Fragments != 3 || s.Reassembled != 1 {
+		t.Errorf("expected 3 fragments and 1 datagram, got %+v", s)
+	}
+	if p.Fragments != 3 {
+		t.Errorf("expected the datagram built from 3 fragments, got %d", p.Fragments)
+	}
+
+	// Without reassembly every fragment comes back, non-first ones without ports.
+	r, _ = NewReader(bytes.NewReader(data))
+	r.SetReassembler(nil)
+	n := 0
+	for {
+		p, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 && p.SrcPort != 0 {
+			t.Errorf("expected the last fragment without ports, got %d", p.SrcPort)
+		}
+		n++
+	}
+	if n != 4 {
+		t.Errorf("expected 4 packets without reassembly, got %d", n)
+	}
+}
+
+func TestReader_ReassembledFragmentsKeepMACs(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	withMACs := func(b []byte) []byte {
+		copy(b[0:6], []byte{0xaa, 0, 0, 0, 0, 2})
+		copy(b[6:12], []byte{0xbb, 0, 0, 0, 0, 1})
+		return b
+	}
+	frags := ethFragments(withMACs(ethIPv4TCP(11111, 80, 0x18, 3000)), 1480)
+	frames := append(frags, withMACs(ethIPv4TCP(11111, 80, 0x10, 0)))
+	ts := []time.Time{base, base.Add(time.Millisecond), base.Add(2 * time.Millisecond), base.Add(3 * time.Millisecond)}
+	r, err := NewReader(bytes.NewReader(pcapFile(binary.LittleEndian, false, ts, frames)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw []flowmeter.RawPacket
+	for {
+		p, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw = append(raw, p)
+	}
+	if len(raw) != 2 {
+		t.Fatalf("expected the datagram and the ACK, got %d packets", len(raw))
+	}
+	src, dst := flowmeter.MAC{0xbb, 0, 0, 0, 0, 1}, flowmeter.MAC{0xaa, 0, 0, 0, 0, 2}
+	if raw[0].SrcMAC != src || raw[0].DstMAC != dst {
+		t.Errorf("expected the reassembled datagram from %v to %v, got %v -> %v", src, dst, raw[0].SrcMAC, raw[0].DstMAC)
+	}
+	cfg := flowmeter.DefaultConfig()
+	cfg.KeyFields = flowmeter.KeyMAC
+	flows := flowmeter.ProcessPacketsWithConfig(flowmeter.ConvertToPacketInfoWithFields(raw, cfg.KeyFields), cfg)
+	if len(flows) != 1 || flows[0].Features.TotalFwdPackets != 2 {
+		t.Fatalf("expected the datagram and the ACK in 1 MAC-keyed flow, got %d flows", len(flows))
+	}
+	if k := flows[0].Key; k.SrcMAC != src || k.DstMAC != dst {
+		t.Errorf("expected key MACs %v -> %v, got %v -> %v", src, dst, k.SrcMAC, k.DstMAC)
+	}
+}
+
+func TestReader_FragmentsReachIPLayerFeatures(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	frames := append(ethFragments(ethIPv4TCP(11111, 80, 0x18, 3000), 1480), ethIPv4TCP(11111, 80, 0x10, 0))
+	ts := []time.Time{base, base.Add(time.Millisecond), base.Add(2 * time.Millisecond), base.Add(3 * time.Millisecond)}
+	r, err := NewReader(bytes.NewReader(pcapFile(binary.LittleEndian, false, ts, frames)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw []flowmeter.RawPacket
+	for {
+		p, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw = append(raw, p)
+	}
+	flows := flowmeter.ProcessPacketsWithKeys(flowmeter.ConvertToPacketInfo(raw))
+	if len(flows) != 1 {
+		t.Fatalf("expected 1 flow, got %d", len(flows))
+	}
+	if f := flows[0].Features; f.FwdFragments != 3 || f.TotalFwdPackets != 2 {
+		t.Errorf("expected 3 forward fragments in 2 packets, got %d in %d", f.FwdFragments, f.TotalFwdPackets)
+	}
+}
+
+// ethIPIP wraps the IP packet of an ethIPv4TCP frame in an outer IPv4 header
+// 192.0.2.1 -> 192.0.2.2 (IP-in-IP).
+func ethIPIP(frame []byte) []byte {
+	inner := frame[14:]
+	b := make([]byte, 14+20+len(inner))
+	copy(b, frame[:14])
+	ip := b[14:]
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:4], uint16(20+len(inner)))
+	ip[8] = 64
+	ip[9] = 4
+	copy(ip[12:16], []byte{192, 0, 2, 1})
+	copy(ip[16:20], []byte{192, 0, 2, 2})
+	copy(ip[20:], inner)
+	return b
+}
+
+func TestReader_TunnelViews(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	frames := [][]byte{ethIPIP(ethIPv4TCP(11111, 80, 0x02, 0)), ethIPv4TCP(22222, 443, 0x02, 0)}
+	data := pcapFile(binary.LittleEndian, false, []time.Time{base, base.Add(time.Millisecond)}, frames)
+
+	read := func(v decoder.TunnelView) []flowmeter.RawPacket {
+		r, err := NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.SetTunnelView(v)
+		var out []flowmeter.RawPacket
+		for {
+			p, err := r.Next()
+			if err == io.EOF {
+				return out
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, p)
+		}
+	}
+
+	outer := read(decoder.TunnelViewOuter)
+	if len(outer) != 2 || outer[0].SrcIP != "192.0.2.1" || outer[0].Protocol != 4 || outer[0].Tunnel.Type != flowmeter.TunnelNone {
+		t.Errorf("outer view: expected the IP-in-IP packet undecapsulated, got %+v", outer)
+	}
+	inner := read(decoder.TunnelViewInner)
+	if len(inner) != 2 || inner[0].SrcIP != "10.0.0.1" || inner[0].SrcPort != 11111 || inner[0].Tunnel.Type != flowmeter.TunnelIPIP || inner[0].Tunnel.SrcAddr.String() != "192.0.2.1" {
+		t.Errorf("inner view: expected the inner TCP packet with its tunnel, got %+v", inner)
+	}
+	both := read(decoder.TunnelViewBoth)
+	if len(both) != 3 {
+		t.Fatalf("both view: expected 3 packets, got %d", len(both))
+	}
+	if both[0].SrcIP != "192.0.2.1" || both[1].SrcPort != 11111 || !both[1].Timestamp.Equal(base) || both[2].SrcPort != 22222 {
+		t.Errorf("both view: expected outer, inner, then the plain packet, got %+v", both)
+	}
+}
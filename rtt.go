@@ -0,0 +1,83 @@
+package flowmeter
+
+import "time"
+
+// rttDirState times one data segment at a time in one direction (as TCP senders do):
+// the sample is the delay until the other side acknowledges it.
+type rttDirState struct {
+	timing bool
+	end    uint32 // sequence number just past the timed segment
+	sent   time.Time
+}
+
+// rttState measures round-trip times as seen from the capture point, in microseconds.
+//   - Handshake: ServerRTT is SYN -> SYN-ACK (monitor to responder and back), ClientRTT
+//     is SYN-ACK -> ACK (monitor to initiator and back). The last SYN before the SYN-ACK
+//     is used, so a retransmitted SYN does not inflate the sample.
+//   - Continuous: each direction times one data segment at a time; the first ACK from the
+//     other side that covers it gives a sample. Following Karn's algorithm a timed segment
+//     that is retransmitted is not sampled. All samples form the RTT Stats.
+//
+// Continuous samples need SeqNum/AckNum (packets with both 0 and no SYN are ignored, as
+// in tcpHealthState). Packets must arrive sorted by timestamp.
+type rttState struct {
+	synDir                 Direction
+	synTs, synAckTs, ackTs time.Time
+	seenSyn, seenSynAck    bool
+	seenAck                bool
+
+	fwd, bwd rttDirState
+	samples  RunningStats
+}
+
+func (s *rttState) update(p *PacketInfo) {
+	if p.Protocol != 6 {
+		return
+	}
+	s.updateHandshake(p)
+	if p.SeqNum == 0 && p.AckNum == 0 && !p.SYN {
+		return
+	}
+	snd, rcv := &s.fwd, &s.bwd
+	if p.Direction == Backward {
+		snd, rcv = rcv, snd
+	}
+	// The ACK side first: a packet can carry data and acknowledge the other direction.
+	if p.ACK && rcv.timing && !seqLT(p.AckNum, rcv.end) {
+		s.samples.Add(float64(p.Timestamp.Sub(rcv.sent).Microseconds()))
+		rcv.timing = false
+	}
+	if p.PayloadSize == 0 || p.SYN {
+		return
+	}
+	end := p.SeqNum + uint32(p.PayloadSize)
+	switch {
+	case snd.timing && seqLT(p.SeqNum, snd.end):
+		snd.timing = false // retransmission of timed data: ambiguous, drop it (Karn)
+	case !snd.timing:
+		snd.timing, snd.end, snd.sent = true, end, p.Timestamp
+	}
+}
+
+func (s *rttState) updateHandshake(p *PacketInfo) {
+	switch {
+	case p.SYN && !p.ACK && !s.seenSynAck:
+		s.seenSyn, s.synDir, s.synTs = true, p.Direction, p.Timestamp
+	case p.SYN && p.ACK && s.seenSyn && !s.seenSynAck && p.Direction != s.synDir:
+		s.seenSynAck, s.synAckTs = true, p.Timestamp
+	case p.ACK && !p.SYN && s.seenSynAck && !s.seenAck && p.Direction == s.synDir:
+		s.seenAck, s.ackTs = true, p.Timestamp
+	}
+}
+
+func (s *rttState) finalize(f *FlowFeatures) {
+	if s.seenSynAck {
+		f.ServerRTTUs = s.synAckTs.Sub(s.synTs).Microseconds()
+	}
+	if s.seenAck {
+		f.ClientRTTUs = s.ackTs.Sub(s.synAckTs).Microseconds()
+	}
+	if s.samples.Count() > 0 {
+		f.RTT = s.samples.Stats()
+	}
+}
@@ -0,0 +1,43 @@
+package flowmeter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProcessPackets_RTT_Handshake(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	syn := tcpSeg(base, 0, Forward, 100, 0, 0, 1000)
+	syn.SYN, syn.ACK = true, false
+	synRetry := syn
+	synRetry.Timestamp = base.Add(1000 * time.Millisecond)
+	synAck := tcpSeg(base, 1030, Backward, 500, 101, 0, 1000)
+	synAck.SYN = true
+	ack := tcpSeg(base, 1035, Forward, 101, 501, 0, 1000)
+	f := ProcessPacketsWithKeys([]PacketInfo{syn, synRetry, synAck, ack})[0].Features
+	// Retransmitted SYN: server RTT measured from the last SYN (1000ms -> 1030ms)
+	if f.ServerRTTUs != 30_000 || f.ClientRTTUs != 5_000 {
+		t.Errorf("expected server RTT 30000us and client RTT 5000us, got %d and %d", f.ServerRTTUs, f.ClientRTTUs)
+	}
+}
+
+func TestProcessPackets_RTT_DataAckSamples(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	packets := []PacketInfo{
+		tcpSeg(base, 0, Forward, 1000, 1, 100, 1000),  // timed: ends at 1100
+		tcpSeg(base, 2, Forward, 1100, 1, 100, 1000),  // not timed (one at a time)
+		tcpSeg(base, 10, Backward, 1, 1100, 0, 1000),  // sample 10ms
+		tcpSeg(base, 12, Forward, 1200, 1, 100, 1000), // timed: ends at 1300
+		tcpSeg(base, 40, Backward, 1, 1300, 0, 1000),  // sample 28ms
+		tcpSeg(base, 50, Forward, 1300, 1, 100, 1000), // timed
+		tcpSeg(base, 90, Forward, 1300, 1, 100, 1000), // retransmitted: dropped (Karn)
+		tcpSeg(base, 95, Backward, 1, 1400, 0, 1000),  // no sample
+	}
+	f := ProcessPacketsWithKeys(packets)[0].Features
+	if f.RTT.Min != 10_000 || f.RTT.Max != 28_000 || f.RTT.Mean != 19_000 {
+		t.Errorf("expected RTT samples 10000 and 28000 us, got min=%f max=%f mean=%f", f.RTT.Min, f.RTT.Max, f.RTT.Mean)
+	}
+	if f.ServerRTTUs != 0 || f.ClientRTTUs != 0 {
+		t.Errorf("expected no handshake RTT without SYN, got %d %d", f.ServerRTTUs, f.ClientRTTUs)
+	}
+}
@@ -0,0 +1,251 @@
+package flowmeter
+
+// Unit is the unit of a feature value.
+type Unit string
+
+const (
+	UnitMicroseconds  Unit = "us"
+	UnitBytes         Unit = "bytes"
+	UnitBytesSquared  Unit = "bytes^2"
+	UnitPackets       Unit = "packets"
+	UnitBytesPerSec   Unit = "bytes/s"
+	UnitPacketsPerSec Unit = "packets/s"
+	UnitCount         Unit = "count"
+	UnitRatio         Unit = "ratio"
+)
+
+// DataType tells writers whether a feature is integral or fractional. Vector always
+// returns float64; integer features hold whole numbers.
+type DataType int
+
+const (
+	DataTypeInt DataType = iota
+	DataTypeFloat
+)
+
+// FeatureDescriptor describes one flow feature.
+type FeatureDescriptor struct {
+	Name      string   // CICFlowMeter CSV column name
+	Field     string   // FlowFeatures field path, e.g. "FwdPacketLen.Max"; "" for custom features
+	Module    string   // name of the FeatureModule that computes it, e.g. "bulk"
+	Unit      Unit     // unit of the value as returned by Value / Vector
+	Type      DataType // integral or fractional
+	CICColumn int      // CICFlowMeter FlowFeature column number (8–84); 0 for non-CIC features
+
+	value func(f *FlowFeatures) float64
+}
+
+// Value returns this feature's value from f. Features of custom modules are read from
+// f.Custom.
+func (d FeatureDescriptor) Value(f *FlowFeatures) float64 {
+	if d.value == nil {
+		return f.Custom[d.Name]
+	}
+	return d.value(f)
+}
+
+// cicIDColumns precede the features in CICFlowMeter's CSV; Label follows them.
+var cicIDColumns = []string{"Flow ID", "Src IP", "Src Port", "Dst IP", "Dst Port", "Protocol", "Timestamp"}
+
+// featureSchema lists every feature in CICFlowMeter CSV order. Stat order follows CIC:
+// Fwd/Bwd packet length = Max, Min, Mean, Std; Flow/Fwd/Bwd IAT and Active/Idle = Mean,
+// Std, Max, Min. Column 62 (a duplicate Fwd Header Length) is not emitted by CICFlowMeter 4.
+var featureSchema = []FeatureDescriptor{
+	{Name: "Flow Duration", Field: "FlowDurationUs", Module: "basic", Unit: UnitMicroseconds, Type: DataTypeInt, CICColumn: 8, value: func(f *FlowFeatures) float64 { return float64(f.FlowDurationUs) }},
+	{Name: "Total Fwd Packet", Field: "TotalFwdPackets", Module: "counts", Unit: UnitPackets, Type: DataTypeInt, CICColumn: 9, value: func(f *FlowFeatures) float64 { return float64(f.TotalFwdPackets) }},
+	{Name: "Total Bwd packets", Field: "TotalBwdPackets", Module: "counts", Unit: UnitPackets, Type: DataTypeInt, CICColumn: 10, value: func(f *FlowFeatures) float64 { return float64(f.TotalBwdPackets) }},
+	{Name: "Total Length of Fwd Packet", Field: "TotalFwdBytes", Module: "counts", Unit: UnitBytes, Type: DataTypeInt, CICColumn: 11, value: func(f *FlowFeatures) float64 { return float64(f.TotalFwdBytes) }},
+	{Name: "Total Length of Bwd Packet", Field: "TotalBwdBytes", Module: "counts", Unit: UnitBytes, Type: DataTypeInt, CICColumn: 12, value: func(f *FlowFeatures) float64 { return float64(f.TotalBwdBytes) }},
+	{Name: "Fwd Packet Length Max", Field: "FwdPacketLen.Max", Module: "packetlen", Unit: UnitBytes, Type: DataTypeFloat, CICColumn: 13, value: func(f *FlowFeatures) float64 { return f.FwdPacketLen.Max }},
+	{Name: "Fwd Packet Length Min", Field: "FwdPacketLen.Min", Module: "packetlen", Unit: UnitBytes, Type: DataTypeFloat, CICColumn: 14, value: func(f *FlowFeatures) float64 { return f.FwdPacketLen.Min }},
+	{Name: "Fwd Packet Length Mean", Field: "FwdPacketLen.Mean", Module: "packetlen", Unit: UnitBytes, Type: DataTypeFloat, CICColumn: 15, value: func(f *FlowFeatures) float64 { return f.FwdPacketLen.Mean }},
+	{Name: "Fwd Packet Length Std", Field: "FwdPacketLen.Std", Module: "packetlen", Unit: UnitBytes, Type: DataTypeFloat, CICColumn: 16, value: func(f *FlowFeatures) float64 { return f.FwdPacketLen.Std }},
+	{Name: "Bwd Packet Length Max", Field: "BwdPacketLen.Max", Module: "packetlen", Unit: UnitBytes, Type: DataTypeFloat, CICColumn: 17, value: func(f *FlowFeatures) float64 { return f.BwdPacketLen.Max }},
+	{Name: "Bwd Packet Length Min", Field: "BwdPacketLen.Min", Module: "packetlen", Unit: UnitBytes, Type: DataTypeFloat, CICColumn: 18, value: func(f *FlowFeatures) float64 { return f.BwdPacketLen.Min }},
+	{Name: "Bwd Packet Length Mean", Field: "BwdPacketLen.Mean", Module: "packetlen", Unit: UnitBytes, Type: DataTypeFloat, CICColumn: 19, value: func(f *FlowFeatures) float64 { return f.BwdPacketLen.Mean }},
+	{Name: "Bwd Packet Length Std", Field: "BwdPacketLen.Std", Module: "packetlen", Unit: UnitBytes, Type: DataTypeFloat, CICColumn: 20, value: func(f *FlowFeatures) float64 { return f.BwdPacketLen.Std }},
+	{Name: "Flow Bytes/s", Field: "FlowBytesPerSec", Module: "basic", Unit: UnitBytesPerSec, Type: DataTypeFloat, CICColumn: 21, value: func(f *FlowFeatures) float64 { return f.FlowBytesPerSec }},
+	{Name: "Flow Packets/s", Field: "FlowPacketsPerSec", Module: "basic", Unit: UnitPacketsPerSec, Type: DataTypeFloat, CICColumn: 22, value: func(f *FlowFeatures) float64 { return f.FlowPacketsPerSec }},
+	{Name: "Flow IAT Mean", Field: "FlowIAT.Mean", Module: "iat", Unit: UnitMicroseconds, Type: DataTypeFloat, CICColumn: 23, value: func(f *FlowFeatures) float64 { return f.FlowIAT.Mean }},
+	{Name: "Flow IAT Std", Field: "FlowIAT.Std", Module: "iat", Unit: UnitMicroseconds, Type: DataTypeFloat, CICColumn: 24, value: func(f *FlowFeatures) float64 { return f.FlowIAT.Std }},
+	{Name: "Flow IAT Max", Field: "FlowIAT.Max", Module: "iat", Unit: UnitMicroseconds, Type: DataTypeFloat, CICColumn: 25, value: func(f *FlowFeatures) float64 { return f.FlowIAT.Max }},
+	{Name: "Flow IAT Min", Field: "FlowIAT.Min", Module: "iat", Unit: UnitMicroseconds, Type: DataTypeFloat, CICColumn: 26, value: func(f *FlowFeatures) float64 { return f.FlowIAT.Min }},
+	{Name: "Fwd IAT Total", Field: "FwdIATTotal", Module: "iat", Unit: UnitMicroseconds, Type: DataTypeInt, CICColumn: 27, value: func(f *FlowFeatures) float64 { return float64(f.FwdIATTotal.Microseconds()) }},
+	{Name: "Fwd IAT Mean", Field: "FwdIAT.Mean", Module: "iat", Unit: UnitMicroseconds, Type: DataTypeFloat, CICColumn: 28, value: func(f *FlowFeatures) float64 { return f.FwdIAT.Mean }},
+	{Name: "Fwd IAT Std", Field: "FwdIAT.Std", Module: "iat", Unit: UnitMicroseconds, Type: DataTypeFloat, CICColumn: 29, value: func(f *FlowFeatures) float64 { return f.FwdIAT.Std }},
+	{Name: "Fwd IAT Max", Field: "FwdIAT.Max", Module: "iat", Unit: UnitMicroseconds, Type: DataTypeFloat, CICColumn: 30, value: func(f *FlowFeatures) float64 { return f.FwdIAT.Max }},
+	{Name: "Fwd IAT Min", Field: "FwdIAT.Min", Module: "iat", Unit: UnitMicroseconds, Type: DataTypeFloat, CICColumn: 31, value: func(f *FlowFeatures) float64 { return f.FwdIAT.Min }},
+	{Name: "Bwd IAT Total", Field: "BwdIATTotal", Module: "iat", Unit: UnitMicroseconds, Type: DataTypeInt, CICColumn: 32, value: func(f *FlowFeatures) float64 { return float64(f.BwdIATTotal.Microseconds()) }},
+	{Name: "Bwd IAT Mean", Field: "BwdIAT.Mean", Module: "iat", Unit: UnitMicroseconds, Type: DataTypeFloat, CICColumn: 33, value: func(f *FlowFeatures) float64 { return f.BwdIAT.Mean }},
+	{Name: "Bwd IAT Std", Field: "BwdIAT.Std", Module: "iat", Unit: UnitMicroseconds, Type: DataTypeFloat, CICColumn: 34, value: func(f *FlowFeatures) float64 { return f.BwdIAT.Std }},
+	{Name: "Bwd IAT Max", Field: "BwdIAT.Max", Module: "iat", Unit: UnitMicroseconds, Type: DataTypeFloat, CICColumn: 35, value: func(f *FlowFeatures) float64 { return f.BwdIAT.Max }},
+	{Name: "Bwd IAT Min", Field: "BwdIAT.Min", Module: "iat", Unit: UnitMicroseconds, Type: DataTypeFloat, CICColumn: 36, value: func(f *FlowFeatures) float64 { return f.BwdIAT.Min }},
+	{Name: "Fwd PSH Flags", Field: "FwdPSHFlag", Module: "flags", Unit: UnitCount, Type: DataTypeInt, CICColumn: 37, value: func(f *FlowFeatures) float64 { return float64(f.FwdPSHFlag) }},
+	{Name: "Bwd PSH Flags", Field: "BwdPSHFlag", Module: "flags", Unit: UnitCount, Type: DataTypeInt, CICColumn: 38, value: func(f *FlowFeatures) float64 { return float64(f.BwdPSHFlag) }},
+	{Name: "Fwd URG Flags", Field: "FwdURGFlag", Module: "flags", Unit: UnitCount, Type: DataTypeInt, CICColumn: 39, value: func(f *FlowFeatures) float64 { return float64(f.FwdURGFlag) }},
+	{Name: "Bwd URG Flags", Field: "BwdURGFlag", Module: "flags", Unit: UnitCount, Type: DataTypeInt, CICColumn: 40, value: func(f *FlowFeatures) float64 { return float64(f.BwdURGFlag) }},
+	{Name: "Fwd Header Length", Field: "FwdHeaderLen", Module: "flags", Unit: UnitBytes, Type: DataTypeInt, CICColumn: 41, value: func(f *FlowFeatures) float64 { return float64(f.FwdHeaderLen) }},
+	{Name: "Bwd Header Length", Field: "BwdHeaderLen", Module: "flags", Unit: UnitBytes, Type: DataTypeInt, CICColumn: 42, value: func(f *FlowFeatures) float64 { return float64(f.BwdHeaderLen) }},
+	{Name: "Fwd Packets/s", Field: "FwdPacketsPerSec", Module: "rates", Unit: UnitPacketsPerSec, Type: DataTypeFloat, CICColumn: 43, value: func(f *FlowFeatures) float64 { return f.FwdPacketsPerSec }},
+	{Name: "Bwd Packets/s", Field: "BwdPacketsPerSec", Module: "rates", Unit: UnitPacketsPerSec, Type: DataTypeFloat, CICColumn: 44, value: func(f *FlowFeatures) float64 { return f.BwdPacketsPerSec }},
+	{Name: "Packet Length Min", Field: "MinPacketLen", Module: "packetlen", Unit: UnitBytes, Type: DataTypeInt, CICColumn: 45, value: func(f *FlowFeatures) float64 { return float64(f.MinPacketLen) }},
+	{Name: "Packet Length Max", Field: "MaxPacketLen", Module: "packetlen", Unit: UnitBytes, Type: DataTypeInt, CICColumn: 46, value: func(f *FlowFeatures) float64 { return float64(f.MaxPacketLen) }},
+	{Name: "Packet Length Mean", Field: "PacketLenMean", Module: "packetlen", Unit: UnitBytes, Type: DataTypeFloat, CICColumn: 47, value: func(f *FlowFeatures) float64 { return f.PacketLenMean }},
+	{Name: "Packet Length Std", Field: "PacketLenStd", Module: "packetlen", Unit: UnitBytes, Type: DataTypeFloat, CICColumn: 48, value: func(f *FlowFeatures) float64 { return f.PacketLenStd }},
+	{Name: "Packet Length Variance", Field: "PacketLenVar", Module: "packetlen", Unit: UnitBytesSquared, Type: DataTypeFloat, CICColumn: 49, value: func(f *FlowFeatures) float64 { return f.PacketLenVar }},
+	{Name: "FIN Flag Count", Field: "FIN", Module: "flags", Unit: UnitCount, Type: DataTypeInt, CICColumn: 50, value: func(f *FlowFeatures) float64 { return float64(f.FIN) }},
+	{Name: "SYN Flag Count", Field: "SYN", Module: "flags", Unit: UnitCount, Type: DataTypeInt, CICColumn: 51, value: func(f *FlowFeatures) float64 { return float64(f.SYN) }},
+	{Name: "RST Flag Count", Field: "RST", Module: "flags", Unit: UnitCount, Type: DataTypeInt, CICColumn: 52, value: func(f *FlowFeatures) float64 { return float64(f.RST) }},
+	{Name: "PSH Flag Count", Field: "PSH", Module: "flags", Unit: UnitCount, Type: DataTypeInt, CICColumn: 53, value: func(f *FlowFeatures) float64 { return float64(f.PSH) }},
+	{Name: "ACK Flag Count", Field: "ACK", Module: "flags", Unit: UnitCount, Type: DataTypeInt, CICColumn: 54, value: func(f *FlowFeatures) float64 { return float64(f.ACK) }},
+	{Name: "URG Flag Count", Field: "URG", Module: "flags", Unit: UnitCount, Type: DataTypeInt, CICColumn: 55, value: func(f *FlowFeatures) float64 { return float64(f.URG) }},
+	{Name: "CWR Flag Count", Field: "CWR", Module: "flags", Unit: UnitCount, Type: DataTypeInt, CICColumn: 56, value: func(f *FlowFeatures) float64 { return float64(f.CWR) }},
+	{Name: "ECE Flag Count", Field: "ECE", Module: "flags", Unit: UnitCount, Type: DataTypeInt, CICColumn: 57, value: func(f *FlowFeatures) float64 { return float64(f.ECE) }},
+	{Name: "Down/Up Ratio", Field: "DownUpRatio", Module: "ratio", Unit: UnitRatio, Type: DataTypeFloat, CICColumn: 58, value: func(f *FlowFeatures) float64 { return f.DownUpRatio }},
+	{Name: "Average Packet Size", Field: "AvgPacketSize", Module: "packetlen", Unit: UnitBytes, Type: DataTypeFloat, CICColumn: 59, value: func(f *FlowFeatures) float64 { return f.AvgPacketSize }},
+	{Name: "Fwd Segment Size Avg", Field: "AvgFwdSegmentSize", Module: "packetlen", Unit: UnitBytes, Type: DataTypeFloat, CICColumn: 60, value: func(f *FlowFeatures) float64 { return f.AvgFwdSegmentSize }},
+	{Name: "Bwd Segment Size Avg", Field: "AvgBwdSegmentSize", Module: "packetlen", Unit: UnitBytes, Type: DataTypeFloat, CICColumn: 61, value: func(f *FlowFeatures) float64 { return f.AvgBwdSegmentSize }},
+	{Name: "Fwd Bytes/Bulk Avg", Field: "FwdAvgBytesPerBulk", Module: "bulk", Unit: UnitBytes, Type: DataTypeFloat, CICColumn: 63, value: func(f *FlowFeatures) float64 { return f.FwdAvgBytesPerBulk }},
+	{Name: "Fwd Packet/Bulk Avg", Field: "FwdAvgPacketsPerBulk", Module: "bulk", Unit: UnitPackets, Type: DataTypeFloat, CICColumn: 64, value: func(f *FlowFeatures) float64 { return f.FwdAvgPacketsPerBulk }},
+	{Name: "Fwd Bulk Rate Avg", Field: "FwdAvgBulkRate", Module: "bulk", Unit: UnitBytesPerSec, Type: DataTypeFloat, CICColumn: 65, value: func(f *FlowFeatures) float64 { return f.FwdAvgBulkRate }},
+	{Name: "Bwd Bytes/Bulk Avg", Field: "BwdAvgBytesPerBulk", Module: "bulk", Unit: UnitBytes, Type: DataTypeFloat, CICColumn: 66, value: func(f *FlowFeatures) float64 { return f.BwdAvgBytesPerBulk }},
+	{Name: "Bwd Packet/Bulk Avg", Field: "BwdAvgPacketsPerBulk", Module: "bulk", Unit: UnitPackets, Type: DataTypeFloat, CICColumn: 67, value: func(f *FlowFeatures) float64 { return f.BwdAvgPacketsPerBulk }},
+	{Name: "Bwd Bulk Rate Avg", Field: "BwdAvgBulkRate", Module: "bulk", Unit: UnitBytesPerSec, Type: DataTypeFloat, CICColumn: 68, value: func(f *FlowFeatures) float64 { return f.BwdAvgBulkRate }},
+	{Name: "Subflow Fwd Packets", Field: "SubflowFwdPackets", Module: "subflow", Unit: UnitPackets, Type: DataTypeFloat, CICColumn: 69, value: func(f *FlowFeatures) float64 { return f.SubflowFwdPackets }},
+	{Name: "Subflow Fwd Bytes", Field: "SubflowFwdBytes", Module: "subflow", Unit: UnitBytes, Type: DataTypeFloat, CICColumn: 70, value: func(f *FlowFeatures) float64 { return f.SubflowFwdBytes }},
+	{Name: "Subflow Bwd Packets", Field: "SubflowBwdPackets", Module: "subflow", Unit: UnitPackets, Type: DataTypeFloat, CICColumn: 71, value: func(f *FlowFeatures) float64 { return f.SubflowBwdPackets }},
+	{Name: "Subflow Bwd Bytes", Field: "SubflowBwdBytes", Module: "subflow", Unit: UnitBytes, Type: DataTypeFloat, CICColumn: 72, value: func(f *FlowFeatures) float64 { return f.SubflowBwdBytes }},
+	{Name: "FWD Init Win Bytes", Field: "InitWinBytesFwd", Module: "initwin", Unit: UnitBytes, Type: DataTypeInt, CICColumn: 73, value: func(f *FlowFeatures) float64 { return float64(f.InitWinBytesFwd) }},
+	{Name: "Bwd Init Win Bytes", Field: "InitWinBytesBwd", Module: "initwin", Unit: UnitBytes, Type: DataTypeInt, CICColumn: 74, value: func(f *FlowFeatures) float64 { return float64(f.InitWinBytesBwd) }},
+	{Name: "Fwd Act Data Pkts", Field: "ActDataPktFwd", Module: "rates", Unit: UnitPackets, Type: DataTypeInt, CICColumn: 75, value: func(f *FlowFeatures) float64 { return float64(f.ActDataPktFwd) }},
+	{Name: "Fwd Seg Size Min", Field: "MinSegSizeFwd", Module: "rates", Unit: UnitBytes, Type: DataTypeInt, CICColumn: 76, value: func(f *FlowFeatures) float64 { return float64(f.MinSegSizeFwd) }},
+	{Name: "Active Mean", Field: "ActiveTime.Mean", Module: "activeidle", Unit: UnitMicroseconds, Type: DataTypeFloat, CICColumn: 77, value: func(f *FlowFeatures) float64 { return f.ActiveTime.Mean }},
+	{Name: "Active Std", Field: "ActiveTime.Std", Module: "activeidle", Unit: UnitMicroseconds, Type: DataTypeFloat, CICColumn: 78, value: func(f *FlowFeatures) float64 { return f.ActiveTime.Std }},
+	{Name: "Active Max", Field: "ActiveTime.Max", Module: "activeidle", Unit: UnitMicroseconds, Type: DataTypeFloat, CICColumn: 79, value: func(f *FlowFeatures) float64 { return f.ActiveTime.Max }},
+	{Name: "Active Min", Field: "ActiveTime.Min", Module: "activeidle", Unit: UnitMicroseconds, Type: DataTypeFloat, CICColumn: 80, value: func(f *FlowFeatures) float64 { return f.ActiveTime.Min }},
+	{Name: "Idle Mean", Field: "IdleTime.Mean", Module: "activeidle", Unit: UnitMicroseconds, Type: DataTypeFloat, CICColumn: 81, value: func(f *FlowFeatures) float64 { return f.IdleTime.Mean }},
+	{Name: "Idle Std", Field: "IdleTime.Std", Module: "activeidle", Unit: UnitMicroseconds, Type: DataTypeFloat, CICColumn: 82, value: func(f *FlowFeatures) float64 { return f.IdleTime.Std }},
+	{Name: "Idle Max", Field: "IdleTime.Max", Module: "activeidle", Unit: UnitMicroseconds, Type: DataTypeFloat, CICColumn: 83, value: func(f *FlowFeatures) float64 { return f.IdleTime.Max }},
+	{Name: "Idle Min", Field: "IdleTime.Min", Module: "activeidle", Unit: UnitMicroseconds, Type: DataTypeFloat, CICColumn: 84, value: func(f *FlowFeatures) float64 { return f.IdleTime.Min }},
+}
+
+// extendedSchema lists the features goflowmeter computes beyond CICFlowMeter's columns.
+// They are not part of FeatureSchema, CICHeader or Vector, so CIC-compatible output is
+// unchanged.
+var extendedSchema = []FeatureDescriptor{
+	{Name: "Fwd Retransmissions", Field: "FwdRetransmissions", Module: "tcphealth", Unit: UnitPackets, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.FwdRetransmissions) }},
+	{Name: "Bwd Retransmissions", Field: "BwdRetransmissions", Module: "tcphealth", Unit: UnitPackets, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.BwdRetransmissions) }},
+	{Name: "Fwd Out Of Order", Field: "FwdOutOfOrder", Module: "tcphealth", Unit: UnitPackets, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.FwdOutOfOrder) }},
+	{Name: "Bwd Out Of Order", Field: "BwdOutOfOrder", Module: "tcphealth", Unit: UnitPackets, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.BwdOutOfOrder) }},
+	{Name: "Fwd Dup ACKs", Field: "FwdDupAcks", Module: "tcphealth", Unit: UnitPackets, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.FwdDupAcks) }},
+	{Name: "Bwd Dup ACKs", Field: "BwdDupAcks", Module: "tcphealth", Unit: UnitPackets, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.BwdDupAcks) }},
+	{Name: "Fwd Zero Window", Field: "FwdZeroWindow", Module: "tcphealth", Unit: UnitPackets, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.FwdZeroWindow) }},
+	{Name: "Bwd Zero Window", Field: "BwdZeroWindow", Module: "tcphealth", Unit: UnitPackets, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.BwdZeroWindow) }},
+	{Name: "Fwd Window Full", Field: "FwdWindowFull", Module: "tcphealth", Unit: UnitPackets, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.FwdWindowFull) }},
+	{Name: "Bwd Window Full", Field: "BwdWindowFull", Module: "tcphealth", Unit: UnitPackets, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.BwdWindowFull) }},
+	{Name: "Fwd MSS", Field: "FwdMSS", Module: "tcpopts", Unit: UnitBytes, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.FwdMSS) }},
+	{Name: "Bwd MSS", Field: "BwdMSS", Module: "tcpopts", Unit: UnitBytes, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.BwdMSS) }},
+	{Name: "Fwd Window Scale", Field: "FwdWindowScale", Module: "tcpopts", Unit: UnitCount, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.FwdWindowScale) }},
+	{Name: "Bwd Window Scale", Field: "BwdWindowScale", Module: "tcpopts", Unit: UnitCount, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.BwdWindowScale) }},
+	{Name: "Fwd Init Win Scaled", Field: "FwdInitWinScaled", Module: "tcpopts", Unit: UnitBytes, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.FwdInitWinScaled) }},
+	{Name: "Bwd Init Win Scaled", Field: "BwdInitWinScaled", Module: "tcpopts", Unit: UnitBytes, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.BwdInitWinScaled) }},
+	{Name: "Fwd SACK Permitted", Field: "FwdSACKPermitted", Module: "tcpopts", Unit: UnitCount, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.FwdSACKPermitted) }},
+	{Name: "Bwd SACK Permitted", Field: "BwdSACKPermitted", Module: "tcpopts", Unit: UnitCount, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.BwdSACKPermitted) }},
+	{Name: "Fwd Timestamps", Field: "FwdTimestamps", Module: "tcpopts", Unit: UnitCount, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.FwdTimestamps) }},
+	{Name: "Bwd Timestamps", Field: "BwdTimestamps", Module: "tcpopts", Unit: UnitCount, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.BwdTimestamps) }},
+	{Name: "Client RTT", Field: "ClientRTTUs", Module: "rtt", Unit: UnitMicroseconds, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.ClientRTTUs) }},
+	{Name: "Server RTT", Field: "ServerRTTUs", Module: "rtt", Unit: UnitMicroseconds, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.ServerRTTUs) }},
+	{Name: "RTT Mean", Field: "RTT.Mean", Module: "rtt", Unit: UnitMicroseconds, Type: DataTypeFloat, value: func(f *FlowFeatures) float64 { return f.RTT.Mean }},
+	{Name: "RTT Std", Field: "RTT.Std", Module: "rtt", Unit: UnitMicroseconds, Type: DataTypeFloat, value: func(f *FlowFeatures) float64 { return f.RTT.Std }},
+	{Name: "RTT Max", Field: "RTT.Max", Module: "rtt", Unit: UnitMicroseconds, Type: DataTypeFloat, value: func(f *FlowFeatures) float64 { return f.RTT.Max }},
+	{Name: "RTT Min", Field: "RTT.Min", Module: "rtt", Unit: UnitMicroseconds, Type: DataTypeFloat, value: func(f *FlowFeatures) float64 { return f.RTT.Min }},
+	{Name: "Fwd TTL Mean", Field: "FwdTTL.Mean", Module: "iplayer", Unit: UnitCount, Type: DataTypeFloat, value: func(f *FlowFeatures) float64 { return f.FwdTTL.Mean }},
+	{Name: "Fwd TTL Std", Field: "FwdTTL.Std", Module: "iplayer", Unit: UnitCount, Type: DataTypeFloat, value: func(f *FlowFeatures) float64 { return f.FwdTTL.Std }},
+	{Name: "Fwd TTL Max", Field: "FwdTTL.Max", Module: "iplayer", Unit: UnitCount, Type: DataTypeFloat, value: func(f *FlowFeatures) float64 { return f.FwdTTL.Max }},
+	{Name: "Fwd TTL Min", Field: "FwdTTL.Min", Module: "iplayer", Unit: UnitCount, Type: DataTypeFloat, value: func(f *FlowFeatures) float64 { return f.FwdTTL.Min }},
+	{Name: "Bwd TTL Mean", Field: "BwdTTL.Mean", Module: "iplayer", Unit: UnitCount, Type: DataTypeFloat, value: func(f *FlowFeatures) float64 { return f.BwdTTL.Mean }},
+	{Name: "Bwd TTL Std", Field: "BwdTTL.Std", Module: "iplayer", Unit: UnitCount, Type: DataTypeFloat, value: func(f *FlowFeatures) float64 { return f.BwdTTL.Std }},
+	{Name: "Bwd TTL Max", Field: "BwdTTL.Max", Module: "iplayer", Unit: UnitCount, Type: DataTypeFloat, value: func(f *FlowFeatures) float64 { return f.BwdTTL.Max }},
+	{Name: "Bwd TTL Min", Field: "BwdTTL.Min", Module: "iplayer", Unit: UnitCount, Type: DataTypeFloat, value: func(f *FlowFeatures) float64 { return f.BwdTTL.Min }},
+	{Name: "Fwd TTL Distinct", Field: "FwdTTLDistinct", Module: "iplayer", Unit: UnitCount, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.FwdTTLDistinct) }},
+	{Name: "Bwd TTL Distinct", Field: "BwdTTLDistinct", Module: "iplayer", Unit: UnitCount, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.BwdTTLDistinct) }},
+	{Name: "Fwd ECN CE", Field: "FwdECNCE", Module: "iplayer", Unit: UnitPackets, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.FwdECNCE) }},
+	{Name: "Bwd ECN CE", Field: "BwdECNCE", Module: "iplayer", Unit: UnitPackets, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.BwdECNCE) }},
+	{Name: "Fwd DSCP Distinct", Field: "FwdDSCPDistinct", Module: "iplayer", Unit: UnitCount, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.FwdDSCPDistinct) }},
+	{Name: "Bwd DSCP Distinct", Field: "BwdDSCPDistinct", Module: "iplayer", Unit: UnitCount, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.BwdDSCPDistinct) }},
+	{Name: "Fwd Fragments", Field: "FwdFragments", Module: "iplayer", Unit: UnitPackets, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.FwdFragments) }},
+	{Name: "Bwd Fragments", Field: "BwdFragments", Module: "iplayer", Unit: UnitPackets, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.BwdFragments) }},
+	{Name: "ICMP Type", Field: "ICMPType", Module: "icmp", Unit: UnitCount, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.ICMPType) }},
+	{Name: "ICMP Code", Field: "ICMPCode", Module: "icmp", Unit: UnitCount, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.ICMPCode) }},
+	{Name: "ICMP Distinct Types", Field: "ICMPDistinctTypes", Module: "icmp", Unit: UnitCount, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.ICMPDistinctTypes) }},
+	{Name: "ICMP Echo Requests", Field: "ICMPEchoRequests", Module: "icmp", Unit: UnitPackets, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.ICMPEchoRequests) }},
+	{Name: "ICMP Echo Replies", Field: "ICMPEchoReplies", Module: "icmp", Unit: UnitPackets, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.ICMPEchoReplies) }},
+	{Name: "ICMP Echo Unanswered", Field: "ICMPEchoUnanswered", Module: "icmp", Unit: UnitPackets, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.ICMPEchoUnanswered) }},
+	{Name: "ICMP Unreachable", Field: "ICMPUnreachable", Module: "icmp", Unit: UnitPackets, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.ICMPUnreachable) }},
+	{Name: "ICMP Time Exceeded", Field: "ICMPTimeExceeded", Module: "icmp", Unit: UnitPackets, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.ICMPTimeExceeded) }},
+	{Name: "ICMP Other", Field: "ICMPOther", Module: "icmp", Unit: UnitPackets, Type: DataTypeInt, value: func(f *FlowFeatures) float64 { return float64(f.ICMPOther) }},
+	{Name: "ICMP Echo RTT Mean", Field: "ICMPEchoRTT.Mean", Module: "icmp", Unit: UnitMicroseconds, Type: DataTypeFloat, value: func(f *FlowFeatures) float64 { return f.ICMPEchoRTT.Mean }},
+	{Name: "ICMP Echo RTT Std", Field: "ICMPEchoRTT.Std", Module: "icmp", Unit: UnitMicroseconds, Type: DataTypeFloat, value: func(f *FlowFeatures) float64 { return f.ICMPEchoRTT.Std }},
+	{Name: "ICMP Echo RTT Max", Field: "ICMPEchoRTT.Max", Module: "icmp", Unit: UnitMicroseconds, Type: DataTypeFloat, value: func(f *FlowFeatures) float64 { return f.ICMPEchoRTT.Max }},
+	{Name: "ICMP Echo RTT Min", Field: "ICMPEchoRTT.Min", Module: "icmp", Unit: UnitMicroseconds, Type: DataTypeFloat, value: func(f *FlowFeatures) float64 { return f.ICMPEchoRTT.Min }},
+}
+
+// FeatureSchema returns the ordered feature descriptors. The slice is a copy; the order
+// is the order of FeatureNames and FlowFeatures.Vector.
+func FeatureSchema() []FeatureDescriptor {
+	return append([]FeatureDescriptor(nil), featureSchema...)
+}
+
+// ExtendedFeatureSchema returns the descriptors of the non-CIC features (CICColumn 0).
+// The slice is a copy; read values with FeatureDescriptor.Value.
+func ExtendedFeatureSchema() []FeatureDescriptor {
+	return append([]FeatureDescriptor(nil), extendedSchema...)
+}
+
+// FeatureNames returns the feature names (CICFlowMeter column names) in schema order.
+func FeatureNames() []string {
+	names := make([]string, len(featureSchema))
+	for i, d := range featureSchema {
+		names[i] = d.Name
+	}
+	return names
+}
+
+// CICHeader returns the full CICFlowMeter CSV header: the flow identification columns
+// (Flow ID through Timestamp), the feature names, and Label.
+func CICHeader() []string {
+	h := make([]string, 0, len(cicIDColumns)+len(featureSchema)+1)
+	h = append(h, cicIDColumns...)
+	h = append(h, FeatureNames()...)
+	return append(h, "Label")
+}
+
+// Vector returns the feature values in schema order, for building ML matrices.
+func (f FlowFeatures) Vector() []float64 {
+	return f.AppendVector(make([]float64, 0, len(featureSchema)))
+}
+
+// Map returns every feature value by name: the CIC features, the extended ones and the
+// values of custom modules (see Registry).
+func (f FlowFeatures) Map() map[string]float64 {
+	m := make(map[string]float64, len(featureSchema)+len(extendedSchema)+len(f.Custom))
+	for _, d := range featureSchema {
+		m[d.Name] = d.value(&f)
+	}
+	for _, d := range extendedSchema {
+		m[d.Name] = d.value(&f)
+	}
+	for name, v := range f.Custom {
+		m[name] = v
+	}
+	return m
+}
+
+// AppendVector appends the feature values in schema order to dst and returns the
+// extended slice, so callers can reuse one buffer per row.
+func (f FlowFeatures) AppendVector(dst []float64) []float64 {
+	for _, d := range featureSchema {
+		dst = append(dst, d.value(&f))
+	}
+	return dst
+}
@@ -0,0 +1,86 @@
+package flowmeter
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFeatureSchema_NamesAndColumns(t *testing.T) {
+	schema := FeatureSchema()
+	names := FeatureNames()
+	if len(schema) != 76 || len(names) != len(schema) {
+		t.Fatalf("expected 76 CIC features, got %d descriptors and %d names", len(schema), len(names))
+	}
+	seen := make(map[string]bool)
+	prev := 7
+	for i, d := range schema {
+		if d.Name != names[i] {
+			t.Errorf("index %d: name %q != FeatureNames %q", i, d.Name, names[i])
+		}
+		if seen[d.Name] {
+			t.Errorf("duplicate feature name %q", d.Name)
+		}
+		seen[d.Name] = true
+		if d.CICColumn <= prev || d.CICColumn == 62 {
+			t.Errorf("%s: CIC column %d out of order after %d", d.Name, d.CICColumn, prev)
+		}
+		prev = d.CICColumn
+		if d.Field == "" || d.Unit == "" {
+			t.Errorf("%s: missing Field or Unit", d.Name)
+		}
+	}
+	if prev != 84 {
+		t.Errorf("expected last CIC column 84, got %d", prev)
+	}
+	h := CICHeader()
+	if len(h) != 84 || h[0] != "Flow ID" || h[6] != "Timestamp" || h[7] != "Flow Duration" || h[83] != "Label" {
+		t.Errorf("unexpected CIC header (len %d): %v", len(h), h)
+	}
+}
+
+func TestFlowFeatures_Vector(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	packets := []PacketInfo{
+		{Timestamp: base, HeaderLen: 20, PayloadSize: 100, Direction: Forward, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 1, DstPort: 2, Protocol: 6, SYN: true},
+		{Timestamp: base.Add(2 * time.Second), HeaderLen: 20, PayloadSize: 300, Direction: Backward, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 1, DstPort: 2, Protocol: 6, ACK: true},
+	}
+	f := ProcessPacketsWithKeys(packets)[0].Features
+	vec := f.Vector()
+	byName := make(map[string]float64)
+	for i, d := range FeatureSchema() {
+		byName[d.Name] = vec[i]
+		if got := d.Value(&f); got != vec[i] {
+			t.Errorf("%s: Value %f != Vector %f", d.Name, got, vec[i])
+		}
+	}
+	if byName["Flow Duration"] != 2e6 || byName["Total Bwd packets"] != 1 || byName["Bwd IAT Total"] != 0 || byName["Flow Bytes/s"] != 200 {
+		t.Errorf("unexpected vector values: %v", byName)
+	}
+	buf := make([]float64, 0, len(vec))
+	if got := f.AppendVector(buf); !reflect.DeepEqual(got, vec) {
+		t.Errorf("AppendVector differs from Vector")
+	}
+}
+
+func TestExtendedFeatureSchema(t *testing.T) {
+	cic := make(map[string]bool)
+	for _, name := range FeatureNames() {
+		cic[name] = true
+	}
+	f := FlowFeatures{FwdRetransmissions: 3, BwdWindowFull: 2}
+	byName := make(map[string]float64)
+	for _, d := range ExtendedFeatureSchema() {
+		if d.CICColumn != 0 || d.Field == "" || d.Unit == "" {
+			t.Errorf("%s: expected CICColumn 0 with Field and Unit, got %+v", d.Name, d)
+		}
+		if cic[d.Name] {
+			t.Errorf("duplicate feature name %q", d.Name)
+		}
+		cic[d.Name] = true
+		byName[d.Name] = d.Value(&f)
+	}
+	if byName["Fwd Retransmissions"] != 3 || byName["Bwd Window Full"] != 2 {
+		t.Errorf("unexpected extended values: %v", byName)
+	}
+}
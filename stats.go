@@ -60,3 +60,57 @@ func StatsFromValues(values []float64) Stats {
 	min, max, mean, std := MinMaxMeanStd(values)
 	return Stats{Min: min, Max: max, Mean: mean, Std: std}
 }
+
+// RunningStats is the streaming counterpart of MinMaxMeanStd: values are added one at a
+// time and memory stays constant. Mean is sum/n (as in MinMaxMeanStd); variance uses
+// Welford's online update, so results match the slice-based functions within float tolerance.
+// The zero value is ready to use.
+type RunningStats struct {
+	n        int
+	min, max float64
+	sum      float64
+	wMean    float64 // Welford running mean
+	m2       float64 // sum of squared differences from wMean
+}
+
+// Add adds one value.
+func (s *RunningStats) Add(v float64) {
+	s.n++
+	if s.n == 1 {
+		s.min, s.max = v, v
+	} else if v < s.min {
+		s.min = v
+	} else if v > s.max {
+		s.max = v
+	}
+	s.sum += v
+	d := v - s.wMean
+	s.wMean += d / float64(s.n)
+	s.m2 += d * (v - s.wMean)
+}
+
+// Count returns the number of values added.
+func (s *RunningStats) Count() int {
+	return s.n
+}
+
+// Sum returns the sum of values added.
+func (s *RunningStats) Sum() float64 {
+	return s.sum
+}
+
+// Variance returns sample variance. Returns 0 for fewer than 2 values.
+func (s *RunningStats) Variance() float64 {
+	if s.n < 2 {
+		return 0
+	}
+	return s.m2 / float64(s.n-1)
+}
+
+// Stats returns min, max, mean, and sample standard deviation, like StatsFromValues.
+func (s *RunningStats) Stats() Stats {
+	if s.n == 0 {
+		return Stats{}
+	}
+	return Stats{Min: s.min, Max: s.max, Mean: s.sum / float64(s.n), Std: math.Sqrt(s.Variance())}
+}
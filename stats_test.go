@@ -0,0 +1,39 @@
+package flowmeter
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRunningStats_MatchesMinMaxMeanStd(t *testing.T) {
+	values := []float64{3, 1e6, 17.5, 0, 42, 999_999.25, 7, 7, 1234.5}
+	var rs RunningStats
+	for _, v := range values {
+		rs.Add(v)
+	}
+	min, max, mean, std := MinMaxMeanStd(values)
+	got := rs.Stats()
+	if got.Min != min || got.Max != max || got.Mean != mean {
+		t.Errorf("min/max/mean: expected %f/%f/%f, got %f/%f/%f", min, max, mean, got.Min, got.Max, got.Mean)
+	}
+	if math.Abs(got.Std-std) > 1e-9*std {
+		t.Errorf("Std: expected %f, got %f", std, got.Std)
+	}
+	if v := Variance(values); math.Abs(rs.Variance()-v) > 1e-9*v {
+		t.Errorf("Variance: expected %f, got %f", v, rs.Variance())
+	}
+	if rs.Count() != len(values) {
+		t.Errorf("Count: expected %d, got %d", len(values), rs.Count())
+	}
+}
+
+func TestRunningStats_EmptyAndSingle(t *testing.T) {
+	var rs RunningStats
+	if (rs.Stats() != Stats{}) || rs.Variance() != 0 {
+		t.Errorf("empty: expected zero Stats, got %+v var=%f", rs.Stats(), rs.Variance())
+	}
+	rs.Add(5)
+	if (rs.Stats() != Stats{Min: 5, Max: 5, Mean: 5, Std: 0}) {
+		t.Errorf("single value: expected min=max=mean=5 std=0, got %+v", rs.Stats())
+	}
+}
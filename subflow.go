@@ -1,29 +1,32 @@
 package flowmeter
 
-const subflowIdleThresholdUs = 1_000_000 // 1 second in microseconds
-
-// computeSubflow fills average packets and bytes per subflow in each direction.
-// A subflow boundary is a gap > 1s between consecutive packets (any direction).
+// subflowState accumulates average packets and bytes per subflow in each direction.
+// A subflow boundary is a gap > Config.SubflowIdleThreshold (1s) between consecutive
+// packets (any direction).
 // CIC uses divisor = number of gaps (sfCount); when gaps == 0 they return 0.
-// Packets must be sorted by timestamp.
-func computeSubflow(packets []PacketInfo, f *FlowFeatures) {
-	if len(packets) < 2 {
-		return
-	}
-	gaps := 0
-	lastTs := packets[0].Timestamp.UnixMicro()
-	for i := 1; i < len(packets); i++ {
-		ts := packets[i].Timestamp.UnixMicro()
-		if (ts - lastTs) > subflowIdleThresholdUs {
-			gaps++
-		}
-		lastTs = ts
+// Packets must arrive sorted by timestamp; finalize reads the packet totals, so it must run after counts.
+type subflowState struct {
+	thresholdUs int64
+	n           int
+	lastTs      int64
+	gaps        int
+}
+
+func (s *subflowState) update(p *PacketInfo) {
+	ts := p.Timestamp.UnixMicro()
+	if s.n > 0 && (ts-s.lastTs) > s.thresholdUs {
+		s.gaps++
 	}
-	if gaps <= 0 {
+	s.n++
+	s.lastTs = ts
+}
+
+func (s *subflowState) finalize(f *FlowFeatures) {
+	if s.gaps <= 0 {
 		return // CIC: getSflow_* return 0 when sfCount <= 0
 	}
-	f.SubflowFwdPackets = float64(f.TotalFwdPackets) / float64(gaps)
-	f.SubflowFwdBytes = float64(f.TotalFwdBytes) / float64(gaps)
-	f.SubflowBwdPackets = float64(f.TotalBwdPackets) / float64(gaps)
-	f.SubflowBwdBytes = float64(f.TotalBwdBytes) / float64(gaps)
+	f.SubflowFwdPackets = float64(f.TotalFwdPackets) / float64(s.gaps)
+	f.SubflowFwdBytes = float64(f.TotalFwdBytes) / float64(s.gaps)
+	f.SubflowBwdPackets = float64(f.TotalBwdPackets) / float64(s.gaps)
+	f.SubflowBwdBytes = float64(f.TotalBwdBytes) / float64(s.gaps)
 }
@@ -0,0 +1,191 @@
+package flowmeter
+
+// maxSeqHoles is the number of sequence gaps tracked per direction. Segments that fill a
+// gap are out of order; older gaps are forgotten once more than this many are open.
+const maxSeqHoles = 4
+
+// seqLT reports a < b in 32-bit TCP sequence space (RFC 1982 serial arithmetic).
+func seqLT(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+// seqHole is a range [start, end) of sequence space skipped by a sender.
+type seqHole struct {
+	start, end uint32
+}
+
+// tcpDirState tracks the sequence space sent and the ACKs/windows advertised by one side.
+type tcpDirState struct {
+	seqKnown bool
+	maxEnd   uint32 // highest sequence number sent + 1
+	holes    [maxSeqHoles]seqHole
+	nHoles   int
+
+	ackKnown bool
+	lastAck  uint32
+	lastWin  uint16
+	winInSYN bool // lastWin came from a SYN, which is never scaled
+	synSeen  bool
+	wscale   int // window scale offered in the SYN; -1 if none
+
+	retrans, outOfOrder, dupAcks, zeroWindow, windowFull int
+}
+
+// tcpHealthState counts per-direction TCP retransmissions, out-of-order segments,
+// duplicate ACKs, zero-window advertisements and window-full events.
+//   - A segment (payload, SYN or FIN) that starts below the highest sequence number sent
+//     is out of order if it fills a gap left earlier, else a retransmission. Keep-alives
+//     (at most 1 byte at the highest sequence number - 1) are ignored.
+//   - A duplicate ACK follows RFC 5681: no data, no SYN/FIN, same ACK number and window as
+//     the previous ACK from that side, while the other side has unacknowledged data.
+//   - A zero window is any non-SYN/FIN/RST segment advertising window 0.
+//   - Window full: a data segment ends exactly at the right edge of the peer's last
+//     advertised window, scaled when both SYNs carried the window-scale option.
+//
+// Packets with SeqNum and AckNum both 0 and no SYN are treated as carrying no sequence
+// information and skipped, so callers that do not fill them get zero counts.
+// Packets must arrive sorted by timestamp.
+type tcpHealthState struct {
+	fwd, bwd tcpDirState
+}
+
+func (s *tcpHealthState) update(p *PacketInfo) {
+	if p.Protocol != 6 || (p.SeqNum == 0 && p.AckNum == 0 && !p.SYN) {
+		return
+	}
+	snd, rcv := &s.fwd, &s.bwd
+	if p.Direction == Backward {
+		snd, rcv = rcv, snd
+	}
+	if p.SYN && !snd.synSeen {
+		snd.synSeen, snd.wscale = true, -1
+	}
+	if p.SYN && p.TCPOptions.HasWindowScale {
+		snd.wscale = int(min(p.TCPOptions.WindowScale, maxWindowScale))
+	}
+	snd.updateSeq(p, rcv)
+	snd.updateAck(p, rcv)
+}
+
+func (s *tcpDirState) updateSeq(p *PacketInfo, peer *tcpDirState) {
+	segLen := uint32(p.PayloadSize)
+	if p.SYN {
+		segLen++
+	}
+	if p.FIN {
+		segLen++
+	}
+	if segLen == 0 {
+		return
+	}
+	end := p.SeqNum + segLen
+	if !s.seqKnown {
+		s.seqKnown = true
+		s.maxEnd = end
+	} else if p.PayloadSize <= 1 && !p.SYN && !p.FIN && p.SeqNum == s.maxEnd-1 {
+		return // keep-alive
+	} else if seqLT(s.maxEnd, p.SeqNum) {
+		s.addHole(s.maxEnd, p.SeqNum)
+		s.maxEnd = end
+	} else if seqLT(p.SeqNum, s.maxEnd) {
+		if s.fillHole(p.SeqNum, end) {
+			s.outOfOrder++
+		} else {
+			s.retrans++
+		}
+		if seqLT(s.maxEnd, end) {
+			s.maxEnd = end
+		}
+	} else {
+		s.maxEnd = end
+	}
+	if p.PayloadSize > 0 && !p.SYN && !p.FIN && !p.RST && peer.ackKnown && peer.lastWin > 0 &&
+		end == peer.lastAck+peer.window(s) {
+		s.windowFull++
+	}
+}
+
+// window returns s's last advertised window in bytes, shifted by its scale factor when
+// both s and peer offered window scaling in their SYNs.
+func (s *tcpDirState) window(peer *tcpDirState) uint32 {
+	if !s.winInSYN && s.synSeen && peer.synSeen && s.wscale >= 0 && peer.wscale >= 0 {
+		return uint32(s.lastWin) << uint(s.wscale)
+	}
+	return uint32(s.lastWin)
+}
+
+func (s *tcpDirState) updateAck(p *PacketInfo, peer *tcpDirState) {
+	if p.TCPWindow == 0 && !p.SYN && !p.FIN && !p.RST {
+		s.zeroWindow++
+	}
+	if !p.ACK {
+		return
+	}
+	if s.ackKnown && p.PayloadSize == 0 && !p.SYN && !p.FIN && !p.RST &&
+		p.AckNum == s.lastAck && p.TCPWindow == s.lastWin &&
+		peer.seqKnown && seqLT(p.AckNum, peer.maxEnd) {
+		s.dupAcks++
+	}
+	s.ackKnown = true
+	s.lastAck = p.AckNum
+	s.lastWin = p.TCPWindow
+	s.winInSYN = p.SYN
+}
+
+// addHole records the gap [start, end), dropping the oldest gap when all slots are used.
+func (s *tcpDirState) addHole(start, end uint32) {
+	if s.nHoles == maxSeqHoles {
+		copy(s.holes[:], s.holes[1:])
+		s.nHoles--
+	}
+	s.holes[s.nHoles] = seqHole{start, end}
+	s.nHoles++
+}
+
+// fillHole removes [start, end) from the recorded gaps and reports whether it overlapped
+// any of them. A segment in the middle of a gap splits it when a slot is free; otherwise
+// only the part below the segment is kept.
+func (s *tcpDirState) fillHole(start, end uint32) bool {
+	filled := false
+	for i := 0; i < s.nHoles; i++ {
+		h := &s.holes[i]
+		if !seqLT(start, h.end) || !seqLT(h.start, end) {
+			continue // no overlap
+		}
+		filled = true
+		coversStart, coversEnd := !seqLT(h.start, start), !seqLT(end, h.end)
+		switch {
+		case coversStart && coversEnd:
+			copy(s.holes[i:], s.holes[i+1:s.nHoles])
+			s.nHoles--
+			i--
+		case coversStart:
+			h.start = end
+		case coversEnd:
+			h.end = start
+		default:
+			tail := seqHole{end, h.end}
+			h.end = start
+			if s.nHoles < maxSeqHoles {
+				copy(s.holes[i+2:], s.holes[i+1:s.nHoles])
+				s.holes[i+1] = tail
+				s.nHoles++
+				i++
+			}
+		}
+	}
+	return filled
+}
+
+func (s *tcpHealthState) finalize(f *FlowFeatures) {
+	f.FwdRetransmissions = s.fwd.retrans
+	f.BwdRetransmissions = s.bwd.retrans
+	f.FwdOutOfOrder = s.fwd.outOfOrder
+	f.BwdOutOfOrder = s.bwd.outOfOrder
+	f.FwdDupAcks = s.fwd.dupAcks
+	f.BwdDupAcks = s.bwd.dupAcks
+	f.FwdZeroWindow = s.fwd.zeroWindow
+	f.BwdZeroWindow = s.bwd.zeroWindow
+	f.FwdWindowFull = s.fwd.windowFull
+	f.BwdWindowFull = s.bwd.windowFull
+}
@@ -0,0 +1,103 @@
+package flowmeter
+
+import (
+	"testing"
+	"time"
+)
+
+// tcpSeg builds a TCP packet of the 1.1.1.1:1 <-> 2.2.2.2:2 flow at base+ms.
+func tcpSeg(base time.Time, ms int, dir Direction, seq, ack uint32, payload int, win uint16) PacketInfo {
+	return PacketInfo{
+		Timestamp: base.Add(time.Duration(ms) * time.Millisecond), Direction: dir, HeaderLen: 20, PayloadSize: payload,
+		TCPWindow: win, SeqNum: seq, AckNum: ack, ACK: true,
+		SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 1, DstPort: 2, Protocol: 6,
+	}
+}
+
+func TestProcessPackets_TCPHealth_Retransmission(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	packets := []PacketInfo{
+		tcpSeg(base, 0, Forward, 1000, 1, 100, 1000),
+		tcpSeg(base, 1, Forward, 1100, 1, 100, 1000),
+		tcpSeg(base, 300, Forward, 1000, 1, 100, 1000), // resend of the first segment
+		tcpSeg(base, 301, Backward, 1, 1200, 0, 1000),
+	}
+	f := ProcessPacketsWithKeys(packets)[0].Features
+	if f.FwdRetransmissions != 1 || f.FwdOutOfOrder != 0 || f.BwdRetransmissions != 0 {
+		t.Errorf("expected 1 fwd retransmission and no out-of-order, got retrans=%d/%d ooo=%d", f.FwdRetransmissions, f.BwdRetransmissions, f.FwdOutOfOrder)
+	}
+}
+
+func TestProcessPackets_TCPHealth_OutOfOrder(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	packets := []PacketInfo{
+		tcpSeg(base, 0, Forward, 1000, 1, 100, 1000),
+		tcpSeg(base, 1, Forward, 1200, 1, 100, 1000), // skips 1100-1200
+		tcpSeg(base, 2, Forward, 1100, 1, 100, 1000), // fills the gap
+		tcpSeg(base, 3, Forward, 1100, 1, 100, 1000), // gap already filled: retransmission
+	}
+	f := ProcessPacketsWithKeys(packets)[0].Features
+	if f.FwdOutOfOrder != 1 || f.FwdRetransmissions != 1 {
+		t.Errorf("expected 1 out-of-order and 1 retransmission, got ooo=%d retrans=%d", f.FwdOutOfOrder, f.FwdRetransmissions)
+	}
+}
+
+func TestProcessPackets_TCPHealth_SequenceWrap(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	packets := []PacketInfo{
+		tcpSeg(base, 0, Forward, 0xffffff9c, 1, 100, 1000), // ends at 0
+		tcpSeg(base, 1, Forward, 0, 1, 100, 1000),
+		tcpSeg(base, 2, Forward, 100, 1, 100, 1000),
+	}
+	f := ProcessPacketsWithKeys(packets)[0].Features
+	if f.FwdRetransmissions != 0 || f.FwdOutOfOrder != 0 {
+		t.Errorf("expected in-order segments across the wrap, got retrans=%d ooo=%d", f.FwdRetransmissions, f.FwdOutOfOrder)
+	}
+}
+
+func TestProcessPackets_TCPHealth_DupAcksAndZeroWindow(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	packets := []PacketInfo{
+		tcpSeg(base, 0, Forward, 1000, 1, 100, 1000),
+		tcpSeg(base, 1, Forward, 1100, 1, 100, 1000),
+		tcpSeg(base, 2, Forward, 1200, 1, 100, 1000),
+		tcpSeg(base, 3, Backward, 1, 1100, 0, 5000),
+		tcpSeg(base, 4, Backward, 1, 1100, 0, 5000), // dup ACK
+		tcpSeg(base, 5, Backward, 1, 1100, 0, 5000), // dup ACK
+		tcpSeg(base, 6, Backward, 1, 1100, 0, 6000), // window update, not a dup
+		tcpSeg(base, 7, Backward, 1, 1300, 0, 0),    // everything acked, window closed
+		tcpSeg(base, 8, Backward, 1, 1300, 0, 0),    // no outstanding data: not a dup
+	}
+	f := ProcessPacketsWithKeys(packets)[0].Features
+	if f.BwdDupAcks != 2 || f.FwdDupAcks != 0 {
+		t.Errorf("expected 2 bwd dup ACKs, got fwd=%d bwd=%d", f.FwdDupAcks, f.BwdDupAcks)
+	}
+	if f.BwdZeroWindow != 2 || f.FwdZeroWindow != 0 {
+		t.Errorf("expected 2 bwd zero-window packets, got fwd=%d bwd=%d", f.FwdZeroWindow, f.BwdZeroWindow)
+	}
+}
+
+func TestProcessPackets_TCPHealth_WindowFull(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	packets := []PacketInfo{
+		tcpSeg(base, 0, Backward, 1, 1000, 0, 200), // receiver offers 1000..1200
+		tcpSeg(base, 1, Forward, 1000, 2, 100, 1000),
+		tcpSeg(base, 2, Forward, 1100, 2, 100, 1000), // ends at 1200: window full
+	}
+	f := ProcessPacketsWithKeys(packets)[0].Features
+	if f.FwdWindowFull != 1 || f.BwdWindowFull != 0 {
+		t.Errorf("expected 1 fwd window-full event, got fwd=%d bwd=%d", f.FwdWindowFull, f.BwdWindowFull)
+	}
+}
+
+func TestProcessPackets_TCPHealth_NoSequenceNumbers(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	packets := []PacketInfo{
+		{Timestamp: base, PayloadSize: 100, Direction: Forward, TCPWindow: 0, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 1, DstPort: 2, Protocol: 6, ACK: true},
+		{Timestamp: base.Add(time.Millisecond), PayloadSize: 100, Direction: Forward, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 1, DstPort: 2, Protocol: 6, ACK: true},
+	}
+	f := ProcessPacketsWithKeys(packets)[0].Features
+	if f.FwdRetransmissions != 0 || f.FwdZeroWindow != 0 || f.FwdDupAcks != 0 {
+		t.Errorf("expected zero TCP health counts without sequence numbers, got %+v", f)
+	}
+}
@@ -0,0 +1,128 @@
+package flowmeter
+
+// MaxTCPOptionKinds is the number of option kinds TCPOptions.Kinds records.
+const MaxTCPOptionKinds = 16
+
+// TCPOptions holds the TCP header options used for flow features. The zero value means
+// no options (or a non-TCP packet).
+type TCPOptions struct {
+	MSS            uint16 // maximum segment size (kind 2); valid if HasMSS
+	WindowScale    uint8  // window scale shift count (kind 3); valid if HasWindowScale
+	TSVal, TSEcr   uint32 // timestamp value and echo reply (kind 8); valid if HasTimestamps
+	HasMSS         bool
+	HasWindowScale bool
+	SACKPermitted  bool // kind 4
+	HasTimestamps  bool
+
+	// Option layout for fingerprinting: the kinds in header order (NOP and EOL included,
+	// at most MaxTCPOptionKinds), the bytes following an EOL, whether any of them is
+	// non-zero, and whether parsing stopped at a malformed option.
+	Kinds        [MaxTCPOptionKinds]uint8
+	NumKinds     uint8
+	EOLPadding   uint8
+	DataAfterEOL bool
+	Malformed    bool
+}
+
+// maxWindowScale is the largest shift RFC 7323 allows; larger values are treated as 14.
+const maxWindowScale = 14
+
+// tcpOptsDirState records what one side announced in its SYN and its first window after
+// the handshake.
+type tcpOptsDirState struct {
+	synSeen     bool
+	mss         int
+	wscale      int // -1 if not offered
+	sackOK      bool
+	timestamps  bool // any packet carried the timestamps option
+	initWinSeen bool
+	initWin     int64 // first non-SYN window, unscaled
+	synWin      int64
+}
+
+// tcpOptsState derives MSS, window scale, SACK-permitted and timestamp features from TCP
+// options, plus the effective initial windows: the first window each side advertises
+// after its SYN, shifted by its scale factor when both SYNs carried the window-scale
+// option (RFC 7323 never scales the window in a SYN itself). A side whose only packets
+// are SYNs reports its SYN window.
+type tcpOptsState struct {
+	fwd, bwd tcpOptsDirState
+}
+
+func (s *tcpOptsState) update(p *PacketInfo) {
+	if p.Protocol != 6 {
+		return
+	}
+	d := &s.fwd
+	if p.Direction == Backward {
+		d = &s.bwd
+	}
+	o := &p.TCPOptions
+	if o.HasTimestamps {
+		d.timestamps = true
+	}
+	if p.SYN {
+		if !d.synSeen {
+			d.synSeen = true
+			d.wscale = -1
+			d.synWin = int64(p.TCPWindow)
+		}
+		if o.HasMSS {
+			d.mss = int(o.MSS)
+		}
+		if o.HasWindowScale {
+			d.wscale = int(min(o.WindowScale, maxWindowScale))
+		}
+		if o.SACKPermitted {
+			d.sackOK = true
+		}
+		return
+	}
+	if !d.initWinSeen {
+		d.initWinSeen = true
+		d.initWin = int64(p.TCPWindow)
+	}
+}
+
+// scaleNegotiated reports whether both sides offered window scaling in their SYNs.
+func (s *tcpOptsState) scaleNegotiated() bool {
+	return s.fwd.synSeen && s.bwd.synSeen && s.fwd.wscale >= 0 && s.bwd.wscale >= 0
+}
+
+func (d *tcpOptsDirState) effectiveInitWin(scaled bool) int64 {
+	if !d.initWinSeen {
+		return d.synWin
+	}
+	if scaled {
+		return d.initWin << uint(d.wscale)
+	}
+	return d.initWin
+}
+
+func (d *tcpOptsDirState) windowScale() int {
+	if !d.synSeen {
+		return -1
+	}
+	return d.wscale
+}
+
+func (s *tcpOptsState) finalize(f *FlowFeatures) {
+	scaled := s.scaleNegotiated()
+	f.FwdMSS = s.fwd.mss
+	f.BwdMSS = s.bwd.mss
+	f.FwdWindowScale = s.fwd.windowScale()
+	f.BwdWindowScale = s.bwd.windowScale()
+	f.FwdInitWinScaled = s.fwd.effectiveInitWin(scaled)
+	f.BwdInitWinScaled = s.bwd.effectiveInitWin(scaled)
+	f.FwdSACKPermitted = boolToInt(s.fwd.sackOK)
+	f.BwdSACKPermitted = boolToInt(s.bwd.sackOK)
+	f.FwdTimestamps = boolToInt(s.fwd.timestamps)
+	f.BwdTimestamps = boolToInt(s.bwd.timestamps)
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,73 @@
+package flowmeter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProcessPackets_TCPOptions_Negotiated(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	syn := tcpSeg(base, 0, Forward, 100, 0, 0, 64240)
+	syn.SYN, syn.ACK = true, false
+	syn.TCPOptions = TCPOptions{MSS: 1460, HasMSS: true, WindowScale: 7, HasWindowScale: true, SACKPermitted: true, HasTimestamps: true}
+	synAck := tcpSeg(base, 1, Backward, 500, 101, 0, 65160)
+	synAck.SYN = true
+	synAck.TCPOptions = TCPOptions{MSS: 1400, HasMSS: true, WindowScale: 9, HasWindowScale: true}
+	ack := tcpSeg(base, 2, Forward, 101, 501, 0, 502)
+	data := tcpSeg(base, 3, Backward, 501, 101, 100, 510)
+	f := ProcessPacketsWithKeys([]PacketInfo{syn, synAck, ack, data})[0].Features
+	if f.FwdMSS != 1460 || f.BwdMSS != 1400 {
+		t.Errorf("MSS: expected 1460/1400, got %d/%d", f.FwdMSS, f.BwdMSS)
+	}
+	if f.FwdWindowScale != 7 || f.BwdWindowScale != 9 {
+		t.Errorf("WindowScale: expected 7/9, got %d/%d", f.FwdWindowScale, f.BwdWindowScale)
+	}
+	if f.FwdInitWinScaled != 502<<7 || f.BwdInitWinScaled != 510<<9 {
+		t.Errorf("InitWinScaled: expected %d/%d, got %d/%d", 502<<7, 510<<9, f.FwdInitWinScaled, f.BwdInitWinScaled)
+	}
+	if f.FwdSACKPermitted != 1 || f.BwdSACKPermitted != 0 || f.FwdTimestamps != 1 || f.BwdTimestamps != 0 {
+		t.Errorf("SACK/TS: expected 1/0 and 1/0, got %d/%d and %d/%d", f.FwdSACKPermitted, f.BwdSACKPermitted, f.FwdTimestamps, f.BwdTimestamps)
+	}
+	// CIC's InitWinBytes stay raw
+	if f.InitWinBytesFwd != 64240 {
+		t.Errorf("InitWinBytesFwd: expected raw 64240, got %d", f.InitWinBytesFwd)
+	}
+}
+
+func TestProcessPackets_TCPOptions_ScaleNotNegotiated(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	syn := tcpSeg(base, 0, Forward, 100, 0, 0, 64240)
+	syn.SYN, syn.ACK = true, false
+	syn.TCPOptions = TCPOptions{WindowScale: 7, HasWindowScale: true}
+	synAck := tcpSeg(base, 1, Backward, 500, 101, 0, 65160)
+	synAck.SYN = true
+	ack := tcpSeg(base, 2, Forward, 101, 501, 0, 502)
+	f := ProcessPacketsWithKeys([]PacketInfo{syn, synAck, ack})[0].Features
+	if f.FwdWindowScale != 7 || f.BwdWindowScale != -1 {
+		t.Errorf("WindowScale: expected 7/-1, got %d/%d", f.FwdWindowScale, f.BwdWindowScale)
+	}
+	// Only one side offered scaling: windows are not scaled; backward only sent a SYN-ACK
+	if f.FwdInitWinScaled != 502 || f.BwdInitWinScaled != 65160 {
+		t.Errorf("InitWinScaled: expected 502/65160, got %d/%d", f.FwdInitWinScaled, f.BwdInitWinScaled)
+	}
+}
+
+func TestProcessPackets_TCPOptions_WindowFullScaled(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	syn := tcpSeg(base, 0, Forward, 999, 0, 0, 1000)
+	syn.SYN, syn.ACK = true, false
+	syn.TCPOptions = TCPOptions{WindowScale: 2, HasWindowScale: true}
+	synAck := tcpSeg(base, 1, Backward, 1, 1000, 0, 1000)
+	synAck.SYN = true
+	synAck.TCPOptions = TCPOptions{WindowScale: 1, HasWindowScale: true}
+	packets := []PacketInfo{
+		syn, synAck,
+		tcpSeg(base, 2, Backward, 2, 1000, 0, 100), // 100 << 1 = 200 bytes
+		tcpSeg(base, 3, Forward, 1000, 2, 100, 1000),
+		tcpSeg(base, 4, Forward, 1100, 2, 100, 1000), // ends at 1200: window full
+	}
+	f := ProcessPacketsWithKeys(packets)[0].Features
+	if f.FwdWindowFull != 1 {
+		t.Errorf("expected 1 fwd window-full event with scaled window, got %d", f.FwdWindowFull)
+	}
+}
@@ -0,0 +1,50 @@
+package flowmeter
+
+import "net/netip"
+
+// TunnelType identifies the encapsulation a packet was carried in.
+type TunnelType uint8
+
+const (
+	TunnelNone   TunnelType = iota
+	TunnelGRE               // GRE carrying IP or Ethernet
+	TunnelERSPAN            // ERSPAN type I, II or III over GRE
+	TunnelVXLAN             // VXLAN (UDP 4789)
+	TunnelGENEVE            // GENEVE (UDP 6081)
+	TunnelIPIP              // IPv4 or IPv6 directly in IPv4 or IPv6 (IP-in-IP, 6in4, 4in6)
+	TunnelGTPU              // GTP-U (UDP 2152)
+)
+
+var tunnelNames = [...]string{"", "GRE", "ERSPAN", "VXLAN", "GENEVE", "IPIP", "GTP-U"}
+
+func (t TunnelType) String() string {
+	if int(t) < len(tunnelNames) {
+		return tunnelNames[t]
+	}
+	return "unknown"
+}
+
+// TunnelInfo describes the tunnel a decapsulated packet arrived in; the zero value means
+// no tunnel. For nested tunnels it is the innermost one.
+type TunnelInfo struct {
+	Type    TunnelType
+	SrcAddr netip.Addr // outer source address (tunnel endpoint)
+	DstAddr netip.Addr // outer destination address (tunnel endpoint)
+	ID      uint32     // VNI (VXLAN, GENEVE), TEID (GTP-U), key (GRE) or session ID (ERSPAN); 0 if none
+}
+
+// tunnelState keeps the tunnel of the flow's first packet as flow metadata.
+type tunnelState struct {
+	seen   bool
+	tunnel TunnelInfo
+}
+
+func (s *tunnelState) update(p *PacketInfo) {
+	if !s.seen {
+		s.seen, s.tunnel = true, p.Tunnel
+	}
+}
+
+func (s *tunnelState) finalize(f *FlowFeatures) {
+	f.Tunnel = s.tunnel
+}
@@ -0,0 +1,36 @@
+package flowmeter
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestProcessPackets_Tunnel_Metadata(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	vxlan := TunnelInfo{Type: TunnelVXLAN, SrcAddr: netip.MustParseAddr("192.0.2.1"), DstAddr: netip.MustParseAddr("192.0.2.2"), ID: 4242}
+	raw := []RawPacket{
+		{Timestamp: base, HeaderLen: 20, PayloadSize: 10, SrcIP: "10.0.0.1", DstIP: "10.0.0.2", SrcPort: 1234, DstPort: 80, Protocol: 6, SYN: true, Tunnel: vxlan},
+		{Timestamp: base.Add(time.Millisecond), HeaderLen: 20, SrcIP: "10.0.0.2", DstIP: "10.0.0.1", SrcPort: 80, DstPort: 1234, Protocol: 6, SYN: true, ACK: true, Tunnel: vxlan},
+		{Timestamp: base, HeaderLen: 8, PayloadSize: 30, SrcIP: "10.0.0.3", DstIP: "10.0.0.4", SrcPort: 53, DstPort: 53, Protocol: 17},
+	}
+	flows := ProcessPacketsWithKeys(ConvertToPacketInfo(raw))
+	if len(flows) != 2 {
+		t.Fatalf("expected 2 flows, got %d", len(flows))
+	}
+	for _, fl := range flows {
+		switch fl.Key.Protocol {
+		case 6:
+			if fl.Features.Tunnel != vxlan {
+				t.Errorf("expected tunnel %+v, got %+v", vxlan, fl.Features.Tunnel)
+			}
+		case 17:
+			if fl.Features.Tunnel.Type != TunnelNone {
+				t.Errorf("expected no tunnel, got %+v", fl.Features.Tunnel)
+			}
+		}
+	}
+	if TunnelVXLAN.String() != "VXLAN" || TunnelGTPU.String() != "GTP-U" {
+		t.Errorf("expected VXLAN and GTP-U, got %s and %s", TunnelVXLAN, TunnelGTPU)
+	}
+}
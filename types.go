@@ -1,6 +1,9 @@
 package flowmeter
 
-import "time"
+import (
+	"net/netip"
+	"time"
+)
 
 // Direction is forward (initiator→responder) or backward (responder→initiator).
 // Caller assigns direction when building PacketInfo (e.g. first packet of flow = forward).
@@ -12,12 +15,32 @@ const (
 )
 
 // FlowKey identifies a flow (5-tuple). Comparable for use as map key.
+// Addresses are stored as netip.Addr so equal addresses give equal keys whatever their
+// textual form; SrcIP and DstIP return them as strings.
+// The fields after Protocol extend the key when Config.KeyFields selects them (e.g. to
+// keep overlapping address spaces of different VLANs or tenants apart); they are zero
+// otherwise.
 type FlowKey struct {
-	SrcIP     string
-	DstIP     string
+	SrcAddr   netip.Addr
+	DstAddr   netip.Addr
 	SrcPort   uint16
 	DstPort   uint16
 	Protocol  uint8 // e.g. 6=TCP, 17=UDP
+	VLANID    uint16 // KeyVLAN
+	VNI       uint32 // KeyVNI: tunnel ID (VXLAN / GENEVE VNI, GTP-U TEID, GRE key)
+	ObservationDomain uint32 // KeyObservationDomain: capture interface or exporter domain
+	SrcMAC    MAC    // KeyMAC
+	DstMAC    MAC    // KeyMAC
+}
+
+// SrcIP returns the source address in its canonical text form ("" if unset).
+func (k FlowKey) SrcIP() string {
+	return addrString(k.SrcAddr)
+}
+
+// DstIP returns the destination address in its canonical text form ("" if unset).
+func (k FlowKey) DstIP() string {
+	return addrString(k.DstAddr)
 }
 
 // PacketInfo is the minimal input for flow feature extraction.
@@ -25,9 +48,30 @@ type FlowKey struct {
 type PacketInfo struct {
 	Timestamp   time.Time
 	Direction   Direction
-	HeaderLen   int      // TCP or UDP header length in bytes
+	HeaderLen   int      // TCP, UDP or ICMP header length in bytes
 	PayloadSize int      // TCP or UDP payload size in bytes
 	TCPWindow   uint16   // TCP window size (from TCP header); 0 for non-TCP or if unknown
+	SeqNum      uint32   // TCP sequence number; 0 for non-TCP or if unknown
+	AckNum      uint32   // TCP acknowledgment number; 0 for non-TCP or if unknown
+	TCPOptions  TCPOptions
+	ICMPType    uint8    // ICMP / ICMPv6 type; 0 for other protocols
+	ICMPCode    uint8    // ICMP / ICMPv6 code
+	ICMPID      uint16   // identifier of ICMP queries and replies (echo, ...); 0 otherwise
+	ICMPSeq     uint16   // sequence number of ICMP queries and replies; 0 otherwise
+	TTL         uint8    // IPv4 TTL or IPv6 hop limit; 0 if unknown
+	DSCP        uint8    // differentiated services code point (upper 6 bits of TOS / traffic class)
+	ECN         uint8    // ECN field (lower 2 bits of TOS / traffic class); 3 = CE
+	DontFragment bool    // IPv4 DF flag
+	MoreFragments bool   // IPv4 MF flag or IPv6 fragment header M flag
+	FragmentOffset uint16 // fragment offset in bytes; 0 for the first or an unfragmented datagram
+	Fragments   int      // IP fragments the packet was reassembled from; 0 if it was not reassembled
+	IPID        uint32   // IPv4 identification, or the IPv6 fragment header's; 0 if none
+	Tunnel      TunnelInfo // tunnel the packet was decapsulated from; zero if none
+	VLANID      uint16   // outermost 802.1Q VLAN ID; 0 if untagged
+	ObservationDomain uint32 // capture interface or observation domain ID; 0 if unknown
+	SrcMAC      MAC      // Ethernet source address; zero if unknown
+	DstMAC      MAC      // Ethernet destination address; zero if unknown
+	Swapped     bool     // SrcIP:SrcPort and DstIP:DstPort are the flow's, reversed from the packet's own (set by ConvertToPacketInfo)
 	SrcIP       string
 	DstIP       string
 	SrcPort     uint16
@@ -43,17 +87,25 @@ type PacketInfo struct {
 	ECE         bool
 }
 
-// Key returns the flow key for this packet (for grouping).
+// Key returns the flow key for this packet (for grouping). SrcIP and DstIP are parsed
+// with ParseAddr; a string that is not an IP address gives the zero netip.Addr.
 func (p PacketInfo) Key() FlowKey {
 	return FlowKey{
-		SrcIP:    p.SrcIP,
-		DstIP:    p.DstIP,
+		SrcAddr:  ParseAddr(p.SrcIP),
+		DstAddr:  ParseAddr(p.DstIP),
 		SrcPort:  p.SrcPort,
 		DstPort:  p.DstPort,
 		Protocol: p.Protocol,
 	}
 }
 
+// KeyWith returns Key extended with the fields selected by fields.
+func (p PacketInfo) KeyWith(fields KeyFields) FlowKey {
+	k := p.Key()
+	fields.extend(&k, p.VLANID, p.Tunnel.ID, p.ObservationDomain, p.SrcMAC, p.DstMAC)
+	return k
+}
+
 // Stats holds min, max, mean, std for reuse across feature groups.
 type Stats struct {
 	Min  float64
@@ -128,8 +180,74 @@ type FlowFeatures struct {
 	SubflowBwdBytes      float64
 	InitWinBytesFwd      int64
 	InitWinBytesBwd      int64
+	FwdOSFingerprint     string // p0f raw signature of the forward SYN (osfp.go); "" if none
+	BwdOSFingerprint     string // p0f raw signature of the backward SYN-ACK; "" if none
+	FwdOSLabel           string // best p0f match for the forward SYN, class:name:flavor; "" if none
+	BwdOSLabel           string // best p0f match for the backward SYN-ACK; "" if none
 	ActDataPktFwd        int
 	MinSegSizeFwd        int
 	ActiveTime           Stats
 	IdleTime             Stats
+
+	// TCP health (tcphealth.go); not part of CICFlowMeter's output
+	FwdRetransmissions int
+	BwdRetransmissions int
+	FwdOutOfOrder      int
+	BwdOutOfOrder      int
+	FwdDupAcks         int
+	BwdDupAcks         int
+	FwdZeroWindow      int
+	BwdZeroWindow      int
+	FwdWindowFull      int
+	BwdWindowFull      int
+
+	// TCP options (tcpopts.go); not part of CICFlowMeter's output
+	FwdMSS           int   // MSS option of the forward SYN; 0 if absent
+	BwdMSS           int   // MSS option of the backward SYN(-ACK); 0 if absent
+	FwdWindowScale   int   // window scale shift offered by the forward side; -1 if absent
+	BwdWindowScale   int   // window scale shift offered by the backward side; -1 if absent
+	FwdInitWinScaled int64 // first forward window after the SYN, scaled when negotiated
+	BwdInitWinScaled int64 // first backward window after the SYN-ACK, scaled when negotiated
+	FwdSACKPermitted int   // 1 if the forward SYN offered SACK
+	BwdSACKPermitted int   // 1 if the backward SYN(-ACK) offered SACK
+	FwdTimestamps    int   // 1 if any forward packet carried the timestamps option
+	BwdTimestamps    int   // 1 if any backward packet carried the timestamps option
+
+	// RTT (rtt.go); not part of CICFlowMeter's output
+	ClientRTTUs int64 // SYN-ACK -> ACK
+	ServerRTTUs int64 // SYN -> SYN-ACK
+	RTT         Stats // data -> ACK samples, microseconds
+
+	// IP layer (iplayer.go); not part of CICFlowMeter's output
+	FwdTTL          Stats  // TTL / hop limit of forward packets
+	BwdTTL          Stats  // TTL / hop limit of backward packets
+	FwdTTLDistinct  int    // number of distinct forward TTLs
+	BwdTTLDistinct  int    // number of distinct backward TTLs
+	FwdECNCE        int    // forward packets marked ECN-CE
+	BwdECNCE        int    // backward packets marked ECN-CE
+	FwdDSCPSeen     uint64 // bit i set if a forward packet with a known IP header (TTL != 0) carried DSCP i
+	BwdDSCPSeen     uint64 // bit i set if a backward packet with a known IP header carried DSCP i
+	FwdDSCPDistinct int    // number of distinct forward DSCP values
+	BwdDSCPDistinct int    // number of distinct backward DSCP values
+	FwdFragments    int    // forward IP fragments (MF set or non-zero offset, or reassembled)
+	BwdFragments    int    // backward IP fragments
+
+	// ICMP (icmp.go); not part of CICFlowMeter's output
+	ICMPType           int   // type of the first ICMP message; -1 for non-ICMP flows
+	ICMPCode           int   // code of the first ICMP message; -1 for non-ICMP flows
+	ICMPDistinctTypes  int   // number of distinct ICMP types
+	ICMPEchoRequests   int
+	ICMPEchoReplies    int
+	ICMPEchoUnanswered int   // echo requests without a matching reply
+	ICMPUnreachable    int   // destination unreachable messages
+	ICMPTimeExceeded   int
+	ICMPOther          int   // messages of any other type
+	ICMPEchoRTT        Stats // echo request -> reply, microseconds
+
+	// Tunnel (tunnel.go): metadata, not a feature
+	Tunnel TunnelInfo // tunnel of the flow's first packet; zero if not tunneled
+
+	// Custom holds the values of custom FeatureModules by feature name (module.go);
+	// nil when Config.Features registers none.
+	Custom map[string]float64
 }
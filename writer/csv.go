@@ -0,0 +1,135 @@
+package writer
+
+import (
+	"encoding/csv"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/Bi9River/goflowmeter"
+)
+
+// CSVWriter writes flows as CSV with CICFlowMeter's header and column order: Flow ID,
+// Src IP, Src Port, Dst IP, Dst Port, Protocol, Timestamp, the features and Label.
+// The CIC Flow ID (Options.CICFlowID), flow information (Options.FlowInfo) and extended
+// key (Options.KeyFields) columns follow Timestamp. Timestamp is the time of the flow's first packet
+// in Options.TimeLayout.
+type CSVWriter struct {
+	w      *csv.Writer
+	opts   Options
+	schema []flowmeter.FeatureDescriptor
+	keys   []keyColumn
+	header bool
+	row    []string
+	buf    []byte
+}
+
+// NewCSV returns a CSVWriter writing to w. The header is written with the first flow,
+// or by Close if there is none.
+func NewCSV(w io.Writer, opts Options) *CSVWriter {
+	return &CSVWriter{w: csv.NewWriter(w), opts: opts, schema: opts.schema(), keys: selectedKeyColumns(opts.KeyFields)}
+}
+
+// Header returns the CSV header.
+func (c *CSVWriter) Header() []string {
+	h := append([]string(nil), idColumns()...)
+	if c.opts.CICFlowID {
+		h = append(h, cicFlowIDColumn)
+	}
+	if c.opts.FlowInfo {
+		h = append(h, flowInfoColumns...)
+	}
+	for _, k := range c.keys {
+		h = append(h, k.name)
+	}
+	for _, d := range c.schema {
+		h = append(h, d.Name)
+	}
+	return append(h, "Label")
+}
+
+// Write writes one flow.
+func (c *CSVWriter) Write(fl flowmeter.FlowWithKey) error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	k := &fl.Key
+	row := append(c.row[:0],
+		FlowID(*k),
+		k.SrcIP(),
+		strconv.Itoa(int(k.SrcPort)),
+		k.DstIP(),
+		strconv.Itoa(int(k.DstPort)),
+		strconv.Itoa(int(k.Protocol)),
+		c.time(fl.Start),
+	)
+	if c.opts.CICFlowID {
+		row = append(row, FlowID(flowmeter.CICFlowKey(*k)))
+	}
+	if c.opts.FlowInfo {
+		row = append(row,
+			c.time(fl.End),
+			fl.Initiator.IP(),
+			strconv.Itoa(int(fl.Initiator.Port)),
+			fl.Reason.String(),
+		)
+	}
+	for _, kc := range c.keys {
+		if kc.mac {
+			row = append(row, kc.text(k))
+		} else {
+			row = append(row, strconv.FormatUint(kc.value(k), 10))
+		}
+	}
+	for i := range c.schema {
+		d := &c.schema[i]
+		v, keep, err := c.opts.finite(d.Name, d.Value(&fl.Features))
+		if err != nil {
+			return err
+		}
+		if keep {
+			row = append(row, javaDouble(v))
+			continue
+		}
+		c.buf = c.opts.appendValue(c.buf[:0], d, v)
+		row = append(row, string(c.buf))
+	}
+	row = append(row, c.opts.Label)
+	c.row = row
+	return c.w.Write(row)
+}
+
+// Close writes the header if no flow was written and flushes buffered rows.
+func (c *CSVWriter) Close() error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *CSVWriter) time(t time.Time) string {
+	c.buf = c.opts.appendTime(c.buf[:0], t)
+	return string(c.buf)
+}
+
+func (c *CSVWriter) writeHeader() error {
+	if c.header {
+		return nil
+	}
+	c.header = true
+	return c.w.Write(c.Header())
+}
+
+// javaDouble formats a non-finite value the way Java's Double.toString (and so
+// CICFlowMeter) does.
+func javaDouble(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case v > 0:
+		return "Infinity"
+	}
+	return "-Infinity"
+}
@@ -0,0 +1,150 @@
+package writer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Bi9River/goflowmeter"
+)
+
+func readCSV(t *testing.T, b []byte) [][]string {
+	t.Helper()
+	rows, err := csv.NewReader(bytes.NewReader(b)).ReadAll()
+	if err != nil {
+		t.Fatalf("reading CSV: %v", err)
+	}
+	return rows
+}
+
+func TestCSVWriter_CICColumns(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewCSV(&buf, Options{Label: "BENIGN"})
+	for _, fl := range testFlows(t) {
+		if err := w.Write(fl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rows := readCSV(t, buf.Bytes())
+	if len(rows) != 3 {
+		t.Fatalf("expected header + 2 flows, got %d rows", len(rows))
+	}
+	if !reflect.DeepEqual(rows[0], flowmeter.CICHeader()) {
+		t.Errorf("expected the CICFlowMeter header, got %v", rows[0])
+	}
+	row := rows[1]
+	if row[0] != "1.1.1.1-2.2.2.2-12345-80-6" || row[1] != "1.1.1.1" || row[2] != "12345" || row[5] != "6" || row[6] != "01/01/2025 12:00:00 AM" || row[83] != "BENIGN" {
+		t.Errorf("unexpected row: %v", row[:7])
+	}
+	// Flow Duration, packets and Fwd Packet Length Mean ((60+160)/2).
+	if row[7] != "3000000" || row[8] != "2" || row[9] != "1" || row[14] != "110" {
+		t.Errorf("expected 3000000 2 1 110, got %q %q %q %q", row[7], row[8], row[9], row[14])
+	}
+}
+
+func TestCSVWriter_HeaderWithoutFlows(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewCSV(&buf, Options{}).Close(); err != nil {
+		t.Fatal(err)
+	}
+	rows := readCSV(t, buf.Bytes())
+	if len(rows) != 1 || len(rows[0]) != 84 {
+		t.Errorf("expected only the 84-column header, got %v", rows)
+	}
+}
+
+func TestCSVWriter_KeyFields(t *testing.T) {
+	fl := testFlows(t)[0]
+	fl.Key.VLANID = 100
+	fl.Key.SrcMAC = flowmeter.MAC{0, 1, 2, 3, 4, 5}
+	var buf bytes.Buffer
+	w := NewCSV(&buf, Options{KeyFields: flowmeter.KeyVLAN | flowmeter.KeyMAC})
+	if err := w.Write(fl); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rows := readCSV(t, buf.Bytes())
+	if got := rows[0][6:10]; !reflect.DeepEqual(got, []string{"Timestamp", "VLAN ID", "Src MAC", "Dst MAC"}) {
+		t.Errorf("expected key columns after Timestamp, got %v", got)
+	}
+	if rows[1][7] != "100" || rows[1][8] != "00:01:02:03:04:05" || rows[0][10] != "Flow Duration" {
+		t.Errorf("expected VLAN 100 and the source MAC, got %v", rows[1][7:10])
+	}
+}
+
+func TestCSVWriter_FlowInfo(t *testing.T) {
+	fl := testFlows(t)[0]
+	var buf bytes.Buffer
+	w := NewCSV(&buf, Options{FlowInfo: true, TimeLayout: time.RFC3339})
+	if err := w.Write(fl); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rows := readCSV(t, buf.Bytes())
+	if got := rows[0][6:12]; !reflect.DeepEqual(got, []string{"Timestamp", "End Timestamp", "Initiator IP", "Initiator Port", "End Reason", "Flow Duration"}) {
+		t.Errorf("expected the flow information columns after Timestamp, got %v", got)
+	}
+	if got := rows[1][6:11]; !reflect.DeepEqual(got, []string{"2025-01-01T00:00:00Z", "2025-01-01T00:00:03Z", "1.1.1.1", "12345", "fin"}) {
+		t.Errorf("unexpected flow information %v", got)
+	}
+}
+
+func TestCSVWriter_Precision(t *testing.T) {
+	fl := withRatio(testFlows(t)[0], 2.0/3)
+	for _, tc := range []struct {
+		precision int
+		want      string
+	}{
+		{0, "0.6666666666666666"},
+		{3, "0.667"},
+	} {
+		var buf bytes.Buffer
+		w := NewCSV(&buf, Options{Schema: ratioSchema, Precision: tc.precision})
+		if err := w.Write(fl); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+		if got := readCSV(t, buf.Bytes())[1][7]; got != tc.want {
+			t.Errorf("precision %d: expected %s, got %s", tc.precision, tc.want, got)
+		}
+	}
+}
+
+func TestCSVWriter_NonFinite(t *testing.T) {
+	base := testFlows(t)[0]
+	for _, tc := range []struct {
+		policy NonFinite
+		v      float64
+		want   string
+	}{
+		{NonFiniteKeep, math.NaN(), "NaN"},
+		{NonFiniteKeep, math.Inf(1), "Infinity"},
+		{NonFiniteKeep, math.Inf(-1), "-Infinity"},
+		{NonFiniteZero, math.Inf(1), "0"},
+	} {
+		var buf bytes.Buffer
+		w := NewCSV(&buf, Options{Schema: ratioSchema, NonFinite: tc.policy})
+		if err := w.Write(withRatio(base, tc.v)); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+		if got := readCSV(t, buf.Bytes())[1][7]; got != tc.want {
+			t.Errorf("policy %d, %v: expected %s, got %s", tc.policy, tc.v, tc.want, got)
+		}
+	}
+	w := NewCSV(&bytes.Buffer{}, Options{Schema: ratioSchema, NonFinite: NonFiniteError})
+	if err := w.Write(withRatio(base, math.NaN())); !errors.Is(err, ErrNonFinite) {
+		t.Errorf("expected ErrNonFinite, got %v", err)
+	}
+}
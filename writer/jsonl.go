@@ -0,0 +1,130 @@
+package writer
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/Bi9River/goflowmeter"
+)
+
+// JSONLinesWriter writes one JSON object per flow and line. Keys are the ColumnName of
+// each CSV column ("flow_id", "src_ip", ..., "flow_duration", ..., "label"), always in
+// the same order; integers are written without a fraction. Timestamps are RFC 3339
+// strings with nanoseconds, or null if not recorded.
+type JSONLinesWriter struct {
+	w      *bufio.Writer
+	opts   Options
+	schema []flowmeter.FeatureDescriptor
+	keys   []keyColumn
+	names  []string // quoted keys of the features, with their ':'
+	label  []byte
+	buf    []byte
+}
+
+// NewJSONLines returns a JSONLinesWriter writing to w.
+func NewJSONLines(w io.Writer, opts Options) *JSONLinesWriter {
+	j := &JSONLinesWriter{w: bufio.NewWriter(w), opts: opts, schema: opts.schema(), keys: selectedKeyColumns(opts.KeyFields)}
+	for _, d := range j.schema {
+		j.names = append(j.names, `"`+ColumnName(d.Name)+`":`)
+	}
+	j.label = appendJSONString(nil, opts.Label)
+	return j
+}
+
+// Write writes one flow.
+func (j *JSONLinesWriter) Write(fl flowmeter.FlowWithKey) error {
+	k := &fl.Key
+	b := append(j.buf[:0], `{"flow_id":`...)
+	b = appendJSONString(b, FlowID(*k))
+	b = append(b, `,"src_ip":`...)
+	b = appendJSONString(b, k.SrcIP())
+	b = append(b, `,"src_port":`...)
+	b = strconv.AppendUint(b, uint64(k.SrcPort), 10)
+	b = append(b, `,"dst_ip":`...)
+	b = appendJSONString(b, k.DstIP())
+	b = append(b, `,"dst_port":`...)
+	b = strconv.AppendUint(b, uint64(k.DstPort), 10)
+	b = append(b, `,"protocol":`...)
+	b = strconv.AppendUint(b, uint64(k.Protocol), 10)
+	b = append(b, `,"timestamp":`...)
+	b = appendJSONTime(b, fl.Start)
+	if j.opts.CICFlowID {
+		b = append(b, `,"cic_flow_id":`...)
+		b = appendJSONString(b, FlowID(flowmeter.CICFlowKey(*k)))
+	}
+	if j.opts.FlowInfo {
+		b = append(b, `,"end_timestamp":`...)
+		b = appendJSONTime(b, fl.End)
+		b = append(b, `,"initiator_ip":`...)
+		b = appendJSONString(b, fl.Initiator.IP())
+		b = append(b, `,"initiator_port":`...)
+		b = strconv.AppendUint(b, uint64(fl.Initiator.Port), 10)
+		b = append(b, `,"end_reason":`...)
+		b = appendJSONString(b, fl.Reason.String())
+	}
+	for _, kc := range j.keys {
+		b = append(b, `,"`...)
+		b = append(b, ColumnName(kc.name)...)
+		b = append(b, `":`...)
+		if kc.mac {
+			b = appendJSONString(b, kc.text(k))
+		} else {
+			b = strconv.AppendUint(b, kc.value(k), 10)
+		}
+	}
+	for i := range j.schema {
+		d := &j.schema[i]
+		v, keep, err := j.opts.finite(d.Name, d.Value(&fl.Features))
+		if err != nil {
+			return err
+		}
+		b = append(b, ',')
+		b = append(b, j.names[i]...)
+		if keep {
+			b = append(b, "null"...)
+		} else {
+			b = j.opts.appendValue(b, d, v)
+		}
+	}
+	b = append(b, `,"label":`...)
+	b = append(b, j.label...)
+	b = append(b, "}\n"...)
+	j.buf = b
+	_, err := j.w.Write(b)
+	return err
+}
+
+// Close flushes buffered lines.
+func (j *JSONLinesWriter) Close() error {
+	return j.w.Flush()
+}
+
+// appendJSONTime appends t as an RFC 3339 string, or null for the zero time.
+func appendJSONTime(dst []byte, t time.Time) []byte {
+	if t.IsZero() {
+		return append(dst, "null"...)
+	}
+	dst = append(dst, '"')
+	dst = t.AppendFormat(dst, time.RFC3339Nano)
+	return append(dst, '"')
+}
+
+// appendJSONString appends s as a JSON string literal.
+func appendJSONString(dst []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	dst = append(dst, '"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			dst = append(dst, '\\', c)
+		case c < 0x20:
+			dst = append(dst, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+		default:
+			dst = append(dst, c)
+		}
+	}
+	return append(dst, '"')
+}
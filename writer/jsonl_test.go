@@ -0,0 +1,98 @@
+package writer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/Bi9River/goflowmeter"
+)
+
+func TestJSONLinesWriter_StableKeys(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewJSONLines(&buf, Options{Label: `say "hi"`, KeyFields: flowmeter.KeyVNI})
+	for _, fl := range testFlows(t) {
+		if err := w.Write(fl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d", len(lines))
+	}
+	var keys []string
+	dec := json.NewDecoder(strings.NewReader(lines[0]))
+	dec.Token() // {
+	for dec.More() {
+		tok, _ := dec.Token()
+		keys = append(keys, tok.(string))
+		var v any
+		if err := dec.Decode(&v); err != nil {
+			t.Fatalf("decoding line: %v", err)
+		}
+	}
+	if len(keys) != 7+1+76+1 || keys[0] != "flow_id" || keys[6] != "timestamp" || keys[7] != "vni" || keys[8] != "flow_duration" || keys[len(keys)-1] != "label" {
+		t.Errorf("unexpected keys (%d): %v", len(keys), keys)
+	}
+	var m map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["flow_id"] != "1.1.1.1-2.2.2.2-12345-80-6" || m["src_port"] != 12345.0 || m["timestamp"] != "2025-01-01T00:00:00Z" || m["flow_duration"] != 3e6 || m["fwd_packet_length_mean"] != 110.0 || m["label"] != `say "hi"` {
+		t.Errorf("unexpected values: %v", m)
+	}
+	// Integer features are written without a fraction.
+	if !strings.Contains(lines[0], `"flow_duration":3000000,`) {
+		t.Errorf("expected flow_duration as an integer, got %s", lines[0])
+	}
+}
+
+func TestJSONLinesWriter_NonFinite(t *testing.T) {
+	base := testFlows(t)[0]
+	for _, tc := range []struct {
+		policy NonFinite
+		want   string
+	}{
+		{NonFiniteKeep, `"ratio":null`},
+		{NonFiniteZero, `"ratio":0`},
+	} {
+		var buf bytes.Buffer
+		w := NewJSONLines(&buf, Options{Schema: ratioSchema, NonFinite: tc.policy})
+		if err := w.Write(withRatio(base, math.Inf(1))); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+		line, _ := bufio.NewReader(&buf).ReadString('\n')
+		if !strings.Contains(line, tc.want) {
+			t.Errorf("policy %d: expected %s, got %s", tc.policy, tc.want, line)
+		}
+		if !json.Valid([]byte(line)) {
+			t.Errorf("policy %d: invalid JSON %s", tc.policy, line)
+		}
+	}
+}
+
+func TestJSONLinesWriter_CICFlowID(t *testing.T) {
+	fl := testFlows(t)[0]
+	k := &fl.Key
+	k.SrcAddr, k.DstAddr, k.SrcPort, k.DstPort = k.DstAddr, k.SrcAddr, k.DstPort, k.SrcPort
+	var buf bytes.Buffer
+	w := NewJSONLines(&buf, Options{CICFlowID: true})
+	if err := w.Write(fl); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	var m map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["flow_id"] != "2.2.2.2-1.1.1.1-80-12345-6" || m["cic_flow_id"] != "1.1.1.1-2.2.2.2-12345-80-6" {
+		t.Errorf("expected the CIC Flow ID alongside the key's, got %v and %v", m["flow_id"], m["cic_flow_id"])
+	}
+}
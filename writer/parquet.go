@@ -0,0 +1,406 @@
+package writer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/Bi9River/goflowmeter"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+var errClosed = errors.New("writer: ParquetWriter is closed")
+
+// Compression is the codec of Parquet data pages.
+type Compression int
+
+const (
+	CompressionSnappy Compression = iota // the default, as in most Parquet writers
+	CompressionZstd
+	CompressionGzip
+	CompressionNone
+)
+
+// Parquet codec numbers (parquet.thrift CompressionCodec).
+var parquetCodecs = [...]int32{CompressionSnappy: 1, CompressionZstd: 6, CompressionGzip: 2, CompressionNone: 0}
+
+// DefaultRowGroupRows is the number of flows per Parquet row group when
+// ParquetOptions.RowGroupRows is 0.
+const DefaultRowGroupRows = 65536
+
+// ParquetOptions configures a ParquetWriter. Precision does not apply: values are stored
+// as INT64 and DOUBLE columns.
+type ParquetOptions struct {
+	Options
+	// Compression is the page codec; the zero value is snappy.
+	Compression Compression
+	// RowGroupRows is the number of flows buffered per row group; 0 uses
+	// DefaultRowGroupRows.
+	RowGroupRows int
+}
+
+// Parquet physical types (parquet.thrift Type).
+const (
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+)
+
+// Parquet encodings, page types and converted types used here.
+const (
+	encodingPlain            = 0
+	encodingRLE              = 3
+	pageTypeData             = 0
+	convertedUTF8            = 0
+	convertedTimestampMicros = 10
+	repetitionReq            = 0 // REQUIRED
+)
+
+// parquetColumn buffers the PLAIN-encoded values of one column of the current row group.
+type parquetColumn struct {
+	name      string
+	typ       int32
+	converted int32 // converted type, or -1
+	data      []byte
+}
+
+// columnMeta is what the footer records about one column chunk.
+type columnMeta struct {
+	offset           int64
+	compressedSize   int64
+	uncompressedSize int64
+	numValues        int64
+}
+
+// ParquetWriter writes flows as an Apache Parquet file with one required column per
+// CSV column: strings as UTF8 byte arrays, ports and protocol as INT32, timestamps as
+// INT64 TIMESTAMP_MICROS (UTC; 0 if not recorded), key fields and integer features as
+// INT64 and fractional features as DOUBLE. Column names are the
+// ColumnName of the CSV columns. Flows are buffered and written one row group at a time.
+type ParquetWriter struct {
+	w      io.Writer
+	opts   ParquetOptions
+	schema []flowmeter.FeatureDescriptor
+	keys   []keyColumn
+	cols   []parquetColumn
+	rows   int // in the current row group
+	offset int64
+	groups []rowGroupMeta
+	total  int64
+	zstd   *zstd.Encoder
+	values []float64 // feature values of the row being written
+	page   []byte    // compressed page buffer
+	err    error     // sticky write error; set after Close too
+}
+
+type rowGroupMeta struct {
+	columns []columnMeta
+	rows    int64
+	size    int64
+}
+
+// NewParquet returns a ParquetWriter writing to w.
+func NewParquet(w io.Writer, opts ParquetOptions) (*ParquetWriter, error) {
+	if opts.Compression < 0 || int(opts.Compression) >= len(parquetCodecs) {
+		return nil, fmt.Errorf("writer: unknown Parquet compression %d", opts.Compression)
+	}
+	if opts.RowGroupRows <= 0 {
+		opts.RowGroupRows = DefaultRowGroupRows
+	}
+	p := &ParquetWriter{w: w, opts: opts, schema: opts.schema(), keys: selectedKeyColumns(opts.KeyFields)}
+	add := func(name string, typ, converted int32) {
+		p.cols = append(p.cols, parquetColumn{name: ColumnName(name), typ: typ, converted: converted})
+	}
+	ids := idColumns()
+	add(ids[0], parquetByteArray, convertedUTF8) // Flow ID
+	add(ids[1], parquetByteArray, convertedUTF8) // Src IP
+	add(ids[2], parquetInt32, -1)
+	add(ids[3], parquetByteArray, convertedUTF8)
+	add(ids[4], parquetInt32, -1)
+	add(ids[5], parquetInt32, -1)
+	add(ids[6], parquetInt64, convertedTimestampMicros) // Timestamp
+	if opts.CICFlowID {
+		add(cicFlowIDColumn, parquetByteArray, convertedUTF8)
+	}
+	if opts.FlowInfo {
+		add(flowInfoColumns[0], parquetInt64, convertedTimestampMicros) // End Timestamp
+		add(flowInfoColumns[1], parquetByteArray, convertedUTF8)        // Initiator IP
+		add(flowInfoColumns[2], parquetInt32, -1)
+		add(flowInfoColumns[3], parquetByteArray, convertedUTF8)
+	}
+	for _, kc := range p.keys {
+		if kc.mac {
+			add(kc.name, parquetByteArray, convertedUTF8)
+		} else {
+			add(kc.name, parquetInt64, -1)
+		}
+	}
+	for _, d := range p.schema {
+		if d.Type == flowmeter.DataTypeInt {
+			add(d.Name, parquetInt64, -1)
+		} else {
+			add(d.Name, parquetDouble, -1)
+		}
+	}
+	add("Label", parquetByteArray, convertedUTF8)
+	if opts.Compression == CompressionZstd {
+		enc, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, err
+		}
+		p.zstd = enc
+	}
+	return p, nil
+}
+
+// Write buffers one flow, writing a row group when RowGroupRows flows are buffered.
+func (p *ParquetWriter) Write(fl flowmeter.FlowWithKey) error {
+	if p.err != nil {
+		return p.err
+	}
+	// Check the features first so a rejected flow leaves no partial row.
+	values := p.values[:0]
+	for i := range p.schema {
+		d := &p.schema[i]
+		v, _, err := p.opts.finite(d.Name, d.Value(&fl.Features))
+		if err != nil {
+			return err
+		}
+		values = append(values, v)
+	}
+	p.values = values
+	k := &fl.Key
+	c := p.cols
+	c[0].appendString(FlowID(*k))
+	c[1].appendString(k.SrcIP())
+	c[2].appendInt32(int32(k.SrcPort))
+	c[3].appendString(k.DstIP())
+	c[4].appendInt32(int32(k.DstPort))
+	c[5].appendInt32(int32(k.Protocol))
+	c[6].appendInt64(unixMicro(fl.Start))
+	c = c[7:]
+	if p.opts.CICFlowID {
+		c[0].appendString(FlowID(flowmeter.CICFlowKey(*k)))
+		c = c[1:]
+	}
+	if p.opts.FlowInfo {
+		c[0].appendInt64(unixMicro(fl.End))
+		c[1].appendString(fl.Initiator.IP())
+		c[2].appendInt32(int32(fl.Initiator.Port))
+		c[3].appendString(fl.Reason.String())
+		c = c[len(flowInfoColumns):]
+	}
+	for i, kc := range p.keys {
+		if kc.mac {
+			c[i].appendString(kc.text(k))
+		} else {
+			c[i].appendInt64(int64(kc.value(k)))
+		}
+	}
+	c = c[len(p.keys):]
+	for i, v := range values {
+		if c[i].typ == parquetInt64 {
+			c[i].appendInt64(int64(v))
+		} else {
+			c[i].appendDouble(v)
+		}
+	}
+	c[len(values)].appendString(p.opts.Label)
+	p.rows++
+	if p.rows >= p.opts.RowGroupRows {
+		p.err = p.flush()
+	}
+	return p.err
+}
+
+// Close writes the buffered row group and the file footer.
+func (p *ParquetWriter) Close() error {
+	if p.err != nil {
+		return p.err
+	}
+	if p.zstd != nil {
+		defer p.zstd.Close()
+	}
+	if err := p.flush(); err != nil {
+		p.err = err
+		return err
+	}
+	if p.offset == 0 {
+		if err := p.write([]byte("PAR1")); err != nil {
+			return err
+		}
+	}
+	footer := p.footer()
+	footer = binary.LittleEndian.AppendUint32(footer, uint32(len(footer)))
+	footer = append(footer, "PAR1"...)
+	if err := p.write(footer); err != nil {
+		p.err = err
+		return err
+	}
+	p.err = errClosed
+	return nil
+}
+
+// flush writes the buffered rows as a row group with one data page per column.
+func (p *ParquetWriter) flush() error {
+	if p.rows == 0 {
+		return nil
+	}
+	if p.offset == 0 {
+		if err := p.write([]byte("PAR1")); err != nil {
+			return err
+		}
+	}
+	rg := rowGroupMeta{rows: int64(p.rows)}
+	for i := range p.cols {
+		col := &p.cols[i]
+		compressed, err := p.compress(col.data)
+		if err != nil {
+			return err
+		}
+		var t thriftWriter
+		t.begin()
+		t.i32(1, pageTypeData)
+		t.i32(2, int32(len(col.data)))
+		t.i32(3, int32(len(compressed)))
+		t.structField(5) // DataPageHeader
+		t.i32(1, int32(p.rows))
+		t.i32(2, encodingPlain)
+		t.i32(3, encodingRLE)
+		t.i32(4, encodingRLE)
+		t.end()
+		t.end()
+		meta := columnMeta{
+			offset:           p.offset,
+			compressedSize:   int64(len(t.buf) + len(compressed)),
+			uncompressedSize: int64(len(t.buf) + len(col.data)),
+			numValues:        int64(p.rows),
+		}
+		if err := p.write(t.buf); err != nil {
+			return err
+		}
+		if err := p.write(compressed); err != nil {
+			return err
+		}
+		rg.columns = append(rg.columns, meta)
+		rg.size += meta.uncompressedSize
+		col.data = col.data[:0]
+	}
+	p.groups = append(p.groups, rg)
+	p.total += int64(p.rows)
+	p.rows = 0
+	return nil
+}
+
+func (p *ParquetWriter) compress(data []byte) ([]byte, error) {
+	switch p.opts.Compression {
+	case CompressionSnappy:
+		p.page = s2.EncodeSnappy(p.page[:0], data)
+	case CompressionZstd:
+		p.page = p.zstd.EncodeAll(data, p.page[:0])
+	case CompressionGzip:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		p.page = append(p.page[:0], buf.Bytes()...)
+	default:
+		return data, nil
+	}
+	return p.page, nil
+}
+
+// footer encodes the FileMetaData.
+func (p *ParquetWriter) footer() []byte {
+	var t thriftWriter
+	t.begin()
+	t.i32(1, 1) // version
+	t.list(2, thriftStruct, len(p.cols)+1)
+	t.begin() // root
+	t.string(4, "schema")
+	t.i32(5, int32(len(p.cols)))
+	t.end()
+	for _, col := range p.cols {
+		t.begin()
+		t.i32(1, col.typ)
+		t.i32(3, repetitionReq)
+		t.string(4, col.name)
+		if col.converted >= 0 {
+			t.i32(6, col.converted)
+		}
+		t.end()
+	}
+	t.i64(3, p.total)
+	t.list(4, thriftStruct, len(p.groups))
+	for _, rg := range p.groups {
+		t.begin()
+		t.list(1, thriftStruct, len(rg.columns))
+		for i, m := range rg.columns {
+			col := &p.cols[i]
+			t.begin()
+			t.i64(2, m.offset) // file_offset
+			t.structField(3)   // ColumnMetaData
+			t.i32(1, col.typ)
+			t.list(2, thriftI32, 2)
+			t.listI32(encodingPlain)
+			t.listI32(encodingRLE)
+			t.list(3, thriftBinary, 1)
+			t.rawString(col.name)
+			t.i32(4, parquetCodecs[p.opts.Compression])
+			t.i64(5, m.numValues)
+			t.i64(6, m.uncompressedSize)
+			t.i64(7, m.compressedSize)
+			t.i64(9, m.offset) // data_page_offset
+			t.end()
+			t.end()
+		}
+		t.i64(2, rg.size)
+		t.i64(3, rg.rows)
+		t.end()
+	}
+	t.string(6, "goflowmeter")
+	t.end()
+	return t.buf
+}
+
+func (p *ParquetWriter) write(b []byte) error {
+	n, err := p.w.Write(b)
+	p.offset += int64(n)
+	return err
+}
+
+// unixMicro returns t in microseconds since the Unix epoch, or 0 for the zero time.
+func unixMicro(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMicro()
+}
+
+func (c *parquetColumn) appendString(s string) {
+	c.data = binary.LittleEndian.AppendUint32(c.data, uint32(len(s)))
+	c.data = append(c.data, s...)
+}
+
+func (c *parquetColumn) appendInt32(v int32) {
+	c.data = binary.LittleEndian.AppendUint32(c.data, uint32(v))
+}
+
+func (c *parquetColumn) appendInt64(v int64) {
+	c.data = binary.LittleEndian.AppendUint64(c.data, uint64(v))
+}
+
+func (c *parquetColumn) appendDouble(v float64) {
+	c.data = binary.LittleEndian.AppendUint64(c.data, math.Float64bits(v))
+}
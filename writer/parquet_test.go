@@ -0,0 +1,273 @@
+package writer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Bi9River/goflowmeter"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// thriftReader decodes Thrift compact protocol structs into maps from field id to
+// value: int64 for integers, []byte for binary, []any for lists, map[int16]any for
+// structs.
+type thriftReader struct {
+	b   []byte
+	err bool
+}
+
+func (r *thriftReader) varint() int64 {
+	v, n := binary.Varint(r.b)
+	if n <= 0 {
+		r.err = true
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.err = true
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *thriftReader) value(typ byte) any {
+	switch typ {
+	case 1, 2: // bool in a field header
+		return typ == 1
+	case thriftI32, thriftI64, 4:
+		return r.varint()
+	case thriftBinary:
+		n := int(r.uvarint())
+		if n > len(r.b) {
+			r.err = true
+			return nil
+		}
+		s := r.b[:n]
+		r.b = r.b[n:]
+		return s
+	case thriftList:
+		h := r.b[0]
+		r.b = r.b[1:]
+		n := int(h >> 4)
+		if n == 15 {
+			n = int(r.uvarint())
+		}
+		out := make([]any, n)
+		for i := range out {
+			out[i] = r.value(h & 0x0f)
+		}
+		return out
+	case thriftStruct:
+		return r.structure()
+	}
+	r.err = true
+	return nil
+}
+
+func (r *thriftReader) structure() map[int16]any {
+	m := map[int16]any{}
+	var id int16
+	for !r.err && len(r.b) > 0 {
+		h := r.b[0]
+		r.b = r.b[1:]
+		if h == 0 {
+			return m
+		}
+		if d := h >> 4; d != 0 {
+			id += int16(d)
+		} else {
+			id = int16(r.varint())
+		}
+		m[id] = r.value(h & 0x0f)
+	}
+	r.err = true
+	return m
+}
+
+// parquetFile is what the tests read back from a Parquet file.
+type parquetFile struct {
+	rows    int64
+	names   []string
+	groups  int
+	columns map[string][]byte // PLAIN values of every row group, concatenated
+}
+
+func readParquet(t *testing.T, data []byte) parquetFile {
+	t.Helper()
+	if len(data) < 12 || string(data[:4]) != "PAR1" || string(data[len(data)-4:]) != "PAR1" {
+		t.Fatalf("missing PAR1 magic")
+	}
+	n := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	r := thriftReader{b: data[len(data)-8-n : len(data)-8]}
+	meta := r.structure()
+	if r.err || len(r.b) != 0 {
+		t.Fatalf("malformed footer")
+	}
+	pf := parquetFile{rows: meta[3].(int64), columns: map[string][]byte{}}
+	for _, el := range meta[2].([]any)[1:] {
+		pf.names = append(pf.names, string(el.(map[int16]any)[4].([]byte)))
+	}
+	for _, rg := range meta[4].([]any) {
+		pf.groups++
+		for i, cc := range rg.(map[int16]any)[1].([]any) {
+			cm := cc.(map[int16]any)[3].(map[int16]any)
+			off := cm[9].(int64)
+			pr := thriftReader{b: data[off:]}
+			page := pr.structure()
+			body := pr.b[:page[3].(int64)]
+			var plain []byte
+			var err error
+			switch cm[4].(int64) {
+			case 0:
+				plain = body
+			case 1:
+				plain, err = s2.Decode(nil, body)
+			case 2:
+				var zr *gzip.Reader
+				if zr, err = gzip.NewReader(bytes.NewReader(body)); err == nil {
+					plain, err = io.ReadAll(zr)
+				}
+			case 6:
+				var zr *zstd.Decoder
+				if zr, err = zstd.NewReader(nil); err == nil {
+					plain, err = zr.DecodeAll(body, nil)
+				}
+			}
+			if err != nil || int64(len(plain)) != page[2].(int64) {
+				t.Fatalf("column %d: decompressing page: %v", i, err)
+			}
+			pf.columns[pf.names[i]] = append(pf.columns[pf.names[i]], plain...)
+		}
+	}
+	return pf
+}
+
+func TestParquetWriter_Codecs(t *testing.T) {
+	flows := testFlows(t)
+	for _, c := range []Compression{CompressionSnappy, CompressionZstd, CompressionGzip, CompressionNone} {
+		var buf bytes.Buffer
+		w, err := NewParquet(&buf, ParquetOptions{Options: Options{Label: "BENIGN"}, Compression: c, RowGroupRows: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, fl := range flows {
+			if err := w.Write(fl); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		pf := readParquet(t, buf.Bytes())
+		if pf.rows != 2 || pf.groups != 2 {
+			t.Errorf("codec %d: expected 2 rows in 2 row groups, got %d in %d", c, pf.rows, pf.groups)
+		}
+		if len(pf.names) != 7+76+1 || pf.names[0] != "flow_id" || pf.names[6] != "timestamp" || pf.names[7] != "flow_duration" {
+			t.Fatalf("codec %d: unexpected columns %v", c, pf.names)
+		}
+		dur := pf.columns["flow_duration"]
+		if len(dur) != 16 || binary.LittleEndian.Uint64(dur) != 3_000_000 {
+			t.Errorf("codec %d: expected INT64 flow_duration 3000000, got %x", c, dur)
+		}
+		mean := pf.columns["fwd_packet_length_mean"]
+		if len(mean) != 16 || math.Float64frombits(binary.LittleEndian.Uint64(mean)) != 110 {
+			t.Errorf("codec %d: expected DOUBLE fwd_packet_length_mean 110, got %x", c, mean)
+		}
+		ts := pf.columns["timestamp"]
+		if start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).UnixMicro(); len(ts) != 16 || int64(binary.LittleEndian.Uint64(ts)) != start || int64(binary.LittleEndian.Uint64(ts[8:])) != start+100_000 {
+			t.Errorf("codec %d: expected INT64 timestamps of the first packets, got %x", c, ts)
+		}
+		port := pf.columns["dst_port"]
+		if len(port) != 8 || binary.LittleEndian.Uint32(port) != 80 || binary.LittleEndian.Uint32(port[4:]) != 443 {
+			t.Errorf("codec %d: expected INT32 dst_port 80, 443, got %x", c, port)
+		}
+		label := pf.columns["label"]
+		if string(label) != "\x06\x00\x00\x00BENIGN\x06\x00\x00\x00BENIGN" {
+			t.Errorf("codec %d: unexpected label column %q", c, label)
+		}
+	}
+}
+
+func TestParquetWriter_Empty(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewParquet(&buf, ParquetOptions{Options: Options{KeyFields: flowmeter.KeyMAC}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pf := readParquet(t, buf.Bytes())
+	if pf.rows != 0 || pf.groups != 0 || len(pf.names) != 7+2+76+1 || pf.names[7] != "src_mac" {
+		t.Errorf("expected an empty file with MAC columns, got %d rows, %d groups, columns %v", pf.rows, pf.groups, pf.names)
+	}
+	if err := w.Write(testFlows(t)[0]); err == nil {
+		t.Error("expected an error writing after Close")
+	}
+}
+
+func TestParquetWriter_NonFiniteError(t *testing.T) {
+	var buf bytes.Buffer
+	w, _ := NewParquet(&buf, ParquetOptions{Options: Options{Schema: ratioSchema, NonFinite: NonFiniteError}})
+	fl := testFlows(t)[0]
+	if err := w.Write(withRatio(fl, math.NaN())); err == nil {
+		t.Fatal("expected ErrNonFinite")
+	}
+	// The rejected flow leaves no partial row behind.
+	if err := w.Write(withRatio(fl, 0.5)); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	pf := readParquet(t, buf.Bytes())
+	if pf.rows != 1 || len(pf.columns["flow_id"]) != 4+len("1.1.1.1-2.2.2.2-12345-80-6") {
+		t.Errorf("expected 1 row, got %d with flow_id %q", pf.rows, pf.columns["flow_id"])
+	}
+}
+
+// testdata/ratio.parquet holds one flow with the Ratio column, uncompressed. It
+// was checked once with parquet-go, an independent reader, which read back the
+// STRING, INT(32) and TIMESTAMP(MICROS, UTC) columns and the row unchanged. The
+// test pins its FileMetaData footer and bytes to that validated file.
+func TestParquetWriter_Golden(t *testing.T) {
+	want, err := os.ReadFile("testdata/ratio.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w, err := NewParquet(&buf, ParquetOptions{Options: Options{Label: "BENIGN", Schema: ratioSchema}, Compression: CompressionNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(withRatio(testFlows(t)[0], 0.5)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.Bytes()
+	footer := func(b []byte) []byte {
+		if len(b) < 12 {
+			return nil
+		}
+		return b[len(b)-8-int(binary.LittleEndian.Uint32(b[len(b)-8:])):]
+	}
+	if !bytes.Equal(footer(got), footer(want)) {
+		t.Fatalf("footer differs from testdata/ratio.parquet:\ngot  %x\nwant %x", footer(got), footer(want))
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("file differs from testdata/ratio.parquet: got %d bytes, expected %d", len(got), len(want))
+	}
+}
@@ -0,0 +1,194 @@
+// Package writer streams flows to CSV, JSON Lines and Apache Parquet files as they are
+// produced, e.g. by a FlowTable.
+//
+// Every format writes the same columns: the flow identification columns of
+// CICFlowMeter (Flow ID, addresses, ports, protocol, timestamp of the first packet),
+// the CIC Flow ID and flow information columns if selected in Options, the selected
+// extended key fields, the features of Options.Schema and the label. CSV keeps CICFlowMeter's
+// column names; JSON Lines and Parquet use the stable snake_case names of ColumnName.
+package writer
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Bi9River/goflowmeter"
+)
+
+// FlowWriter writes flows one at a time. Close flushes buffered rows (and, for Parquet,
+// writes the footer); it does not close the underlying io.Writer.
+type FlowWriter interface {
+	Write(fl flowmeter.FlowWithKey) error
+	Close() error
+}
+
+// NonFinite selects how NaN and ±Inf feature values are written.
+type NonFinite int
+
+const (
+	// NonFiniteKeep writes them as they are: NaN, Infinity and -Infinity in CSV (as
+	// CICFlowMeter does), null in JSON Lines, IEEE values in Parquet.
+	NonFiniteKeep NonFinite = iota
+	// NonFiniteZero writes 0.
+	NonFiniteZero
+	// NonFiniteError makes Write fail with ErrNonFinite.
+	NonFiniteError
+)
+
+// ErrNonFinite is returned (wrapped) by Write under NonFiniteError.
+var ErrNonFinite = errors.New("writer: non-finite feature value")
+
+// Options configures a FlowWriter. The zero value writes the CIC features with the
+// shortest round-trip float formatting and an empty label.
+type Options struct {
+	// Schema lists the features to write, in column order; nil writes
+	// flowmeter.FeatureSchema (the CIC columns). Registry.Schema selects extended and
+	// custom features.
+	Schema []flowmeter.FeatureDescriptor
+	// KeyFields adds the extended flow key fields (VLAN, VNI, observation domain, MACs)
+	// as columns after the identification columns.
+	KeyFields flowmeter.KeyFields
+	// Label is written to the Label column of every row.
+	Label string
+	// Precision is the number of digits after the decimal point of fractional features
+	// in CSV and JSON Lines; 0 writes the shortest representation that round-trips.
+	Precision int
+	// NonFinite selects how NaN and ±Inf values are written.
+	NonFinite NonFinite
+	// CICFlowID adds a CIC Flow ID column after Timestamp: the Flow ID with the endpoints
+	// in CICFlowMeter's order (flowmeter.CICFlowKey), whatever the order of the flow's
+	// key. With flowmeter.KeyOrderInitiator it keeps a column that joins with
+	// CICFlowMeter's output.
+	CICFlowID bool
+	// FlowInfo adds the End Timestamp, Initiator IP, Initiator Port and End Reason
+	// columns after Timestamp.
+	FlowInfo bool
+	// TimeLayout is the time.Layout of the timestamps in CSV; "" uses CICTimeLayout.
+	// Timestamps are written in the location of the flow's times: UTC for flows from
+	// package reader, where CICFlowMeter writes local time.
+	TimeLayout string
+}
+
+// CICTimeLayout is the layout of CICFlowMeter's Timestamp column (Java's
+// "dd/MM/yyyy hh:mm:ss a").
+const CICTimeLayout = "02/01/2006 03:04:05 PM"
+
+// cicFlowIDColumn is the column added by Options.CICFlowID.
+const cicFlowIDColumn = "CIC Flow ID"
+
+// flowInfoColumns are the columns added by Options.FlowInfo, in CSV order.
+var flowInfoColumns = []string{"End Timestamp", "Initiator IP", "Initiator Port", "End Reason"}
+
+func (o Options) schema() []flowmeter.FeatureDescriptor {
+	if o.Schema == nil {
+		return flowmeter.FeatureSchema()
+	}
+	return o.Schema
+}
+
+// FlowID returns the CICFlowMeter Flow ID of k: SrcIP-DstIP-SrcPort-DstPort-Protocol.
+func FlowID(k flowmeter.FlowKey) string {
+	return fmt.Sprintf("%s-%s-%d-%d-%d", k.SrcIP(), k.DstIP(), k.SrcPort, k.DstPort, k.Protocol)
+}
+
+// ColumnName returns the stable column name used by JSON Lines and Parquet for a CSV
+// column or feature name: lower case, with runs of other characters replaced by "_"
+// ("Flow Bytes/s" -> "flow_bytes_s", "Fwd IAT Mean" -> "fwd_iat_mean").
+func ColumnName(name string) string {
+	var b strings.Builder
+	sep := false
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+		case r >= 'A' && r <= 'Z':
+			r += 'a' - 'A'
+		default:
+			sep = true
+			continue
+		}
+		if sep && b.Len() > 0 {
+			b.WriteByte('_')
+		}
+		sep = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// keyColumn is an extended flow key column.
+type keyColumn struct {
+	field flowmeter.KeyFields
+	name  string
+	mac   bool // written as text; the others are integers
+	value func(k *flowmeter.FlowKey) uint64
+	text  func(k *flowmeter.FlowKey) string
+}
+
+var keyColumns = []keyColumn{
+	{field: flowmeter.KeyVLAN, name: "VLAN ID", value: func(k *flowmeter.FlowKey) uint64 { return uint64(k.VLANID) }},
+	{field: flowmeter.KeyVNI, name: "VNI", value: func(k *flowmeter.FlowKey) uint64 { return uint64(k.VNI) }},
+	{field: flowmeter.KeyObservationDomain, name: "Observation Domain", value: func(k *flowmeter.FlowKey) uint64 { return uint64(k.ObservationDomain) }},
+	{field: flowmeter.KeyMAC, name: "Src MAC", mac: true, text: func(k *flowmeter.FlowKey) string { return k.SrcMAC.String() }},
+	{field: flowmeter.KeyMAC, name: "Dst MAC", mac: true, text: func(k *flowmeter.FlowKey) string { return k.DstMAC.String() }},
+}
+
+// selectedKeyColumns returns the key columns selected by fields.
+func selectedKeyColumns(fields flowmeter.KeyFields) []keyColumn {
+	var out []keyColumn
+	for _, c := range keyColumns {
+		if fields&c.field != 0 {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// idColumns are CICFlowMeter's identification columns, in CSV order.
+func idColumns() []string {
+	return flowmeter.CICHeader()[:7]
+}
+
+// appendTime formats t for CSV with TimeLayout; the zero time (not recorded) is left
+// empty.
+func (o *Options) appendTime(dst []byte, t time.Time) []byte {
+	if t.IsZero() {
+		return dst
+	}
+	layout := o.TimeLayout
+	if layout == "" {
+		layout = CICTimeLayout
+	}
+	return t.AppendFormat(dst, layout)
+}
+
+// finite applies the NonFinite policy to v. keep reports whether v is to be written as
+// a non-finite value.
+func (o *Options) finite(name string, v float64) (out float64, keep bool, err error) {
+	if !math.IsNaN(v) && !math.IsInf(v, 0) {
+		return v, false, nil
+	}
+	switch o.NonFinite {
+	case NonFiniteZero:
+		return 0, false, nil
+	case NonFiniteError:
+		return v, false, fmt.Errorf("%w: %s = %v", ErrNonFinite, name, v)
+	}
+	return v, true, nil
+}
+
+// appendValue formats a feature value for CSV and JSON Lines: integers without a
+// fraction, fractional features with Precision digits or the shortest round-trip form.
+func (o *Options) appendValue(dst []byte, d *flowmeter.FeatureDescriptor, v float64) []byte {
+	if d.Type == flowmeter.DataTypeInt {
+		return strconv.AppendInt(dst, int64(v), 10)
+	}
+	prec := -1
+	if o.Precision > 0 {
+		prec = o.Precision
+	}
+	return strconv.AppendFloat(dst, v, 'f', prec, 64)
+}
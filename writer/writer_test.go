@@ -0,0 +1,70 @@
+package writer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Bi9River/goflowmeter"
+)
+
+// testFlows returns the flows of two TCP connections, 1.1.1.1:12345 -> 2.2.2.2:80 with
+// three packets and 3.3.3.3:22222 -> 4.4.4.4:443 with one, in that order.
+func testFlows(t *testing.T) []flowmeter.FlowWithKey {
+	t.Helper()
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	packets := []flowmeter.PacketInfo{
+		{Timestamp: base, HeaderLen: 40, PayloadSize: 60, Direction: flowmeter.Forward, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 12345, DstPort: 80, Protocol: 6, SYN: true},
+		{Timestamp: base.Add(time.Second), HeaderLen: 40, PayloadSize: 160, Direction: flowmeter.Forward, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 12345, DstPort: 80, Protocol: 6, ACK: true},
+		{Timestamp: base.Add(3 * time.Second), HeaderLen: 40, PayloadSize: 110, Direction: flowmeter.Backward, SrcIP: "1.1.1.1", DstIP: "2.2.2.2", SrcPort: 12345, DstPort: 80, Protocol: 6, FIN: true, ACK: true},
+		{Timestamp: base.Add(100 * time.Millisecond), HeaderLen: 40, PayloadSize: 40, Direction: flowmeter.Forward, SrcIP: "3.3.3.3", DstIP: "4.4.4.4", SrcPort: 22222, DstPort: 443, Protocol: 6},
+	}
+	flows := flowmeter.ProcessPacketsWithKeys(packets)
+	if len(flows) != 2 || flows[0].Key.SrcPort != 12345 {
+		t.Fatalf("expected the 12345 flow first of 2, got %v", flows)
+	}
+	return flows
+}
+
+// ratioSchema is a one-feature schema reading the custom value "Ratio".
+var ratioSchema = []flowmeter.FeatureDescriptor{{Name: "Ratio", Module: "test", Unit: flowmeter.UnitRatio, Type: flowmeter.DataTypeFloat}}
+
+func withRatio(fl flowmeter.FlowWithKey, v float64) flowmeter.FlowWithKey {
+	fl.Features.Custom = map[string]float64{"Ratio": v}
+	return fl
+}
+
+func TestColumnName(t *testing.T) {
+	for name, want := range map[string]string{
+		"Flow ID":                 "flow_id",
+		"Flow Bytes/s":            "flow_bytes_s",
+		"Fwd IAT Mean":            "fwd_iat_mean",
+		"Down/Up Ratio":           "down_up_ratio",
+		"FWD Init Win Bytes":      "fwd_init_win_bytes",
+		"Fwd Segment Size Avg":    "fwd_segment_size_avg",
+		"  leading and trailing ": "leading_and_trailing",
+	} {
+		if got := ColumnName(name); got != want {
+			t.Errorf("ColumnName(%q): expected %q, got %q", name, want, got)
+		}
+	}
+}
+
+func TestColumnName_Unique(t *testing.T) {
+	seen := map[string]string{}
+	names := append([]string(nil), flowmeter.CICHeader()...)
+	for _, d := range flowmeter.ExtendedFeatureSchema() {
+		names = append(names, d.Name)
+	}
+	for _, c := range keyColumns {
+		names = append(names, c.name)
+	}
+	names = append(names, cicFlowIDColumn)
+	names = append(names, flowInfoColumns...)
+	for _, name := range names {
+		col := ColumnName(name)
+		if prev, ok := seen[col]; ok {
+			t.Errorf("%q and %q both map to %q", prev, name, col)
+		}
+		seen[col] = name
+	}
+}